@@ -0,0 +1,42 @@
+(ns facepalm.c200-2015080301
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150803.01")
+
+(defn- add-job-status-transitions-table
+  []
+  (println "\t* adds the job_status_transitions table")
+  (exec-raw "CREATE TABLE job_status_transitions (
+               id                  uuid not null default uuid_generate_v1(),
+               job_id              uuid not null,
+               condor_job_event_id uuid not null,
+               from_status         varchar(32) not null,
+               to_status           varchar(32) not null,
+               reason              text,
+               date_triggered      timestamp with time zone not null,
+               date_recorded       timestamp with time zone not null default now()
+             )"))
+
+(defn- add-job-status-transitions-constraints
+  []
+  (println "\t* adds constraints to the job_status_transitions table")
+  (exec-raw "ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_pkey
+               PRIMARY KEY (id)")
+  (exec-raw "ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE")
+  (exec-raw "ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE CASCADE"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150803.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-status-transitions-table)
+  (add-job-status-transitions-constraints))
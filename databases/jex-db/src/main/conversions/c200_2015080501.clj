@@ -0,0 +1,44 @@
+(ns facepalm.c200-2015080501
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150805.01")
+
+(defn- add-outbound-notifications-table
+  []
+  (println "\t* adds the outbound_notifications table")
+  (exec-raw "CREATE TABLE outbound_notifications (
+               id              uuid not null default uuid_generate_v1(),
+               url             text not null,
+               idempotency_key text not null,
+               payload         text not null,
+               status          varchar(16) not null,
+               attempts        integer not null default 0,
+               max_attempts    integer not null,
+               last_error      text not null default '',
+               next_attempt    timestamp with time zone not null,
+               date_created    timestamp with time zone not null default now(),
+               date_delivered  timestamp with time zone
+             )")
+  (exec-raw "ALTER TABLE ONLY outbound_notifications
+               ADD CONSTRAINT outbound_notifications_pkey
+               PRIMARY KEY (id)")
+  (exec-raw "ALTER TABLE ONLY outbound_notifications
+               ADD CONSTRAINT outbound_notifications_idempotency_key_key
+               UNIQUE (idempotency_key)"))
+
+(defn- add-dead-outbound-notifications-view
+  []
+  (println "\t* adds the dead_outbound_notifications view")
+  (exec-raw "CREATE VIEW dead_outbound_notifications AS
+               SELECT *
+                 FROM outbound_notifications
+                WHERE status = 'dead'"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150805.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-outbound-notifications-table)
+  (add-dead-outbound-notifications-view))
@@ -0,0 +1,21 @@
+(ns facepalm.c200-2015080701
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150807.01")
+
+(defn- add-event-mapping-columns
+  []
+  (println "\t* adds the event mapping columns to the condor_events table")
+  (exec-raw "ALTER TABLE ONLY condor_events ADD COLUMN de_status varchar(32)")
+  (exec-raw "ALTER TABLE ONLY condor_events ADD COLUMN job_status varchar(32)")
+  (exec-raw "ALTER TABLE ONLY condor_events ADD COLUMN terminal boolean")
+  (exec-raw "ALTER TABLE ONLY condor_events ADD COLUMN update_last_events boolean")
+  (exec-raw "ALTER TABLE ONLY condor_events ADD COLUMN action varchar(32)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150807.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-event-mapping-columns))
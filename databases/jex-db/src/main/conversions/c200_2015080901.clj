@@ -0,0 +1,22 @@
+(ns facepalm.c200-2015080901
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150809.01")
+
+(defn- add-stop-request-confirmation-columns
+  []
+  (println "\t* adds the confirmation columns to the condor_job_stop_requests table")
+  (exec-raw "ALTER TABLE ONLY condor_job_stop_requests ADD COLUMN date_confirmed timestamp with time zone")
+  (exec-raw "ALTER TABLE ONLY condor_job_stop_requests ADD COLUMN condor_job_event_id uuid")
+  (exec-raw "ALTER TABLE ONLY condor_job_stop_requests
+               ADD CONSTRAINT condor_job_stop_requests_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE SET NULL"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150809.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-stop-request-confirmation-columns))
@@ -0,0 +1,24 @@
+(ns facepalm.c200-2015081001
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150810.01")
+
+(defn- add-job-listing-indexes
+  []
+  (println "\t* adds indexes for job listings and searches")
+  (exec-raw "CREATE INDEX jobs_submitter_date_submitted_idx ON jobs(submitter, date_submitted, id)")
+  (exec-raw "CREATE INDEX jobs_app_id_idx ON jobs(app_id)")
+  (exec-raw "CREATE INDEX jobs_batch_id_idx ON jobs(batch_id)")
+  (exec-raw "CREATE INDEX jobs_date_submitted_idx ON jobs(date_submitted, id)")
+  (exec-raw "CREATE INDEX jobs_date_completed_idx ON jobs(date_completed, id)")
+  (exec-raw "CREATE INDEX jobs_exit_code_idx ON jobs(exit_code)")
+  (exec-raw "CREATE INDEX job_status_transitions_job_id_date_triggered_idx
+               ON job_status_transitions(job_id, date_triggered, date_recorded)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150810.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-listing-indexes))
@@ -0,0 +1,20 @@
+(ns facepalm.c200-2015081101
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150811.01")
+
+(defn- add-transition-sequence-column
+  []
+  (println "\t* adds the sequence column to the job_status_transitions table")
+  (exec-raw "ALTER TABLE ONLY job_status_transitions ADD COLUMN sequence bigserial not null")
+  (exec-raw "ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_sequence_key
+               UNIQUE (sequence)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150811.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-transition-sequence-column))
@@ -0,0 +1,26 @@
+(ns facepalm.c200-2015081201
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150812.01")
+
+(defn- add-event-indexes
+  []
+  (println "\t* adds indexes for event lookups and for deleting jobs")
+  (exec-raw "CREATE INDEX jobs_condor_id_idx ON jobs(condor_id)")
+  (exec-raw "CREATE INDEX condor_job_events_checksum_idx ON condor_job_events(checksum)")
+  (exec-raw "CREATE INDEX condor_raw_events_job_id_idx ON condor_raw_events(job_id)")
+  (exec-raw "CREATE INDEX condor_job_events_job_id_idx ON condor_job_events(job_id)")
+  (exec-raw "CREATE INDEX condor_job_events_condor_raw_event_id_idx ON condor_job_events(condor_raw_event_id)")
+  (exec-raw "CREATE INDEX last_condor_job_events_condor_job_event_id_idx ON last_condor_job_events(condor_job_event_id)")
+  (exec-raw "CREATE INDEX condor_job_stop_requests_job_id_idx ON condor_job_stop_requests(job_id)")
+  (exec-raw "CREATE INDEX condor_job_stop_requests_condor_job_event_id_idx ON condor_job_stop_requests(condor_job_event_id)")
+  (exec-raw "CREATE INDEX condor_job_deps_predecessor_id_idx ON condor_job_deps(predecessor_id)")
+  (exec-raw "CREATE INDEX job_status_transitions_condor_job_event_id_idx ON job_status_transitions(condor_job_event_id)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150812.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-event-indexes))
@@ -0,0 +1,46 @@
+(ns facepalm.c200-2015081301
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150813.01")
+
+(defn- add-job-resource-usage-table
+  []
+  (println "\t* adds the job_resource_usage table")
+  (exec-raw "CREATE TABLE job_resource_usage (
+               job_id              uuid not null,
+               condor_job_event_id uuid not null,
+               user_cpu_seconds    bigint not null default 0,
+               sys_cpu_seconds     bigint not null default 0,
+               bytes_sent          bigint not null default 0,
+               bytes_received      bigint not null default 0,
+               cpus_requested      bigint not null default 0,
+               cpus_allocated      bigint not null default 0,
+               memory_usage_mb     bigint not null default 0,
+               memory_requested_mb bigint not null default 0,
+               memory_allocated_mb bigint not null default 0,
+               disk_usage_kb       bigint not null default 0,
+               disk_requested_kb   bigint not null default 0,
+               disk_allocated_kb   bigint not null default 0,
+               date_triggered      timestamp with time zone not null
+             )")
+  (exec-raw "ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_pkey
+               PRIMARY KEY (job_id)")
+  (exec-raw "ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE")
+  (exec-raw "ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE CASCADE")
+  (exec-raw "CREATE INDEX job_resource_usage_date_triggered_idx ON job_resource_usage(date_triggered)")
+  (exec-raw "CREATE INDEX job_resource_usage_condor_job_event_id_idx ON job_resource_usage(condor_job_event_id)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150813.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-resource-usage-table))
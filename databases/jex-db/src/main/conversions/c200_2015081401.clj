@@ -0,0 +1,18 @@
+(ns facepalm.c200-2015081401
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150814.01")
+
+(defn- add-job-termination-columns
+  []
+  (println "\t* adds the termination columns to the jobs table")
+  (exec-raw "ALTER TABLE ONLY jobs ADD COLUMN termination_kind varchar(16) not null default ''")
+  (exec-raw "ALTER TABLE ONLY jobs ADD COLUMN termination_signal integer not null default 0"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150814.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-termination-columns))
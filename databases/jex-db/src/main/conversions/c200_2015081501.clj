@@ -0,0 +1,17 @@
+(ns facepalm.c200-2015081501
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150815.01")
+
+(defn- add-job-date-archived-column
+  []
+  (println "\t* adds the date_archived column to the jobs table")
+  (exec-raw "ALTER TABLE ONLY jobs ADD COLUMN date_archived timestamp with time zone"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150815.01"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-date-archived-column))
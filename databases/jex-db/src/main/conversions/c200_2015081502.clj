@@ -0,0 +1,19 @@
+(ns facepalm.c200-2015081502
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150815.02")
+
+(defn- add-notification-invocation-id-column
+  []
+  (println "\t* adds the invocation_id column to the outbound_notifications table")
+  (exec-raw "ALTER TABLE ONLY outbound_notifications ADD COLUMN invocation_id uuid")
+  (exec-raw "CREATE INDEX outbound_notifications_invocation_id_idx ON outbound_notifications(invocation_id, url, date_created)")
+  (exec-raw "CREATE OR REPLACE VIEW dead_outbound_notifications AS SELECT * FROM outbound_notifications WHERE status = 'dead'"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150815.02"
+  []
+  (println "Performing the conversion for" version)
+  (add-notification-invocation-id-column))
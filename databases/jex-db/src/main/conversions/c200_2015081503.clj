@@ -0,0 +1,25 @@
+(ns facepalm.c200-2015081503
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150815.03")
+
+(defn- add-service-locks-table
+  []
+  (println "\t* adds the service_locks table")
+  (exec-raw "CREATE TABLE service_locks (
+               name          text not null,
+               holder        text not null,
+               date_acquired timestamp with time zone not null,
+               date_expires  timestamp with time zone not null
+             )")
+  (exec-raw "ALTER TABLE ONLY service_locks
+               ADD CONSTRAINT service_locks_pkey
+               PRIMARY KEY (name)"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150815.03"
+  []
+  (println "Performing the conversion for" version)
+  (add-service-locks-table))
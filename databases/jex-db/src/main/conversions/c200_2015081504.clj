@@ -0,0 +1,28 @@
+(ns facepalm.c200-2015081504
+  (:use [korma.core]))
+
+(def ^:private version
+  "The destination database version."
+  "2.0.0:20150815.04")
+
+(defn- add-job-flags-table
+  []
+  (println "\t* adds the job_flags table")
+  (exec-raw "CREATE TABLE job_flags (
+               job_id   uuid not null,
+               flag     text not null,
+               date_set timestamp with time zone not null
+             )")
+  (exec-raw "ALTER TABLE ONLY job_flags
+               ADD CONSTRAINT job_flags_pkey
+               PRIMARY KEY (job_id, flag)")
+  (exec-raw "ALTER TABLE ONLY job_flags
+               ADD CONSTRAINT job_flags_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE"))
+
+(defn convert
+  "Performs the conversion for database version 2.0.0:20150815.04"
+  []
+  (println "Performing the conversion for" version)
+  (add-job-flags-table))
@@ -1 +1 @@
-INSERT INTO version (version) VALUES ('1.9.3:20141114.01');
+INSERT INTO version (version) VALUES ('2.0.0:20150815.04');
@@ -15,5 +15,8 @@ CREATE TABLE jobs (
   app_id            uuid,
   exit_code         integer, -- nullable because the job might be running
   failure_threshold integer NOT NULL,
-  failure_count     integer
+  failure_count     integer,
+  termination_kind  character varying(16) not null default '', -- normal, signal, or core dump
+  termination_signal integer not null default 0,
+  date_archived     timestamp with time zone -- set once the job's event history is archived
 );
@@ -7,5 +7,12 @@ CREATE TABLE condor_events (
   id           uuid not null default uuid_generate_v1(), -- primary key
   event_number varchar(3) not null,
   event_name   text not null,
-  event_desc   text not null
+  event_desc   text not null,
+  -- optional overrides for the event mappings built into jex-events. A row
+  -- is only used as a mapping if both de_status and action are set.
+  de_status          varchar(32),
+  job_status         varchar(32),
+  terminal           boolean,
+  update_last_events boolean,
+  action             varchar(32)
 );
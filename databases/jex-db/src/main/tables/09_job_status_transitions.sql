@@ -0,0 +1,16 @@
+SET search_path = public, pg_catalog;
+
+--
+-- job_status_transitions
+--
+CREATE TABLE job_status_transitions (
+  id                  uuid not null default uuid_generate_v1(), -- primary key
+  job_id              uuid not null, -- foreign key into the jobs table
+  condor_job_event_id uuid not null, -- foreign key into the condor_job_events table
+  from_status         varchar(32) not null,
+  to_status           varchar(32) not null,
+  reason              text,
+  date_triggered      timestamp with time zone not null,
+  date_recorded       timestamp with time zone not null default now(),
+  sequence            bigserial not null -- orders transitions for the status streams
+);
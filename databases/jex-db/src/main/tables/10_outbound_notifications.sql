@@ -0,0 +1,19 @@
+SET search_path = public, pg_catalog;
+
+--
+-- outbound_notifications
+--
+CREATE TABLE outbound_notifications (
+  id              uuid not null default uuid_generate_v1(), -- primary key
+  url             text not null,
+  idempotency_key text not null,
+  payload         text not null,
+  status          varchar(16) not null, -- pending, delivered, or dead
+  attempts        integer not null default 0,
+  max_attempts    integer not null,
+  last_error      text not null default '',
+  next_attempt    timestamp with time zone not null,
+  date_created    timestamp with time zone not null default now(),
+  date_delivered  timestamp with time zone,
+  invocation_id   uuid -- notifications for the same job are delivered in order
+);
@@ -0,0 +1,22 @@
+SET search_path = public, pg_catalog;
+
+--
+-- job_resource_usage
+--
+CREATE TABLE job_resource_usage (
+  job_id              uuid not null, -- primary key, foreign key into the jobs table
+  condor_job_event_id uuid not null, -- foreign key into the condor_job_events table for the terminated event
+  user_cpu_seconds    bigint not null default 0,
+  sys_cpu_seconds     bigint not null default 0,
+  bytes_sent          bigint not null default 0,
+  bytes_received      bigint not null default 0,
+  cpus_requested      bigint not null default 0,
+  cpus_allocated      bigint not null default 0,
+  memory_usage_mb     bigint not null default 0,
+  memory_requested_mb bigint not null default 0,
+  memory_allocated_mb bigint not null default 0,
+  disk_usage_kb       bigint not null default 0,
+  disk_requested_kb   bigint not null default 0,
+  disk_allocated_kb   bigint not null default 0,
+  date_triggered      timestamp with time zone not null
+);
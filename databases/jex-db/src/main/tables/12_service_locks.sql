@@ -0,0 +1,11 @@
+SET search_path = public, pg_catalog;
+
+--
+-- service_locks
+--
+CREATE TABLE service_locks (
+  name          text not null, -- primary key
+  holder        text not null, -- who holds the lock, e.g. "events on <host> (pid <pid>)"
+  date_acquired timestamp with time zone not null,
+  date_expires  timestamp with time zone not null -- the lock is free after this unless it's renewed
+);
@@ -0,0 +1,10 @@
+SET search_path = public, pg_catalog;
+
+--
+-- job_flags
+--
+CREATE TABLE job_flags (
+  job_id   uuid not null, -- foreign key into the jobs table
+  flag     text not null, -- what happened, e.g. failure_threshold_enforced
+  date_set timestamp with time zone not null
+);
@@ -139,3 +139,157 @@ ALTER TABLE ONLY condor_job_deps
 ALTER TABLE ONLY version
     ADD CONSTRAINT version_pkey
     PRIMARY KEY (id);
+
+
+--
+-- Primary key for the job_status_transitions table
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Foreign key into the jobs table for job_status_transitions
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_job_events table for job_status_transitions
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the outbound_notifications table
+--
+ALTER TABLE ONLY outbound_notifications
+    ADD CONSTRAINT outbound_notifications_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Idempotency keys must be unique for outbound_notifications
+--
+ALTER TABLE ONLY outbound_notifications
+    ADD CONSTRAINT outbound_notifications_idempotency_key_key
+    UNIQUE (idempotency_key);
+
+
+--
+-- Foreign key into the condor_job_events table for condor_job_stop_requests
+--
+ALTER TABLE ONLY condor_job_stop_requests
+    ADD CONSTRAINT condor_job_stop_requests_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE SET NULL;
+
+
+--
+-- Indexes for job listings and searches
+--
+CREATE INDEX jobs_submitter_date_submitted_idx ON jobs(submitter, date_submitted, id);
+CREATE INDEX jobs_app_id_idx ON jobs(app_id);
+CREATE INDEX jobs_batch_id_idx ON jobs(batch_id);
+CREATE INDEX jobs_date_submitted_idx ON jobs(date_submitted, id);
+CREATE INDEX jobs_date_completed_idx ON jobs(date_completed, id);
+CREATE INDEX jobs_exit_code_idx ON jobs(exit_code);
+CREATE INDEX job_status_transitions_job_id_date_triggered_idx
+    ON job_status_transitions(job_id, date_triggered, date_recorded);
+
+
+--
+-- Indexes for the lookups done for every event, and for the foreign keys that
+-- deleting a job cascades through.
+--
+CREATE INDEX jobs_condor_id_idx ON jobs(condor_id);
+CREATE INDEX condor_job_events_checksum_idx ON condor_job_events(checksum);
+CREATE INDEX condor_raw_events_job_id_idx ON condor_raw_events(job_id);
+CREATE INDEX condor_job_events_job_id_idx ON condor_job_events(job_id);
+CREATE INDEX condor_job_events_condor_raw_event_id_idx ON condor_job_events(condor_raw_event_id);
+CREATE INDEX last_condor_job_events_condor_job_event_id_idx ON last_condor_job_events(condor_job_event_id);
+CREATE INDEX condor_job_stop_requests_job_id_idx ON condor_job_stop_requests(job_id);
+CREATE INDEX condor_job_stop_requests_condor_job_event_id_idx ON condor_job_stop_requests(condor_job_event_id);
+CREATE INDEX condor_job_deps_predecessor_id_idx ON condor_job_deps(predecessor_id);
+CREATE INDEX job_status_transitions_condor_job_event_id_idx ON job_status_transitions(condor_job_event_id);
+
+
+--
+-- Transition sequence numbers must be unique; they're the event IDs in the
+-- status streams.
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_sequence_key
+    UNIQUE (sequence);
+
+
+--
+-- Primary key for the job_resource_usage table
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_pkey
+    PRIMARY KEY (job_id);
+
+
+--
+-- Primary key for the service_locks table
+--
+ALTER TABLE ONLY service_locks
+    ADD CONSTRAINT service_locks_pkey
+    PRIMARY KEY (name);
+
+
+--
+-- Primary key for the job_flags table. Each flag is only set once per job.
+--
+ALTER TABLE ONLY job_flags
+    ADD CONSTRAINT job_flags_pkey
+    PRIMARY KEY (job_id, flag);
+
+
+--
+-- Foreign key into the jobs table for job_flags
+--
+ALTER TABLE ONLY job_flags
+    ADD CONSTRAINT job_flags_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the jobs table for job_resource_usage
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_job_events table for job_resource_usage
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE CASCADE;
+
+
+--
+-- Usage reports are filtered by when the jobs terminated.
+--
+CREATE INDEX job_resource_usage_date_triggered_idx ON job_resource_usage(date_triggered);
+CREATE INDEX job_resource_usage_condor_job_event_id_idx ON job_resource_usage(condor_job_event_id);
+
+
+--
+-- A job's pending notifications are looked up before each delivery so that
+-- they go out in order.
+--
+CREATE INDEX outbound_notifications_invocation_id_idx ON outbound_notifications(invocation_id, url, date_created);
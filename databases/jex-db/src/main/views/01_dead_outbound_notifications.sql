@@ -0,0 +1,10 @@
+SET search_path = public, pg_catalog;
+
+--
+-- dead_outbound_notifications view containing the outbound notifications that
+-- ran out of delivery attempts.
+--
+CREATE VIEW dead_outbound_notifications AS
+    SELECT *
+      FROM outbound_notifications
+     WHERE status = 'dead';
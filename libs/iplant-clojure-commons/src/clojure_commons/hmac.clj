@@ -0,0 +1,77 @@
+(ns clojure-commons.hmac
+  "Signs outgoing HTTP requests with a shared secret, for services such as
+   jex-events that authenticate other services with an Authorization header
+   like this:
+
+       Authorization: HMAC <service>:<unix-timestamp>:<signature>
+
+   The signature is the hex encoded HMAC-SHA256, keyed with the service's
+   secret, of the request method, the request URI (path and query string), the
+   timestamp, and the hex encoded SHA256 of the request body, each followed by
+   a newline."
+  (:require [clojure.string :as string])
+  (:import [java.net URI]
+           [java.security MessageDigest]
+           [javax.crypto Mac]
+           [javax.crypto.spec SecretKeySpec]))
+
+(defn- hex
+  "Hex encodes a byte array."
+  [bs]
+  (apply str (map #(format "%02x" (bit-and % 0xff)) bs)))
+
+(defn- utf8-bytes
+  [s]
+  (.getBytes (str s) "UTF-8"))
+
+(defn- body-bytes
+  "Returns the bytes of a request body, which can be nil, a string, or a byte
+   array. Streams can't be signed since they'd have to be read."
+  [body]
+  (cond
+   (nil? body)    (byte-array 0)
+   (string? body) (utf8-bytes body)
+   :else          body))
+
+(defn- sha256-hex
+  [bs]
+  (hex (.digest (MessageDigest/getInstance "SHA-256") bs)))
+
+(defn request-uri
+  "Returns the path and query string of a URL as they're sent in the request
+   line."
+  [url]
+  (let [uri   (URI. (str url))
+        path  (.getRawPath uri)
+        query (.getRawQuery uri)]
+    (str (if (string/blank? path) "/" path)
+         (when query (str "?" query)))))
+
+(defn signature
+  "Returns the hex encoded signature of a request."
+  [secret method uri timestamp body]
+  (let [mac (Mac/getInstance "HmacSHA256")]
+    (.init mac (SecretKeySpec. (utf8-bytes secret) "HmacSHA256"))
+    (hex (.doFinal mac (utf8-bytes (str (string/upper-case (name method)) "\n"
+                                        uri "\n"
+                                        timestamp "\n"
+                                        (sha256-hex (body-bytes body)) "\n"))))))
+
+(defn authorization
+  "Returns the Authorization header for a request made by 'service' at
+   'timestamp', which is in seconds since the epoch."
+  [service secret method url body timestamp]
+  (format "HMAC %s:%d:%s" service timestamp
+          (signature secret method (request-uri url) timestamp body)))
+
+(defn sign-request
+  "Adds an Authorization header to a clj-http request map, which needs to have
+   the :method and :url keys. The :body has to be encoded already. Requests are
+   left alone if the service or secret is blank, so that signing can be turned
+   on by configuring them."
+  [service secret {:keys [method url body] :as req}]
+  (if (or (string/blank? service) (string/blank? secret))
+    req
+    (let [timestamp (quot (System/currentTimeMillis) 1000)]
+      (assoc-in req [:headers "Authorization"]
+                (authorization service secret method url body timestamp)))))
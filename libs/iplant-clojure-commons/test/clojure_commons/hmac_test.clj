@@ -0,0 +1,31 @@
+(ns clojure-commons.hmac-test
+  (:use [clojure.test]
+        [clojure-commons.hmac]))
+
+;; The expected signatures were produced by SignRequest in jex-events.
+
+(deftest request-uri-test
+  (is (= "/jobs?x=1" (request-uri "http://jex-events:60000/jobs?x=1"))
+      "request-uri keeps the query string")
+  (is (= "/" (request-uri "http://jex-events:60000"))
+      "request-uri uses / for URLs without a path"))
+
+(deftest authorization-test
+  (is (= "HMAC jex:1439640000:e32a3910100e118aed668286fdfdd1556ecdaff11c959782e12a59c987475607"
+         (authorization "jex" "secret" :post "http://jex-events:60000/jobs?x=1"
+                        "{\"condorid\":\"1\"}" 1439640000))
+      "authorization signs the method, URI, timestamp, and body")
+  (is (= "HMAC jex:1439640000:14f0240c0660b1f754db1081582db3f3ecf1756bb08e2b524bfe2526e8c9616b"
+         (authorization "jex" "secret" :get "http://jex-events:60000/invocations/abc"
+                        nil 1439640000))
+      "authorization signs requests without a body"))
+
+(deftest sign-request-test
+  (let [req {:method :get :url "http://jex-events:60000/invocations/abc"}]
+    (is (= req (sign-request "" "secret" req))
+        "sign-request leaves requests alone without a service")
+    (is (= req (sign-request "jex" nil req))
+        "sign-request leaves requests alone without a secret")
+    (is (re-matches #"HMAC jex:[0-9]+:[0-9a-f]{64}"
+                    (get-in (sign-request "jex" "secret" req) [:headers "Authorization"]))
+        "sign-request adds the Authorization header")))
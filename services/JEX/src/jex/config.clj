@@ -24,6 +24,8 @@
    :path            [v/required cfg/stringv]
    :request-disk    [v/required cfg/stringv]
    :jex-events      [v/required cfg/stringv]
+   :jex-events-hmac-service cfg/stringv
+   :jex-events-hmac-secret  cfg/stringv
    :log-file        cfg/stringv
    :log-size        cfg/stringv
    :log-backlog     cfg/stringv
@@ -31,7 +33,7 @@
 
  (ref-set
   cfg/filters
-  #{:irods-password}))
+  #{:irods-password :jex-events-hmac-secret}))
 
 (defn jar-path
   "Returns the path to porklock on the filesystem out on the Condor cluster."
@@ -128,6 +130,17 @@
   []
   (:jex-events @cfg/cfg))
 
+(defn jex-events-hmac-service
+  "The service name that requests to jex-events are signed as. Requests aren't
+   signed if it's not set."
+  []
+  (:jex-events-hmac-service @cfg/cfg))
+
+(defn jex-events-hmac-secret
+  "The shared secret that requests to jex-events are signed with."
+  []
+  (:jex-events-hmac-secret @cfg/cfg))
+
 (defn log-level
   "The log level. One of log, trace, debug, info, warn, error, or fatal."
   []
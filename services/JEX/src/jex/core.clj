@@ -57,7 +57,13 @@
         (trap "arg-preview" jp/cmdline-preview (:body request)))
 
   (DELETE "/stop/:uuid" [uuid]
-          (trap "stop" jp/stop-analysis uuid)))
+          (trap "stop" jp/stop-analysis uuid))
+
+  (POST "/release/:uuid" [uuid]
+        (trap "release" jp/release-analysis uuid))
+
+  (GET "/condor-jobs/:condor-id" [condor-id]
+       (trap "condor-job" jp/condor-job-uuid condor-id)))
 
 (defn req-logger
   [handler]
@@ -8,6 +8,7 @@
             [clojure.tools.logging :as log]
             [clojure.java.shell :as sh]
             [clj-http.client :as http]
+            [clojure-commons.hmac :as hmac]
             [cemerick.url :refer (url url-encode)]
             [cheshire.core :as cheshire]
             [clojure.string :as string]
@@ -42,6 +43,14 @@
   {"PATH"          (cfg/path-env)
    "CONDOR_CONFIG" (cfg/condor-config)})
 
+(defn jex-events-request
+  "Sends a request to jex-events. It's signed if the JEX is configured with
+   credentials for jex-events."
+  [req]
+  (http/request (hmac/sign-request (cfg/jex-events-hmac-service)
+                                   (cfg/jex-events-hmac-secret)
+                                   req)))
+
 (defn push-job-to-jex-events
   [condor-id submitter app-id inv-id]
   (let [job-record {:condorid     condor-id
@@ -49,9 +58,10 @@
                     :appid        app-id
                     :invocationid inv-id}
         post-url   (str (url (cfg/jex-events-url) "jobs"))
-        result     (http/post post-url {:form-params job-record
-                                        :content-type :json}
-                              {:throw-exceptions false})]
+        result     (jex-events-request {:method       :post
+                                        :url          post-url
+                                        :body         (cheshire/encode job-record)
+                                        :content-type :json})]
     (log/info result)))
 
 (defn condor-rm
@@ -59,6 +69,42 @@
   [sub-id]
   (sh/with-sh-env (condor-env) (sh/sh "condor_rm" sub-id)))
 
+(defn condor-release
+  "Releases a held condor job."
+  [sub-id]
+  (sh/with-sh-env (condor-env) (sh/sh "condor_release" sub-id)))
+
+(defn condor-job-attr
+  "Returns the value of an attribute of a condor job, using condor_q for jobs
+   that are still in the queue and condor_history for the ones that have left
+   it. nil is returned if the job can't be found or doesn't have the attribute."
+  [condor-id attr]
+  (let [lookup (fn [cmd & args]
+                 (let [{:keys [exit out err]}
+                       (sh/with-sh-env (condor-env)
+                         (apply sh/sh cmd (concat args ["-format" "%s\n" attr condor-id])))]
+                   (when-not (= exit 0)
+                     (throw+ {:error_code "ERR_FAILED_NON_ZERO"
+                              :condor_id condor-id
+                              :out out
+                              :err err}))
+                   (first (remove string/blank? (string/split-lines out)))))]
+    (or (lookup "condor_q")
+        (lookup "condor_history" "-match" "1"))))
+
+(defn condor-job-uuid
+  "Returns the analysis UUID of a condor job, which is in the IpcUuid attribute
+   that's added to each job the JEX submits. jex-events uses this to link jobs
+   to analyses when it doesn't hear about them any other way."
+  [condor-id]
+  (when-not (re-matches #"[0-9]+" condor-id)
+    (throw+ {:error_code "ERR_BAD_REQUEST"
+             :condor_id condor-id}))
+  (if-let [uuid (condor-job-attr condor-id "IpcUuid")]
+    {:uuid uuid}
+    (throw+ {:error_code "ERR_NOT_FOUND"
+             :condor_id condor-id})))
+
 (defn missing-condor-id
   [uuid]
   (hash-map
@@ -68,7 +114,7 @@
 (defn get-job-sub-id
   [uuid]
   (let [get-url (str (url (cfg/jex-events-url) "invocations" uuid))
-        result  (cheshire/decode (:body (http/get get-url)) true)]
+        result  (cheshire/decode (:body (jex-events-request {:method :get :url get-url})) true)]
     (when-not result
       (throw+ (missing-condor-id uuid)))
     (when-not (contains? result :CondorID)
@@ -92,6 +138,23 @@
                    :err err})))
       (throw+ {:error_code "ERR_MISSING_CONDOR_ID" :uuid uuid}))))
 
+(defn release-analysis
+  "Calls condor_release on the submission id associated with the provided
+   analysis id."
+  [uuid]
+  (log/warn "Received request to release job" uuid)
+  (let [sub-id (get-job-sub-id uuid)]
+    (log/debug (str "Grabbed Condor ID: " sub-id))
+    (if sub-id
+      (let [{:keys [exit out err]} (condor-release sub-id)]
+        (when-not (= exit 0)
+          (log/debug (str "condor-release status was: " exit))
+          (throw+ {:error_code "ERR_FAILED_NON_ZERO"
+                   :sub_id sub-id
+                   :out out
+                   :err err})))
+      (throw+ {:error_code "ERR_MISSING_CONDOR_ID" :uuid uuid}))))
+
 (defn param?
   "Returns true of the object passed in is actually a param."
   [param-map]
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// These are the access levels that routes can require.
+const (
+	// AccessPublic routes can be used without credentials.
+	AccessPublic = iota
+
+	// AccessUser routes need credentials. Handlers limit non-admin users to
+	// their own jobs.
+	AccessUser
+
+	// AccessAdmin routes need credentials that grant admin access, which
+	// includes all service-to-service (HMAC) credentials.
+	AccessAdmin
+)
+
+const (
+	// defaultHMACMaxSkew is how far the timestamp in an HMAC signature can be
+	// from the current time unless HMACMaxSkew is set.
+	defaultHMACMaxSkew = 5 * time.Minute
+
+	// jwtLeeway is how much clock skew is allowed when checking the exp and nbf
+	// claims of a JWT.
+	jwtLeeway = time.Minute
+
+	// jwksReloadInterval is the minimum amount of time between reloads of the
+	// JWKS file, which is reloaded when a token is signed by an unknown key.
+	jwksReloadInterval = time.Minute
+
+	// maxSignedBodySize is the largest request body that will be read to check
+	// an HMAC signature.
+	maxSignedBodySize = 10 << 20
+
+	// defaultJWTUsernameClaim is the JWT claim that contains the username
+	// unless JWTUsernameClaim is set.
+	defaultJWTUsernameClaim = "sub"
+)
+
+// These are returned when a request can't be authenticated.
+var (
+	ErrNoCredentials      = errors.New("the request doesn't have any credentials")
+	ErrUnsupportedScheme  = errors.New("the Authorization scheme isn't supported")
+	ErrInvalidCredentials = errors.New("the credentials are invalid")
+	ErrExpiredCredentials = errors.New("the credentials have expired")
+)
+
+// AuthConfig contains the settings for authenticating HTTP requests.
+// HMACSecrets maps the names of services to their shared secrets. HMACMaxSkew
+// is in seconds. JWKSFile is the path to a JSON Web Key Set containing the RSA
+// keys that JWTs are signed with. If JWTIssuer or JWTAudience are set, the iss
+// and aud claims of each JWT must match them. JWTAdminRole is the role in the
+// roles claim that gives a user access to everyone's jobs.
+type AuthConfig struct {
+	HMACSecrets      map[string]string
+	HMACMaxSkew      int
+	JWKSFile         string
+	JWTIssuer        string
+	JWTAudience      string
+	JWTUsernameClaim string
+	JWTAdminRole     string
+}
+
+// Principal is who made a request. Admins can see and change every job; other
+// users can only see their own.
+type Principal struct {
+	Subject string
+	Admin   bool
+	Service bool
+}
+
+// Authenticator checks the credentials in the Authorization header of requests
+// that use its scheme.
+type Authenticator interface {
+	Scheme() string
+	Authenticate(request *http.Request, credentials string) (*Principal, error)
+}
+
+// Auth authenticates requests using whichever Authenticator handles the
+// scheme in their Authorization header.
+type Auth struct {
+	authenticators map[string]Authenticator
+}
+
+// NewAuth returns an Auth for the configuration. nil is returned if the
+// configuration doesn't enable any authenticators.
+func NewAuth(config *AuthConfig) (*Auth, error) {
+	a := &Auth{authenticators: make(map[string]Authenticator)}
+	if len(config.HMACSecrets) > 0 {
+		a.Add(NewHMACAuthenticator(config.HMACSecrets, time.Duration(config.HMACMaxSkew)*time.Second))
+	}
+	if config.JWKSFile != "" {
+		jwt, err := NewJWTAuthenticator(config)
+		if err != nil {
+			return nil, err
+		}
+		a.Add(jwt)
+	}
+	if len(a.authenticators) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Add registers an Authenticator for its scheme.
+func (a *Auth) Add(authenticator Authenticator) {
+	a.authenticators[strings.ToLower(authenticator.Scheme())] = authenticator
+}
+
+// Schemes returns the schemes that are supported, for the WWW-Authenticate
+// header.
+func (a *Auth) Schemes() []string {
+	var retval []string
+	for _, authenticator := range a.authenticators {
+		retval = append(retval, authenticator.Scheme())
+	}
+	sort.Strings(retval)
+	return retval
+}
+
+// Authenticate returns the Principal for a request.
+func (a *Auth) Authenticate(request *http.Request) (*Principal, error) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrNoCredentials
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCredentials
+	}
+	authenticator, ok := a.authenticators[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, ErrUnsupportedScheme
+	}
+	return authenticator.Authenticate(request, strings.TrimSpace(parts[1]))
+}
+
+// CanAccessJob returns true if 'p' can see the job.
+func CanAccessJob(p *Principal, job *JobRecord) bool {
+	return p != nil && (p.Admin || p.Subject == job.Submitter)
+}
+
+// HMACAuthenticator authenticates service-to-service requests signed with a
+// shared secret. The Authorization header looks like this:
+//
+//	Authorization: HMAC <service>:<unix-timestamp>:<signature>
+//
+// where the signature is the hex encoded HMAC-SHA256, keyed with the service's
+// secret, of the request method, the request URI (path and query string), the
+// timestamp, and the hex encoded SHA256 of the request body, each followed by
+// a newline. See SignRequest.
+type HMACAuthenticator struct {
+	secrets map[string][]byte
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewHMACAuthenticator returns an HMACAuthenticator for the services' secrets.
+func NewHMACAuthenticator(secrets map[string]string, maxSkew time.Duration) *HMACAuthenticator {
+	if maxSkew <= 0 {
+		maxSkew = defaultHMACMaxSkew
+	}
+	a := &HMACAuthenticator{
+		secrets: make(map[string][]byte),
+		maxSkew: maxSkew,
+		now:     time.Now,
+	}
+	for service, secret := range secrets {
+		a.secrets[service] = []byte(secret)
+	}
+	return a
+}
+
+// Scheme implements Authenticator.
+func (a *HMACAuthenticator) Scheme() string {
+	return "HMAC"
+}
+
+// readBody reads the request body and replaces it so that handlers can still
+// read it.
+func readBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil {
+		return []byte{}, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxSignedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, fmt.Errorf("the request body is larger than %d bytes", maxSignedBodySize)
+	}
+	request.Body.Close()
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// hmacSignature returns the hex encoded signature of a request.
+func hmacSignature(secret []byte, method, uri, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", method, uri, timestamp, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the Authorization header of a request that's going to be
+// made by 'service' using its secret. The request body is read and replaced.
+func SignRequest(request *http.Request, service, secret string, now time.Time) error {
+	body, err := readBody(request)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := hmacSignature([]byte(secret), request.Method, request.URL.RequestURI(), timestamp, body)
+	request.Header.Set("Authorization", fmt.Sprintf("HMAC %s:%s:%s", service, timestamp, signature))
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (a *HMACAuthenticator) Authenticate(request *http.Request, credentials string) (*Principal, error) {
+	parts := strings.Split(credentials, ":")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+	service, timestamp, signature := parts[0], parts[1], parts[2]
+	secret, ok := a.secrets[service]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	skew := a.now().Sub(time.Unix(seconds, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, ErrExpiredCredentials
+	}
+	body, err := readBody(request)
+	if err != nil {
+		return nil, err
+	}
+	expected := hmacSignature(secret, request.Method, request.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Subject: service, Admin: true, Service: true}, nil
+}
+
+// jsonWebKey is a key in a JSON Web Key Set. Only RSA keys are used.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// decodeSegment decodes the unpadded base64url encoding used by JWTs and JWKs.
+func decodeSegment(s string) ([]byte, error) {
+	if m := len(s) % 4; m != 0 {
+		s += strings.Repeat("=", 4-m)
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// ParseJWKS returns the RSA signing keys in a JSON Web Key Set, keyed by their
+// key IDs.
+func ParseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s has an invalid modulus: %s", k.Kid, err)
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %s has an invalid exponent", k.Kid)
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("the key set doesn't contain any RSA signing keys")
+	}
+	return keys, nil
+}
+
+// JWTAuthenticator authenticates users with RS256 JWT bearer tokens that are
+// signed by one of the keys in a JWKS file.
+type JWTAuthenticator struct {
+	path          string
+	issuer        string
+	audience      string
+	usernameClaim string
+	adminRole     string
+	now           func() time.Time
+
+	mutex      sync.Mutex
+	keys       map[string]*rsa.PublicKey
+	lastLoaded time.Time
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator that uses the keys in the
+// configured JWKS file.
+func NewJWTAuthenticator(config *AuthConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		path:          config.JWKSFile,
+		issuer:        config.JWTIssuer,
+		audience:      config.JWTAudience,
+		usernameClaim: config.JWTUsernameClaim,
+		adminRole:     config.JWTAdminRole,
+		now:           time.Now,
+	}
+	if a.usernameClaim == "" {
+		a.usernameClaim = defaultJWTUsernameClaim
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// load reads the keys in the JWKS file. The caller must hold the mutex or be
+// the constructor.
+func (a *JWTAuthenticator) load() error {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", a.path, err)
+	}
+	a.keys = keys
+	a.lastLoaded = a.now()
+	return nil
+}
+
+// key returns the key with the key ID. If there isn't one, the JWKS file is
+// reloaded in case the keys were rotated, but no more than once every
+// jwksReloadInterval. Tokens without a key ID can be used if there's only one
+// key.
+func (a *JWTAuthenticator) key(kid string) *rsa.PublicKey {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	lookup := func() *rsa.PublicKey {
+		if kid == "" && len(a.keys) == 1 {
+			for _, k := range a.keys {
+				return k
+			}
+		}
+		return a.keys[kid]
+	}
+	if k := lookup(); k != nil {
+		return k
+	}
+	if a.now().Sub(a.lastLoaded) < jwksReloadInterval {
+		return nil
+	}
+	if err := a.load(); err != nil {
+		logger.Printf("Error reloading the JWKS file: %s", err)
+		a.lastLoaded = a.now()
+		return nil
+	}
+	return lookup()
+}
+
+// Scheme implements Authenticator.
+func (a *JWTAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+// jwtClaims are the claims that are checked. Audience can be a string or a
+// list of strings.
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Audience  interface{} `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	Roles     []string    `json:"roles"`
+}
+
+// hasAudience returns true if the aud claim is or contains the audience.
+func (c *jwtClaims) hasAudience(audience string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(request *http.Request, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	// Only RS256 is accepted so that tokens can't pick a weaker algorithm, or
+	// none at all.
+	if header.Alg != "RS256" {
+		return nil, ErrInvalidCredentials
+	}
+	key := a.key(header.Kid)
+	if key == nil {
+		return nil, ErrInvalidCredentials
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	var claims jwtClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	now := a.now()
+	if claims.ExpiresAt == nil || now.Add(-jwtLeeway).After(time.Unix(int64(*claims.ExpiresAt), 0)) {
+		return nil, ErrExpiredCredentials
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, ErrInvalidCredentials
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, ErrInvalidCredentials
+	}
+	if a.audience != "" && !claims.hasAudience(a.audience) {
+		return nil, ErrInvalidCredentials
+	}
+	var all map[string]interface{}
+	if err = json.Unmarshal(payload, &all); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	username, _ := all[a.usernameClaim].(string)
+	if username == "" {
+		return nil, ErrInvalidCredentials
+	}
+	p := &Principal{Subject: username}
+	for _, role := range claims.Roles {
+		if a.adminRole != "" && role == a.adminRole {
+			p.Admin = true
+		}
+	}
+	return p, nil
+}
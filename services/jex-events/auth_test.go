@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeSegment(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Unix(1439300000, 0)
+	a := NewHMACAuthenticator(map[string]string{"jex": "s3cret"}, 0)
+	a.now = func() time.Time { return now }
+
+	request, _ := http.NewRequest("POST", "/jobs?x=1", strings.NewReader(`{"Submitter":"ipcdev"}`))
+	if err := SignRequest(request, "jex", "s3cret", now); err != nil {
+		t.Fatal(err)
+	}
+	credentials := strings.TrimPrefix(request.Header.Get("Authorization"), "HMAC ")
+	p, err := a.Authenticate(request, credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "jex" || !p.Admin || !p.Service {
+		t.Errorf("The principal was %#v", p)
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if string(body) != `{"Submitter":"ipcdev"}` {
+		t.Errorf("The body wasn't restored after it was checked: %s", body)
+	}
+
+	tampered, _ := http.NewRequest("POST", "/jobs?x=1", strings.NewReader(`{"Submitter":"someone"}`))
+	if _, err = a.Authenticate(tampered, credentials); err != ErrInvalidCredentials {
+		t.Errorf("A tampered body returned %v", err)
+	}
+
+	stale, _ := http.NewRequest("GET", "/jobs", nil)
+	SignRequest(stale, "jex", "s3cret", now.Add(-time.Hour))
+	if _, err = a.Authenticate(stale, strings.TrimPrefix(stale.Header.Get("Authorization"), "HMAC ")); err != ErrExpiredCredentials {
+		t.Errorf("A stale signature returned %v", err)
+	}
+
+	unknown, _ := http.NewRequest("GET", "/jobs", nil)
+	SignRequest(unknown, "nobody", "s3cret", now)
+	if _, err = a.Authenticate(unknown, strings.TrimPrefix(unknown.Header.Get("Authorization"), "HMAC ")); err != ErrInvalidCredentials {
+		t.Errorf("An unknown service returned %v", err)
+	}
+}
+
+// testJWT holds a key pair and a JWTAuthenticator that trusts it.
+type testJWT struct {
+	key  *rsa.PrivateKey
+	auth *JWTAuthenticator
+	now  time.Time
+}
+
+func newTestJWT(t *testing.T) *testJWT {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   encodeSegment(key.N.Bytes()),
+				"e":   encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+			},
+		},
+	})
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(jwks)
+	auth, err := NewJWTAuthenticator(&AuthConfig{
+		JWKSFile:     f.Name(),
+		JWTIssuer:    "https://auth.example.org",
+		JWTAudience:  "jex-events",
+		JWTAdminRole: "de-admins",
+	})
+	os.Remove(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1439300000, 0)
+	auth.now = func() time.Time { return now }
+	return &testJWT{key: key, auth: auth, now: now}
+}
+
+func (j *testJWT) token(t *testing.T, alg string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encodeSegment(header) + "." + encodeSegment(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, j.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + encodeSegment(signature)
+}
+
+func (j *testJWT) claims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": "ipcdev",
+		"iss": "https://auth.example.org",
+		"aud": []string{"jex-events"},
+		"exp": j.now.Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	j := newTestJWT(t)
+	p, err := j.auth.Authenticate(nil, j.token(t, "RS256", j.claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "ipcdev" || p.Admin {
+		t.Errorf("The principal was %#v", p)
+	}
+	p, err = j.auth.Authenticate(nil, j.token(t, "RS256", j.claims(map[string]interface{}{"roles": []string{"de-admins"}})))
+	if err != nil || !p.Admin {
+		t.Errorf("The admin role wasn't recognized: %#v, %v", p, err)
+	}
+	cases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", j.token(t, "RS256", j.claims(map[string]interface{}{"exp": j.now.Add(-time.Hour).Unix()})), ErrExpiredCredentials},
+		{"no exp", j.token(t, "RS256", j.claims(map[string]interface{}{"exp": nil})), ErrExpiredCredentials},
+		{"issuer", j.token(t, "RS256", j.claims(map[string]interface{}{"iss": "https://evil.example.org"})), ErrInvalidCredentials},
+		{"audience", j.token(t, "RS256", j.claims(map[string]interface{}{"aud": "something-else"})), ErrInvalidCredentials},
+		{"algorithm", j.token(t, "HS256", j.claims(nil)), ErrInvalidCredentials},
+		{"garbage", "not.a.token", ErrInvalidCredentials},
+	}
+	for _, c := range cases {
+		if _, err = j.auth.Authenticate(nil, c.token); err != c.want {
+			t.Errorf("The %s case returned %v instead of %v", c.name, err, c.want)
+		}
+	}
+	parts := strings.Split(j.token(t, "RS256", j.claims(nil)), ".")
+	forged, _ := json.Marshal(j.claims(map[string]interface{}{"sub": "someone-else"}))
+	parts[1] = encodeSegment(forged)
+	if _, err = j.auth.Authenticate(nil, strings.Join(parts, ".")); err != ErrInvalidCredentials {
+		t.Errorf("A forged payload returned %v", err)
+	}
+}
+
+func TestRouterAuth(t *testing.T) {
+	j := newTestJWT(t)
+	auth := &Auth{authenticators: make(map[string]Authenticator)}
+	auth.Add(j.auth)
+	var seen *Principal
+	r := NewRouter()
+	r.Auth = auth
+	handler := func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+		seen = p
+	}
+	r.Handle("GET", "/public", AccessPublic, handler)
+	r.Handle("GET", "/user", AccessUser, handler)
+	r.Handle("GET", "/admin", AccessAdmin, handler)
+
+	serve := func(path, token string) int {
+		seen = nil
+		request, _ := http.NewRequest("GET", path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	user := j.token(t, "RS256", j.claims(nil))
+	admin := j.token(t, "RS256", j.claims(map[string]interface{}{"roles": []string{"de-admins"}}))
+	if code := serve("/public", ""); code != http.StatusOK {
+		t.Errorf("The public route returned %d", code)
+	}
+	if code := serve("/user", ""); code != http.StatusUnauthorized {
+		t.Errorf("The user route returned %d without credentials", code)
+	}
+	if code := serve("/user", user); code != http.StatusOK || seen == nil || seen.Subject != "ipcdev" {
+		t.Errorf("The user route returned %d for %#v", code, seen)
+	}
+	if code := serve("/admin", user); code != http.StatusForbidden {
+		t.Errorf("The admin route returned %d for a user", code)
+	}
+	if code := serve("/admin", admin); code != http.StatusOK {
+		t.Errorf("The admin route returned %d for an admin", code)
+	}
+}
+
+func TestCanAccessJob(t *testing.T) {
+	job := &JobRecord{Submitter: "ipcdev"}
+	cases := []struct {
+		p    *Principal
+		want bool
+	}{
+		{nil, false},
+		{&Principal{Subject: "ipcdev"}, true},
+		{&Principal{Subject: "someone-else"}, false},
+		{&Principal{Subject: "someone-else", Admin: true}, true},
+	}
+	for _, c := range cases {
+		if got := CanAccessJob(c.p, job); got != c.want {
+			t.Errorf("CanAccessJob returned %t for %#v", got, c.p)
+		}
+	}
+}
+
+func TestRouterWithoutAuth(t *testing.T) {
+	var seen *Principal
+	r := NewRouter()
+	handler := func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+		seen = p
+	}
+	r.Handle("GET", "/public", AccessPublic, handler)
+	r.Handle("GET", "/admin", AccessAdmin, handler)
+	serve := func(path string) int {
+		seen = nil
+		request, _ := http.NewRequest("GET", path, nil)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := serve("/public"); code != http.StatusOK {
+		t.Errorf("The public route returned %d without Auth", code)
+	}
+	if code := serve("/admin"); code != http.StatusServiceUnavailable || seen != nil {
+		t.Errorf("The admin route returned %d without Auth", code)
+	}
+	r.AllowUnauthenticated = true
+	if code := serve("/admin"); code != http.StatusOK || seen == nil || !seen.Admin {
+		t.Errorf("The admin route returned %d for %#v with AllowUnauthenticated", code, seen)
+	}
+}
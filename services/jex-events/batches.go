@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// BatchMember is a job in a batch along with the status it's currently in and
+// the times it started and finished according to its status transitions.
+type BatchMember struct {
+	JobRecord
+	Status    string
+	Started   time.Time
+	Completed time.Time
+}
+
+// BatchSummary is the aggregated view of a batch: the batch's own job, all of
+// the jobs whose BatchID points at it, and the numbers computed from them.
+//
+// Counts maps a status to the number of jobs in it. Progress is the percentage
+// of jobs that have reached a terminal status. EarliestStart is when the first
+// job started running and LatestCompletion is when the last job finished; it's
+// only set once all of the jobs have finished. Status is the aggregate status
+// computed from the jobs by BatchStatus.
+type BatchSummary struct {
+	Batch            JobRecord
+	Jobs             []BatchMember
+	Counts           map[string]int
+	Total            int
+	Progress         float64
+	EarliestStart    time.Time
+	LatestCompletion time.Time
+	Status           string
+}
+
+// NewBatchMember returns a BatchMember for the job, using its transitions
+// (oldest first) to work out its status and when it started and finished.
+// Jobs that haven't transitioned yet are considered to be Submitted, since
+// they wouldn't be in the database otherwise.
+func NewBatchMember(job JobRecord, transitions []JobStatusTransition) BatchMember {
+	member := BatchMember{
+		JobRecord: job,
+		Status:    StatusSubmitted,
+	}
+	for _, t := range transitions {
+		member.Status = t.ToStatus
+		if t.ToStatus == StatusRunning && member.Started.IsZero() {
+			member.Started = t.DateTriggered
+		}
+		if IsTerminalStatus(t.ToStatus) {
+			member.Completed = t.DateTriggered
+		}
+	}
+	return member
+}
+
+// BatchStatus computes the status of a batch from the statuses of its jobs.
+// A batch is Completed once all of its jobs have completed, and Failed once
+// all of its jobs have finished and at least one of them failed. Until then
+// it's Running if any of its jobs are running or have finished, Held if all of
+// the unfinished jobs are held, and Submitted otherwise.
+func BatchStatus(counts map[string]int, total int) string {
+	finished := counts[StatusCompleted] + counts[StatusFailed]
+	switch {
+	case total == 0:
+		return StatusSubmitted
+	case finished == total && counts[StatusFailed] > 0:
+		return StatusFailed
+	case finished == total:
+		return StatusCompleted
+	case counts[StatusHeld] > 0 && counts[StatusHeld]+finished == total:
+		return StatusHeld
+	case counts[StatusRunning] > 0 || counts[StatusEvicted] > 0 || finished > 0:
+		return StatusRunning
+	case counts[StatusHeld] > 0:
+		return StatusHeld
+	default:
+		return StatusSubmitted
+	}
+}
+
+// SummarizeBatch aggregates the batch's members into a BatchSummary.
+func SummarizeBatch(batch JobRecord, members []BatchMember) *BatchSummary {
+	summary := &BatchSummary{
+		Batch:  batch,
+		Jobs:   members,
+		Counts: make(map[string]int),
+		Total:  len(members),
+	}
+	if summary.Jobs == nil {
+		summary.Jobs = []BatchMember{}
+	}
+	finished := 0
+	for _, member := range members {
+		summary.Counts[member.Status] = summary.Counts[member.Status] + 1
+		if !member.Started.IsZero() && (summary.EarliestStart.IsZero() || member.Started.Before(summary.EarliestStart)) {
+			summary.EarliestStart = member.Started
+		}
+		if IsTerminalStatus(member.Status) {
+			finished = finished + 1
+			if member.Completed.After(summary.LatestCompletion) {
+				summary.LatestCompletion = member.Completed
+			}
+		}
+	}
+	if finished != summary.Total {
+		summary.LatestCompletion = time.Time{}
+	}
+	if summary.Total > 0 {
+		summary.Progress = float64(finished) * 100 / float64(summary.Total)
+	}
+	summary.Status = BatchStatus(summary.Counts, summary.Total)
+	return summary
+}
+
+// IsFinished returns true if every job in the batch has reached a terminal
+// status.
+func (b *BatchSummary) IsFinished() bool {
+	return b.Total > 0 && IsTerminalStatus(b.Status)
+}
+
+// Message returns a short description of how the batch turned out.
+func (b *BatchSummary) Message() string {
+	return fmt.Sprintf("batch %s: %d of %d failed", b.Status, b.Counts[StatusFailed], b.Total)
+}
+
+// LoadBatchSummary looks up the batch's job and its members and aggregates
+// them into a BatchSummary. sql.ErrNoRows is returned if there isn't a job with
+// an ID of 'batchID'.
+func LoadBatchSummary(d JobStore, batchID string) (*BatchSummary, error) {
+	batch, err := d.GetJob(batchID)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := d.GetBatchJobs(batchID)
+	if err != nil {
+		return nil, err
+	}
+	var members []BatchMember
+	for _, job := range jobs {
+		transitions, err := d.GetJobStatusTransitions(job.ID)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, NewBatchMember(job, transitions))
+	}
+	return SummarizeBatch(*batch, members), nil
+}
+
+// BatchJobFinished is called when a job in a batch reaches a terminal status.
+// If that was the last job in the batch to finish, the batch's aggregate status
+// is sent to the notifiers, once. Batches that were already reported as failed
+// because they went over their failure threshold aren't reported again.
+func (p *PostEventHandler) BatchJobFinished(job *JobRecord, event *Event) error {
+	summary, err := LoadBatchSummary(p.DB, job.BatchID)
+	if err != nil {
+		return err
+	}
+	if !summary.IsFinished() {
+		return nil
+	}
+	enforced, err := p.DB.HasJobFlag(summary.Batch.ID, FlagFailureThresholdEnforced)
+	if err != nil {
+		return err
+	}
+	if enforced {
+		logger.Printf("Batch %s finished, but it was already reported as failed", summary.Batch.ID)
+		return nil
+	}
+	first, err := p.DB.SetJobFlag(summary.Batch.ID, FlagBatchFinishedReported, time.Now())
+	if err != nil || !first {
+		return err
+	}
+	logger.Printf("Batch %s finished: %s", summary.Batch.ID, summary.Message())
+	batch := &summary.Batch
+	return p.Notifiers.Notify(&StatusChange{
+		Status:         summary.Status,
+		CompletionDate: fmt.Sprintf("%d", summary.LatestCompletion.UnixNano()/int64(time.Millisecond)),
+		InvocationID:   batch.InvocationID,
+		CondorID:       batch.CondorID,
+		AppID:          batch.AppID,
+		User:           batch.Submitter,
+		ExitCode:       batch.ExitCode,
+		EventNumber:    event.EventNumber,
+		Message:        summary.Message(),
+		Hash:           fmt.Sprintf("batch:%s", batch.ID),
+	})
+}
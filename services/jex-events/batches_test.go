@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBatchMember(t *testing.T) {
+	start := time.Date(2015, 8, 9, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	transitions := []JobStatusTransition{
+		{ToStatus: StatusSubmitted, DateTriggered: start.Add(-time.Minute)},
+		{ToStatus: StatusRunning, DateTriggered: start},
+		{ToStatus: StatusHeld, DateTriggered: start.Add(time.Minute)},
+		{ToStatus: StatusRunning, DateTriggered: start.Add(2 * time.Minute)},
+		{ToStatus: StatusCompleted, DateTriggered: end},
+	}
+	m := NewBatchMember(JobRecord{ID: "job"}, transitions)
+	if m.Status != StatusCompleted {
+		t.Errorf("The status was %s instead of %s", m.Status, StatusCompleted)
+	}
+	if !m.Started.Equal(start) {
+		t.Errorf("Started was %s instead of %s", m.Started, start)
+	}
+	if !m.Completed.Equal(end) {
+		t.Errorf("Completed was %s instead of %s", m.Completed, end)
+	}
+	m = NewBatchMember(JobRecord{ID: "new"}, nil)
+	if m.Status != StatusSubmitted {
+		t.Errorf("A job without transitions had a status of %s", m.Status)
+	}
+}
+
+func TestBatchStatus(t *testing.T) {
+	cases := []struct {
+		counts   map[string]int
+		total    int
+		expected string
+	}{
+		{map[string]int{}, 0, StatusSubmitted},
+		{map[string]int{StatusSubmitted: 3}, 3, StatusSubmitted},
+		{map[string]int{StatusSubmitted: 2, StatusRunning: 1}, 3, StatusRunning},
+		{map[string]int{StatusSubmitted: 2, StatusCompleted: 1}, 3, StatusRunning},
+		{map[string]int{StatusHeld: 2, StatusCompleted: 1}, 3, StatusHeld},
+		{map[string]int{StatusCompleted: 3}, 3, StatusCompleted},
+		{map[string]int{StatusCompleted: 2, StatusFailed: 1}, 3, StatusFailed},
+	}
+	for _, c := range cases {
+		if actual := BatchStatus(c.counts, c.total); actual != c.expected {
+			t.Errorf("BatchStatus(%v, %d) returned %s instead of %s", c.counts, c.total, actual, c.expected)
+		}
+	}
+}
+
+func TestSummarizeBatch(t *testing.T) {
+	start := time.Date(2015, 8, 9, 10, 0, 0, 0, time.UTC)
+	members := []BatchMember{
+		{JobRecord: JobRecord{ID: "a"}, Status: StatusCompleted, Started: start.Add(time.Minute), Completed: start.Add(time.Hour)},
+		{JobRecord: JobRecord{ID: "b"}, Status: StatusFailed, Started: start, Completed: start.Add(2 * time.Hour)},
+		{JobRecord: JobRecord{ID: "c"}, Status: StatusRunning, Started: start.Add(2 * time.Minute)},
+		{JobRecord: JobRecord{ID: "d"}, Status: StatusSubmitted},
+	}
+	s := SummarizeBatch(JobRecord{ID: "batch"}, members)
+	if s.Total != 4 {
+		t.Errorf("Total was %d instead of 4", s.Total)
+	}
+	if s.Counts[StatusCompleted] != 1 || s.Counts[StatusFailed] != 1 || s.Counts[StatusRunning] != 1 || s.Counts[StatusSubmitted] != 1 {
+		t.Errorf("The counts were wrong: %v", s.Counts)
+	}
+	if s.Progress != 50 {
+		t.Errorf("Progress was %f instead of 50", s.Progress)
+	}
+	if !s.EarliestStart.Equal(start) {
+		t.Errorf("EarliestStart was %s instead of %s", s.EarliestStart, start)
+	}
+	if !s.LatestCompletion.IsZero() {
+		t.Errorf("LatestCompletion was set before the batch finished: %s", s.LatestCompletion)
+	}
+	if s.Status != StatusRunning || s.IsFinished() {
+		t.Errorf("The batch was %s instead of %s", s.Status, StatusRunning)
+	}
+
+	members[2].Status = StatusCompleted
+	members[2].Completed = start.Add(3 * time.Hour)
+	members[3].Status = StatusCompleted
+	members[3].Completed = start.Add(90 * time.Minute)
+	s = SummarizeBatch(JobRecord{ID: "batch"}, members)
+	if !s.LatestCompletion.Equal(start.Add(3 * time.Hour)) {
+		t.Errorf("LatestCompletion was %s", s.LatestCompletion)
+	}
+	if s.Status != StatusFailed || !s.IsFinished() {
+		t.Errorf("The finished batch was %s instead of %s", s.Status, StatusFailed)
+	}
+	if s.Message() != "batch Failed: 1 of 4 failed" {
+		t.Errorf("The message was '%s'", s.Message())
+	}
+}
+
+func TestBatchJobFinished(t *testing.T) {
+	p, r := newRecordingHandler()
+	batchID, err := p.DB.InsertJob(&JobRecord{CondorID: "1", Submitter: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := p.DB.PatchJob(processJobEvents(t, p.DB, 2).ID, &JobRequest{BatchID: &batchID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Somebody asking for the batch to be stopped doesn't keep it from being
+	// reported when it finishes.
+	_, err = p.DB.InsertCondorJobStopRequest(&CondorJobStopRequest{JobID: batchID, Username: "test", DateRequested: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &Event{EventNumber: "005"}
+	if err = p.BatchJobFinished(job, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.changes) != 1 || r.changes[0].Status != StatusCompleted {
+		t.Fatalf("The finished batch was reported as %v", describeChanges(r.changes))
+	}
+
+	// It's only reported once.
+	if err = p.BatchJobFinished(job, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.changes) != 1 {
+		t.Errorf("The finished batch was reported again: %v", describeChanges(r.changes))
+	}
+}
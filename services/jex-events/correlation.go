@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+const (
+	defaultInvocationLookupInterval = 5 * time.Minute
+	invocationLookupTimeout         = 30 * time.Second
+
+	// orphanLookupWindow is how long the JEX keeps getting asked about a job
+	// without an invocation ID after the job's last event.
+	orphanLookupWindow = 24 * time.Hour
+)
+
+// Backfill sends the current status of a job upstream again once it's linked
+// to its invocation, since the updates that were sent for it before didn't say
+// which analysis they were for. The status comes from the job's last event.
+// Nothing is sent if the job doesn't have a last event, or if the last event is
+// in 'sent', which holds the IDs of the job events that already went out with
+// the invocation ID.
+func (p *PostEventHandler) Backfill(job *JobRecord, sent map[string]bool) error {
+	last, err := p.DB.GetLastCondorJobEvent(job.ID)
+	if err == sql.ErrNoRows || (err == nil && sent[last.CondorJobEventID]) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	je, err := p.DB.GetCondorJobEvent(last.CondorJobEventID)
+	if err != nil {
+		return err
+	}
+	re, err := p.DB.GetCondorRawEvent(je.CondorRawEventID)
+	if err != nil {
+		return err
+	}
+	event := &Event{Event: re.EventText, Hash: je.Hash}
+	event.parse()
+	event.JobID = job.ID
+	event.CondorID = job.CondorID
+	event.InvocationID = job.InvocationID
+	event.AppID = job.AppID
+	event.User = job.Submitter
+
+	// held jobs are released or killed when the event first comes in, and
+	// that's not something to do twice.
+	switch event.Mapping().Action {
+	case ActionHeld, ActionUnrouted:
+		return nil
+	}
+	logger.Printf("Sending the '%s' status of job %s upstream again now that it's linked to invocation %s", JobStatusStatus(event), job.ID, job.InvocationID)
+	return p.notify(event)
+}
+
+// LinkInvocation sets the invocation ID of a job that doesn't have one yet and
+// backfills its status. An invocation ID can't be linked to more than one job.
+func (p *PostEventHandler) LinkInvocation(job *JobRecord, invocationID string) error {
+	other, err := p.DB.GetJobByInvocationID(invocationID)
+	if err != nil {
+		return err
+	}
+	if other != nil && other.ID != job.ID {
+		return fmt.Errorf("invocation %s is already linked to job %s", invocationID, other.ID)
+	}
+	linked, err := p.DB.PatchJob(job.ID, &JobRequest{InvocationID: &invocationID})
+	if err != nil {
+		return err
+	}
+	*job = *linked
+	return p.Backfill(job, nil)
+}
+
+// Correlator links the jobs that received events without ever finding out
+// their invocation IDs by asking the JEX about them. The jobs that have had an
+// event in the last Window are looked up every Interval. URL is a template
+// that's executed with the JobRecord to get the URL to look a job up at. The
+// JEX should respond with a JSON object with the invocation ID in its uuid
+// field, or with a 404 if it doesn't know about the job.
+type Correlator struct {
+	Events   *PostEventHandler
+	URL      *template.Template
+	Interval time.Duration
+	Window   time.Duration
+	Client   *http.Client
+}
+
+// NewCorrelator returns a pointer to a Correlator set up from the
+// configuration. nil is returned if InvocationLookupURL isn't set.
+func NewCorrelator(cfg *Configuration, events *PostEventHandler) (*Correlator, error) {
+	if cfg.InvocationLookupURL == "" {
+		return nil, nil
+	}
+	t, err := template.New("InvocationLookupURL").Parse(cfg.InvocationLookupURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Correlator{
+		Events:   events,
+		URL:      t,
+		Interval: configDuration(cfg.InvocationLookupInterval, defaultInvocationLookupInterval),
+		Window:   orphanLookupWindow,
+		Client:   &http.Client{Timeout: invocationLookupTimeout},
+	}, nil
+}
+
+// Run links orphaned jobs every Interval until something is sent on the quit
+// channel.
+func (c *Correlator) Run(quit <-chan int) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.LinkOrphans(time.Now()); err != nil {
+			logger.Printf("Error linking jobs to their invocations: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// LinkOrphans looks up the jobs without invocation IDs that have had an event
+// since Window before 'now' and links the ones that the JEX knows about. Jobs
+// that can't be looked up are logged and skipped. The number of jobs that were
+// linked is returned.
+func (c *Correlator) LinkOrphans(now time.Time) (int, error) {
+	jobs, err := c.Events.DB.GetOrphanedJobs(now.Add(-c.Window))
+	if err != nil {
+		return 0, err
+	}
+	linked := 0
+	for i := range jobs {
+		job := &jobs[i]
+		invocationID, err := c.Lookup(job)
+		if err != nil {
+			logger.Printf("Error looking up the invocation ID for job %s: %s", job.ID, err)
+			continue
+		}
+		if invocationID == "" {
+			continue
+		}
+		if err = c.Events.LinkInvocation(job, invocationID); err != nil {
+			logger.Printf("Error linking job %s to invocation %s: %s", job.ID, invocationID, err)
+			continue
+		}
+		logger.Printf("Linked job %s to invocation %s", job.ID, invocationID)
+		linked++
+	}
+	return linked, nil
+}
+
+// Lookup asks the JEX for the invocation ID of the job. An empty string is
+// returned if the JEX doesn't know about it.
+func (c *Correlator) Lookup(job *JobRecord) (string, error) {
+	var u bytes.Buffer
+	if err := c.URL.Execute(&u, job); err != nil {
+		return "", err
+	}
+	resp, err := c.Client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s returned %s", u.String(), resp.Status)
+	}
+	var found struct {
+		UUID string `json:"uuid"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return "", err
+	}
+	if found.UUID != "" && uuid.Parse(found.UUID) == nil {
+		return "", fmt.Errorf("%s returned an invocation ID that isn't a UUID: %s", u.String(), found.UUID)
+	}
+	return found.UUID, nil
+}
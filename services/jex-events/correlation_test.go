@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+const (
+	submitEventWithAd = "000 (%d.000.000) 08/11/15 12:00:00 Job submitted from host: <127.0.0.1:9618>\n\tIpcUuid = \"%s\"\n"
+	jobAdEvent        = "028 (%d.000.000) 08/11/15 12:02:00 Job ad information event triggered.\nIpcUuid = \"%s\"\n"
+)
+
+// newRecordingHandler returns a PostEventHandler that stores everything in a
+// new MemoryStore and records the status changes it sends.
+func newRecordingHandler() (*PostEventHandler, *recordingNotifier) {
+	r := &recordingNotifier{}
+	return &PostEventHandler{DB: NewMemoryStore(), Notifiers: Notifiers{{Name: "recording", Notifier: r}}}, r
+}
+
+// processTexts runs the event texts through ProcessEvents as one batch.
+func processTexts(t *testing.T, p *PostEventHandler, texts ...string) {
+	var bodies [][]byte
+	for _, text := range texts {
+		bodies = append(bodies, condorEventDelivery(t, text).Body)
+	}
+	ProcessEvents(p.DB, p, bodies)
+}
+
+// describeChanges lists the statuses and invocation IDs of the changes.
+func describeChanges(changes []*StatusChange) []string {
+	var described []string
+	for _, c := range changes {
+		described = append(described, fmt.Sprintf("%s %s", c.Status, c.InvocationID))
+	}
+	return described
+}
+
+func TestParseInvocationID(t *testing.T) {
+	invID := uuid.New()
+	e := &Event{Event: fmt.Sprintf(submitEventWithAd, 100, invID)}
+	e.Parse()
+	if e.InvocationID != invID {
+		t.Errorf("The invocation ID parsed out of the submit event was '%s'", e.InvocationID)
+	}
+	e = &Event{Event: fmt.Sprintf(jobAdEvent, 100, "not a uuid")}
+	e.Parse()
+	if e.InvocationID != "" {
+		t.Errorf("'%s' was parsed out as the invocation ID", e.InvocationID)
+	}
+	e = &Event{Event: fmt.Sprintf(replayEventTexts[1], 100) + "\tIpcUuid = \"" + invID + "\"\n"}
+	e.Parse()
+	if e.InvocationID != "" {
+		t.Error("The invocation ID was parsed out of an execute event")
+	}
+}
+
+func TestProcessEventsBackfill(t *testing.T) {
+	p, r := newRecordingHandler()
+	invID := uuid.New()
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(replayEventTexts[1], 100))
+	processTexts(t, p, fmt.Sprintf(jobAdEvent, 100, invID))
+	got := strings.Join(describeChanges(r.changes), ", ")
+	if want := fmt.Sprintf("Submitted , Running , Running %s", invID); got != want {
+		t.Errorf("The changes sent were '%s' instead of '%s'", got, want)
+	}
+	if job, _ := p.DB.GetJobByCondorID("100"); job == nil || job.InvocationID != invID {
+		t.Errorf("The job wasn't linked: %#v", job)
+	}
+
+	// Nothing is sent again for a job that's linked by its first event.
+	p, r = newRecordingHandler()
+	processTexts(t, p, fmt.Sprintf(submitEventWithAd, 101, invID), fmt.Sprintf(replayEventTexts[1], 101))
+	got = strings.Join(describeChanges(r.changes), ", ")
+	if want := fmt.Sprintf("Submitted %s, Running %s", invID, invID); got != want {
+		t.Errorf("The changes sent were '%s' instead of '%s'", got, want)
+	}
+}
+
+func TestJobHTTPPostBackfill(t *testing.T) {
+	p, r := newRecordingHandler()
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(replayEventTexts[1], 100))
+	api := &HTTPAPI{d: p.DB, events: p, allowUnauthenticated: true, stopTimeout: defaultStopTimeout}
+	server := httptest.NewServer(api.Router())
+	defer server.Close()
+
+	invID := uuid.New()
+	body := fmt.Sprintf(`{"CondorID" : "100", "Submitter" : "unit_tests", "AppID" : "%s", "InvocationID" : "%s"}`, uuid.New(), invID)
+	resp, err := http.Post(server.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(r.changes) != 3 || r.changes[2].Status != StatusRunning || r.changes[2].InvocationID != invID {
+		t.Errorf("The changes sent were %v", describeChanges(r.changes))
+	}
+}
+
+func TestNewCorrelator(t *testing.T) {
+	if c, err := NewCorrelator(&Configuration{}, nil); c != nil || err != nil {
+		t.Errorf("A correlator was set up without an InvocationLookupURL: %#v, %v", c, err)
+	}
+	if _, err := NewCorrelator(&Configuration{InvocationLookupURL: "http://jex/{{.CondorID"}, nil); err == nil {
+		t.Error("A correlator was set up with an invalid InvocationLookupURL")
+	}
+	c, err := NewCorrelator(&Configuration{InvocationLookupURL: "http://jex/condor-jobs/{{.CondorID}}"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Interval != defaultInvocationLookupInterval || c.Window != orphanLookupWindow {
+		t.Errorf("The correlator was %#v", c)
+	}
+}
+
+func TestCorrelatorLinkOrphans(t *testing.T) {
+	invID := uuid.New()
+	jex := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/condor-jobs/100":
+			fmt.Fprintf(w, `{"uuid" : "%s"}`, invID)
+		case "/condor-jobs/101":
+			fmt.Fprint(w, `{"uuid" : "not a uuid"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer jex.Close()
+
+	p, r := newRecordingHandler()
+	for _, condorID := range []int{100, 101, 102} {
+		processTexts(t, p, fmt.Sprintf(replayEventTexts[0], condorID), fmt.Sprintf(replayEventTexts[1], condorID))
+	}
+	c := &Correlator{
+		Events: p,
+		URL:    template.Must(template.New("url").Parse(jex.URL + "/condor-jobs/{{.CondorID}}")),
+		Window: time.Hour,
+		Client: http.DefaultClient,
+	}
+	r.changes = nil
+	linked, err := c.LinkOrphans(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != 1 {
+		t.Errorf("%d jobs were linked instead of 1", linked)
+	}
+	job, _ := p.DB.GetJobByCondorID("100")
+	if job == nil || job.InvocationID != invID {
+		t.Errorf("The job wasn't linked: %#v", job)
+	}
+	if len(r.changes) != 1 || r.changes[0].Status != StatusRunning || r.changes[0].InvocationID != invID {
+		t.Errorf("The changes sent were %v", describeChanges(r.changes))
+	}
+	if linked, _ = c.LinkOrphans(time.Now()); linked != 0 {
+		t.Errorf("%d jobs were linked again", linked)
+	}
+	if orphans, _ := p.DB.GetOrphanedJobs(time.Now().Add(time.Hour)); len(orphans) != 0 {
+		t.Errorf("Jobs without recent events were orphans: %#v", orphans)
+	}
+
+	other, _ := p.DB.GetJobByCondorID("102")
+	if err = p.LinkInvocation(other, invID); err == nil {
+		t.Error("An invocation ID was linked to a second job")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -11,6 +12,14 @@ import (
 type Databaser struct {
 	db         *sql.DB
 	ConnString string
+
+	// The statements for the queries that are run for every event are
+	// prepared the first time they're used and kept until the Databaser is
+	// closed. The condor_events rows rarely change, so they're cached by
+	// event number.
+	mutex        sync.Mutex
+	stmts        map[string]*sql.Stmt
+	condorEvents map[string]CondorEvent
 }
 
 // NewDatabaser returns a pointer to a Databaser instnace that has already
@@ -31,20 +40,60 @@ func NewDatabaser(connString string) (*Databaser, error) {
 	return databaser, nil
 }
 
+// Close closes the prepared statements and the connection to the database.
+func (d *Databaser) Close() error {
+	d.mutex.Lock()
+	for query, stmt := range d.stmts {
+		stmt.Close()
+		delete(d.stmts, query)
+	}
+	d.mutex.Unlock()
+	return d.db.Close()
+}
+
+// prepare returns a prepared statement for the query, preparing it if this is
+// the first time it's been used.
+func (d *Databaser) prepare(query string) (*sql.Stmt, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if stmt, ok := d.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if d.stmts == nil {
+		d.stmts = make(map[string]*sql.Stmt)
+	}
+	d.stmts[query] = stmt
+	return stmt, nil
+}
+
+// forgetCondorEvents empties the condor_events cache. It's called whenever
+// the condor_events table is changed.
+func (d *Databaser) forgetCondorEvents() {
+	d.mutex.Lock()
+	d.condorEvents = nil
+	d.mutex.Unlock()
+}
+
 // JobRecord is a type that contains info that goes into the jobs table.
 type JobRecord struct {
-	ID               string
-	BatchID          string
-	CondorID         string
-	Submitter        string
-	DateSubmitted    time.Time
-	DateStarted      time.Time
-	DateCompleted    time.Time
-	AppID            string
-	InvocationID     string
-	ExitCode         int
-	FailureThreshold int64
-	FailureCount     int64
+	ID                string
+	BatchID           string
+	CondorID          string
+	Submitter         string
+	DateSubmitted     time.Time
+	DateStarted       time.Time
+	DateCompleted     time.Time
+	AppID             string
+	InvocationID      string
+	ExitCode          int
+	FailureThreshold  int64
+	FailureCount      int64
+	TerminationKind   string
+	TerminationSignal int
 }
 
 // InsertJob adds a new JobRecord to the database.
@@ -61,7 +110,9 @@ func (d *Databaser) InsertJob(jr *JobRecord) (string, error) {
 		failure_threshold,
 		failure_count,
 		condor_id,
-		invocation_id
+		invocation_id,
+		termination_kind,
+		termination_signal
 	) VALUES (
 		cast($1 AS uuid),
 		$2,
@@ -73,8 +124,14 @@ func (d *Databaser) InsertJob(jr *JobRecord) (string, error) {
 		$8,
 		$9,
 		$10,
-		$11
+		$11,
+		$12,
+		$13
 	) RETURNING id`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return "", err
+	}
 	var fixedBatch *string
 	if jr.BatchID == "" {
 		fixedBatch = nil
@@ -94,8 +151,7 @@ func (d *Databaser) InsertJob(jr *JobRecord) (string, error) {
 		fixedInvID = &jr.InvocationID
 	}
 	var id string
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		fixedBatch,
 		jr.Submitter,
 		jr.DateSubmitted,
@@ -107,6 +163,8 @@ func (d *Databaser) InsertJob(jr *JobRecord) (string, error) {
 		jr.FailureCount,
 		jr.CondorID,
 		fixedInvID,
+		jr.TerminationKind,
+		jr.TerminationSignal,
 	).Scan(&id)
 	if err != nil {
 		return "", err
@@ -217,16 +275,22 @@ func (d *Databaser) GetJob(uuid string) (*JobRecord, error) {
 				 failure_threshold,
 				 failure_count,
 				 condor_id,
-				 invocation_id
+				 invocation_id,
+				 termination_kind,
+				 termination_signal
 	  FROM jobs
 	 WHERE id = cast($1 as uuid)
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
 	jr := &JobRecord{}
-	rows := d.db.QueryRow(query, uuid)
+	rows := stmt.QueryRow(uuid)
 	var batchid interface{}
 	var appid interface{}
 	var invid interface{}
-	err := rows.Scan(
+	err = rows.Scan(
 		&jr.ID,
 		&batchid,
 		&jr.Submitter,
@@ -239,6 +303,8 @@ func (d *Databaser) GetJob(uuid string) (*JobRecord, error) {
 		&jr.FailureCount,
 		&jr.CondorID,
 		&invid,
+		&jr.TerminationKind,
+		&jr.TerminationSignal,
 	)
 	// This evil has been perpetrated to avoid an issue where time.Time instances
 	// set to their zero value and stored in PostgreSQL with timezone info can
@@ -274,16 +340,22 @@ func (d *Databaser) GetJobByCondorID(condorID string) (*JobRecord, error) {
 				failure_threshold,
 				failure_count,
 				condor_id,
-				invocation_id
+				invocation_id,
+				termination_kind,
+				termination_signal
  	 FROM jobs
 	WHERE condor_id = $1
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
 	jr := &JobRecord{}
-	rows := d.db.QueryRow(query, condorID)
+	rows := stmt.QueryRow(condorID)
 	var batchid interface{}
 	var appid interface{}
 	var invid interface{}
-	err := rows.Scan(
+	err = rows.Scan(
 		&jr.ID,
 		&batchid,
 		&jr.Submitter,
@@ -296,6 +368,8 @@ func (d *Databaser) GetJobByCondorID(condorID string) (*JobRecord, error) {
 		&jr.FailureCount,
 		&jr.CondorID,
 		&invid,
+		&jr.TerminationKind,
+		&jr.TerminationSignal,
 	)
 	// This evil has been perpetrated to avoid an issue where time.Time instances
 	// set to their zero value and stored in PostgreSQL with timezone info can
@@ -331,7 +405,9 @@ func (d *Databaser) GetJobByInvocationID(invocationID string) (*JobRecord, error
 				 failure_threshold,
 				 failure_count,
 				 condor_id,
-				 invocation_id
+				 invocation_id,
+				 termination_kind,
+				 termination_signal
 	  FROM jobs
 	 WHERE invocation_id = cast($1 as uuid)
 	`
@@ -353,6 +429,8 @@ func (d *Databaser) GetJobByInvocationID(invocationID string) (*JobRecord, error
 		&jr.FailureCount,
 		&jr.CondorID,
 		&invid,
+		&jr.TerminationKind,
+		&jr.TerminationSignal,
 	)
 	// rows.Scan returns ErrNoRows if no rows were found.
 	if err == sql.ErrNoRows {
@@ -378,9 +456,22 @@ func (d *Databaser) GetJobByInvocationID(invocationID string) (*JobRecord, error
 	return jr, err
 }
 
-// UpdateJob updates a job instance in the database
-func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
-	query := `
+// PatchJob sets the fields that are in the request on the job with the given
+// ID and leaves the rest of them alone. sql.ErrNoRows is returned if the job
+// doesn't exist.
+func (d *Databaser) PatchJob(id string, r *JobRequest) (*JobRecord, error) {
+	query, args := r.UpdateQuery(id)
+	if query != "" {
+		var updated string
+		if err := d.db.QueryRow(query, args...).Scan(&updated); err != nil {
+			return nil, err
+		}
+	}
+	return d.GetJob(id)
+}
+
+// updateJobQuery replaces every column of a job.
+const updateJobQuery = `
 	UPDATE jobs
 		SET batch_id = cast($1 as uuid),
 				submitter = $2,
@@ -392,10 +483,34 @@ func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
 				failure_threshold = $8,
 				failure_count = $9,
 				condor_id = $10,
-				invocation_id = $11
-	WHERE id = cast($12 as uuid)
+				invocation_id = $11,
+				termination_kind = $12,
+				termination_signal = $13
+	WHERE id = cast($14 as uuid)
+	RETURNING id
+	`
+
+// updateEventJobQuery sets the columns of a job that its events decide. The
+// rest of them are left to the HTTP API. A job can be linked to its invocation
+// while a batch of its events is being handled, so the invocation ID is only
+// set if the job doesn't have one.
+const updateEventJobQuery = `
+	UPDATE jobs
+	   SET exit_code = $1,
+	       failure_count = $2,
+	       termination_kind = $3,
+	       termination_signal = $4,
+	       invocation_id = COALESCE(invocation_id, cast($5 as uuid))
+	 WHERE id = cast($6 as uuid)
 	RETURNING id
 	`
+
+// UpdateJob updates a job instance in the database
+func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
+	stmt, err := d.prepare(updateJobQuery)
+	if err != nil {
+		return nil, err
+	}
 	var id string
 	var batchid *string
 	if jr.BatchID == "" {
@@ -415,8 +530,7 @@ func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
 	} else {
 		invid = &jr.InvocationID
 	}
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		batchid,
 		jr.Submitter,
 		jr.DateSubmitted,
@@ -428,6 +542,8 @@ func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
 		jr.FailureCount,
 		jr.CondorID,
 		invid,
+		jr.TerminationKind,
+		jr.TerminationSignal,
 		jr.ID,
 	).Scan(&id)
 	if err != nil {
@@ -440,6 +556,110 @@ func (d *Databaser) UpdateJob(jr *JobRecord) (*JobRecord, error) {
 	return updated, nil
 }
 
+// ListJobs returns the jobs that match the filter along with their current
+// statuses. Use JobFilter.Page to turn the results into a page of listings.
+func (d *Databaser) ListJobs(f *JobFilter) ([]JobListing, error) {
+	query, args := f.Query()
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobListing
+	epoch := time.Unix(0, 0)
+	for rows.Next() {
+		var jl JobListing
+		var batchid interface{}
+		var appid interface{}
+		var invid interface{}
+		err = rows.Scan(
+			&jl.ID,
+			&batchid,
+			&jl.Submitter,
+			&jl.DateSubmitted,
+			&jl.DateStarted,
+			&jl.DateCompleted,
+			&appid,
+			&jl.ExitCode,
+			&jl.FailureThreshold,
+			&jl.FailureCount,
+			&jl.CondorID,
+			&invid,
+			&jl.TerminationKind,
+			&jl.TerminationSignal,
+			&jl.Status,
+		)
+		if err != nil {
+			return nil, err
+		}
+		// The cursor has to use the dates as they're stored, so grab the sort
+		// value before the dates get re-zeroed like they are in GetJob.
+		jl.sortValue = jl.DateSubmitted
+		if f.Sort == SortDateCompleted {
+			jl.sortValue = jl.DateCompleted
+		}
+		if jl.DateSubmitted.Before(epoch) {
+			jl.DateSubmitted = epoch
+		}
+		if jl.DateStarted.Before(epoch) {
+			jl.DateStarted = epoch
+		}
+		if jl.DateCompleted.Before(epoch) {
+			jl.DateCompleted = epoch
+		}
+		FixBatchID(&jl.JobRecord, batchid)
+		FixAppID(&jl.JobRecord, appid)
+		FixInvID(&jl.JobRecord, invid)
+		retval = append(retval, jl)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return retval, nil
+}
+
+// GetBatchJobs returns a []JobRecord of all of the jobs whose BatchID is the
+// ID passed into the function.
+func (d *Databaser) GetBatchJobs(batchID string) ([]JobRecord, error) {
+	query := `
+	SELECT cast(id as varchar)
+	  FROM jobs
+	 WHERE batch_id = cast($1 as uuid)
+	 ORDER BY date_submitted
+	`
+	return d.queryJobs(query, batchID)
+}
+
+// queryJobs runs a query that returns a single column of job IDs and looks up
+// the JobRecord for each of them.
+func (d *Databaser) queryJobs(query string, args ...interface{}) ([]JobRecord, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var retval []JobRecord
+	for _, id := range ids {
+		record, err := d.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, *record)
+	}
+	return retval, nil
+}
+
 // CondorEvent contains info about an event that Condor emitted.
 type CondorEvent struct {
 	ID          string
@@ -462,6 +682,7 @@ func (d *Databaser) InsertCondorEvent(ce *CondorEvent) (string, error) {
 			$3
 		) RETURNING id
 	`
+	d.forgetCondorEvents()
 	var id string
 	err := d.db.QueryRow(
 		query,
@@ -480,6 +701,7 @@ func (d *Databaser) DeleteCondorEvent(uuid string) error {
 	query := `
 	DELETE FROM condor_events WHERE id = cast($1 as uuid)
 	`
+	d.forgetCondorEvents()
 	_, err := d.db.Exec(query, uuid)
 	if err != nil {
 		return err
@@ -524,8 +746,15 @@ func (d *Databaser) GetCondorEvent(uuid string) (*CondorEvent, error) {
 }
 
 // GetCondorEventByNumber gets a CondorEvent from the database and returns a pointer to
-// a filled out instance of CondorEvent.
+// a filled out instance of CondorEvent. The results are cached until the
+// condor_events table is changed through the Databaser.
 func (d *Databaser) GetCondorEventByNumber(number string) (*CondorEvent, error) {
+	d.mutex.Lock()
+	cached, ok := d.condorEvents[number]
+	d.mutex.Unlock()
+	if ok {
+		return &cached, nil
+	}
 	query := `
 	SELECT id,
 				event_number,
@@ -534,12 +763,15 @@ func (d *Databaser) GetCondorEventByNumber(number string) (*CondorEvent, error)
 		FROM condor_events
 	WHERE event_number = $1
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
 	var id string
 	var eventNumber string
 	var eventName string
 	var eventDesc string
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		number,
 	).Scan(
 		&id,
@@ -556,6 +788,12 @@ func (d *Databaser) GetCondorEventByNumber(number string) (*CondorEvent, error)
 		EventName:   eventName,
 		EventDesc:   eventDesc,
 	}
+	d.mutex.Lock()
+	if d.condorEvents == nil {
+		d.condorEvents = make(map[string]CondorEvent)
+	}
+	d.condorEvents[number] = *ce
+	d.mutex.Unlock()
 	return ce, nil
 }
 
@@ -571,6 +809,7 @@ func (d *Databaser) UpdateCondorEvent(ce *CondorEvent) (*CondorEvent, error) {
 	 WHERE id = cast($4 as uuid)
 	RETURNING id
 	`
+	d.forgetCondorEvents()
 	var id string
 	err := d.db.QueryRow(
 		query,
@@ -590,6 +829,45 @@ func (d *Databaser) UpdateCondorEvent(ce *CondorEvent) (*CondorEvent, error) {
 
 }
 
+// GetCondorEventMappings returns the event mappings stored in the
+// condor_events table. Only events with an action set are returned; the rest
+// use the built-in mappings.
+func (d *Databaser) GetCondorEventMappings() ([]EventMapping, error) {
+	query := `
+	SELECT event_number,
+	       de_status,
+	       coalesce(job_status, ''),
+	       coalesce(terminal, false),
+	       coalesce(update_last_events, false),
+	       action
+	  FROM condor_events
+	 WHERE action IS NOT NULL
+	   AND de_status IS NOT NULL
+	`
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []EventMapping
+	for rows.Next() {
+		var m EventMapping
+		err := rows.Scan(
+			&m.EventNumber,
+			&m.Status,
+			&m.JobStatus,
+			&m.Terminal,
+			&m.UpdateLastEvents,
+			&m.Action,
+		)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, m)
+	}
+	return retval, rows.Err()
+}
+
 // CondorRawEvent contains the raw, unparsed event that was emitted from Condor.
 type CondorRawEvent struct {
 	ID            string
@@ -611,9 +889,12 @@ func (d *Databaser) InsertCondorRawEvent(re *CondorRawEvent) (string, error) {
 			$3
 		) RETURNING id
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return "", err
+	}
 	var id string
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		re.JobID,
 		re.EventText,
 		re.DateTriggered,
@@ -738,9 +1019,12 @@ func (d *Databaser) InsertCondorJobEvent(je *CondorJobEvent) (string, error) {
 		$5
 	) RETURNING id
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return "", err
+	}
 	var id string
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		je.JobID,
 		je.CondorEventID,
 		je.CondorRawEventID,
@@ -759,8 +1043,12 @@ func (d *Databaser) DoesCondorJobEventExist(checksum string) (bool, error) {
 	query := `
 	SELECT COUNT(*) as job_count FROM condor_job_events where checksum = $1
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
 	var count int64
-	err := d.db.QueryRow(query, checksum).Scan(&count)
+	err = stmt.QueryRow(checksum).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -770,6 +1058,80 @@ func (d *Databaser) DoesCondorJobEventExist(checksum string) (bool, error) {
 	return false, nil
 }
 
+// FindCondorJobEventChecksums returns the checksums that are already in the
+// database out of the ones that are passed in.
+func (d *Databaser) FindCondorJobEventChecksums(checksums []string) (map[string]bool, error) {
+	query := `
+	SELECT DISTINCT checksum FROM condor_job_events WHERE checksum IN (%s)
+	`
+	return queryChecksums(d.db, query, checksums)
+}
+
+// CountCondorJobEventsByNumber returns the number of events with the given
+// event number that have been recorded for a job.
+func (d *Databaser) CountCondorJobEventsByNumber(jobID, eventNumber string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	 WHERE je.job_id = cast($1 as uuid)
+	   AND ce.event_number = $2
+	`
+	var count int
+	err := d.db.QueryRow(query, jobID, eventNumber).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetJobEventHistory returns all of the events recorded for a job, along with
+// their names, descriptions, and raw text, in the order they were recorded.
+func (d *Databaser) GetJobEventHistory(jobID string) ([]JobEventRecord, error) {
+	query := `
+	SELECT cast(je.id as varchar),
+	       cast(je.job_id as varchar),
+	       ce.event_number,
+	       ce.event_name,
+	       ce.event_desc,
+	       COALESCE(je.checksum, ''),
+	       je.date_triggered,
+	       re.event_text
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	  JOIN condor_raw_events re ON je.condor_raw_event_id = re.id
+	 WHERE je.job_id = cast($1 as uuid)
+	 ORDER BY je.date_triggered ASC
+	`
+	rows, err := d.db.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobEventRecord
+	for rows.Next() {
+		var r JobEventRecord
+		err = rows.Scan(
+			&r.ID,
+			&r.JobID,
+			&r.EventNumber,
+			&r.EventName,
+			&r.EventDescription,
+			&r.Hash,
+			&r.DateRecorded,
+			&r.RawEvent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return retval, nil
+}
+
 // AddCondorJobEvent adds a CondorJobEvent to the database. You'll probably want
 // to use this over InsertCondorJobEvent.
 func (d *Databaser) AddCondorJobEvent(jobID string, eventID string, rawEventID string, hash string) (string, error) {
@@ -886,9 +1248,12 @@ func (d *Databaser) InsertLastCondorJobEvent(je *LastCondorJobEvent) (string, er
 		cast($2 as uuid)
 	) RETURNING job_id
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return "", err
+	}
 	var jobID string
-	err := d.db.QueryRow(
-		query,
+	err = stmt.QueryRow(
 		je.JobID,
 		je.CondorJobEventID,
 	).Scan(&jobID)
@@ -920,9 +1285,13 @@ func (d *Databaser) GetLastCondorJobEvent(uuid string) (*LastCondorJobEvent, err
 		FROM last_condor_job_events
 	 WHERE job_id = cast($1 as uuid)
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
 	var jobID string
 	var condorJobEventID string
-	err := d.db.QueryRow(query, uuid).Scan(
+	err = stmt.QueryRow(uuid).Scan(
 		&jobID,
 		&condorJobEventID,
 	)
@@ -945,8 +1314,12 @@ func (d *Databaser) UpdateLastCondorJobEvent(je *LastCondorJobEvent) (*LastCondo
 	 WHERE job_id = cast($2 as uuid)
 	RETURNING job_id
 	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
 	var jobID string
-	err := d.db.QueryRow(query, je.CondorJobEventID, je.JobID).Scan(&jobID)
+	err = stmt.QueryRow(je.CondorJobEventID, je.JobID).Scan(&jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -982,13 +1355,17 @@ func (d *Databaser) UpsertLastCondorJobEvent(jobEventID, jobID string) (string,
 	return updated.JobID, nil
 }
 
-// CondorJobStopRequest records a request to stop a job.
+// CondorJobStopRequest records a request to stop a job. DateConfirmed and
+// CondorJobEventID are filled in when the job's abort event arrives, and are
+// left empty until then.
 type CondorJobStopRequest struct {
-	ID            string
-	JobID         string
-	Username      string
-	DateRequested time.Time
-	Reason        string
+	ID               string
+	JobID            string
+	Username         string
+	DateRequested    time.Time
+	Reason           string
+	DateConfirmed    time.Time
+	CondorJobEventID string
 }
 
 // InsertCondorJobStopRequest adds a record of a job stop request.
@@ -1032,6 +1409,39 @@ func (d *Databaser) DeleteCondorJobStopRequest(uuid string) error {
 	return nil
 }
 
+// scanCondorJobStopRequest scans a row from condor_job_stop_requests into a
+// CondorJobStopRequest.
+func scanCondorJobStopRequest(row rowScanner) (*CondorJobStopRequest, error) {
+	jr := &CondorJobStopRequest{}
+	var reason interface{}
+	var confirmed interface{}
+	var jobEventID interface{}
+	err := row.Scan(
+		&jr.ID,
+		&jr.JobID,
+		&jr.Username,
+		&jr.DateRequested,
+		&reason,
+		&confirmed,
+		&jobEventID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := reason.([]uint8); ok {
+		jr.Reason = string(r)
+	} else if r, ok := reason.(string); ok {
+		jr.Reason = r
+	}
+	if t, ok := confirmed.(time.Time); ok {
+		jr.DateConfirmed = t
+	}
+	if id, ok := jobEventID.([]uint8); ok {
+		jr.CondorJobEventID = string(id)
+	}
+	return jr, nil
+}
+
 // GetCondorJobStopRequest returns the record of a job stop request.
 func (d *Databaser) GetCondorJobStopRequest(uuid string) (*CondorJobStopRequest, error) {
 	query := `
@@ -1039,36 +1449,50 @@ func (d *Databaser) GetCondorJobStopRequest(uuid string) (*CondorJobStopRequest,
 	       job_id,
 				 username,
 				 date_requested,
-				 reason
+				 reason,
+				 date_confirmed,
+				 condor_job_event_id
 	  FROM condor_job_stop_requests
 	 WHERE id = cast($1 as uuid)
 	`
-	var id string
-	var jobID string
-	var username string
-	var dateRequested time.Time
-	var reason string
-	err := d.db.QueryRow(
-		query,
-		uuid,
-	).Scan(
-		&id,
-		&jobID,
-		&username,
-		&dateRequested,
-		&reason,
-	)
+	return scanCondorJobStopRequest(d.db.QueryRow(query, uuid))
+}
+
+// GetLastCondorJobStopRequest returns the most recent stop request for a job.
+// sql.ErrNoRows is returned if nobody has asked for the job to be stopped.
+func (d *Databaser) GetLastCondorJobStopRequest(jobID string) (*CondorJobStopRequest, error) {
+	query := `
+	SELECT id,
+	       job_id,
+	       username,
+	       date_requested,
+	       reason,
+	       date_confirmed,
+	       condor_job_event_id
+	  FROM condor_job_stop_requests
+	 WHERE job_id = cast($1 as uuid)
+	 ORDER BY date_requested DESC
+	 LIMIT 1
+	`
+	return scanCondorJobStopRequest(d.db.QueryRow(query, jobID))
+}
+
+// ConfirmCondorJobStopRequests marks all of the unconfirmed stop requests for a
+// job as confirmed by the job event passed in. The number of stop requests that
+// were confirmed is returned.
+func (d *Databaser) ConfirmCondorJobStopRequests(jobID, jobEventID string, confirmed time.Time) (int64, error) {
+	query := `
+	UPDATE condor_job_stop_requests
+	   SET date_confirmed = $3,
+	       condor_job_event_id = cast($2 as uuid)
+	 WHERE job_id = cast($1 as uuid)
+	   AND date_confirmed IS NULL
+	`
+	result, err := d.db.Exec(query, jobID, jobEventID, confirmed)
 	if err != nil {
-		return nil, err
-	}
-	jr := &CondorJobStopRequest{
-		ID:            id,
-		JobID:         jobID,
-		Username:      username,
-		DateRequested: dateRequested,
-		Reason:        reason,
+		return 0, err
 	}
-	return jr, nil
+	return result.RowsAffected()
 }
 
 // UpdateCondorJobStopRequest updates the record of a job stop request.
@@ -1078,10 +1502,20 @@ func (d *Databaser) UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*Condo
 	   SET job_id = cast($1 as uuid),
 		     username = $2,
 				 date_requested = $3,
-				 reason = $4
-	 WHERE id = cast($5 as uuid)
+				 reason = $4,
+				 date_confirmed = $5,
+				 condor_job_event_id = cast($6 as uuid)
+	 WHERE id = cast($7 as uuid)
 	RETURNING id
 	`
+	var confirmed *time.Time
+	if !jr.DateConfirmed.IsZero() {
+		confirmed = &jr.DateConfirmed
+	}
+	var jobEventID *string
+	if jr.CondorJobEventID != "" {
+		jobEventID = &jr.CondorJobEventID
+	}
 	var id string
 	err := d.db.QueryRow(
 		query,
@@ -1089,6 +1523,8 @@ func (d *Databaser) UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*Condo
 		jr.Username,
 		jr.DateRequested,
 		jr.Reason,
+		confirmed,
+		jobEventID,
 		jr.ID,
 	).Scan(&id)
 	if err != nil {
@@ -1101,6 +1537,71 @@ func (d *Databaser) UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*Condo
 	return updated, nil
 }
 
+// HasCondorJobStopRequest returns true if a request to stop the job has
+// already been recorded.
+func (d *Databaser) HasCondorJobStopRequest(jobID string) (bool, error) {
+	query := `
+	SELECT COUNT(*) FROM condor_job_stop_requests WHERE job_id = cast($1 as uuid)
+	`
+	var count int64
+	err := d.db.QueryRow(query, jobID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// The flags that are set on jobs to record that something has been done for
+// them, so that it's only done once.
+const (
+	// FlagFailureThresholdEnforced is set on a job or batch once it's been
+	// stopped and reported as failed for going over its failure threshold.
+	FlagFailureThresholdEnforced = "failure_threshold_enforced"
+
+	// FlagBatchFinishedReported is set on a batch once its aggregate status
+	// has been sent to the notifiers.
+	FlagBatchFinishedReported = "batch_finished_reported"
+)
+
+// JobFlag records that something was done for a job.
+type JobFlag struct {
+	JobID   string
+	Flag    string
+	DateSet time.Time
+}
+
+// SetJobFlag sets the flag on the job. The returned bool is false if the flag
+// was already set, which makes this safe for deciding who gets to do
+// something once.
+func (d *Databaser) SetJobFlag(jobID, flag string, set time.Time) (bool, error) {
+	query := `
+	INSERT INTO job_flags (job_id, flag, date_set)
+	SELECT cast($1 as uuid), $2, $3
+	 WHERE NOT EXISTS (SELECT 1 FROM job_flags WHERE job_id = cast($1 as uuid) AND flag = $2)
+	`
+	result, err := d.db.Exec(query, jobID, flag, set)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasJobFlag returns true if the flag is set on the job.
+func (d *Databaser) HasJobFlag(jobID, flag string) (bool, error) {
+	query := `
+	SELECT COUNT(*) FROM job_flags WHERE job_id = cast($1 as uuid) AND flag = $2
+	`
+	var count int64
+	if err := d.db.QueryRow(query, jobID, flag).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // CondorJobDep tracks dependencies between jobs.
 type CondorJobDep struct {
 	SuccessorID   string
@@ -1129,22 +1630,87 @@ func (d *Databaser) InsertCondorJobDep(jd *CondorJobDep) error {
 	return nil
 }
 
-// GetPredecessors will return a []JobRecord containing the JobRecords for jobs
-// that are predecessors of the job whose ID is passed in.
-func (d *Databaser) GetPredecessors(successor string) ([]JobRecord, error) {
+// AddCondorJobDep adds a job dependency to the database unless it's already
+// there, returning ErrDependencyCycle if it would create a cycle. The check
+// and the insert happen in one transaction that locks condor_job_deps against
+// other writers, so dependencies are added one at a time.
+func (d *Databaser) AddCondorJobDep(jd *CondorJobDep) error {
+	if jd.PredecessorID == jd.SuccessorID {
+		return ErrDependencyCycle
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`LOCK TABLE condor_job_deps IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
 	query := `
-	SELECT successor_id,
-	       predecessor_id
+	SELECT COUNT(*)
 	  FROM condor_job_deps
 	 WHERE successor_id = cast($1 as uuid)
+	   AND predecessor_id = cast($2 as uuid)
 	`
-	rows, err := d.db.Query(query, successor)
-	if err != nil {
-		return nil, err
+	var count int64
+	if err = tx.QueryRow(query, jd.SuccessorID, jd.PredecessorID).Scan(&count); err != nil {
+		return err
 	}
-	var retval []JobRecord
-	for rows.Next() {
-		var successorID string
+	if count > 0 {
+		return tx.Commit()
+	}
+
+	// The dependency creates a cycle if the predecessor already depends on
+	// the successor.
+	query = `
+	WITH RECURSIVE descendants(id) AS (
+	    SELECT successor_id
+	      FROM condor_job_deps
+	     WHERE predecessor_id = cast($1 as uuid)
+	  UNION
+	    SELECT deps.successor_id
+	      FROM condor_job_deps deps
+	      JOIN descendants de ON deps.predecessor_id = de.id
+	)
+	SELECT COUNT(*) FROM descendants WHERE id = cast($2 as uuid)
+	`
+	if err = tx.QueryRow(query, jd.SuccessorID, jd.PredecessorID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDependencyCycle
+	}
+	query = `
+	INSERT INTO condor_job_deps (
+		successor_id,
+		predecessor_id
+	) VALUES (
+		cast($1 as uuid),
+		cast($2 as uuid)
+	)
+	`
+	if _, err = tx.Exec(query, jd.SuccessorID, jd.PredecessorID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPredecessors will return a []JobRecord containing the JobRecords for jobs
+// that are predecessors of the job whose ID is passed in.
+func (d *Databaser) GetPredecessors(successor string) ([]JobRecord, error) {
+	query := `
+	SELECT successor_id,
+	       predecessor_id
+	  FROM condor_job_deps
+	 WHERE successor_id = cast($1 as uuid)
+	`
+	rows, err := d.db.Query(query, successor)
+	if err != nil {
+		return nil, err
+	}
+	var retval []JobRecord
+	for rows.Next() {
+		var successorID string
 		var predecessorID string
 		err := rows.Scan(&successorID, &predecessorID)
 		if err != nil {
@@ -1201,6 +1767,1311 @@ func (d *Databaser) DeleteCondorJobDep(predUUID, succUUID string) error {
 	return nil
 }
 
+// HasCondorJobDep returns true if the successor already depends on the
+// predecessor.
+func (d *Databaser) HasCondorJobDep(predUUID, succUUID string) (bool, error) {
+	query := `
+	SELECT COUNT(*)
+	  FROM condor_job_deps
+	 WHERE successor_id = cast($1 as uuid)
+	   AND predecessor_id = cast($2 as uuid)
+	`
+	var count int64
+	err := d.db.QueryRow(query, succUUID, predUUID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAncestors returns a []JobRecord of all of the jobs that the job whose ID
+// is passed in depends on, either directly or through other jobs.
+func (d *Databaser) GetAncestors(successor string) ([]JobRecord, error) {
+	query := `
+	WITH RECURSIVE ancestors(id) AS (
+	    SELECT predecessor_id
+	      FROM condor_job_deps
+	     WHERE successor_id = cast($1 as uuid)
+	  UNION
+	    SELECT deps.predecessor_id
+	      FROM condor_job_deps deps
+	      JOIN ancestors a ON deps.successor_id = a.id
+	)
+	SELECT cast(id as varchar) FROM ancestors
+	`
+	return d.queryJobs(query, successor)
+}
+
+// GetDescendants returns a []JobRecord of all of the jobs that depend on the
+// job whose ID is passed in, either directly or through other jobs.
+func (d *Databaser) GetDescendants(predecessor string) ([]JobRecord, error) {
+	query := `
+	WITH RECURSIVE descendants(id) AS (
+	    SELECT successor_id
+	      FROM condor_job_deps
+	     WHERE predecessor_id = cast($1 as uuid)
+	  UNION
+	    SELECT deps.successor_id
+	      FROM condor_job_deps deps
+	      JOIN descendants de ON deps.predecessor_id = de.id
+	)
+	SELECT cast(id as varchar) FROM descendants
+	`
+	return d.queryJobs(query, predecessor)
+}
+
+// InsertJobStatusTransition adds a record of a job moving from one status to
+// another. The ID, DateRecorded, and Sequence fields are ignored, although
+// Sequence is set to the value assigned by the database.
+func (d *Databaser) InsertJobStatusTransition(jt *JobStatusTransition) (string, error) {
+	query := `
+	INSERT INTO job_status_transitions (
+		job_id,
+		condor_job_event_id,
+		from_status,
+		to_status,
+		reason,
+		date_triggered
+	) VALUES (
+		cast($1 as uuid),
+		cast($2 as uuid),
+		$3,
+		$4,
+		$5,
+		$6
+	) RETURNING id, sequence
+	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = stmt.QueryRow(
+		jt.JobID,
+		jt.CondorJobEventID,
+		jt.FromStatus,
+		jt.ToStatus,
+		jt.Reason,
+		jt.DateTriggered,
+	).Scan(&id, &jt.Sequence)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteJobStatusTransition removes the record of a job status transition.
+func (d *Databaser) DeleteJobStatusTransition(uuid string) error {
+	query := `
+	DELETE FROM job_status_transitions WHERE id = cast($1 as uuid)
+	`
+	_, err := d.db.Exec(query, uuid)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetLastJobStatusTransition returns the most recent status transition for a
+// job. sql.ErrNoRows is returned if the job hasn't transitioned yet.
+func (d *Databaser) GetLastJobStatusTransition(jobID string) (*JobStatusTransition, error) {
+	query := `
+	SELECT id,
+	       job_id,
+	       condor_job_event_id,
+	       from_status,
+	       to_status,
+	       reason,
+	       date_triggered,
+	       date_recorded,
+	       sequence
+	  FROM job_status_transitions
+	 WHERE job_id = cast($1 as uuid)
+	 ORDER BY date_triggered DESC, date_recorded DESC
+	 LIMIT 1
+	`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	jt := &JobStatusTransition{}
+	err = stmt.QueryRow(jobID).Scan(
+		&jt.ID,
+		&jt.JobID,
+		&jt.CondorJobEventID,
+		&jt.FromStatus,
+		&jt.ToStatus,
+		&jt.Reason,
+		&jt.DateTriggered,
+		&jt.DateRecorded,
+		&jt.Sequence,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return jt, nil
+}
+
+// GetJobStatusTransitions returns all of the status transitions for a job in
+// the order they were triggered.
+func (d *Databaser) GetJobStatusTransitions(jobID string) ([]JobStatusTransition, error) {
+	query := `
+	SELECT id,
+	       job_id,
+	       condor_job_event_id,
+	       from_status,
+	       to_status,
+	       reason,
+	       date_triggered,
+	       date_recorded,
+	       sequence
+	  FROM job_status_transitions
+	 WHERE job_id = cast($1 as uuid)
+	 ORDER BY date_triggered ASC, date_recorded ASC
+	`
+	rows, err := d.db.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobStatusTransition
+	for rows.Next() {
+		var jt JobStatusTransition
+		err := rows.Scan(
+			&jt.ID,
+			&jt.JobID,
+			&jt.CondorJobEventID,
+			&jt.FromStatus,
+			&jt.ToStatus,
+			&jt.Reason,
+			&jt.DateTriggered,
+			&jt.DateRecorded,
+			&jt.Sequence,
+		)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, jt)
+	}
+	return retval, rows.Err()
+}
+
+// GetStreamEvents returns up to 'limit' of the status transitions that come
+// after the 'after' sequence number for the jobs that pass the filter, in the
+// order they were recorded. It's used to catch up stream subscribers that are
+// resuming a stream.
+func (d *Databaser) GetStreamEvents(f *StreamFilter, after int64, limit int) ([]StreamEvent, error) {
+	query := `
+	SELECT t.sequence,
+	       cast(j.id as varchar),
+	       COALESCE(cast(j.invocation_id as varchar), ''),
+	       COALESCE(cast(j.batch_id as varchar), ''),
+	       j.submitter,
+	       COALESCE(j.condor_id, ''),
+	       COALESCE(cast(j.app_id as varchar), ''),
+	       t.from_status,
+	       t.to_status,
+	       COALESCE(t.reason, ''),
+	       t.date_triggered
+	  FROM job_status_transitions t
+	  JOIN jobs j ON t.job_id = j.id
+	 WHERE t.sequence > $1
+	   AND ($2 = '' OR j.id = cast(NULLIF($2, '') as uuid))
+	   AND ($3 = '' OR j.invocation_id = cast(NULLIF($3, '') as uuid))
+	   AND ($4 = '' OR j.batch_id = cast(NULLIF($4, '') as uuid))
+	   AND ($5 = '' OR j.submitter = $5)
+	 ORDER BY t.sequence ASC
+	 LIMIT $6
+	`
+	rows, err := d.db.Query(query, after, f.JobID, f.InvocationID, f.BatchID, f.Submitter, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []StreamEvent
+	for rows.Next() {
+		var e StreamEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.JobID,
+			&e.InvocationID,
+			&e.BatchID,
+			&e.Submitter,
+			&e.CondorID,
+			&e.AppID,
+			&e.FromStatus,
+			&e.ToStatus,
+			&e.Reason,
+			&e.DateTriggered,
+		)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, e)
+	}
+	return retval, rows.Err()
+}
+
+// InsertOutboundNotification adds a notification to the outbound queue. The ID,
+// DateCreated, and DateDelivered fields are ignored.
+func (d *Databaser) InsertOutboundNotification(n *OutboundNotification) (string, error) {
+	query := `
+	INSERT INTO outbound_notifications (
+		url,
+		idempotency_key,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		last_error,
+		next_attempt,
+		invocation_id
+	) VALUES (
+		$1,
+		$2,
+		$3,
+		$4,
+		$5,
+		$6,
+		$7,
+		$8,
+		cast($9 as uuid)
+	) RETURNING id
+	`
+	var id string
+	err := d.db.QueryRow(
+		query,
+		n.URL,
+		n.IdempotencyKey,
+		n.Payload,
+		n.Status,
+		n.Attempts,
+		n.MaxAttempts,
+		n.LastError,
+		n.NextAttempt,
+		nullableUUID(n.InvocationID),
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// outboundNotificationColumns is the list of columns selected when looking up
+// outbound notifications. The order matches scanOutboundNotification.
+const outboundNotificationColumns = `
+	cast(id as varchar),
+	url,
+	idempotency_key,
+	payload,
+	status,
+	attempts,
+	max_attempts,
+	last_error,
+	next_attempt,
+	date_created,
+	date_delivered,
+	COALESCE(cast(invocation_id as varchar), '')
+`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanOutboundNotification fills in an OutboundNotification from a row that
+// contains the outboundNotificationColumns.
+func scanOutboundNotification(row rowScanner) (*OutboundNotification, error) {
+	n := &OutboundNotification{}
+	var delivered interface{}
+	err := row.Scan(
+		&n.ID,
+		&n.URL,
+		&n.IdempotencyKey,
+		&n.Payload,
+		&n.Status,
+		&n.Attempts,
+		&n.MaxAttempts,
+		&n.LastError,
+		&n.NextAttempt,
+		&n.DateCreated,
+		&delivered,
+		&n.InvocationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := delivered.(time.Time); ok {
+		n.DateDelivered = t
+	}
+	return n, nil
+}
+
+// GetOutboundNotification returns a notification from the outbound queue.
+func (d *Databaser) GetOutboundNotification(uuid string) (*OutboundNotification, error) {
+	query := `SELECT ` + outboundNotificationColumns + `
+	  FROM outbound_notifications
+	 WHERE id = cast($1 as uuid)
+	`
+	return scanOutboundNotification(d.db.QueryRow(query, uuid))
+}
+
+// GetOutboundNotificationByKey returns the notification with the given
+// idempotency key. sql.ErrNoRows is returned if it hasn't been queued.
+func (d *Databaser) GetOutboundNotificationByKey(key string) (*OutboundNotification, error) {
+	query := `SELECT ` + outboundNotificationColumns + `
+	  FROM outbound_notifications
+	 WHERE idempotency_key = $1
+	`
+	return scanOutboundNotification(d.db.QueryRow(query, key))
+}
+
+// queryOutboundNotifications runs a query that selects the
+// outboundNotificationColumns and returns the notifications it finds.
+func (d *Databaser) queryOutboundNotifications(query string, args ...interface{}) ([]OutboundNotification, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []OutboundNotification
+	for rows.Next() {
+		n, err := scanOutboundNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, *n)
+	}
+	return retval, rows.Err()
+}
+
+// GetDueOutboundNotifications returns up to 'limit' pending notifications
+// whose next attempt is at or before 'now', oldest first. Notifications that
+// are waiting on an earlier pending notification for the same job and URL
+// aren't due.
+func (d *Databaser) GetDueOutboundNotifications(now time.Time, limit int) ([]OutboundNotification, error) {
+	query := `SELECT ` + outboundNotificationColumns + `
+	  FROM outbound_notifications n
+	 WHERE status = $1
+	   AND next_attempt <= $2
+	   AND NOT EXISTS (SELECT 1
+	                     FROM outbound_notifications e
+	                    WHERE e.invocation_id = n.invocation_id
+	                      AND e.url = n.url
+	                      AND e.status = $1
+	                      AND e.date_created < n.date_created)
+	 ORDER BY date_created ASC
+	 LIMIT $3
+	`
+	return d.queryOutboundNotifications(query, NotificationPending, now, limit)
+}
+
+// GetDeadOutboundNotifications returns the notifications that ran out of
+// delivery attempts, most recent first.
+func (d *Databaser) GetDeadOutboundNotifications() ([]OutboundNotification, error) {
+	query := `SELECT ` + outboundNotificationColumns + `
+	  FROM dead_outbound_notifications
+	 ORDER BY date_created DESC
+	`
+	return d.queryOutboundNotifications(query)
+}
+
+// UpdateOutboundNotification records the outcome of a delivery attempt.
+func (d *Databaser) UpdateOutboundNotification(n *OutboundNotification) (*OutboundNotification, error) {
+	query := `
+	UPDATE outbound_notifications
+	   SET status = $1,
+	       attempts = $2,
+	       last_error = $3,
+	       next_attempt = $4,
+	       date_delivered = $5
+	 WHERE id = cast($6 as uuid)
+	RETURNING id
+	`
+	var delivered *time.Time
+	if !n.DateDelivered.IsZero() {
+		delivered = &n.DateDelivered
+	}
+	var id string
+	err := d.db.QueryRow(
+		query,
+		n.Status,
+		n.Attempts,
+		n.LastError,
+		n.NextAttempt,
+		delivered,
+		n.ID,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetOutboundNotification(id)
+}
+
+// DeleteOutboundNotification removes a notification from the outbound queue.
+func (d *Databaser) DeleteOutboundNotification(uuid string) error {
+	query := `
+	DELETE FROM outbound_notifications WHERE id = cast($1 as uuid)
+	`
+	_, err := d.db.Exec(query, uuid)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// pgUsageRow is the SQL for one row of resource usage from usageRow.
+const pgUsageRow = `(cast(? as uuid), cast(? as uuid), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// GetJobResourceUsage returns the resource usage of a job. sql.ErrNoRows is
+// returned if none has been recorded.
+func (d *Databaser) GetJobResourceUsage(jobID string) (*JobResourceUsage, error) {
+	query := `
+	SELECT ` + jobResourceUsageColumns + `
+	  FROM job_resource_usage
+	 WHERE job_id = cast($1 as uuid)
+	`
+	u := &JobResourceUsage{}
+	if err := d.db.QueryRow(query, jobID).Scan(u.scanDest(&u.DateTriggered)...); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SumJobResourceUsage adds up the resource usage of the jobs that match the
+// filter.
+func (d *Databaser) SumJobResourceUsage(f *UsageFilter) (*UsageTotals, error) {
+	query, args := f.Query()
+	return scanUsageTotals(d.db.QueryRow(query, args...))
+}
+
+// GetOrphanedJobs returns the jobs that don't have an invocation ID but have
+// received an event since 'since', in the order they were submitted.
+func (d *Databaser) GetOrphanedJobs(since time.Time) ([]JobRecord, error) {
+	query := `
+	SELECT cast(j.id as varchar)
+	  FROM jobs j
+	 WHERE j.invocation_id IS NULL
+	   AND EXISTS (SELECT 1
+	                 FROM condor_raw_events r
+	                WHERE r.job_id = j.id
+	                  AND r.date_triggered >= $1)
+	 ORDER BY j.date_submitted, j.id
+	`
+	return d.queryJobs(query, since)
+}
+
+// GetExpiredJobs returns up to 'limit' of the jobs that moved into a terminal
+// status before 'before' and haven't been archived, in the order they were
+// submitted.
+func (d *Databaser) GetExpiredJobs(before time.Time, limit int) ([]JobRecord, error) {
+	query := `
+	SELECT cast(j.id as varchar)
+	  FROM jobs j
+	 WHERE j.date_archived IS NULL
+	   AND ` + jobStatusExpr + ` IN ($1, $2)
+	   AND (SELECT max(t.date_triggered)
+	          FROM job_status_transitions t
+	         WHERE t.job_id = j.id) < $3
+	 ORDER BY j.date_submitted, j.id
+	 LIMIT $4
+	`
+	return d.queryJobs(query, StatusCompleted, StatusFailed, before, limit)
+}
+
+// GetJobArchive returns everything that's stored for a job.
+func (d *Databaser) GetJobArchive(jobID string) (*JobArchive, error) {
+	job, err := d.GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	ja := &JobArchive{Job: *job}
+
+	var archived interface{}
+	err = d.db.QueryRow(`SELECT date_archived FROM jobs WHERE id = cast($1 as uuid)`, jobID).Scan(&archived)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := archived.(time.Time); ok {
+		ja.DateArchived = t
+	}
+
+	rows, err := d.db.Query(`
+	SELECT id,
+	       job_id,
+	       event_text,
+	       date_triggered
+	  FROM condor_raw_events
+	 WHERE job_id = cast($1 as uuid)
+	 ORDER BY date_triggered
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var re CondorRawEvent
+		if err = rows.Scan(&re.ID, &re.JobID, &re.EventText, &re.DateTriggered); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.RawEvents = append(ja.RawEvents, re)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = d.db.Query(`
+	SELECT je.id,
+	       je.job_id,
+	       je.condor_event_id,
+	       je.condor_raw_event_id,
+	       COALESCE(je.checksum, ''),
+	       je.date_triggered,
+	       ce.event_number
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	 WHERE je.job_id = cast($1 as uuid)
+	 ORDER BY je.date_triggered
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var je ArchivedJobEvent
+		err = rows.Scan(
+			&je.ID,
+			&je.JobID,
+			&je.CondorEventID,
+			&je.CondorRawEventID,
+			&je.Hash,
+			&je.DateTriggered,
+			&je.EventNumber,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.JobEvents = append(ja.JobEvents, je)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	last, err := d.GetLastCondorJobEvent(jobID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if last != nil {
+		ja.LastEventID = last.CondorJobEventID
+	}
+
+	rows, err = d.db.Query(`
+	SELECT id,
+	       job_id,
+	       username,
+	       date_requested,
+	       reason,
+	       date_confirmed,
+	       condor_job_event_id
+	  FROM condor_job_stop_requests
+	 WHERE job_id = cast($1 as uuid)
+	 ORDER BY date_requested
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		sr, err := scanCondorJobStopRequest(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.StopRequests = append(ja.StopRequests, *sr)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if ja.Transitions, err = d.GetJobStatusTransitions(jobID); err != nil {
+		return nil, err
+	}
+
+	if ja.Usage, err = d.GetJobResourceUsage(jobID); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err = d.db.Query(`
+	SELECT successor_id,
+	       predecessor_id
+	  FROM condor_job_deps
+	 WHERE successor_id = cast($1 as uuid)
+	    OR predecessor_id = cast($1 as uuid)
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dep CondorJobDep
+		if err = rows.Scan(&dep.SuccessorID, &dep.PredecessorID); err != nil {
+			return nil, err
+		}
+		ja.Dependencies = append(ja.Dependencies, dep)
+	}
+	return ja, rows.Err()
+}
+
+// RestoreJobArchive puts an archived job back into the database with the IDs
+// it had before, all in one transaction. If the job is still in the database,
+// only the raw events, job events, and transitions that were pruned are put
+// back, and the job can be archived again. ErrArchiveRestored is returned if
+// there's nothing to put back. Dependencies are only restored if the other job
+// is in the database too.
+func (d *Databaser) RestoreJobArchive(ja *JobArchive) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM jobs WHERE id = cast($1 as uuid))`,
+		ja.Job.ID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		restored, err := restorePGJobHistory(tx, ja)
+		if err != nil {
+			return err
+		}
+		if restored == 0 {
+			return ErrArchiveRestored
+		}
+		_, err = tx.Exec(`UPDATE jobs SET date_archived = NULL WHERE id = cast($1 as uuid)`, ja.Job.ID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	jr := &ja.Job
+	_, err = tx.Exec(`
+	INSERT INTO jobs (
+		id,
+		batch_id,
+		submitter,
+		date_submitted,
+		date_started,
+		date_completed,
+		app_id,
+		exit_code,
+		failure_threshold,
+		failure_count,
+		condor_id,
+		invocation_id,
+		termination_kind,
+		termination_signal
+	) VALUES (
+		cast($1 as uuid),
+		cast($2 as uuid),
+		$3,
+		$4,
+		$5,
+		$6,
+		cast($7 as uuid),
+		$8,
+		$9,
+		$10,
+		$11,
+		cast($12 as uuid),
+		$13,
+		$14
+	)`,
+		jr.ID,
+		nullableUUID(jr.BatchID),
+		jr.Submitter,
+		jr.DateSubmitted,
+		jr.DateStarted,
+		jr.DateCompleted,
+		nullableUUID(jr.AppID),
+		jr.ExitCode,
+		jr.FailureThreshold,
+		jr.FailureCount,
+		jr.CondorID,
+		nullableUUID(jr.InvocationID),
+		jr.TerminationKind,
+		jr.TerminationSignal,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err = restorePGJobHistory(tx, ja); err != nil {
+		return err
+	}
+
+	if ja.LastEventID != "" {
+		_, err = tx.Exec(`
+		INSERT INTO last_condor_job_events (
+			job_id,
+			condor_job_event_id
+		) VALUES (
+			cast($1 as uuid),
+			cast($2 as uuid)
+		)`,
+			jr.ID,
+			ja.LastEventID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sr := range ja.StopRequests {
+		var confirmed interface{}
+		if !sr.DateConfirmed.IsZero() {
+			confirmed = sr.DateConfirmed
+		}
+		_, err = tx.Exec(`
+		INSERT INTO condor_job_stop_requests (
+			id,
+			job_id,
+			username,
+			date_requested,
+			reason,
+			date_confirmed,
+			condor_job_event_id
+		) VALUES (
+			cast($1 as uuid),
+			cast($2 as uuid),
+			$3,
+			$4,
+			$5,
+			$6,
+			cast($7 as uuid)
+		)`,
+			sr.ID,
+			sr.JobID,
+			sr.Username,
+			sr.DateRequested,
+			sr.Reason,
+			confirmed,
+			nullableUUID(sr.CondorJobEventID),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ja.Usage != nil {
+		err = execBatch(tx, insertUsageQuery, pgUsageRow, [][]interface{}{usageRow(ja.Usage, ja.Usage.DateTriggered)})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dep := range ja.Dependencies {
+		_, err = tx.Exec(`
+		INSERT INTO condor_job_deps (
+			successor_id,
+			predecessor_id
+		) SELECT cast($1 as uuid), cast($2 as uuid)
+		   WHERE EXISTS (SELECT 1 FROM jobs WHERE id = cast($1 as uuid))
+		     AND EXISTS (SELECT 1 FROM jobs WHERE id = cast($2 as uuid))
+		     AND NOT EXISTS (SELECT 1 FROM condor_job_deps WHERE successor_id = cast($1 as uuid))
+		`,
+			dep.SuccessorID,
+			dep.PredecessorID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// restorePGJobHistory puts the raw events, job events, and transitions in the
+// archive that aren't in the database back inside 'tx', and returns how many
+// rows it put back.
+func restorePGJobHistory(tx *sql.Tx, ja *JobArchive) (int, error) {
+	restored := 0
+	insert := func(query string, args ...interface{}) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		restored += int(count)
+		return err
+	}
+
+	for _, re := range ja.RawEvents {
+		err := insert(`
+		INSERT INTO condor_raw_events (
+			id,
+			job_id,
+			event_text,
+			date_triggered
+		) SELECT cast($1 as uuid),
+		         cast($2 as uuid),
+		         cast($3 as text),
+		         cast($4 as timestamp with time zone)
+		   WHERE NOT EXISTS (SELECT 1 FROM condor_raw_events WHERE id = cast($1 as uuid))
+		`,
+			re.ID,
+			re.JobID,
+			re.EventText,
+			re.DateTriggered,
+		)
+		if err != nil {
+			return restored, err
+		}
+	}
+
+	// The condor_events are looked up by number, since their IDs depend on
+	// the database that the job was archived from.
+	for _, je := range ja.JobEvents {
+		err := insert(`
+		INSERT INTO condor_job_events (
+			id,
+			job_id,
+			condor_event_id,
+			condor_raw_event_id,
+			checksum,
+			date_triggered
+		) SELECT cast($1 as uuid),
+		         cast($2 as uuid),
+		         (SELECT id FROM condor_events WHERE event_number = $3 LIMIT 1),
+		         cast($4 as uuid),
+		         cast($5 as text),
+		         cast($6 as timestamp with time zone)
+		   WHERE NOT EXISTS (SELECT 1 FROM condor_job_events WHERE id = cast($1 as uuid))
+		`,
+			je.ID,
+			je.JobID,
+			je.EventNumber,
+			je.CondorRawEventID,
+			je.Hash,
+			je.DateTriggered,
+		)
+		if err != nil {
+			return restored, err
+		}
+	}
+
+	// Transitions keep their sequence numbers so that stream subscribers
+	// don't see them again. The sequence is moved past them in case they came
+	// from another database.
+	var maxSequence int64
+	for _, jt := range ja.Transitions {
+		err := insert(`
+		INSERT INTO job_status_transitions (
+			id,
+			job_id,
+			condor_job_event_id,
+			from_status,
+			to_status,
+			reason,
+			date_triggered,
+			date_recorded,
+			sequence
+		) SELECT cast($1 as uuid),
+		         cast($2 as uuid),
+		         cast($3 as uuid),
+		         cast($4 as text),
+		         cast($5 as text),
+		         cast($6 as text),
+		         cast($7 as timestamp with time zone),
+		         cast($8 as timestamp with time zone),
+		         cast($9 as bigint)
+		   WHERE NOT EXISTS (SELECT 1 FROM job_status_transitions WHERE id = cast($1 as uuid))
+		`,
+			jt.ID,
+			jt.JobID,
+			jt.CondorJobEventID,
+			jt.FromStatus,
+			jt.ToStatus,
+			jt.Reason,
+			jt.DateTriggered,
+			jt.DateRecorded,
+			jt.Sequence,
+		)
+		if err != nil {
+			return restored, err
+		}
+		if jt.Sequence > maxSequence {
+			maxSequence = jt.Sequence
+		}
+	}
+	if maxSequence > 0 {
+		_, err := tx.Exec(`
+		SELECT setval(
+			pg_get_serial_sequence('job_status_transitions', 'sequence'),
+			GREATEST($1, nextval(pg_get_serial_sequence('job_status_transitions', 'sequence')))
+		)`,
+			maxSequence,
+		)
+		if err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// PruneJobHistory deletes the job's raw events and transitions with the given
+// IDs in one transaction, and records that the job was archived at
+// 'archived'. The job events parsed from the raw events go with them, along
+// with anything else that refers to those job events.
+func (d *Databaser) PruneJobHistory(jobID string, rawEventIDs, transitionIDs []string, archived time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range transitionIDs {
+		_, err = tx.Exec(
+			`DELETE FROM job_status_transitions WHERE id = cast($1 as uuid) AND job_id = cast($2 as uuid)`,
+			id,
+			jobID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range rawEventIDs {
+		_, err = tx.Exec(
+			`DELETE FROM condor_raw_events WHERE id = cast($1 as uuid) AND job_id = cast($2 as uuid)`,
+			id,
+			jobID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	var updated string
+	err = tx.QueryRow(
+		`UPDATE jobs SET date_archived = $1 WHERE id = cast($2 as uuid) RETURNING id`,
+		archived,
+		jobID,
+	).Scan(&updated)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WriteEventBatch stores everything in the batch in one transaction. The jobs
+// are updated with the same prepared statement as UpdateJob, and everything
+// else is added with multi-row INSERTs.
+func (d *Databaser) WriteEventBatch(b *EventBatch) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = d.writeEventBatch(tx, b); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeEventBatch does the work of WriteEventBatch inside 'tx'.
+func (d *Databaser) writeEventBatch(tx *sql.Tx, b *EventBatch) error {
+	update, err := d.prepare(updateEventJobQuery)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]interface{}
+	for _, jr := range b.NewJobs {
+		rows = append(rows, []interface{}{
+			jr.ID,
+			nullableUUID(jr.BatchID),
+			jr.Submitter,
+			jr.DateSubmitted,
+			jr.DateStarted,
+			jr.DateCompleted,
+			nullableUUID(jr.AppID),
+			jr.ExitCode,
+			jr.FailureThreshold,
+			jr.FailureCount,
+			jr.CondorID,
+			nullableUUID(jr.InvocationID),
+			jr.TerminationKind,
+			jr.TerminationSignal,
+		})
+	}
+	err = execBatch(tx, `
+	INSERT INTO jobs (
+		id,
+		batch_id,
+		submitter,
+		date_submitted,
+		date_started,
+		date_completed,
+		app_id,
+		exit_code,
+		failure_threshold,
+		failure_count,
+		condor_id,
+		invocation_id,
+		termination_kind,
+		termination_signal
+	) VALUES %s
+	`, `(cast(? as uuid), cast(? as uuid), ?, ?, ?, ?, cast(? as uuid), ?, ?, ?, ?, cast(? as uuid), ?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	update = tx.Stmt(update)
+	for _, jr := range b.Jobs {
+		var id string
+		err = update.QueryRow(
+			jr.ExitCode,
+			jr.FailureCount,
+			jr.TerminationKind,
+			jr.TerminationSignal,
+			nullableUUID(jr.InvocationID),
+			jr.ID,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows = nil
+	for _, re := range b.RawEvents {
+		rows = append(rows, []interface{}{re.ID, re.JobID, re.EventText, re.DateTriggered})
+	}
+	err = execBatch(
+		tx,
+		`INSERT INTO condor_raw_events (id, job_id, event_text, date_triggered) VALUES %s`,
+		`(cast(? as uuid), cast(? as uuid), ?, ?)`,
+		rows,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, je := range b.JobEvents {
+		rows = append(rows, []interface{}{je.ID, je.JobID, je.CondorEventID, je.CondorRawEventID, je.DateTriggered, je.Hash})
+	}
+	err = execBatch(tx, `
+	INSERT INTO condor_job_events (
+		id,
+		job_id,
+		condor_event_id,
+		condor_raw_event_id,
+		date_triggered,
+		checksum
+	) VALUES %s
+	`, `(cast(? as uuid), cast(? as uuid), cast(? as uuid), cast(? as uuid), ?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	// The last events are replaced by deleting the old ones first, since
+	// there's no upsert in the versions of PostgreSQL that are supported.
+	var jobIDs [][]interface{}
+	rows = nil
+	for _, le := range b.LastEvents {
+		jobIDs = append(jobIDs, []interface{}{le.JobID})
+		rows = append(rows, []interface{}{le.JobID, le.CondorJobEventID})
+	}
+	err = execBatch(tx, `DELETE FROM last_condor_job_events WHERE job_id IN (%s)`, `cast(? as uuid)`, jobIDs)
+	if err != nil {
+		return err
+	}
+	err = execBatch(
+		tx,
+		`INSERT INTO last_condor_job_events (job_id, condor_job_event_id) VALUES %s`,
+		`(cast(? as uuid), cast(? as uuid))`,
+		rows,
+	)
+	if err != nil {
+		return err
+	}
+
+	// So is the resource usage.
+	jobIDs = nil
+	rows = nil
+	for i := range b.Usage {
+		u := &b.Usage[i]
+		jobIDs = append(jobIDs, []interface{}{u.JobID})
+		rows = append(rows, usageRow(u, u.DateTriggered))
+	}
+	err = execBatch(tx, `DELETE FROM job_resource_usage WHERE job_id IN (%s)`, `cast(? as uuid)`, jobIDs)
+	if err != nil {
+		return err
+	}
+	if err = execBatch(tx, insertUsageQuery, pgUsageRow, rows); err != nil {
+		return err
+	}
+
+	// The transitions get their IDs here so that the sequence numbers that
+	// come back can be matched up with them.
+	transitions := make(map[string]*JobStatusTransition)
+	rows = nil
+	for _, jt := range b.Transitions {
+		jt.ID = newUUID()
+		transitions[jt.ID] = jt
+		rows = append(rows, []interface{}{
+			jt.ID,
+			jt.JobID,
+			jt.CondorJobEventID,
+			jt.FromStatus,
+			jt.ToStatus,
+			jt.Reason,
+			jt.DateTriggered,
+			jt.DateRecorded,
+		})
+	}
+	err = batchQueries(`
+	INSERT INTO job_status_transitions (
+		id,
+		job_id,
+		condor_job_event_id,
+		from_status,
+		to_status,
+		reason,
+		date_triggered,
+		date_recorded
+	) VALUES %s
+	RETURNING id, sequence
+	`, `(cast(? as uuid), cast(? as uuid), cast(? as uuid), ?, ?, ?, ?, ?)`, rows, func(query string, args []interface{}) error {
+		result, err := tx.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer result.Close()
+		for result.Next() {
+			var id string
+			var sequence int64
+			if err = result.Scan(&id, &sequence); err != nil {
+				return err
+			}
+			if jt, ok := transitions[id]; ok {
+				jt.Sequence = sequence
+			}
+		}
+		return result.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteJobRebuild stores the rebuilt state of a job in one transaction. The
+// job's transitions are only replaced if they changed, so that they keep their
+// sequence numbers otherwise. The same goes for its resource usage.
+func (d *Databaser) WriteJobRebuild(r *JobRebuild) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, je := range r.JobEvents {
+		_, err = tx.Exec(`
+		UPDATE condor_job_events
+		   SET condor_event_id = cast($1 as uuid)
+		 WHERE id = cast($2 as uuid)
+		`, je.CondorEventID, je.ID)
+		if err != nil {
+			return err
+		}
+	}
+	batch := &EventBatch{Jobs: []*JobRecord{&r.Job}}
+	_, err = tx.Exec(`DELETE FROM last_condor_job_events WHERE job_id = cast($1 as uuid)`, r.Job.ID)
+	if err != nil {
+		return err
+	}
+	if r.LastEventID != "" {
+		batch.LastEvents = []LastCondorJobEvent{{JobID: r.Job.ID, CondorJobEventID: r.LastEventID}}
+	}
+	if r.TransitionsChanged() {
+		_, err = tx.Exec(`DELETE FROM job_status_transitions WHERE job_id = cast($1 as uuid)`, r.Job.ID)
+		if err != nil {
+			return err
+		}
+		batch.Transitions = r.Transitions
+	}
+	if !r.Before.Usage.Equal(r.Usage) {
+		_, err = tx.Exec(`DELETE FROM job_resource_usage WHERE job_id = cast($1 as uuid)`, r.Job.ID)
+		if err != nil {
+			return err
+		}
+		if r.Usage != nil {
+			batch.Usage = []JobResourceUsage{*r.Usage}
+		}
+	}
+	if err = d.writeEventBatch(tx, batch); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AcquireServiceLock gives the lock to l.Holder until 'ttl' after 'now' if it's
+// free, it's expired, or l.Holder already has it. The lock as it's stored
+// afterwards is returned, so the lock wasn't acquired if its Holder is
+// somebody else.
+func (d *Databaser) AcquireServiceLock(l *ServiceLock, now time.Time, ttl time.Duration) (*ServiceLock, error) {
+	expires := now.Add(ttl)
+	update := `
+	UPDATE service_locks
+	   SET date_acquired = CASE WHEN holder = $2 THEN date_acquired ELSE $3 END,
+	       holder = $2,
+	       date_expires = $4
+	 WHERE name = $1
+	   AND (holder = $2 OR date_expires <= $3)
+	`
+	result, err := d.db.Exec(update, l.Name, l.Holder, now, expires)
+	if err != nil {
+		return nil, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		insert := `
+		INSERT INTO service_locks (name, holder, date_acquired, date_expires)
+		SELECT $1, $2, $3, $4
+		 WHERE NOT EXISTS (SELECT 1 FROM service_locks WHERE name = $1)
+		`
+		if _, err = d.db.Exec(insert, l.Name, l.Holder, now, expires); err != nil {
+			return nil, err
+		}
+	}
+	query := `
+	SELECT name, holder, date_acquired, date_expires
+	  FROM service_locks
+	 WHERE name = $1
+	`
+	current := &ServiceLock{}
+	err = d.db.QueryRow(query, l.Name).Scan(&current.Name, &current.Holder, &current.DateAcquired, &current.DateExpires)
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// ReleaseServiceLock lets go of the lock if 'holder' has it.
+func (d *Databaser) ReleaseServiceLock(name, holder string) error {
+	_, err := d.db.Exec(`DELETE FROM service_locks WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
+
 // Version contains info about the version of the database in use.
 type Version struct {
 	ID      int64
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
 	"testing"
@@ -428,6 +429,23 @@ func TestCRUDCondorJobEvent(t *testing.T) {
 	if updatedCJE.DateTriggered.Format(time.RFC822Z) != cje.DateTriggered.Format(time.RFC822Z) {
 		t.Errorf("DateTriggereds don't match after update")
 	}
+	history, err := d.GetJobEventHistory(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(history) != 1 {
+		t.Errorf("The event history had %d events instead of 1", len(history))
+	} else {
+		if history[0].ID != cje.ID {
+			t.Errorf("The ID in the event history doesn't match")
+		}
+		if history[0].EventName != ce.EventName {
+			t.Errorf("The EventName in the event history doesn't match")
+		}
+		if history[0].RawEvent != cr.EventText {
+			t.Errorf("The RawEvent in the event history doesn't match")
+		}
+	}
 	err = d.DeleteCondorJobEvent(cje.ID)
 	if err != nil {
 		t.Error(err)
@@ -601,6 +619,13 @@ func TestCondorJobStopRequest(t *testing.T) {
 		t.Error(err)
 	}
 	sr.ID = srID
+	has, err := d.HasCondorJobStopRequest(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if !has {
+		t.Errorf("HasCondorJobStopRequest returned false after a stop request was inserted")
+	}
 	newSR, err := d.GetCondorJobStopRequest(sr.ID)
 	if err != nil {
 		t.Error(err)
@@ -620,7 +645,18 @@ func TestCondorJobStopRequest(t *testing.T) {
 	if newSR.Reason != sr.Reason {
 		t.Errorf("Reasons don't match")
 	}
+	if !newSR.DateConfirmed.IsZero() {
+		t.Errorf("DateConfirmed was set on a new stop request")
+	}
+	lastSR, err := d.GetLastCondorJobStopRequest(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if lastSR.ID != sr.ID {
+		t.Errorf("GetLastCondorJobStopRequest returned %s instead of %s", lastSR.ID, sr.ID)
+	}
 	sr.Reason = "poop"
+	sr.DateConfirmed = time.Now()
 	updated, err := d.UpdateCondorJobStopRequest(sr)
 	if err != nil {
 		t.Error(err)
@@ -640,16 +676,77 @@ func TestCondorJobStopRequest(t *testing.T) {
 	if updated.Reason != sr.Reason {
 		t.Errorf("Reasons don't match after update")
 	}
+	if updated.DateConfirmed.Format(time.RFC822Z) != sr.DateConfirmed.Format(time.RFC822Z) {
+		t.Errorf("DateConfirmeds don't match after update")
+	}
 	err = d.DeleteCondorJobStopRequest(sr.ID)
 	if err != nil {
 		t.Error(err)
 	}
+	has, err = d.HasCondorJobStopRequest(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if has {
+		t.Errorf("HasCondorJobStopRequest returned true after the stop request was deleted")
+	}
 	err = d.DeleteJob(jr.ID)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestGetBatchJobs(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	batch := &JobRecord{
+		Submitter:        "unit_tests",
+		AppID:            uuid.New(),
+		FailureThreshold: 1,
+	}
+	batch.ID, err = d.InsertJob(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var memberIDs []string
+	for i := 0; i < 3; i++ {
+		member := &JobRecord{
+			BatchID:   batch.ID,
+			Submitter: "unit_tests",
+			AppID:     batch.AppID,
+		}
+		id, err := d.InsertJob(member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memberIDs = append(memberIDs, id)
+	}
+	members, err := d.GetBatchJobs(batch.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(members) != len(memberIDs) {
+		t.Errorf("GetBatchJobs returned %d jobs instead of %d", len(members), len(memberIDs))
+	}
+	for _, member := range members {
+		if member.BatchID != batch.ID {
+			t.Errorf("Job %s has a BatchID of %s instead of %s", member.ID, member.BatchID, batch.ID)
+		}
+	}
+	for _, id := range memberIDs {
+		if err = d.DeleteJob(id); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = d.DeleteJob(batch.ID); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCRUDJobDeps(t *testing.T) {
 	connString := ConnString()
 	d, err := NewDatabaser(connString)
@@ -784,3 +881,545 @@ func TestCRUDJobDeps(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestJobDependencyGraph(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		jr := &JobRecord{
+			Submitter: "unit_tests",
+			AppID:     uuid.New(),
+		}
+		id, err := d.InsertJob(jr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	deps := []*CondorJobDep{
+		{PredecessorID: ids[0], SuccessorID: ids[1]},
+		{PredecessorID: ids[1], SuccessorID: ids[2]},
+	}
+	for _, dep := range deps {
+		if err = AddJobDependency(d, dep); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = AddJobDependency(d, deps[0]); err != nil {
+		t.Errorf("Adding an existing dependency returned an error: %s", err)
+	}
+	err = AddJobDependency(d, &CondorJobDep{PredecessorID: ids[2], SuccessorID: ids[0]})
+	if err != ErrDependencyCycle {
+		t.Errorf("Adding a dependency that creates a cycle returned %v instead of ErrDependencyCycle", err)
+	}
+	ancestors, err := d.GetAncestors(ids[2])
+	if err != nil {
+		t.Error(err)
+	}
+	if len(ancestors) != 2 {
+		t.Errorf("Number of ancestors returned wasn't 2: %d", len(ancestors))
+	}
+	descendants, err := d.GetDescendants(ids[0])
+	if err != nil {
+		t.Error(err)
+	}
+	if len(descendants) != 2 {
+		t.Errorf("Number of descendants returned wasn't 2: %d", len(descendants))
+	}
+	for _, dep := range deps {
+		if err = d.DeleteCondorJobDep(dep.PredecessorID, dep.SuccessorID); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, id := range ids {
+		if err = d.DeleteJob(id); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	submitter := uuid.New()
+	submitted := time.Now()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		jr := &JobRecord{
+			Submitter:     submitter,
+			DateSubmitted: submitted.Add(time.Duration(i) * time.Minute),
+			AppID:         uuid.New(),
+		}
+		id, err := d.InsertJob(jr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	filter := &JobFilter{Submitter: submitter, Sort: SortDateSubmitted, Limit: 2}
+	listings, err := d.ListJobs(filter)
+	if err != nil {
+		t.Error(err)
+	}
+	page := filter.Page(listings)
+	if len(page.Jobs) != 2 {
+		t.Errorf("The first page had %d jobs instead of 2", len(page.Jobs))
+	}
+	if page.Jobs[0].ID != ids[0] || page.Jobs[1].ID != ids[1] {
+		t.Errorf("The first page wasn't sorted by submission date")
+	}
+	if page.Jobs[0].Status != StatusSubmitted {
+		t.Errorf("The status was %s instead of %s", page.Jobs[0].Status, StatusSubmitted)
+	}
+	filter.Cursor, err = DecodeJobCursor(page.NextCursor)
+	if err != nil {
+		t.Error(err)
+	}
+	listings, err = d.ListJobs(filter)
+	if err != nil {
+		t.Error(err)
+	}
+	page = filter.Page(listings)
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != ids[2] {
+		t.Errorf("The second page didn't contain just the last job")
+	}
+	if page.NextCursor != "" {
+		t.Errorf("The last page had a cursor")
+	}
+	for _, id := range ids {
+		if err = d.DeleteJob(id); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	applied, err := d.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Migrating an up to date database applied %v", applied)
+	}
+	if err = d.CheckVersion(); err != nil {
+		t.Error(err)
+	}
+	versions, err := d.GetVersions()
+	if err != nil {
+		t.Error(err)
+	}
+	latest, err := LatestDBVersion(versions)
+	if err != nil {
+		t.Error(err)
+	}
+	if latest != schemaVersion {
+		t.Errorf("The database was at %s instead of %s", latest, schemaVersion)
+	}
+}
+
+func TestPatchJob(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	invocationID := uuid.New()
+	jr := &JobRecord{
+		Submitter:    "test_user",
+		CondorID:     "10000",
+		AppID:        uuid.New(),
+		InvocationID: invocationID,
+	}
+	id, err := d.InsertJob(jr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.DeleteJob(id)
+	r, err := ParseJobRequest([]byte(`{"ExitCode" : 2, "BatchID" : ""}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := d.PatchJob(id, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.ExitCode != 2 {
+		t.Errorf("The exit code was %d instead of 2", patched.ExitCode)
+	}
+	if patched.InvocationID != invocationID || patched.Submitter != "test_user" || patched.AppID != jr.AppID {
+		t.Errorf("Fields that weren't in the request were changed: %#v", patched)
+	}
+	if _, err = d.PatchJob(uuid.New(), r); err != sql.ErrNoRows {
+		t.Errorf("Patching a job that doesn't exist returned %v instead of sql.ErrNoRows", err)
+	}
+}
+
+func TestJobStatusTransitions(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	jr := &JobRecord{
+		BatchID:       "",
+		Submitter:     "unit_tests",
+		DateSubmitted: time.Now(),
+		DateStarted:   time.Now(),
+		DateCompleted: time.Now(),
+		AppID:         uuid.New(),
+	}
+	jobID, err := d.InsertJob(jr)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	jr.ID = jobID
+	_, err = d.GetLastJobStatusTransition(jr.ID)
+	if err != sql.ErrNoRows {
+		t.Errorf("GetLastJobStatusTransition didn't return sql.ErrNoRows for a new job: %s", err)
+	}
+	ce := &CondorEvent{
+		EventNumber: "001",
+		EventName:   "test_event",
+		EventDesc:   "event for unit tests",
+	}
+	eventID, err := d.InsertCondorEvent(ce)
+	if err != nil {
+		t.Error(err)
+	}
+	ce.ID = eventID
+	rawEventID, err := d.AddCondorRawEvent("this is a unit test event", jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	jobEventID, err := d.AddCondorJobEvent(jr.ID, ce.ID, rawEventID, "")
+	if err != nil {
+		t.Error(err)
+	}
+	first := &JobStatusTransition{
+		JobID:            jr.ID,
+		CondorJobEventID: jobEventID,
+		FromStatus:       StatusNone,
+		ToStatus:         StatusSubmitted,
+		Reason:           "unit tests",
+		DateTriggered:    time.Now().Add(-time.Minute),
+	}
+	firstID, err := d.InsertJobStatusTransition(first)
+	if err != nil {
+		t.Error(err)
+	}
+	second := &JobStatusTransition{
+		JobID:            jr.ID,
+		CondorJobEventID: jobEventID,
+		FromStatus:       StatusSubmitted,
+		ToStatus:         StatusRunning,
+		Reason:           "unit tests",
+		DateTriggered:    time.Now(),
+	}
+	secondID, err := d.InsertJobStatusTransition(second)
+	if err != nil {
+		t.Error(err)
+	}
+	last, err := d.GetLastJobStatusTransition(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if last.ID != secondID {
+		t.Errorf("The last transition was %s instead of %s", last.ID, secondID)
+	}
+	if last.ToStatus != StatusRunning {
+		t.Errorf("The last transition's ToStatus was %s instead of %s", last.ToStatus, StatusRunning)
+	}
+	all, err := d.GetJobStatusTransitions(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("Number of transitions returned wasn't 2: %d", len(all))
+	}
+	if all[0].ID != firstID {
+		t.Errorf("The first transition was %s instead of %s", all[0].ID, firstID)
+	}
+	if second.Sequence <= first.Sequence {
+		t.Errorf("The second transition's sequence (%d) wasn't after the first's (%d)", second.Sequence, first.Sequence)
+	}
+	if last.Sequence != second.Sequence {
+		t.Errorf("The last transition's sequence was %d instead of %d", last.Sequence, second.Sequence)
+	}
+	streamed, err := d.GetStreamEvents(&StreamFilter{JobID: jr.ID}, first.Sequence, streamReplayLimit)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(streamed) != 1 {
+		t.Errorf("Number of stream events after the first transition wasn't 1: %d", len(streamed))
+	} else if streamed[0].ID != second.Sequence || streamed[0].ToStatus != StatusRunning {
+		t.Errorf("The stream event was %d (%s) instead of %d (%s)", streamed[0].ID, streamed[0].ToStatus, second.Sequence, StatusRunning)
+	}
+	streamed, err = d.GetStreamEvents(&StreamFilter{JobID: jr.ID, Submitter: "someone else"}, 0, streamReplayLimit)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(streamed) != 0 {
+		t.Errorf("Stream events were returned for another submitter: %d", len(streamed))
+	}
+	err = d.DeleteJobStatusTransition(firstID)
+	if err != nil {
+		t.Error(err)
+	}
+	err = d.DeleteJobStatusTransition(secondID)
+	if err != nil {
+		t.Error(err)
+	}
+	err = d.DeleteCondorEvent(ce.ID)
+	if err != nil {
+		t.Error(err)
+	}
+	err = d.DeleteJob(jr.ID)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCRUDOutboundNotifications(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Error(err)
+	}
+	defer d.db.Close()
+	n := &OutboundNotification{
+		URL:            "http://localhost/de-job",
+		IdempotencyKey: uuid.New(),
+		Payload:        "{}",
+		Status:         NotificationPending,
+		MaxAttempts:    1,
+		NextAttempt:    time.Now().Add(-time.Minute),
+	}
+	id, err := d.InsertOutboundNotification(n)
+	if err != nil {
+		t.Error(err)
+	}
+	byKey, err := d.GetOutboundNotificationByKey(n.IdempotencyKey)
+	if err != nil {
+		t.Error(err)
+	}
+	if byKey.ID != id {
+		t.Errorf("IDs don't match after lookup by key")
+	}
+	due, err := d.GetDueOutboundNotifications(time.Now(), 1000)
+	if err != nil {
+		t.Error(err)
+	}
+	found := false
+	for _, dn := range due {
+		if dn.ID == id {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Notification %s wasn't returned as due", id)
+	}
+	byKey.Status = NotificationDead
+	byKey.Attempts = 1
+	byKey.LastError = "unit tests"
+	updated, err := d.UpdateOutboundNotification(byKey)
+	if err != nil {
+		t.Error(err)
+	}
+	if updated.Status != NotificationDead {
+		t.Errorf("Status was %s after update", updated.Status)
+	}
+	if !updated.DateDelivered.IsZero() {
+		t.Errorf("DateDelivered was set for a dead notification")
+	}
+	dead, err := d.GetDeadOutboundNotifications()
+	if err != nil {
+		t.Error(err)
+	}
+	found = false
+	for _, dn := range dead {
+		if dn.ID == id {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Notification %s wasn't returned as dead", id)
+	}
+	err = d.DeleteOutboundNotification(id)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobArchives(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	finished := time.Now().Add(-48 * time.Hour)
+	id := addFinishedJob(t, d, "archive-test", finished)
+	defer d.DeleteJob(id)
+	expired, err := d.GetExpiredJobs(finished.Add(time.Minute), 1000)
+	if err != nil {
+		t.Error(err)
+	}
+	found := false
+	for _, jr := range expired {
+		if jr.ID == id {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Job %s wasn't returned as expired", id)
+	}
+	expired, _ = d.GetExpiredJobs(finished.Add(-time.Minute), 1000)
+	for _, jr := range expired {
+		if jr.ID == id {
+			t.Errorf("Job %s expired before it finished", id)
+		}
+	}
+	ja, err := d.GetJobArchive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ja.RawEvents) != 1 || len(ja.JobEvents) != 1 || len(ja.StopRequests) != 1 || len(ja.Transitions) != 1 {
+		t.Fatalf("The archive was %#v", ja)
+	}
+	if err = d.DeleteJob(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.RestoreJobArchive(ja); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.RestoreJobArchive(ja); err != ErrArchiveRestored {
+		t.Errorf("Restoring a job twice returned %v instead of ErrArchiveRestored", err)
+	}
+	restored, err := d.GetJobArchive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.LastEventID != ja.LastEventID || restored.Transitions[0].Sequence != ja.Transitions[0].Sequence {
+		t.Errorf("The restored job was %#v", restored)
+	}
+	if history, _ := d.GetJobEventHistory(id); len(history) != 1 || history[0].RawEvent != "raw archive-test" {
+		t.Errorf("The restored history was %#v", history)
+	}
+}
+
+func TestPruneJobHistory(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStorePruneJobHistory(t, d, "prune-test")
+}
+
+func TestEventBatches(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	id, err := d.InsertJob(&JobRecord{CondorID: "event-batch-test", Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.DeleteJob(id)
+	testStoreEventBatches(t, d, id)
+}
+
+func TestOrphanedJobs(t *testing.T) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreOrphanedJobs(t, d, int(time.Now().Unix()))
+}
+
+func TestRebuild(t *testing.T) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreRebuild(t, d, int(time.Now().Unix()))
+}
+
+func TestTermination(t *testing.T) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreTermination(t, d, int(time.Now().Unix()))
+}
+
+func TestResourceUsage(t *testing.T) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreResourceUsage(t, d, int(time.Now().Unix()))
+}
+
+func BenchmarkReplay(b *testing.B) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer d.Close()
+	benchmarkReplay(b, d, 1)
+}
+
+func BenchmarkBatchedReplay(b *testing.B) {
+	d, err := NewDatabaser(ConnString())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer d.Close()
+	benchmarkReplay(b, d, 500)
+}
+
+func TestServiceLocks(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreServiceLocks(t, d, "lock-test")
+}
+
+func TestJobFlags(t *testing.T) {
+	connString := ConnString()
+	d, err := NewDatabaser(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	testStoreJobFlags(t, d, "flags-test")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDependencyCycle is returned when adding a dependency would make a job
+// depend on itself, either directly or through other jobs.
+var ErrDependencyCycle = errors.New("the dependency would create a cycle")
+
+// JobDependencies describes where a job sits in its dependency graph.
+// Predecessors and Successors are the jobs it's directly connected to, while
+// Ancestors and Descendants include everything reachable through them.
+type JobDependencies struct {
+	JobID        string
+	Predecessors []JobRecord
+	Successors   []JobRecord
+	Ancestors    []JobRecord
+	Descendants  []JobRecord
+}
+
+// CreatesCycle returns true if making the dependency's successor depend on its
+// predecessor would create a cycle. 'descendants' must contain all of the jobs
+// that already depend on the successor.
+func CreatesCycle(dep *CondorJobDep, descendants []JobRecord) bool {
+	if dep.PredecessorID == dep.SuccessorID {
+		return true
+	}
+	for _, job := range descendants {
+		if job.ID == dep.PredecessorID {
+			return true
+		}
+	}
+	return false
+}
+
+// AddJobDependency records that the successor depends on the predecessor.
+// ErrDependencyCycle is returned if the dependency would create a cycle.
+// Adding a dependency that already exists does nothing. The store checks for
+// the cycle and adds the dependency atomically, one dependency at a time, so
+// two requests can't each add half of a cycle.
+func AddJobDependency(d JobStore, dep *CondorJobDep) error {
+	return d.AddCondorJobDep(dep)
+}
+
+// LoadJobDependencies looks up the dependency graph around a job.
+func LoadJobDependencies(d JobStore, jobID string) (*JobDependencies, error) {
+	var err error
+	deps := &JobDependencies{JobID: jobID}
+	if deps.Predecessors, err = d.GetPredecessors(jobID); err != nil {
+		return nil, err
+	}
+	if deps.Successors, err = d.GetSuccessors(jobID); err != nil {
+		return nil, err
+	}
+	if deps.Ancestors, err = d.GetAncestors(jobID); err != nil {
+		return nil, err
+	}
+	if deps.Descendants, err = d.GetDescendants(jobID); err != nil {
+		return nil, err
+	}
+	for _, list := range []*[]JobRecord{&deps.Predecessors, &deps.Successors, &deps.Ancestors, &deps.Descendants} {
+		if *list == nil {
+			*list = []JobRecord{}
+		}
+	}
+	return deps, nil
+}
+
+// PredecessorFailureMessage returns the message reported upstream for a job
+// that was stopped because a job it depends on failed.
+func PredecessorFailureMessage(predecessor *JobRecord) string {
+	return fmt.Sprintf("predecessor failed: job %s", predecessor.ID)
+}
+
+// PropagateFailure stops every job that depends on a job that just failed,
+// since none of them can succeed without it. Each of the stopped jobs is
+// reported as failed.
+func (p *PostEventHandler) PropagateFailure(job *JobRecord, event *Event) error {
+	descendants, err := p.DB.GetDescendants(job.ID)
+	if err != nil {
+		return err
+	}
+	message := PredecessorFailureMessage(job)
+	for i := range descendants {
+		descendant := &descendants[i]
+		running, err := p.isStillGoing(descendant)
+		if err != nil {
+			logger.Printf("Error getting the status of job %s, which depends on job %s: %s", descendant.ID, job.ID, err)
+			continue
+		}
+		if !running {
+			continue
+		}
+		stopped, err := p.stopJob(descendant, message)
+		if err != nil {
+			logger.Printf("Error stopping job %s, which depends on job %s: %s", descendant.ID, job.ID, err)
+		}
+		if !stopped {
+			continue
+		}
+		if err = p.Notifiers.Notify(stoppedStatusChange(descendant, event, message)); err != nil {
+			logger.Printf("Error reporting the failure of job %s: %s", descendant.ID, err)
+		}
+	}
+	return nil
+}
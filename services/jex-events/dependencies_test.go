@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCreatesCycle(t *testing.T) {
+	dep := &CondorJobDep{PredecessorID: "a", SuccessorID: "a"}
+	if !CreatesCycle(dep, nil) {
+		t.Error("A job depending on itself wasn't a cycle")
+	}
+	dep = &CondorJobDep{PredecessorID: "a", SuccessorID: "b"}
+	if CreatesCycle(dep, []JobRecord{{ID: "c"}, {ID: "d"}}) {
+		t.Error("A dependency that doesn't loop back was a cycle")
+	}
+	if !CreatesCycle(dep, []JobRecord{{ID: "c"}, {ID: "a"}}) {
+		t.Error("A dependency on a descendant wasn't a cycle")
+	}
+}
+
+func TestPredecessorFailureMessage(t *testing.T) {
+	msg := PredecessorFailureMessage(&JobRecord{ID: "job-id"})
+	if msg != "predecessor failed: job job-id" {
+		t.Errorf("The message was '%s'", msg)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// maxBatchParams is the most parameters that are put in one statement when
+// rows are inserted in batches. It's the limit in older versions of SQLite;
+// PostgreSQL allows many more.
+const maxBatchParams = 999
+
+// batchRetryWait is how long BatchEventHandler waits after a batch can't be
+// stored before it handles the next one.
+const batchRetryWait = 5 * time.Second
+
+// EventBatch is everything that a batch of events adds to a JobStore, which
+// WriteEventBatch writes in a single transaction. NewJobs are added to the
+// store. Jobs only update the columns that events decide: the exit code, the
+// failure count, and how the job terminated. Their invocation IDs are only
+// stored for jobs that don't have one yet, since the HTTP API and the
+// Correlator can link a job while its events are being handled.
+// The IDs of the new jobs and of the raw and job events have to be filled in
+// beforehand so that the rows can refer to each other. The transitions need
+// their DateRecorded set; their IDs and sequence numbers are filled in when
+// they're written. LastEvents and Usage hold at most one entry per job, and
+// replace what's stored for the job.
+type EventBatch struct {
+	NewJobs     []*JobRecord
+	Jobs        []*JobRecord
+	RawEvents   []CondorRawEvent
+	JobEvents   []CondorJobEvent
+	LastEvents  []LastCondorJobEvent
+	Transitions []*JobStatusTransition
+	Usage       []JobResourceUsage
+}
+
+// batchQueries calls fn with the statements that handle all of the rows a
+// chunk at a time, keeping each statement under maxBatchParams parameters.
+// 'format' is the statement with a %s where the list of rows goes, and 'row'
+// is the SQL for one row with a ? for each value. The ?s are replaced with
+// numbered parameters, which both PostgreSQL and SQLite accept.
+func batchQueries(format, row string, rows [][]interface{}, fn func(query string, args []interface{}) error) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	perChunk := maxBatchParams / len(rows[0])
+	for start := 0; start < len(rows); start += perChunk {
+		end := start + perChunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var values []string
+		var args []interface{}
+		for _, r := range rows[start:end] {
+			parts := strings.Split(row, "?")
+			var v string
+			for i, part := range parts {
+				if i > 0 {
+					args = append(args, r[i-1])
+					v += fmt.Sprintf("$%d", len(args))
+				}
+				v += part
+			}
+			values = append(values, v)
+		}
+		if err := fn(fmt.Sprintf(format, strings.Join(values, ", ")), args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execBatch runs the statements from batchQueries in the transaction.
+func execBatch(tx *sql.Tx, format, row string, rows [][]interface{}) error {
+	return batchQueries(format, row, rows, func(query string, args []interface{}) error {
+		_, err := tx.Exec(query, args...)
+		return err
+	})
+}
+
+// checksumRows returns the checksums as rows for batchQueries.
+func checksumRows(checksums []string) [][]interface{} {
+	rows := make([][]interface{}, len(checksums))
+	for i, checksum := range checksums {
+		rows[i] = []interface{}{checksum}
+	}
+	return rows
+}
+
+// queryChecksums runs the SELECT of checksums in 'format' for all of the
+// checksums and returns the ones that it finds.
+func queryChecksums(db *sql.DB, format string, checksums []string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	err := batchQueries(format, "?", checksumRows(checksums), func(query string, args []interface{}) error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var checksum string
+			if err = rows.Scan(&checksum); err != nil {
+				return err
+			}
+			found[checksum] = true
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// processedEvent is an event from a batch that was added to the EventBatch,
+// along with what's needed to finish handling it once the batch is written.
+type processedEvent struct {
+	event      *Event
+	job        *JobRecord
+	jobEventID string
+	transition *JobStatusTransition
+	failed     bool
+	linked     bool
+	rejected   bool
+}
+
+// applyEvent updates the job with what the event says about it. It returns
+// true if the event counts as a failure of the job.
+func applyEvent(job *JobRecord, event *Event) bool {
+	// set the invocation id, but only if it's not set and the event actually
+	// has a value to update it with.
+	if job.InvocationID == "" && event.InvocationID != "" {
+		job.InvocationID = event.InvocationID
+		logger.Printf("Setting InvocationID to %s", job.InvocationID)
+	} else {
+		logger.Printf("Setting the InvocationID was not necessary")
+	}
+
+	// only the terminated event says how the job ended. Condor often logs a
+	// job ad event after it, which mustn't wipe out the exit code.
+	if event.EventNumber != "005" {
+		return false
+	}
+	job.ExitCode = event.ExitCode
+	job.TerminationKind = event.TerminationKind
+	job.TerminationSignal = event.TerminationSignal
+
+	// we're expecting an exit code of 0 for successful runs. HT jobs may have
+	// more than one failure.
+	failed := job.ExitCode != 0
+	if failed {
+		job.FailureCount = job.FailureCount + 1
+	}
+	return failed
+}
+
+// ProcessEvents handles the bodies of a batch of event messages. The events
+// are handled in order, the same way EventHandler handles them one at a time,
+// except that everything they add to the JobStore is written at once with
+// WriteEventBatch. Events whose checksums are already stored, or that came
+// earlier in the batch, are skipped. Nothing goes upstream until the batch has
+// been written, so the notifications, stop confirmations, failure handling,
+// and status stream updates all happen afterwards. If the batch can't be read
+// from or written to the JobStore, none of the events in it are recorded or
+// sent, and the error is returned so that the messages can be redelivered.
+// The same goes for a batch that comes in while another process, like a
+// rebuild, holds the events lock, or that has an event whose condor event
+// can't be looked up. Messages that can't be decoded and events with unknown
+// event numbers are logged and dropped.
+func ProcessEvents(d JobStore, eventHandler *PostEventHandler, bodies [][]byte) error {
+	var events []*Event
+	var checksums []string
+	for _, body := range bodies {
+		event := &Event{}
+		err := json.Unmarshal(body, event)
+		if err != nil {
+			logger.Print(err)
+			logger.Print(string(body[:]))
+			continue
+		}
+
+		// parse the body of the event that came from the amqp broker.
+		event.Parse()
+		logger.Println(event.String())
+		events = append(events, event)
+		checksums = append(checksums, event.Hash)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if eventHandler.LockHolder != "" {
+		if err := acquireLock(d, eventsLock, eventHandler.LockHolder); err != nil {
+			logger.Printf("Error acquiring the %s lock: %s", eventsLock, err)
+			return err
+		}
+	}
+	existing, err := d.FindCondorJobEventChecksums(checksums)
+	if err != nil {
+		logger.Printf("Error checking for job event existence by checksum: %s", err)
+		return err
+	}
+
+	// The jobs and their last transitions are looked up once per batch and
+	// kept up to date as the events are handled. Jobs that aren't in the
+	// database yet are added along with the rest of the batch.
+	batch := &EventBatch{}
+	jobs := make(map[string]*JobRecord)
+	updated := make(map[string]bool)
+	lastTransitions := make(map[string]*JobStatusTransition)
+	lastEvents := make(map[string]int)
+	usage := make(map[string]int)
+
+	// The job events that went upstream with an invocation ID are tracked so
+	// that they aren't sent again when a job gets linked to its invocation.
+	sent := make(map[string]map[string]bool)
+	var processed []*processedEvent
+	var recorded time.Time
+	for _, event := range events {
+		// adds the job to the batch, but only if it doesn't already exist.
+		job, ok := jobs[event.CondorID]
+		if !ok {
+			job, err = d.GetJobByCondorID(event.CondorID)
+			if err == sql.ErrNoRows {
+				job = &JobRecord{ID: newUUID(), CondorID: event.CondorID}
+				batch.NewJobs = append(batch.NewJobs, job)
+				updated[job.ID] = true
+				lastTransitions[job.ID] = nil
+			} else if err != nil {
+				logger.Printf("Error getting job by condor id: %s", err)
+				return err
+			}
+			jobs[event.CondorID] = job
+		}
+
+		// don't add the event to the database if it's already there.
+		if existing[event.Hash] {
+			logger.Printf("An event with a hash of %s already exists in the database, skipping", event.Hash)
+			continue
+		}
+
+		// the condor event is looked up before the event changes anything in
+		// the batch. Events with unknown numbers can't be stored, so they're
+		// dropped, but any other error fails the batch so it's redelivered.
+		ce, err := d.GetCondorEventByNumber(event.EventNumber)
+		if err == sql.ErrNoRows {
+			logger.Printf("Event %s has an unknown event number, skipping: %s", event.Hash, event.EventNumber)
+			continue
+		} else if err != nil {
+			logger.Printf("Error getting condor event: %s", err)
+			return err
+		}
+		existing[event.Hash] = true
+
+		// Redelivered events were skipped above, so failures don't get counted
+		// twice.
+		orphaned := job.InvocationID == ""
+		failed := applyEvent(job, event)
+		linked := orphaned && job.InvocationID != ""
+		if !updated[job.ID] {
+			updated[job.ID] = true
+			batch.Jobs = append(batch.Jobs, job)
+		}
+
+		// update the parsed event object with info from the job.
+		event.JobID = job.ID
+		event.CondorID = job.CondorID
+		event.InvocationID = job.InvocationID
+		event.AppID = job.AppID
+		event.User = job.Submitter
+
+		// figure out if the event is allowed to change the status of the job.
+		// events that arrive out of order are still stored below, but they
+		// don't get sent upstream and don't count as the last event.
+		lastTransition, ok := lastTransitions[job.ID]
+		if !ok {
+			lastTransition, err = d.GetLastJobStatusTransition(job.ID)
+			if err == sql.ErrNoRows {
+				lastTransition = nil
+			} else if err != nil {
+				logger.Printf("Error getting the last status transition for job %s: %s", job.ID, err)
+				return err
+			}
+			lastTransitions[job.ID] = lastTransition
+		}
+		transition, err := NextJobStatus(job.ID, lastTransition, event, time.Now())
+		rejected := err != nil
+		if rejected {
+			logger.Printf("Event %s for job %s was not applied: %s", event.Hash, job.ID, err)
+		}
+
+		// store the unparsed (raw), event information along with the parsed event.
+		now := time.Now()
+		rawEvent := CondorRawEvent{
+			ID:            newUUID(),
+			JobID:         job.ID,
+			EventText:     event.Event,
+			DateTriggered: now,
+		}
+		jobEvent := CondorJobEvent{
+			ID:               newUUID(),
+			JobID:            job.ID,
+			CondorEventID:    ce.ID,
+			CondorRawEventID: rawEvent.ID,
+			Hash:             event.Hash,
+			DateTriggered:    now,
+		}
+		batch.RawEvents = append(batch.RawEvents, rawEvent)
+		batch.JobEvents = append(batch.JobEvents, jobEvent)
+		if !rejected && job.InvocationID != "" {
+			if sent[job.ID] == nil {
+				sent[job.ID] = make(map[string]bool)
+			}
+			sent[job.ID][jobEvent.ID] = true
+		}
+		// resource usage is recorded even if the event arrived out of order,
+		// since the job still used the resources.
+		if event.Usage != nil {
+			u := *event.Usage
+			u.JobID = job.ID
+			u.CondorJobEventID = jobEvent.ID
+			u.DateTriggered = now
+			if i, ok := usage[job.ID]; ok {
+				batch.Usage[i] = u
+			} else {
+				usage[job.ID] = len(batch.Usage)
+				batch.Usage = append(batch.Usage, u)
+			}
+		}
+		if !rejected && eventHandler.ShouldUpdateLastEvents(event) {
+			le := LastCondorJobEvent{JobID: job.ID, CondorJobEventID: jobEvent.ID}
+			if i, ok := lastEvents[job.ID]; ok {
+				batch.LastEvents[i] = le
+			} else {
+				lastEvents[job.ID] = len(batch.LastEvents)
+				batch.LastEvents = append(batch.LastEvents, le)
+			}
+		}
+		if !rejected && transition != nil {
+			// transitions triggered at the same time are ordered by when they
+			// were recorded, so every transition in the batch gets a later
+			// time than the one before it, down to the microsecond that
+			// PostgreSQL keeps.
+			if now.Sub(recorded) < time.Microsecond {
+				now = recorded.Add(time.Microsecond)
+			}
+			recorded = now
+			transition.CondorJobEventID = jobEvent.ID
+			transition.DateRecorded = recorded
+			batch.Transitions = append(batch.Transitions, transition)
+			lastTransitions[job.ID] = transition
+		} else {
+			transition = nil
+		}
+		processed = append(processed, &processedEvent{
+			event:      event,
+			job:        job,
+			jobEventID: jobEvent.ID,
+			transition: transition,
+			failed:     failed,
+			linked:     linked,
+			rejected:   rejected,
+		})
+	}
+
+	if err = d.WriteEventBatch(batch); err != nil {
+		logger.Printf("Error storing a batch of %d event(s): %s", len(processed), err)
+		return err
+	}
+
+	// A batch's status only needs to be checked once, after all of the jobs
+	// in the event batch that finished have been recorded.
+	finishedBatches := make(map[string]bool)
+	for _, p := range processed {
+		job, event, transition := p.job, p.event, p.transition
+
+		// send event updates upstream to Donkey
+		if !p.rejected {
+			err = eventHandler.Route(event)
+			if err != nil {
+				logger.Printf("Error sending event upstream: %s", err)
+			}
+		}
+		err = eventHandler.ConfirmStop(event, p.jobEventID)
+		if err != nil {
+			logger.Printf("Error confirming the stop requests for job %s: %s", job.ID, err)
+		}
+		if p.linked {
+			err = eventHandler.Backfill(job, sent[job.ID])
+			if err != nil {
+				logger.Printf("Error backfilling the status of job %s: %s", job.ID, err)
+			}
+		}
+		if transition != nil {
+			logger.Printf("Job %s moved from '%s' to '%s'", job.ID, transition.FromStatus, transition.ToStatus)
+			if eventHandler.Streams != nil {
+				eventHandler.Streams.Publish(NewStreamEvent(job, transition))
+			}
+		}
+		if p.failed {
+			err = eventHandler.EnforceFailureThresholds(job, event)
+			if err != nil {
+				logger.Printf("Error enforcing the failure thresholds for job %s: %s", job.ID, err)
+			}
+		}
+		if transition != nil && transition.ToStatus == StatusFailed {
+			err = eventHandler.PropagateFailure(job, event)
+			if err != nil {
+				logger.Printf("Error propagating the failure of job %s to its successors: %s", job.ID, err)
+			}
+		}
+		if transition != nil && IsTerminalStatus(transition.ToStatus) && job.BatchID != "" && !finishedBatches[job.BatchID] {
+			finishedBatches[job.BatchID] = true
+			err = eventHandler.BatchJobFinished(job, event)
+			if err != nil {
+				logger.Printf("Error checking whether batch %s is finished: %s", job.BatchID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fillBatch adds deliveries to the batch until it has 'size' of them or
+// 'timeout' fires. If 'timeout' is nil, only the deliveries that are already
+// waiting are added. The returned bool is true if 'quit' received a value or
+// was closed, or if 'deliveries' was closed.
+func fillBatch(batch []amqp.Delivery, size int, deliveries <-chan amqp.Delivery, quit <-chan int, timeout <-chan time.Time) ([]amqp.Delivery, bool) {
+	for len(batch) < size {
+		var delivery amqp.Delivery
+		var ok bool
+		if timeout == nil {
+			select {
+			case delivery, ok = <-deliveries:
+			case <-quit:
+				return batch, true
+			default:
+				return batch, false
+			}
+		} else {
+			select {
+			case delivery, ok = <-deliveries:
+			case <-quit:
+				return batch, true
+			case <-timeout:
+				return batch, false
+			}
+		}
+		if !ok {
+			logger.Println("The AMQP delivery channel was closed.")
+			return batch, true
+		}
+		batch = append(batch, delivery)
+	}
+	return batch, false
+}
+
+// ackBatch acknowledges the deliveries in a batch once it has been stored, or
+// rejects them so that the broker delivers them again if it couldn't be.
+func ackBatch(batch []amqp.Delivery, stored bool) {
+	for _, delivery := range batch {
+		var err error
+		if stored {
+			err = delivery.Ack(false) //We're not doing batch deliveries, which is what the false means
+		} else {
+			err = delivery.Nack(false, true)
+		}
+		if err != nil && delivery.Acknowledger != nil {
+			logger.Printf("Error acknowledging delivery %d: %s", delivery.DeliveryTag, err)
+		}
+	}
+}
+
+// BatchEventHandler processes incoming event messages in batches of up to
+// 'size' events with ProcessEvents. Once the first delivery of a batch
+// arrives, the handler waits up to 'wait' for the rest of the batch; if 'wait'
+// is 0, only the deliveries that are already waiting are added to it. The
+// deliveries are only acknowledged once the batch has been stored. If it
+// can't be, they're requeued and the handler waits for batchRetryWait before
+// carrying on, so that an outage doesn't turn into a busy loop. It returns
+// when 'quit' receives a value or is closed, or when 'deliveries' is closed.
+// The batch that's being put together when that happens is processed first.
+func BatchEventHandler(deliveries <-chan amqp.Delivery, quit <-chan int, d JobStore, eventHandler *PostEventHandler, size int, wait time.Duration) {
+	for {
+		var batch []amqp.Delivery
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				logger.Println("The AMQP delivery channel was closed.")
+				return
+			}
+			batch = append(batch, delivery)
+		case <-quit:
+			return
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		batch, stop := fillBatch(batch, size, deliveries, quit, timeout)
+		if timer != nil {
+			timer.Stop()
+		}
+		var bodies [][]byte
+		for _, delivery := range batch {
+			bodies = append(bodies, delivery.Body)
+		}
+		err := ProcessEvents(d, eventHandler, bodies)
+		ackBatch(batch, err == nil)
+		if stop {
+			return
+		}
+		if err != nil {
+			select {
+			case <-time.After(batchRetryWait):
+			case <-quit:
+				return
+			}
+		}
+	}
+}
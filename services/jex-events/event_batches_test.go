@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestBatchQueries(t *testing.T) {
+	var rows [][]interface{}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	var queries []string
+	var chunks []int
+	err := batchQueries("INSERT INTO t (a, b) VALUES %s", "(?, cast(? as text))", rows, func(query string, args []interface{}) error {
+		queries = append(queries, query)
+		chunks = append(chunks, len(args))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(chunks) != "[998 998 4]" {
+		t.Errorf("The rows were split into chunks of %v parameters", chunks)
+	}
+	if !strings.HasPrefix(queries[2], "INSERT INTO t (a, b) VALUES ($1, cast($2 as text)), ($3, cast($4 as text))") {
+		t.Errorf("The last query was %s", queries[2])
+	}
+	if err = batchQueries("%s", "?", nil, nil); err != nil {
+		t.Errorf("Handling no rows returned %v", err)
+	}
+}
+
+// replayEvents is the number of events in the backlog that the replay
+// benchmarks work through in each iteration.
+const replayEvents = 100000
+
+// replayEventTexts are the events that each job in the backlog goes through.
+var replayEventTexts = []string{
+	"000 (%d.000.000) 08/11/15 12:00:00 Job submitted from host: <127.0.0.1:9618>\n",
+	"001 (%d.000.000) 08/11/15 12:01:00 Job executing on host: <127.0.0.1:9618>\n",
+	"005 (%d.000.000) 08/11/15 12:10:00 Job terminated.\n\t(1) Normal termination (return value 0)\n",
+}
+
+// benchmarkReplay measures how quickly BatchEventHandler works through a
+// backlog of replayEvents events that have already been delivered, handling
+// up to batchSize events at a time. The jobs in the backlog are new for each
+// iteration, and they're deleted afterwards without being timed.
+func benchmarkReplay(b *testing.B, d JobStore, batchSize int) {
+	saved := logger
+	logger = log.New(ioutil.Discard, "", 0)
+	defer func() { logger = saved }()
+	handler := &PostEventHandler{DB: d}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		first := time.Now().UnixNano()
+		deliveries := make(chan amqp.Delivery, replayEvents)
+		for n := 0; n < replayEvents; n++ {
+			text := fmt.Sprintf(replayEventTexts[n%len(replayEventTexts)], first+int64(n/len(replayEventTexts)))
+			deliveries <- condorEventDelivery(b, text)
+		}
+		close(deliveries)
+		b.StartTimer()
+
+		start := time.Now()
+		BatchEventHandler(deliveries, make(chan int), d, handler, batchSize, 0)
+		elapsed := time.Since(start)
+
+		b.StopTimer()
+		b.Logf("Replayed %d events in %s (%.0f events/s)", replayEvents, elapsed, replayEvents/elapsed.Seconds())
+		for n := 0; n < replayEvents; n += len(replayEventTexts) {
+			condorID := fmt.Sprintf("%d", first+int64(n/len(replayEventTexts)))
+			if job, err := d.GetJobByCondorID(condorID); err == nil {
+				d.DeleteJob(job.ID)
+			}
+		}
+		b.StartTimer()
+	}
+}
+
+// unwritableStore is a MemoryStore that can't write event batches.
+type unwritableStore struct {
+	*MemoryStore
+}
+
+func (u unwritableStore) WriteEventBatch(b *EventBatch) error {
+	return errors.New("the database is down")
+}
+
+// condorEventlessStore is a MemoryStore that can't look up condor events.
+type condorEventlessStore struct {
+	*MemoryStore
+}
+
+func (c condorEventlessStore) GetCondorEventByNumber(number string) (*CondorEvent, error) {
+	return nil, errors.New("the database is down")
+}
+
+// countingAcknowledger counts the deliveries that are acknowledged and
+// rejected.
+type countingAcknowledger struct {
+	acks, nacks int
+}
+
+func (c *countingAcknowledger) Ack(tag uint64, multiple bool) error {
+	c.acks++
+	return nil
+}
+
+func (c *countingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		c.nacks++
+	}
+	return nil
+}
+
+func (c *countingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func TestProcessEventsLocked(t *testing.T) {
+	p, r := newRecordingHandler()
+	p.LockHolder = "jex-events"
+	body := condorEventDelivery(t, fmt.Sprintf(submitEventWithAd, 100, "ee4bdbe2-4372-11e5-8f4f-cbbf5e9d6a47")).Body
+	if _, err := p.DB.AcquireServiceLock(&ServiceLock{Name: eventsLock, Holder: "rebuild"}, time.Now(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := ProcessEvents(p.DB, p, [][]byte{body}); err == nil {
+		t.Error("A batch was stored while a rebuild held the events lock")
+	}
+	if _, err := p.DB.GetJobByCondorID("100"); err == nil || len(r.changes) != 0 {
+		t.Error("The events were handled while a rebuild held the events lock")
+	}
+	if err := p.DB.ReleaseServiceLock(eventsLock, "rebuild"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ProcessEvents(p.DB, p, [][]byte{body}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RebuildJobs(p.DB, nil, false, ioutil.Discard); err == nil {
+		t.Error("A rebuild started while the events were being stored")
+	}
+}
+
+func TestProcessEventsWriteFailure(t *testing.T) {
+	p, r := newRecordingHandler()
+	p.DB = unwritableStore{NewMemoryStore()}
+	body := condorEventDelivery(t, fmt.Sprintf(submitEventWithAd, 100, "ee4bdbe2-4372-11e5-8f4f-cbbf5e9d6a47")).Body
+	if err := ProcessEvents(p.DB, p, [][]byte{body}); err == nil {
+		t.Error("A batch that couldn't be written didn't return an error")
+	}
+	if len(r.changes) != 0 {
+		t.Errorf("Status changes were sent for a batch that wasn't written: %v", describeChanges(r.changes))
+	}
+
+	// The deliveries are only acknowledged once their batch is stored.
+	acks := &countingAcknowledger{}
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{Acknowledger: acks, Body: body}
+	close(deliveries)
+	BatchEventHandler(deliveries, make(chan int), p.DB, p, 10, 0)
+	if acks.acks != 0 || acks.nacks != 1 {
+		t.Errorf("%d deliveries were acknowledged and %d requeued", acks.acks, acks.nacks)
+	}
+	p.DB = NewMemoryStore()
+	deliveries = make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{Acknowledger: acks, Body: body}
+	close(deliveries)
+	BatchEventHandler(deliveries, make(chan int), p.DB, p, 10, 0)
+	if acks.acks != 1 || len(r.changes) != 1 {
+		t.Errorf("%d deliveries were acknowledged and %d changes sent", acks.acks, len(r.changes))
+	}
+}
+
+func TestProcessEventsCondorEventFailure(t *testing.T) {
+	p, r := newRecordingHandler()
+	m := p.DB.(*MemoryStore)
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(replayEventTexts[1], 100))
+	failed := condorEventDelivery(t, "005 (100.000.000) 08/11/15 12:10:00 Job terminated.\n\t(1) Normal termination (return value 1)\n").Body
+
+	// The job isn't changed when the event can't be stored, so the batch can
+	// be redelivered.
+	r.changes = nil
+	p.DB = condorEventlessStore{m}
+	if err := ProcessEvents(p.DB, p, [][]byte{failed}); err == nil {
+		t.Error("A batch whose condor event couldn't be looked up didn't return an error")
+	}
+	job, err := m.GetJobByCondorID("100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.FailureCount != 0 || len(r.changes) != 0 {
+		t.Errorf("The event changed the job (%d failures) or was sent (%v)", job.FailureCount, describeChanges(r.changes))
+	}
+
+	p.DB = m
+	if err = ProcessEvents(p.DB, p, [][]byte{failed}); err != nil {
+		t.Fatal(err)
+	}
+	if job, err = m.GetJobByCondorID("100"); err != nil {
+		t.Fatal(err)
+	}
+	if job.FailureCount != 1 {
+		t.Errorf("The redelivered event left the job with %d failures", job.FailureCount)
+	}
+
+	// Events with unknown numbers are dropped without failing the batch.
+	unknown := condorEventDelivery(t, "099 (100.000.000) 08/11/15 12:11:00 Something new.\n").Body
+	if err = ProcessEvents(p.DB, p, [][]byte{unknown}); err != nil {
+		t.Errorf("An event with an unknown number failed the batch: %s", err)
+	}
+}
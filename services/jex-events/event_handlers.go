@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -12,11 +12,13 @@ import (
 )
 
 // JobStatus contains the actual status of a job, as required by the /de-job
-// endpoint.
+// endpoint. The message is only included when there's something to say about
+// the status, like the signal that killed the job.
 type JobStatus struct {
 	Status         string `json:"status"`
 	CompletionDate string `json:"completion_date,omitempty"`
 	UUID           string `json:"uuid"`
+	Message        string `json:"message,omitempty"`
 }
 
 // JobState is the wrapping object for JobStatus. Doesn't have any operational
@@ -25,64 +27,48 @@ type JobState struct {
 	State JobStatus `json:"state"`
 }
 
+// stopRequestUser is the username recorded for the stop requests that
+// jex-events makes on its own, like when a failure threshold is exceeded.
+const stopRequestUser = "jex-events"
+
 // PostEventHandler is a type that contains the functions that handle the
 // different job states. Right now we map the Condor states to DE states here.
+// If LockHolder is set, the events lock is acquired under that name before
+// each batch of events is stored.
 type PostEventHandler struct {
-	PostURL string
-	JEXURL  string
-	DB      *Databaser
+	JEXURL     string
+	DB         JobStore
+	Queue      *NotificationQueue
+	Notifiers  Notifiers
+	HoldPolicy HoldPolicy
+	Streams    *StatusBroker
+	LockHolder string
 }
 
-// Returns the routing function
-
 // Route decides which handling function an event should be passed along to and
-// then invokes that function.
+// then invokes that function. The function is picked by the Action in the
+// event's mapping.
 func (p *PostEventHandler) Route(event *Event) error {
-	switch event.EventNumber {
-	case "000": //Job Submitted
+	switch event.Mapping().Action {
+	case ActionSubmitted:
 		return p.Submitted(event)
-	case "001": //Job running
+	case ActionRunning:
 		return p.Running(event)
-	case "002": // error in executable
-		return p.Failed(event)
-	case "004": // job evicted
+	case ActionFailed:
 		return p.Failed(event)
-	case "005": // job terminated
+	case ActionCompleted:
 		return p.Completed(event)
-	case "009": // job aborted
-		return p.Failed(event)
-	case "010": // job suspended
-		return p.Failed(event)
-	case "012": // job held
+	case ActionHeld:
 		return p.Held(event)
 	default:
 		return p.Unrouted(event)
 	}
 }
 
-// Indicates whether or not an event should cause last_condor_job_events to be
-// updated.
+// ShouldUpdateLastEvents indicates whether or not an event should cause
+// last_condor_job_events to be updated.
 func (p *PostEventHandler) ShouldUpdateLastEvents(event *Event) bool {
-	switch event.EventNumber {
-	case "000": //Job Submitted
-		return true
-	case "001": //Job running
-		return true
-	case "002": // error in executable
-		return true
-	case "004": // job evicted
-		return true
-	case "005": // job terminated
-		return true
-	case "009": // job aborted
-		return true
-	case "010": // job suspended
-		return true
-	case "012": // job held
-		return true
-	default:
-		return false
-	}
+	return event.Mapping().UpdateLastEvents
 }
 
 // LogResponse logs a htt.Response.
@@ -102,39 +88,22 @@ func LogResponse(resp *http.Response) {
 // JobStatusStatus returns the string that should be used in the Status field
 // of a JobStatus instance.
 func JobStatusStatus(event *Event) string {
-	switch event.EventNumber {
-	case "000": //Job Submitted
-		return "Submitted"
-	case "001": //Job running
-		return "Running"
-	case "002": // error in executable
-		return "Failed"
-	case "004": // job evicted
-		return "Failed"
-	case "005": // job terminated
-		if event.IsFailure() {
-			return "Failed"
-		}
-		return "Completed"
-	case "009": // job aborted
-		return "Failed"
-	case "010": // job suspended
-		return "Failed"
-	case "012": // job held
-		return "Failed"
-	default:
-		return "Running"
+	status := event.Mapping().Status
+	if status == StatusCompleted && event.IsFailure() {
+		return StatusFailed
 	}
+	return status
 }
 
 // NewJobState returns a new instance of JobState populated with info from
-// the passed in event and status string. Does not include a completion date.
+// the passed in event. The completion date is only included for events that
+// are mapped as terminal.
 func NewJobState(event *Event) JobState {
 	js := JobStatus{
 		Status: JobStatusStatus(event),
 		UUID:   event.InvocationID,
 	}
-	if js.Status == "Failed" || js.Status == "Completed" {
+	if event.Mapping().Terminal {
 		now := fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond))
 		js.CompletionDate = now
 	}
@@ -144,58 +113,30 @@ func NewJobState(event *Event) JobState {
 	return state
 }
 
+// notify sends the status change caused by the event to each of the
+// configured notifiers that are subscribed to it.
+func (p *PostEventHandler) notify(event *Event) error {
+	return p.Notifiers.Notify(NewStatusChange(event))
+}
+
 // Submitted handles the events with an EventNumber of "000". This will
-// assemble the JSON and POST it to the /de-job endpoints. This does not
+// notify the configured notifiers, including the /de-job endpoint. This does not
 // require a completion date and the Status will be set to "Submitted."
 func (p *PostEventHandler) Submitted(event *Event) error {
-	state := NewJobState(event)
-	json, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
-	logger.Printf("Sending 'Submitted' event to %s: %s", p.PostURL, string(json))
-	resp, err := http.Post(p.PostURL, "application/json", bytes.NewBuffer(json))
-	if err != nil {
-		return err
-	}
-	LogResponse(resp)
-	return nil
+	return p.notify(event)
 }
 
-// Running handles the events with an EventNumber "001". This function assembles the JSON
-// and POSTs it to the /de-job endpoint. This does not require a completion date in the
+// Running handles the events with an EventNumber "001". This function notifies the
+// configured notifiers, including the /de-job endpoint. This does not require a completion date in the
 // outgoing JSON. The "status" field will be set to "Running".
 func (p *PostEventHandler) Running(event *Event) error {
-	state := NewJobState(event)
-	json, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
-	logger.Printf("Sending 'Running' event to %s: %s", p.PostURL, string(json))
-	resp, err := http.Post(p.PostURL, "application/json", bytes.NewBuffer(json))
-	if err != nil {
-		return err
-	}
-	LogResponse(resp)
-	return nil
+	return p.notify(event)
 }
 
 // Failed handles all events that map to a job failure, which encompasses multiple
 // event numbers.
 func (p *PostEventHandler) Failed(event *Event) error {
-	state := NewJobState(event)
-	json, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
-	logger.Printf("Sending 'Failed' event to %s: %s", p.PostURL, string(json))
-	resp, err := http.Post(p.PostURL, "application/json", bytes.NewBuffer(json))
-	if err != nil {
-		return err
-	}
-	LogResponse(resp)
-	return nil
-
+	return p.notify(event)
 }
 
 // Completed handles event number 005, which maps to a job completion. However,
@@ -206,46 +147,171 @@ func (p *PostEventHandler) Completed(event *Event) error {
 	if event.IsFailure() {
 		return p.Failed(event)
 	}
-	state := NewJobState(event)
-	json, err := json.Marshal(state)
+	return p.notify(event)
+}
+
+// Held handles event number 012, which means that a job was put into the held
+// state. What happens next is decided by the HoldPolicy based on the hold
+// reason code and subcode. Transient holds are released after a delay by
+// queueing a POST to the release URL in the JEX, and everything else is killed
+// by sending a DELETE to the stop URL in the JEX and recording a stop request.
+// Either way the notifiers are told why the job was held, including Donkey,
+// which gets the hold reason with a status of Running. A killed job will come
+// through as failed afterwards. Jobs that already have a stop request aren't
+// stopped again.
+func (p *PostEventHandler) Held(event *Event) error {
+	logger.Printf("Job %s is in the held state: %s (code %d, subcode %d)", event.ID, event.HoldReason, event.HoldCode, event.HoldSubcode)
+	// The hold being handled has already been stored, so it's left out of
+	// the count of the job's earlier holds, each of which was released.
+	holds, err := p.DB.CountCondorJobEventsByNumber(event.JobID, "012")
 	if err != nil {
 		return err
 	}
-	logger.Printf("Sending Completed' event to %s: %s", p.PostURL, string(json))
-	resp, err := http.Post(p.PostURL, "application/json", bytes.NewBuffer(json))
+	releases := holds - 1
+	if releases < 0 {
+		releases = 0
+	}
+	decision := p.HoldPolicy.Decide(event.HoldCode, event.HoldSubcode, releases)
+	change := NewStatusChange(event)
+	change.Status = StatusHeld
+	change.Message = HoldMessage(event, decision)
+	if err = p.Notifiers.Notify(change); err != nil {
+		logger.Printf("Error sending the hold notification for job %s: %s", event.ID, err)
+	}
+	if decision.Action == HoldActionRelease {
+		return p.release(event, decision.After)
+	}
+	stopped, err := p.recordStopRequest(event.JobID, change.Message)
+	if err != nil {
+		logger.Printf("Error recording the stop request for held job %s: %s", event.ID, err)
+	} else if !stopped {
+		logger.Printf("Held job %s already has a stop request, so it isn't being stopped again", event.ID)
+		return nil
+	}
+	return p.stop(event)
+}
+
+// jexURL returns the URL for a JEX endpoint that acts on the job with the
+// given invocation ID.
+func (p *PostEventHandler) jexURL(endpoint, invocationID string) (string, error) {
+	u, err := url.Parse(p.JEXURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(endpoint, invocationID)
+	return u.String(), nil
+}
+
+// release queues a request for the JEX to release the held job after a delay.
+// Going through the outbound notification queue means that the release still
+// happens if jex-events is restarted in the meantime.
+func (p *PostEventHandler) release(event *Event, after time.Duration) error {
+	releaseURL, err := p.jexURL("/release", event.InvocationID)
 	if err != nil {
 		return err
 	}
-	LogResponse(resp)
-	return nil
+	at := time.Now().Add(after)
+	logger.Printf("Queueing a request to release job %s at %s to %s", event.ID, at, releaseURL)
+	key := fmt.Sprintf("release:%s:%s", event.InvocationID, event.Hash)
+	return p.Queue.EnqueueAt(event.InvocationID, releaseURL, key, []byte("{}"), at)
 }
 
-// Held handles event number 012, which means that a job was put into the held
-// state. Our system can't recover from a job going into this state, so the job
-// has to be killed. This is accomplished by sending a DELETE to a URL in the
-// JEX. We don't have to send a status notification to Donkey here, since the
-// job will come through as failed.
-func (p *PostEventHandler) Held(event *Event) error {
-	logger.Printf("Job %s is in the held state", event.ID)
-	stopPath := path.Join("/stop", event.InvocationID)
-	stopURL, err := url.Parse(p.JEXURL)
+// stop sends a DELETE to the stop URL in the JEX for the job in the event.
+func (p *PostEventHandler) stop(event *Event) error {
+	return p.stopInvocation(event.InvocationID)
+}
+
+// stopInvocation sends a DELETE to the stop URL in the JEX for the job with
+// the given invocation ID.
+func (p *PostEventHandler) stopInvocation(invocationID string) error {
+	stopURL, err := p.jexURL("/stop", invocationID)
 	if err != nil {
 		return err
 	}
-	stopURL.Path = stopPath
-	logger.Printf("Posting a request to stop job %s to %s", event.ID, stopURL.String())
-	req, err := http.NewRequest("DELETE", stopURL.String(), nil)
+	logger.Printf("Posting a request to stop job %s to %s", invocationID, stopURL)
+	req, err := http.NewRequest("DELETE", stopURL, nil)
 	if err != nil {
-		return nil
+		return err
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	LogResponse(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", stopURL, resp.Status)
+	}
 	return nil
 }
 
+// isStillGoing returns true if the job hasn't reached a terminal status.
+func (p *PostEventHandler) isStillGoing(job *JobRecord) (bool, error) {
+	last, err := p.DB.GetLastJobStatusTransition(job.ID)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !IsTerminalStatus(last.ToStatus), nil
+}
+
+// recordStopRequest records a stop request for the job unless one has
+// already been recorded. The returned bool is false if there was already a
+// stop request for the job.
+func (p *PostEventHandler) recordStopRequest(jobID, reason string) (bool, error) {
+	exists, err := p.DB.HasCondorJobStopRequest(jobID)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = p.DB.InsertCondorJobStopRequest(&CondorJobStopRequest{
+		JobID:         jobID,
+		Username:      stopRequestUser,
+		DateRequested: time.Now(),
+		Reason:        reason,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// stopJob records a stop request for the job and asks the JEX to
+// stop it. Jobs that have already had a stop requested are left alone, as are
+// jobs that don't have an invocation ID yet, since the JEX can't look them up.
+func (p *PostEventHandler) stopJob(job *JobRecord, reason string) (bool, error) {
+	stopped, err := p.recordStopRequest(job.ID, reason)
+	if err != nil || !stopped {
+		return false, err
+	}
+	if job.InvocationID == "" {
+		logger.Printf("Job %s doesn't have an invocation ID, so the JEX can't be asked to stop it", job.ID)
+		return true, nil
+	}
+	logger.Printf("Stopping job %s: %s", job.ID, reason)
+	return true, p.stopInvocation(job.InvocationID)
+}
+
+// stoppedStatusChange returns the "Failed" status change reported for a job
+// that jex-events stopped. The job's ID and the reason it was stopped make up
+// the notification key, so a job that's stopped again for another reason is
+// reported again.
+func stoppedStatusChange(job *JobRecord, event *Event, message string) *StatusChange {
+	return &StatusChange{
+		Status:         StatusFailed,
+		CompletionDate: fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond)),
+		InvocationID:   job.InvocationID,
+		CondorID:       job.CondorID,
+		AppID:          job.AppID,
+		User:           job.Submitter,
+		ExitCode:       job.ExitCode,
+		EventNumber:    event.EventNumber,
+		Message:        message,
+		Hash:           fmt.Sprintf("stopped:%s:%s", job.ID, message),
+	}
+}
+
 // Unrouted handles events that don't get forwarded to the users. Right now we
 // just log them.
 func (p *PostEventHandler) Unrouted(event *Event) error {
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// These are the actions that PostEventHandler.Route can take for an event.
+const (
+	ActionSubmitted = "submitted"
+	ActionRunning   = "running"
+	ActionFailed    = "failed"
+	ActionCompleted = "completed"
+	ActionHeld      = "held"
+	ActionUnrouted  = "unrouted"
+)
+
+// EventMapping declares how jex-events treats a Condor event number.
+//
+// Status is the status reported upstream and JobStatus is the status used by
+// the job state machine, which may be empty for events that don't change the
+// status of a job. Events with a Status of "Completed" are reported as
+// "Failed" if the job exited with a non-zero exit code. Terminal events get a
+// completion date when they're reported upstream. UpdateLastEvents decides
+// whether the event becomes the job's last event, and Action decides which
+// PostEventHandler function handles it.
+type EventMapping struct {
+	EventNumber      string
+	Status           string
+	JobStatus        string
+	Terminal         bool
+	UpdateLastEvents bool
+	Action           string
+}
+
+// defaultEventMappings contains the built-in mappings. Event numbers that
+// aren't listed here or in the configuration get unknownEventMapping. Evicted
+// and held jobs aren't finished: Condor puts an evicted job back in the queue,
+// and the HoldPolicy decides whether a held job is released or killed, so
+// neither is reported as failed until a later event says so.
+var defaultEventMappings = []EventMapping{
+	{"000", StatusSubmitted, StatusSubmitted, false, true, ActionSubmitted}, // job submitted
+	{"001", StatusRunning, StatusRunning, false, true, ActionRunning},       // job running
+	{"002", StatusFailed, StatusFailed, true, true, ActionFailed},           // error in executable
+	{"004", StatusRunning, StatusEvicted, false, true, ActionUnrouted},      // job evicted
+	{"005", StatusCompleted, StatusCompleted, true, true, ActionCompleted},  // job terminated
+	{"009", StatusFailed, StatusFailed, true, true, ActionFailed},           // job aborted
+	{"010", StatusFailed, StatusFailed, true, true, ActionFailed},           // job suspended
+	{"012", StatusRunning, StatusHeld, false, true, ActionHeld},             // job held
+}
+
+// unknownEventMapping is used for event numbers that don't have a mapping.
+var unknownEventMapping = EventMapping{
+	Status: StatusRunning,
+	Action: ActionUnrouted,
+}
+
+// EventMappings is a lookup table of EventMappings keyed by event number.
+type EventMappings map[string]EventMapping
+
+// eventMappings contains the mappings in use. It starts out with the defaults
+// and is replaced at startup by LoadEventMappings.
+var eventMappings = NewEventMappings(defaultEventMappings)
+
+// NewEventMappings returns a lookup table containing 'mappings'. Later entries
+// replace earlier ones with the same event number.
+func NewEventMappings(mappings []EventMapping) EventMappings {
+	m := make(EventMappings)
+	m.Merge(mappings)
+	return m
+}
+
+// Merge adds 'mappings' to the table, replacing any existing entries with the
+// same event numbers.
+func (m EventMappings) Merge(mappings []EventMapping) {
+	for _, mapping := range mappings {
+		m[mapping.EventNumber] = mapping
+	}
+}
+
+// Lookup returns the mapping for an event number.
+func (m EventMappings) Lookup(eventNumber string) EventMapping {
+	if mapping, ok := m[eventNumber]; ok {
+		return mapping
+	}
+	mapping := unknownEventMapping
+	mapping.EventNumber = eventNumber
+	return mapping
+}
+
+// Validate returns an error describing the first invalid mapping in the
+// table, if there is one.
+func (m EventMappings) Validate() error {
+	var numbers []string
+	for number := range m {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+	for _, number := range numbers {
+		if err := m[number].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the mapping has an unknown action or status.
+func (e EventMapping) Validate() error {
+	if len(e.EventNumber) != 3 {
+		return fmt.Errorf("event number '%s' must be three digits", e.EventNumber)
+	}
+	switch e.Action {
+	case ActionSubmitted, ActionRunning, ActionFailed, ActionCompleted, ActionHeld, ActionUnrouted:
+	default:
+		return fmt.Errorf("event %s has an unknown action '%s'", e.EventNumber, e.Action)
+	}
+	switch e.Status {
+	case StatusSubmitted, StatusRunning, StatusCompleted, StatusFailed:
+	default:
+		return fmt.Errorf("event %s has an unknown status '%s'", e.EventNumber, e.Status)
+	}
+	if _, ok := allowedTransitions[e.JobStatus]; !ok {
+		return fmt.Errorf("event %s has an unknown job status '%s'", e.EventNumber, e.JobStatus)
+	}
+	return nil
+}
+
+// LoadEventMappings builds the mapping table from the defaults, the mappings
+// stored in the condor_events table, and the mappings in the configuration, in
+// that order, so the configuration has the final say. The database is skipped
+// if 'd' is nil.
+func LoadEventMappings(d JobStore, configured []EventMapping) (EventMappings, error) {
+	m := NewEventMappings(defaultEventMappings)
+	if d != nil {
+		stored, err := d.GetCondorEventMappings()
+		if err != nil {
+			return nil, err
+		}
+		m.Merge(stored)
+	}
+	m.Merge(configured)
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Mapping returns the mapping for the event's number.
+func (e *Event) Mapping() EventMapping {
+	return eventMappings.Lookup(e.EventNumber)
+}
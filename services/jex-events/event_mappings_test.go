@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestEventMappingsLookup(t *testing.T) {
+	m := NewEventMappings(defaultEventMappings)
+	held := m.Lookup("012")
+	if held.Action != ActionHeld || held.JobStatus != StatusHeld || held.Status != StatusRunning || held.Terminal {
+		t.Errorf("Unexpected mapping for 012: %#v", held)
+	}
+	unknown := m.Lookup("006")
+	if unknown.Action != ActionUnrouted || unknown.Status != StatusRunning || unknown.UpdateLastEvents {
+		t.Errorf("Unexpected mapping for an unmapped event: %#v", unknown)
+	}
+	if unknown.EventNumber != "006" {
+		t.Errorf("The unmapped event number was %s instead of 006", unknown.EventNumber)
+	}
+}
+
+func TestEventMappingsMerge(t *testing.T) {
+	m := NewEventMappings(defaultEventMappings)
+	m.Merge([]EventMapping{
+		{"022", StatusRunning, StatusRunning, false, true, ActionRunning},
+		{"004", StatusFailed, StatusEvicted, true, true, ActionFailed},
+	})
+	if m.Lookup("022").Action != ActionRunning {
+		t.Error("The 022 mapping wasn't added")
+	}
+	if m.Lookup("004").Action != ActionFailed {
+		t.Error("The 004 mapping wasn't replaced")
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+	m.Merge([]EventMapping{{"021", StatusFailed, StatusFailed, true, true, "explode"}})
+	if err := m.Validate(); err == nil {
+		t.Error("An unknown action didn't fail validation")
+	}
+}
+
+func TestMappedEventBehavior(t *testing.T) {
+	saved := eventMappings
+	defer func() { eventMappings = saved }()
+	eventMappings = NewEventMappings(defaultEventMappings)
+
+	completed := &Event{EventNumber: "005", ExitCode: 0}
+	if completed.IsFailure() || JobStatusStatus(completed) != StatusCompleted {
+		t.Error("A 005 with an exit code of 0 wasn't Completed")
+	}
+	failed := &Event{EventNumber: "005", ExitCode: 1}
+	if !failed.IsFailure() || JobStatusStatus(failed) != StatusFailed || EventStatus(failed) != StatusFailed {
+		t.Error("A 005 with an exit code of 1 wasn't Failed")
+	}
+	evicted := &Event{EventNumber: "004"}
+	if evicted.IsFailure() || EventStatus(evicted) != StatusEvicted || NewJobState(evicted).State.CompletionDate != "" {
+		t.Error("A 004 wasn't a non-terminal event that moves the job to Evicted")
+	}
+	held := &Event{EventNumber: "012"}
+	if held.IsFailure() || EventStatus(held) != StatusHeld || NewJobState(held).State.CompletionDate != "" {
+		t.Error("A 012 wasn't a non-terminal event that moves the job to Held")
+	}
+
+	eventMappings.Merge([]EventMapping{{"021", StatusFailed, StatusFailed, true, true, ActionFailed}})
+	remote := &Event{EventNumber: "021"}
+	if !remote.IsFailure() || NewJobState(remote).State.CompletionDate == "" {
+		t.Error("The added 021 mapping wasn't used")
+	}
+	p := &PostEventHandler{}
+	if !p.ShouldUpdateLastEvents(remote) {
+		t.Error("The added 021 mapping didn't update the last events")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExceedsFailureThreshold returns true if 'failures' is more than 'threshold'.
+// A threshold of zero or less means that the threshold isn't enforced, which
+// is what you get for jobs that were submitted without one.
+func ExceedsFailureThreshold(failures, threshold int64) bool {
+	return threshold > 0 && failures > threshold
+}
+
+// BatchFailures returns the number of jobs in the batch that have failed at
+// least once, along with the number of jobs in the batch.
+func BatchFailures(members []JobRecord) (int64, int64) {
+	var failed int64
+	for _, member := range members {
+		if member.FailureCount > 0 {
+			failed = failed + 1
+		}
+	}
+	return failed, int64(len(members))
+}
+
+// BatchFailureMessage returns the message reported upstream when a batch is
+// failed because too many of its jobs failed.
+func BatchFailureMessage(failed, total int64) string {
+	return fmt.Sprintf("batch failed: %d of %d", failed, total)
+}
+
+// JobFailureMessage returns the message reported upstream when a job is
+// stopped because it failed too many times.
+func JobFailureMessage(job *JobRecord) string {
+	return fmt.Sprintf("job failed: %d failures exceeds the threshold of %d", job.FailureCount, job.FailureThreshold)
+}
+
+// EnforceFailureThresholds checks the failure thresholds for a job that just
+// failed and for the batch that it's a part of. A job that goes over its own
+// threshold is stopped. A batch goes over its threshold when more of its jobs
+// have failed than the FailureThreshold of the batch's job allows, at which
+// point the batch members that are still going are stopped and the batch is
+// reported as failed. Each threshold is only enforced once; the
+// failure_threshold_enforced flag on the job or batch keeps track of that.
+func (p *PostEventHandler) EnforceFailureThresholds(job *JobRecord, event *Event) error {
+	if ExceedsFailureThreshold(job.FailureCount, job.FailureThreshold) {
+		first, err := p.DB.SetJobFlag(job.ID, FlagFailureThresholdEnforced, time.Now())
+		if err != nil {
+			return err
+		}
+		if first {
+			if err = p.stopFailedJob(job, event, JobFailureMessage(job)); err != nil {
+				return err
+			}
+		}
+	}
+	if job.BatchID == "" {
+		return nil
+	}
+	return p.enforceBatchFailureThreshold(job.BatchID, event)
+}
+
+// enforceBatchFailureThreshold stops the rest of the batch and reports it as
+// failed if too many of its jobs have failed.
+func (p *PostEventHandler) enforceBatchFailureThreshold(batchID string, event *Event) error {
+	batch, err := p.DB.GetJob(batchID)
+	if err != nil {
+		return err
+	}
+	members, err := p.DB.GetBatchJobs(batchID)
+	if err != nil {
+		return err
+	}
+	failed, total := BatchFailures(members)
+	if !ExceedsFailureThreshold(failed, batch.FailureThreshold) {
+		return nil
+	}
+	first, err := p.DB.SetJobFlag(batchID, FlagFailureThresholdEnforced, time.Now())
+	if err != nil || !first {
+		return err
+	}
+	message := BatchFailureMessage(failed, total)
+	logger.Printf("Batch %s exceeded its failure threshold of %d: %s", batchID, batch.FailureThreshold, message)
+	for i := range members {
+		member := &members[i]
+		running, err := p.isStillGoing(member)
+		if err != nil {
+			logger.Printf("Error getting the status of job %s in batch %s: %s", member.ID, batchID, err)
+			continue
+		}
+		if !running {
+			continue
+		}
+		if _, err = p.stopJob(member, message); err != nil {
+			logger.Printf("Error stopping job %s in batch %s: %s", member.ID, batchID, err)
+		}
+	}
+	return p.Notifiers.Notify(stoppedStatusChange(batch, event, message))
+}
+
+// stopFailedJob stops a job that went over its failure threshold and reports
+// it as failed. Jobs that somebody already asked to stop aren't stopped or
+// reported again.
+func (p *PostEventHandler) stopFailedJob(job *JobRecord, event *Event, message string) error {
+	stopped, err := p.stopJob(job, message)
+	if err != nil || !stopped {
+		return err
+	}
+	if err = p.Notifiers.Notify(stoppedStatusChange(job, event, message)); err != nil {
+		logger.Printf("Error reporting the failure of job %s: %s", job.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestExceedsFailureThreshold(t *testing.T) {
+	if ExceedsFailureThreshold(5, 0) {
+		t.Error("A threshold of 0 was enforced")
+	}
+	if ExceedsFailureThreshold(2, 2) {
+		t.Error("A failure count equal to the threshold exceeded it")
+	}
+	if !ExceedsFailureThreshold(3, 2) {
+		t.Error("A failure count over the threshold didn't exceed it")
+	}
+}
+
+func TestBatchFailures(t *testing.T) {
+	members := []JobRecord{
+		{ID: "a", FailureCount: 0},
+		{ID: "b", FailureCount: 2},
+		{ID: "c", FailureCount: 1},
+		{ID: "d", FailureCount: 0},
+	}
+	failed, total := BatchFailures(members)
+	if failed != 2 {
+		t.Errorf("BatchFailures counted %d failed jobs instead of 2", failed)
+	}
+	if total != 4 {
+		t.Errorf("BatchFailures counted %d jobs instead of 4", total)
+	}
+	if msg := BatchFailureMessage(failed, total); msg != "batch failed: 2 of 4" {
+		t.Errorf("BatchFailureMessage returned '%s'", msg)
+	}
+}
+
+func TestStoppedStatusChange(t *testing.T) {
+	job := &JobRecord{ID: "job-id", InvocationID: "inv-id", Submitter: "test"}
+	event := &Event{EventNumber: "005"}
+	change := stoppedStatusChange(job, event, "batch failed: 2 of 4")
+	if change.Status != StatusFailed {
+		t.Errorf("The status was '%s' instead of '%s'", change.Status, StatusFailed)
+	}
+	if change.InvocationID != "inv-id" || change.User != "test" {
+		t.Errorf("The status change wasn't populated from the job: %#v", change)
+	}
+	if change.CompletionDate == "" {
+		t.Error("The completion date wasn't set")
+	}
+	if NotificationKey(change) != "inv-id:Failed:stopped:job-id:batch failed: 2 of 4" {
+		t.Errorf("The notification key was '%s'", NotificationKey(change))
+	}
+	other := stoppedStatusChange(job, event, PredecessorFailureMessage(&JobRecord{ID: "other-id"}))
+	if NotificationKey(other) == NotificationKey(change) {
+		t.Error("Stopping the job for another reason used the same notification key")
+	}
+}
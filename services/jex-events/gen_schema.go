@@ -0,0 +1,112 @@
+// +build ignore
+
+// gen_schema.go generates schema.go from the jex-db project, which is where the
+// schema and its conversions are maintained. Run it with 'go generate' after
+// changing anything in databases/jex-db.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	src = flag.String("src", "../../databases/jex-db/src/main", "The jex-db source directory.")
+	out = flag.String("out", "schema.go", "The file to write.")
+)
+
+// schemaDirs are the directories of SQL files that make up a new database, in
+// the order they're loaded.
+var schemaDirs = []string{"extensions", "tables", "views", "data"}
+
+var (
+	versionRegexp  = regexp.MustCompile(`\(def \^:private version\s+"[^"]*"\s+"([^"]+)"\)`)
+	execRawRegexp  = regexp.MustCompile(`\(exec-raw\s+"((?:[^"\\]|\\.)*)"\)`)
+	versionInsert  = regexp.MustCompile(`INSERT INTO version \(version\) VALUES \('([^']+)'\)`)
+	clojureEscapes = strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t")
+)
+
+// raw returns the string as a Go raw string literal.
+func raw(s string) string {
+	if strings.Contains(s, "`") {
+		log.Fatalf("backquotes aren't supported in the SQL:\n%s", s)
+	}
+	return "`" + s + "`"
+}
+
+func main() {
+	flag.Parse()
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// This file was generated by gen_schema.go from databases/jex-db. DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package main")
+	fmt.Fprintln(&buf)
+
+	version := ""
+	fmt.Fprintln(&buf, "// schemaFiles are the SQL files that set up a new database, in order.")
+	fmt.Fprintln(&buf, "var schemaFiles = []SchemaFile{")
+	for _, dir := range schemaDirs {
+		paths, err := filepath.Glob(filepath.Join(*src, dir, "*.sql"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if m := versionInsert.FindSubmatch(contents); m != nil {
+				version = string(m[1])
+			}
+			fmt.Fprintf(&buf, "{Name: %q, SQL: %s},\n", dir+"/"+filepath.Base(path), raw(string(contents)))
+		}
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+	if version == "" {
+		log.Fatal("the schema doesn't insert a version")
+	}
+	fmt.Fprintln(&buf, "// schemaVersion is the version of the database that schemaFiles set up.")
+	fmt.Fprintf(&buf, "const schemaVersion = %q\n\n", version)
+
+	paths, err := filepath.Glob(filepath.Join(*src, "conversions", "*.clj"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(paths)
+	fmt.Fprintln(&buf, "// migrations are the conversions from jex-db, in order.")
+	fmt.Fprintln(&buf, "var migrations = []Migration{")
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := versionRegexp.FindSubmatch(contents)
+		if m == nil {
+			log.Fatalf("%s doesn't define its version", path)
+		}
+		fmt.Fprintf(&buf, "{Version: %q, Statements: []string{\n", m[1])
+		for _, stmt := range execRawRegexp.FindAllSubmatch(contents, -1) {
+			fmt.Fprintf(&buf, "%s,\n", raw(clojureEscapes.Replace(string(stmt[1]))))
+		}
+		fmt.Fprintln(&buf, "}},")
+	}
+	fmt.Fprintln(&buf, "}")
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile(*out, formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// These are the actions that a hold policy can take for a held job.
+const (
+	HoldActionRelease = "release"
+	HoldActionKill    = "kill"
+)
+
+// HoldRule decides what happens to jobs held with one of the listed HTCondor
+// hold reason codes and subcodes. A rule without Codes matches every code and a
+// rule without Subcodes matches every subcode. Jobs matching a release rule are
+// released after ReleaseAfter minutes, up to MaxReleases times; after that they
+// are killed like everything else.
+type HoldRule struct {
+	Codes        []int
+	Subcodes     []int
+	Action       string
+	ReleaseAfter int
+	MaxReleases  int
+}
+
+// Matches returns true if the rule applies to the hold code and subcode.
+func (r *HoldRule) Matches(code, subcode int) bool {
+	return containsInt(r.Codes, code) && containsInt(r.Subcodes, subcode)
+}
+
+// containsInt returns true if 'list' is empty or contains 'value'.
+func containsInt(list []int, value int) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HoldPolicy is a list of HoldRules. The first matching rule wins. Holds that
+// don't match a rule cause the job to be killed, which is what happened to all
+// held jobs before hold policies existed.
+type HoldPolicy []HoldRule
+
+// defaultHoldPolicy releases jobs held because of file transfer failures
+// (HTCondor hold codes 12 and 13), which are usually transient.
+var defaultHoldPolicy = HoldPolicy{
+	{Codes: []int{12, 13}, Action: HoldActionRelease, ReleaseAfter: 5, MaxReleases: 3},
+}
+
+// HoldDecision is the outcome of applying a HoldPolicy to a held job.
+type HoldDecision struct {
+	Action string
+	After  time.Duration
+	Reason string
+}
+
+// Decide applies the policy to a job held with 'code' and 'subcode' that has
+// already been released 'releases' times.
+func (h HoldPolicy) Decide(code, subcode, releases int) HoldDecision {
+	for i := range h {
+		rule := &h[i]
+		if !rule.Matches(code, subcode) {
+			continue
+		}
+		if rule.Action != HoldActionRelease {
+			return HoldDecision{Action: HoldActionKill, Reason: "the hold is permanent"}
+		}
+		if releases >= rule.MaxReleases {
+			return HoldDecision{
+				Action: HoldActionKill,
+				Reason: fmt.Sprintf("the job has already been released %d time(s)", releases),
+			}
+		}
+		return HoldDecision{
+			Action: HoldActionRelease,
+			After:  time.Duration(rule.ReleaseAfter) * time.Minute,
+			Reason: "the hold is transient",
+		}
+	}
+	return HoldDecision{Action: HoldActionKill, Reason: "no hold rule matched"}
+}
+
+// Validate returns an error if any of the rules has an unknown action or a
+// negative setting.
+func (h HoldPolicy) Validate() error {
+	for i, rule := range h {
+		switch rule.Action {
+		case HoldActionRelease, HoldActionKill:
+		default:
+			return fmt.Errorf("hold rule %d has an unknown action '%s'", i, rule.Action)
+		}
+		if rule.ReleaseAfter < 0 || rule.MaxReleases < 0 {
+			return fmt.Errorf("hold rule %d has a negative ReleaseAfter or MaxReleases", i)
+		}
+	}
+	return nil
+}
+
+// HoldMessage returns the message sent to the notifiers when a job is held.
+func HoldMessage(event *Event, decision HoldDecision) string {
+	held := fmt.Sprintf("Job was held: %s (code %d, subcode %d).", event.HoldReason, event.HoldCode, event.HoldSubcode)
+	if decision.Action == HoldActionRelease {
+		return fmt.Sprintf("%s It will be released in %s because %s.", held, decision.After, decision.Reason)
+	}
+	return fmt.Sprintf("%s It is being stopped because %s.", held, decision.Reason)
+}
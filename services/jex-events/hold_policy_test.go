@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+func TestSetHoldReason(t *testing.T) {
+	e := &Event{
+		Event: "012 (3216.000.000) 05/12 13:46:29 Job was held.\n" +
+			"\tTransfer input files failure at execution point slot1@node: reading from file /tmp/in: (errno 2) No such file or directory\n" +
+			"\tCode 13 Subcode 2\n" +
+			"...\n",
+	}
+	e.Parse()
+	if e.EventNumber != "012" {
+		t.Fatalf("The event number was %s instead of 012", e.EventNumber)
+	}
+	if !strings.HasPrefix(e.HoldReason, "Transfer input files failure") {
+		t.Errorf("The hold reason was '%s'", e.HoldReason)
+	}
+	if e.HoldCode != 13 {
+		t.Errorf("The hold code was %d instead of 13", e.HoldCode)
+	}
+	if e.HoldSubcode != 2 {
+		t.Errorf("The hold subcode was %d instead of 2", e.HoldSubcode)
+	}
+}
+
+func TestHoldPolicyDecide(t *testing.T) {
+	policy := HoldPolicy{
+		{Codes: []int{13}, Subcodes: []int{2}, Action: HoldActionRelease, ReleaseAfter: 10, MaxReleases: 2},
+		{Codes: []int{12, 13}, Action: HoldActionKill},
+	}
+	d := policy.Decide(13, 2, 0)
+	if d.Action != HoldActionRelease || d.After != 10*time.Minute {
+		t.Errorf("A transient hold wasn't released after 10 minutes: %#v", d)
+	}
+	d = policy.Decide(13, 2, 2)
+	if d.Action != HoldActionKill {
+		t.Errorf("A job that was already released twice wasn't killed: %#v", d)
+	}
+	d = policy.Decide(13, 7, 0)
+	if d.Action != HoldActionKill {
+		t.Errorf("A permanent hold wasn't killed: %#v", d)
+	}
+	d = policy.Decide(1, 0, 0)
+	if d.Action != HoldActionKill {
+		t.Errorf("An unmatched hold wasn't killed: %#v", d)
+	}
+}
+
+func TestHoldPolicyValidate(t *testing.T) {
+	if err := defaultHoldPolicy.Validate(); err != nil {
+		t.Error(err)
+	}
+	bad := HoldPolicy{{Action: "ignore"}}
+	if err := bad.Validate(); err == nil {
+		t.Error("An unknown action didn't fail validation")
+	}
+}
+
+func TestHoldMessage(t *testing.T) {
+	e := &Event{HoldReason: "Transfer failed", HoldCode: 12, HoldSubcode: 1}
+	msg := HoldMessage(e, HoldDecision{Action: HoldActionRelease, After: 5 * time.Minute, Reason: "the hold is transient"})
+	if !strings.Contains(msg, "Transfer failed (code 12, subcode 1)") || !strings.Contains(msg, "released in 5m0s") {
+		t.Errorf("Unexpected release message: %s", msg)
+	}
+	msg = HoldMessage(e, HoldDecision{Action: HoldActionKill, Reason: "the hold is permanent"})
+	if !strings.Contains(msg, "being stopped because the hold is permanent") {
+		t.Errorf("Unexpected kill message: %s", msg)
+	}
+}
+
+func TestHeldStopsOnce(t *testing.T) {
+	var stops int
+	jex := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			stops++
+		}
+	}))
+	defer jex.Close()
+
+	p, _ := newRecordingHandler()
+	p.JEXURL = jex.URL
+	job := processJobEvents(t, p.DB, 300)
+	event := &Event{EventNumber: "012", JobID: job.ID, InvocationID: uuid.New(), HoldCode: 1, Hash: "hold"}
+	if err := p.Held(event); err != nil {
+		t.Fatal(err)
+	}
+	first, err := p.DB.GetLastCondorJobStopRequest(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same hold coming through again doesn't stop the job again.
+	if err = p.Held(event); err != nil {
+		t.Fatal(err)
+	}
+	last, err := p.DB.GetLastCondorJobStopRequest(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.ID != first.ID {
+		t.Errorf("A second stop request was recorded for the held job")
+	}
+	if stops != 1 {
+		t.Errorf("The JEX was asked to stop the held job %d times instead of once", stops)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -16,74 +17,550 @@ import (
 // to the database so that each endpoint does not have to set up its own
 // connection.
 type HTTPAPI struct {
-	d *Databaser
+	d                    JobStore
+	events               *PostEventHandler
+	auth                 *Auth
+	allowUnauthenticated bool
+	stopTimeout          time.Duration
+}
+
+// APIError describes an error returned by the HTTP API. Status is the HTTP
+// status code, Code is a short machine-readable version of it, and RequestID
+// is the ID from the X-Request-ID header, which ties the error to the log.
+// Fields is only set for request bodies that failed validation.
+type APIError struct {
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+	Fields    []FieldError `json:",omitempty"`
+}
+
+// ErrorResponse is the JSON envelope that every error is returned in.
+type ErrorResponse struct {
+	Error APIError
+}
+
+// errorCodes maps the HTTP statuses that the API returns to the codes used in
+// APIErrors.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "bad_gateway",
+	statusUpgradeRequired:          "upgrade_required",
+}
+
+// errorCode returns the APIError code for an HTTP status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if text := http.StatusText(status); text != "" {
+		return strings.Replace(strings.ToLower(text), " ", "_", -1)
+	}
+	return "error"
+}
+
+// WriteError writes out an error message in the JSON error envelope and sets
+// the HTTP status.
+func WriteError(writer http.ResponseWriter, status int, msg string) {
+	writeAPIError(writer, APIError{Status: status, Message: msg})
+}
+
+// writeAPIError fills in the code and request ID of the error and writes it
+// out in the JSON error envelope.
+func writeAPIError(writer http.ResponseWriter, apiErr APIError) {
+	apiErr.Code = errorCode(apiErr.Status)
+	apiErr.RequestID = writer.Header().Get(requestIDHeader)
+	marshalled, err := json.Marshal(&ErrorResponse{Error: apiErr})
+	if err != nil {
+		logger.Printf("Error marshalling the error '%s': %s", apiErr.Message, err)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(apiErr.Status)
+	writer.Write(marshalled)
 }
 
 // WriteRequestError writes out an error message to the writer and sets the
 // the HTTP status to 400.
 func WriteRequestError(writer http.ResponseWriter, msg string) {
-	writer.WriteHeader(http.StatusBadRequest)
-	writer.Write([]byte(msg))
+	WriteError(writer, http.StatusBadRequest, msg)
+}
+
+// WriteBodyError writes out a 400 for a request body that couldn't be parsed.
+// If it failed validation, the problem with each field is included.
+func WriteBodyError(writer http.ResponseWriter, err error) {
+	apiErr := APIError{Status: http.StatusBadRequest, Message: err.Error()}
+	if verr, ok := err.(*ValidationError); ok {
+		apiErr.Message = "The request body is invalid"
+		apiErr.Fields = verr.Fields
+	}
+	writeAPIError(writer, apiErr)
+}
+
+// writeJSON writes out the JSON for 'v' with the given HTTP status.
+func writeJSON(writer http.ResponseWriter, status int, v interface{}) {
+	marshalled, err := json.Marshal(v)
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(marshalled)
 }
 
 // LogAPIMsg prints a message to the log with associated request info.
 func LogAPIMsg(request *http.Request, msg string) {
 	logger.Printf(
-		"Method: %s\tFrom: %s\tTo: %s\t Log: %s",
+		"Method: %s\tFrom: %s\tTo: %s\tRequest: %s\t Log: %s",
 		request.Method,
 		request.RemoteAddr,
 		request.RequestURI,
+		request.Header.Get(requestIDHeader),
 		msg,
 	)
 }
 
-// RouteJobRequests routes requests to one of the Job-related handlers. It
-// decides which function to call by examining the request method. If the
-// request method somehow ends up being blank (which shouldn't happen), then
-// the request is assumed to be a GET request. Right now only GETs and POSTs
-// are supported.
-func (h *HTTPAPI) RouteJobRequests(writer http.ResponseWriter, request *http.Request) {
-	LogAPIMsg(request, "Job request received; routing")
-	switch request.Method {
-	case "GET":
-		h.JobHTTPGet(writer, request)
-	case "POST":
-		h.JobHTTPPost(writer, request)
-	case "":
-		h.JobHTTPGet(writer, request)
-	default:
-		LogAPIMsg(request, fmt.Sprintf("Method %s is not supported on /jobs", request.Method))
-	}
-}
-
-// RouteInvocationRequests routes requests to one of the Invocation-related
-// handlers. Right now only GET requests are supported. If the request method
-// somehow ends up being blank, the request is assumed to be a GET request.
-func (h *HTTPAPI) RouteInvocationRequests(writer http.ResponseWriter, request *http.Request) {
-	LogAPIMsg(request, "Invocation request received; routing")
-	switch request.Method {
-	case "GET":
-		h.InvocationHTTPGet(writer, request)
-	case "":
-		h.InvocationHTTPGet(writer, request)
-	default:
-		LogAPIMsg(request, fmt.Sprintf("Method %s is not supported on /invocation", request.Method))
-	}
-}
-
-// RouteLastEventRequests routes requests to one of the LastEvent-related handlers
-// Only GET requests are supported. If the request method somehow ends up being
-// blank, the request is assumed to be a GET request.
-func (h *HTTPAPI) RouteLastEventRequests(writer http.ResponseWriter, request *http.Request) {
-	LogAPIMsg(request, "Last event lookup request received; routing")
-	switch request.Method {
-	case "GET":
-		h.LastEventHTTP(writer, request)
-	case "":
-		h.LastEventHTTP(writer, request)
-	default:
-		LogAPIMsg(request, fmt.Sprintf("Method %s is not supported for last event lookups", request.Method))
+// Router returns a Router with all of the API's endpoints. The endpoints are
+// described in openapi.json, which is served at /openapi.json.
+func (h *HTTPAPI) Router() *Router {
+	r := NewRouter()
+	r.Auth = h.auth
+	r.AllowUnauthenticated = h.allowUnauthenticated
+	r.Handle("GET", "/jobs", AccessUser, h.JobsHTTPList)
+	r.Handle("POST", "/jobs", AccessAdmin, h.JobHTTPPost)
+	r.Handle("GET", "/jobs/{id}", AccessUser, h.JobHTTPGet)
+	r.Handle("PATCH", "/jobs/{id}", AccessAdmin, h.JobHTTPPatch)
+	r.Handle("GET", "/jobs/{id}/stop", AccessUser, h.JobStopHTTPGet)
+	r.Handle("POST", "/jobs/{id}/stop", AccessUser, h.JobStopHTTPPost)
+	r.Handle("GET", "/jobs/{id}/usage", AccessUser, h.JobUsageHTTPGet)
+	r.Handle("GET", "/jobs/{id}/events", AccessUser, func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+		h.EventHistoryHTTPGet(writer, request, p, h.d.GetJob)
+	})
+	r.Handle("GET", "/invocations/{id}", AccessUser, h.InvocationHTTPGet)
+	r.Handle("GET", "/invocations/{id}/events", AccessUser, func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+		h.EventHistoryHTTPGet(writer, request, p, h.d.GetJobByInvocationID)
+	})
+	r.Handle("GET", "/last-events/{id}", AccessUser, h.LastEventHTTP)
+	r.Handle("GET", "/batches/{id}", AccessUser, h.BatchHTTPGet)
+	r.Handle("GET", "/batches/{id}/jobs", AccessUser, h.BatchHTTPGet)
+	r.Handle("GET", "/dependencies/{id}", AccessUser, h.DependencyHTTPGet)
+	r.Handle("POST", "/dependencies", AccessAdmin, h.DependencyHTTPPost)
+	r.Handle("DELETE", "/dependencies/{predecessor}/{successor}", AccessAdmin, h.DependencyHTTPDelete)
+	r.Handle("GET", "/dead-notifications", AccessAdmin, h.DeadNotificationsHTTPGet)
+	r.Handle("GET", "/stream", AccessUser, h.StreamHTTPGet)
+	r.Handle("GET", "/usage", AccessUser, h.UsageHTTPGet)
+	r.Handle("GET", "/openapi.json", AccessPublic, OpenAPIHTTPGet)
+	return r
+}
+
+// subresourceJob returns the job in a path like /jobs/<uuid>/stop. If the job
+// can't be found, the error response is written and nil is returned.
+func (h *HTTPAPI) subresourceJob(writer http.ResponseWriter, request *http.Request, p *Principal) *JobRecord {
+	jobID := path.Base(path.Dir(request.URL.Path))
+	if uuid.Parse(jobID) == nil {
+		WriteRequestError(writer, fmt.Sprintf("The path must contain a job UUID: %s", jobID))
+		return nil
+	}
+	jr, err := h.d.GetJob(jobID)
+	if err == sql.ErrNoRows || (err == nil && !CanAccessJob(p, jr)) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", jobID))
+		return nil
+	}
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return nil
 	}
+	return jr
+}
+
+// writeStopRequest writes out the JSON for a stop request along with its
+// status, which is one of "pending", "confirmed", or "timed out".
+func (h *HTTPAPI) writeStopRequest(writer http.ResponseWriter, sr *CondorJobStopRequest, status int) {
+	writeJSON(writer, status, NewStopRequestState(sr, h.stopTimeout, time.Now()))
+}
+
+// JobStopHTTPGet returns the most recent stop request for a job, along with
+// whether it's pending, confirmed, or timed out.
+func (h *HTTPAPI) JobStopHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	jr := h.subresourceJob(writer, request, p)
+	if jr == nil {
+		return
+	}
+	sr, err := h.d.GetLastCondorJobStopRequest(jr.ID)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Nobody has asked for job %s to be stopped", jr.ID))
+		return
+	}
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.writeStopRequest(writer, sr, http.StatusOK)
+}
+
+// JobStopHTTPPost asks for a job to be stopped. The incoming JSON should have
+// the following format:
+//    {
+//      "Username" : "<string>",
+//      "Reason"   : "<string>"
+//    }
+// The Username field is required. The stop request is recorded and forwarded
+// to the JEX, and a 202 is returned along with the request, which stays pending
+// until the job's abort event arrives. If the job already has a stop request
+// that's pending or confirmed, that request is returned with a 200 and nothing
+// is sent to the JEX. A 409 is returned if the job has already finished.
+func (h *HTTPAPI) JobStopHTTPPost(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	jr := h.subresourceJob(writer, request, p)
+	if jr == nil {
+		return
+	}
+	bytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		LogAPIMsg(request, err.Error())
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	LogAPIMsg(request, string(bytes))
+	var parsed CondorJobStopRequest
+	if err = json.Unmarshal(bytes, &parsed); err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	if !p.Admin {
+		if parsed.Username != "" && parsed.Username != p.Subject {
+			WriteError(writer, http.StatusForbidden, "Stop requests can only be made in your own name")
+			return
+		}
+		parsed.Username = p.Subject
+	}
+	sr, created, err := h.events.RequestStop(jr, parsed.Username, parsed.Reason, h.stopTimeout)
+	switch {
+	case err == ErrStopUserMissing || err == ErrNoInvocationID:
+		WriteRequestError(writer, err.Error())
+		return
+	case err == ErrJobFinished:
+		WriteError(writer, http.StatusConflict, err.Error())
+		return
+	case err != nil && sr == nil:
+		LogAPIMsg(request, fmt.Sprintf("Error requesting a stop for job %s: %s", jr.ID, err))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	case err != nil:
+		LogAPIMsg(request, fmt.Sprintf("Error forwarding the stop request for job %s to the JEX: %s", jr.ID, err))
+		WriteError(writer, http.StatusBadGateway, err.Error())
+		return
+	}
+	if created {
+		h.writeStopRequest(writer, sr, http.StatusAccepted)
+		return
+	}
+	h.writeStopRequest(writer, sr, http.StatusOK)
+}
+
+// JobUsageHTTPGet returns the resource usage of a job as a JSON object. A 404
+// is returned if the job hasn't terminated with any usage recorded.
+func (h *HTTPAPI) JobUsageHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	jr := h.subresourceJob(writer, request, p)
+	if jr == nil {
+		return
+	}
+	u, err := h.d.GetJobResourceUsage(jr.ID)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("No resource usage has been recorded for job %s", jr.ID))
+		return
+	}
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, u)
+}
+
+// UsageHTTPGet adds up the resource usage of the jobs that match the
+// submitter, app_id, from, and to query parameters and returns the totals as a
+// JSON object. The dates apply to when the jobs terminated. Users that aren't
+// admins can only add up the usage of their own jobs.
+func (h *HTTPAPI) UsageHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	logger.Printf("Handling GET request for %s", request.URL.Path)
+	filter, err := ParseUsageFilter(request.URL.Query())
+	if err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	if !p.Admin {
+		if filter.Submitter != "" && filter.Submitter != p.Subject {
+			WriteError(writer, http.StatusForbidden, "Only the usage of your own jobs can be reported")
+			return
+		}
+		filter.Submitter = p.Subject
+	}
+	totals, err := h.d.SumJobResourceUsage(filter)
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, totals)
+}
+
+// EventHistoryHTTPGet returns the timeline of events for a job as a JSON list,
+// ordered by when the events were triggered. Each event contains its number,
+// name, and description from the condor_events table, the status it maps to,
+// and the fields parsed out of it. The raw event text is included if the
+// 'raw' query parameter is set to 'true'.
+func (h *HTTPAPI) EventHistoryHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal, lookup func(string) (*JobRecord, error)) {
+	logger.Printf("Handling GET request for %s", request.URL.Path)
+	id := path.Base(path.Dir(request.URL.Path))
+	if uuid.Parse(id) == nil {
+		WriteRequestError(writer, fmt.Sprintf("The path must contain a UUID: %s", id))
+		return
+	}
+	jr, err := lookup(id)
+	if err == sql.ErrNoRows || (err == nil && (jr == nil || !CanAccessJob(p, jr))) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", id))
+		return
+	}
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	records, err := h.d.GetJobEventHistory(jr.ID)
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	includeRaw := request.URL.Query().Get("raw") == "true"
+	writeJSON(writer, http.StatusOK, BuildTimeline(records, includeRaw))
+}
+
+// StreamHTTPGet streams job status changes to the client as they happen. The
+// job_id, invocation_id, batch_id, and submitter query parameters pick which
+// jobs the changes are sent for; at least one of them is required. Changes are
+// sent as server-sent events unless the request asks to be upgraded to a
+// WebSocket, in which case each change is sent as a JSON text message. Each
+// change has an ID; clients that reconnect with the last ID they received in
+// the Last-Event-ID header or the last_event_id query parameter are sent the
+// changes they missed before the stream picks back up.
+func (h *HTTPAPI) StreamHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	logger.Printf("Handling GET request for %s", request.URL.Path)
+	values := request.URL.Query()
+	filter, err := ParseStreamFilter(values)
+	if err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	if !p.Admin {
+		if filter.Submitter != "" && filter.Submitter != p.Subject {
+			WriteError(writer, http.StatusForbidden, "Only your own jobs can be streamed")
+			return
+		}
+		filter.Submitter = p.Subject
+	}
+	lastID, err := ParseLastEventID(request.Header.Get("Last-Event-ID"), values)
+	if err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	sub := h.events.Streams.Subscribe(filter)
+	defer h.events.Streams.Unsubscribe(sub)
+	var missed []StreamEvent
+	if lastID > 0 {
+		if missed, err = h.d.GetStreamEvents(filter, lastID, streamReplayLimit); err != nil {
+			WriteError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	var sink streamSink
+	done := make(chan bool)
+	if IsWebSocketRequest(request) {
+		conn, err := UpgradeWebSocket(writer, request)
+		if err != nil {
+			logger.Printf("Error upgrading %s to a WebSocket: %s", request.URL.Path, err)
+			return
+		}
+		defer conn.Close()
+		go func() {
+			conn.ReadUntilClosed()
+			close(done)
+		}()
+		sink = &wsSink{conn: conn}
+	} else {
+		sse, err := newSSESink(writer)
+		if err != nil {
+			WriteError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if notifier, ok := writer.(http.CloseNotifier); ok {
+			closed := notifier.CloseNotify()
+			go func() {
+				<-closed
+				close(done)
+			}()
+		}
+		sink = sse
+	}
+	if err = serveStream(h.d, sink, sub, filter, missed, done); err != nil {
+		logger.Printf("Error streaming %s: %s", request.URL.String(), err)
+	}
+}
+
+// BatchHTTPGet returns the summary of a batch as a JSON object. The batch is
+// identified by the UUID of its job, which is the BatchID of each of the jobs
+// in the batch. A path of /batches/<uuid> returns the batch's job, its member
+// jobs along with their statuses, the number of jobs in each status, the
+// percentage of jobs that have finished, when the first job started, when the
+// last job finished, and the aggregate status of the batch. A path of
+// /batches/<uuid>/jobs returns just the list of member jobs.
+func (h *HTTPAPI) BatchHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	logger.Printf("Handling GET request for %s", request.URL.Path)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/batches"), "/"), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "jobs") {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("%s was not found", request.URL.Path))
+		return
+	}
+	batchID := parts[0]
+	if uuid.Parse(batchID) == nil {
+		WriteRequestError(writer, fmt.Sprintf("The path must contain a batch UUID: %s", batchID))
+		return
+	}
+	summary, err := LoadBatchSummary(h.d, batchID)
+	if err == sql.ErrNoRows || (err == nil && !CanAccessJob(p, &summary.Batch)) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Batch %s was not found", batchID))
+		return
+	}
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(parts) == 2 {
+		writeJSON(writer, http.StatusOK, summary.Jobs)
+		return
+	}
+	writeJSON(writer, http.StatusOK, summary)
+}
+
+// dependencyPathIDs returns the UUIDs in a /dependencies path.
+func dependencyPathIDs(request *http.Request) []string {
+	trimmed := strings.Trim(strings.TrimPrefix(request.URL.Path, "/dependencies"), "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// DependencyHTTPGet returns the dependency graph around a job as a JSON object.
+// The path must be /dependencies/<job-uuid>. The response contains the jobs
+// that the job directly depends on (Predecessors), the jobs that directly
+// depend on it (Successors), and the full sets of jobs that it depends on
+// (Ancestors) and that depend on it (Descendants).
+func (h *HTTPAPI) DependencyHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	logger.Printf("Handling GET request for %s", request.URL.Path)
+	ids := dependencyPathIDs(request)
+	if len(ids) != 1 || uuid.Parse(ids[0]) == nil {
+		WriteRequestError(writer, "The path must be /dependencies/<job-uuid>")
+		return
+	}
+	if jr, err := h.d.GetJob(ids[0]); err == sql.ErrNoRows || (err == nil && !CanAccessJob(p, jr)) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", ids[0]))
+		return
+	} else if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	deps, err := LoadJobDependencies(h.d, ids[0])
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, deps)
+}
+
+// DependencyHTTPPost makes one job depend on another. The incoming JSON should
+// have the following format:
+//    {
+//      "PredecessorID" : "<uuid>",
+//      "SuccessorID"   : "<uuid>"
+//    }
+// Both jobs must already exist. A 409 is returned if the dependency would
+// create a cycle in the dependency graph.
+func (h *HTTPAPI) DependencyHTTPPost(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	bytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		LogAPIMsg(request, err.Error())
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	LogAPIMsg(request, string(bytes))
+	var dep CondorJobDep
+	if err = json.Unmarshal(bytes, &dep); err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	for _, id := range []string{dep.PredecessorID, dep.SuccessorID} {
+		if uuid.Parse(id) == nil {
+			WriteRequestError(writer, "The PredecessorID and SuccessorID fields must both be UUIDs")
+			return
+		}
+		if _, err = h.d.GetJob(id); err == sql.ErrNoRows {
+			WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", id))
+			return
+		} else if err != nil {
+			WriteError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	err = AddJobDependency(h.d, &dep)
+	if err == ErrDependencyCycle {
+		WriteError(writer, http.StatusConflict, fmt.Sprintf("Job %s can't depend on job %s: %s", dep.SuccessorID, dep.PredecessorID, err))
+		return
+	}
+	if err != nil {
+		LogAPIMsg(request, fmt.Sprintf("Error adding dependency: %s", err))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, dep)
+}
+
+// DependencyHTTPDelete removes a dependency. The path must be
+// /dependencies/<predecessor-uuid>/<successor-uuid>.
+func (h *HTTPAPI) DependencyHTTPDelete(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	ids := dependencyPathIDs(request)
+	if len(ids) != 2 || uuid.Parse(ids[0]) == nil || uuid.Parse(ids[1]) == nil {
+		WriteRequestError(writer, "The path must be /dependencies/<predecessor-uuid>/<successor-uuid>")
+		return
+	}
+	exists, err := h.d.HasCondorJobDep(ids[0], ids[1])
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s doesn't depend on job %s", ids[1], ids[0]))
+		return
+	}
+	if err = h.d.DeleteCondorJobDep(ids[0], ids[1]); err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+// DeadNotificationsHTTPGet returns a JSON list of the outbound notifications
+// that ran out of delivery attempts, including the last error for each one.
+func (h *HTTPAPI) DeadNotificationsHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	dead, err := h.d.GetDeadOutboundNotifications()
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dead == nil {
+		dead = []OutboundNotification{}
+	}
+	writeJSON(writer, http.StatusOK, dead)
 }
 
 // LastEventHTTP handles HTTP requests for looking up a job's last event. The
@@ -105,7 +582,7 @@ func (h *HTTPAPI) RouteLastEventRequests(writer http.ResponseWriter, request *ht
 //
 // 'completion_date' will be a timestamp that looks like
 // '2006-01-02T15:04:05Z07:00'.
-func (h *HTTPAPI) LastEventHTTP(writer http.ResponseWriter, request *http.Request) {
+func (h *HTTPAPI) LastEventHTTP(writer http.ResponseWriter, request *http.Request, p *Principal) {
 	logger.Printf("Handling GET request for %s", request.URL.Path)
 	baseName := path.Base(request.URL.Path)
 	if baseName == "" {
@@ -119,30 +596,38 @@ func (h *HTTPAPI) LastEventHTTP(writer http.ResponseWriter, request *http.Reques
 	}
 	jr, err := h.d.GetJobByInvocationID(baseName)
 	if err != nil {
-		WriteRequestError(writer, err.Error())
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if jr == nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("Job %s was not found", baseName)))
+	if jr == nil || !CanAccessJob(p, jr) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", baseName))
 		return
 	}
 	lastCondorJobEvent, err := h.d.GetLastCondorJobEvent(jr.ID)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Last event for job %s using invocation %s was not found", jr.ID, baseName))
+		return
+	}
 	if err != nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("Last event for job %s using invocation %s was not found", jr.ID, baseName)))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	lastJobEvent, err := h.d.GetCondorJobEvent(lastCondorJobEvent.CondorJobEventID)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("JobEvent %s was not found for last event lookup", lastCondorJobEvent.CondorJobEventID))
+		return
+	}
 	if err != nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("JobEvent %s was not found for last event lookup", lastCondorJobEvent.CondorJobEventID)))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	condorEvent, err := h.d.GetCondorEvent(lastJobEvent.CondorEventID)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("CondorEvent %s was not found for last event lookup", lastJobEvent.CondorEventID))
+		return
+	}
 	if err != nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("CondorEvent %s was not found for last event lookup", lastJobEvent.CondorEventID)))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	appEvent := &Event{
@@ -157,20 +642,19 @@ func (h *HTTPAPI) LastEventHTTP(writer http.ResponseWriter, request *http.Reques
 	jobState := NewJobState(appEvent)
 	marshalled, err := json.Marshal(jobState)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(err.Error()))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	logger.Printf("Response for last event lookup by invocation %s:\n%s", baseName, string(marshalled[:]))
+	writer.Header().Set("Content-Type", "application/json")
 	writer.Write(marshalled)
-
 }
 
 // InvocationHTTPGet is responsible for getting a Job from the database by
 // its InvocationID and returning the job record as a JSON encoded string body.
 // The Invocation UUID is extracted from the basename of the URL path and must
 // be a valid UUID.
-func (h *HTTPAPI) InvocationHTTPGet(writer http.ResponseWriter, request *http.Request) {
+func (h *HTTPAPI) InvocationHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
 	logger.Printf("Handling GET request for %s", request.URL.Path)
 	baseName := path.Base(request.URL.Path)
 	if baseName == "" {
@@ -184,28 +668,75 @@ func (h *HTTPAPI) InvocationHTTPGet(writer http.ResponseWriter, request *http.Re
 	}
 	jr, err := h.d.GetJobByInvocationID(baseName)
 	if err != nil {
-		WriteRequestError(writer, err.Error())
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if jr == nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("Job %s was not found", baseName)))
+	if jr == nil || !CanAccessJob(p, jr) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", baseName))
 		return
 	}
 	marshalled, err := json.Marshal(jr)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(err.Error()))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	logger.Printf("Response for job lookup by invocation ID %s:\n%s", baseName, string(marshalled[:]))
+	writer.Header().Set("Content-Type", "application/json")
 	writer.Write(marshalled)
 }
 
+// JobsHTTPList returns a page of jobs that match the filters in the query
+// string as a JSON object. The response looks like this:
+//
+//    {
+//      "Jobs"       : [<job>, ...],
+//      "NextCursor" : "<string>"
+//    }
+//
+// Each job is a job record with an additional Status field. The supported
+// query parameters are:
+//
+//    submitter        - the username of the user that submitted the job
+//    app_id           - the UUID of the app
+//    status           - the job's current status, like Running or Failed
+//    batch_id         - the UUID of the batch the job is a part of
+//    submitted_after  - an RFC3339 timestamp
+//    submitted_before - an RFC3339 timestamp
+//    completed_after  - an RFC3339 timestamp
+//    completed_before - an RFC3339 timestamp
+//    exit_code        - the job's exit code
+//    sort             - date_submitted (the default) or date_completed
+//    order            - desc (the default) or asc
+//    limit            - the page size, 50 by default and at most 500
+//    cursor           - the NextCursor from the previous page
+//
+// NextCursor is empty on the last page.
+func (h *HTTPAPI) JobsHTTPList(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	logger.Printf("Handling GET request for %s", request.URL.String())
+	filter, err := ParseJobFilter(request.URL.Query())
+	if err != nil {
+		WriteRequestError(writer, err.Error())
+		return
+	}
+	if !p.Admin {
+		if filter.Submitter != "" && filter.Submitter != p.Subject {
+			WriteError(writer, http.StatusForbidden, "Only your own jobs can be listed")
+			return
+		}
+		filter.Submitter = p.Subject
+	}
+	listings, err := h.d.ListJobs(filter)
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, filter.Page(listings))
+}
+
 // JobHTTPGet is responsible for retrieving a Job from the database and returning
 // its record as a JSON object. The UUID for the job is extracted from the basename
 // of the URL path and must be a valid UUID.
-func (h *HTTPAPI) JobHTTPGet(writer http.ResponseWriter, request *http.Request) {
+func (h *HTTPAPI) JobHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
 	logger.Printf("Handling GET request for %s", request.URL.Path)
 	baseName := path.Base(request.URL.Path)
 	if baseName == "" {
@@ -218,40 +749,38 @@ func (h *HTTPAPI) JobHTTPGet(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 	jr, err := h.d.GetJob(baseName)
-	if err != nil {
-		WriteRequestError(writer, err.Error())
+	if err == sql.ErrNoRows || (err == nil && (jr == nil || !CanAccessJob(p, jr))) {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", baseName))
 		return
 	}
-	if jr == nil {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte(fmt.Sprintf("Job %s was not found", baseName)))
+	if err != nil {
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	marshalled, err := json.Marshal(jr)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(err.Error()))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	logger.Printf("Response for job lookup by UUID %s:\n%s", baseName, string(marshalled[:]))
+	writer.Header().Set("Content-Type", "application/json")
 	writer.Write(marshalled)
 }
 
 // JobHTTPPost is responsible for parsing JSON and inserting a new job into the
 // database. The incoming JSON should have the following format:
 //    {
-//      "Submitter"   : "<string>",
-//      "AppID"       : "<uuid>",
-//      "CommandLine" : "<string>",
-//      "CondorID"    : "<string>"
+//      "Submitter" : "<string>",
+//      "AppID"     : "<uuid>",
+//      "CondorID"  : "<string>"
 //    }
 // Those are the required fields. The following fields are also accepted:
 // 	  {
 //      "BatchID"          : "<uuid>",
+//      "InvocationID"     : "<uuid>",
 //      "DateSubmitted"    : "<timestamp>",
 //      "DateStarted"      : "<timestamp>",
 //      "DateCompleted"    : "<timestamp>",
-//      "EnvVariables"     : "<string>",
 //      "ExitCode"         : <int>,
 //      "FailureCount"     : <int>,
 //      "FailureThreshold" : <int>
@@ -261,7 +790,11 @@ func (h *HTTPAPI) JobHTTPGet(writer http.ResponseWriter, request *http.Request)
 //    2006-01-02T15:04:05Z07:00
 // The timezone *is* stored with dates, so you'll probably want to convert to
 // UTC before sending the timestamps in the JSON.
-func (h *HTTPAPI) JobHTTPPost(writer http.ResponseWriter, request *http.Request) {
+//
+// Any other fields are rejected, except for CommandLine and EnvVariables,
+// which are ignored. If a job with the same CondorID already exists, only the
+// fields in the request are updated on it.
+func (h *HTTPAPI) JobHTTPPost(writer http.ResponseWriter, request *http.Request, p *Principal) {
 	bytes, err := ioutil.ReadAll(request.Body)
 	if err != nil {
 		LogAPIMsg(request, err.Error())
@@ -269,56 +802,90 @@ func (h *HTTPAPI) JobHTTPPost(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 	LogAPIMsg(request, fmt.Sprintf("%s", string(bytes)))
-	var parsed JobRecord
-	err = json.Unmarshal(bytes, &parsed)
+	parsed, err := ParseJobRequest(bytes, true)
 	if err != nil {
-		WriteRequestError(writer, err.Error())
+		LogAPIMsg(request, fmt.Sprintf("Invalid job: %s", err))
+		WriteBodyError(writer, err)
 		return
 	}
-	if parsed.Submitter == "" {
-		errMsg := "The Submitter field is required in the POST JSON"
-		LogAPIMsg(request, errMsg)
-		WriteRequestError(writer, errMsg)
-		return
+	var job *JobRecord
+	existing, err := h.d.GetJobByCondorID(*parsed.CondorID)
+	if err == sql.ErrNoRows {
+		var id string
+		id, err = h.d.InsertJob(parsed.NewJobRecord(time.Now()))
+		if err == nil {
+			job, err = h.d.GetJob(id)
+		}
+	} else if err == nil {
+		job, err = h.d.PatchJob(existing.ID, parsed)
 	}
-	if parsed.AppID == "" {
-		errMsg := "The AppID field is required in the POST JSON"
+	if err != nil {
+		errMsg := fmt.Sprintf("Error upserting job: %s", err)
 		LogAPIMsg(request, errMsg)
-		WriteRequestError(writer, errMsg)
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if parsed.CondorID == "" {
-		errMsg := "The CondorID field is required in the POST JSON"
-		LogAPIMsg(request, errMsg)
-		WriteRequestError(writer, errMsg)
+	if existing != nil && existing.InvocationID == "" && job.InvocationID != "" {
+		h.backfill(request, job)
+	}
+	writer.Write([]byte(job.ID))
+}
+
+// backfill sends the status of a job that was just linked to its invocation
+// upstream again. Errors are logged instead of failing the request, since the
+// job was updated either way.
+func (h *HTTPAPI) backfill(request *http.Request, job *JobRecord) {
+	if h.events == nil {
 		return
 	}
-	// if parsed.InvocationID == "" {
-	// 	errMsg := "The InvocationID field is required in the POST JSON"
-	// 	LogAPIMsg(request, errMsg)
-	// 	WriteRequestError(writer, errMsg)
-	// 	return
-	// }
-	parsed.InvocationID = ""
+	if err := h.events.Backfill(job, nil); err != nil {
+		LogAPIMsg(request, fmt.Sprintf("Error backfilling the status of job %s: %s", job.ID, err))
+	}
+}
 
-	if parsed.DateSubmitted.IsZero() {
-		parsed.DateSubmitted = time.Now()
+// JobHTTPPatch updates the job whose UUID is at the end of the path with the
+// fields in the request body, which accepts the same fields as JobHTTPPost but
+// doesn't require any particular ones. Fields that aren't in the request are
+// left alone. The updated job is returned.
+func (h *HTTPAPI) JobHTTPPatch(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	id := path.Base(request.URL.Path)
+	if uuid.Parse(id) == nil {
+		WriteRequestError(writer, fmt.Sprintf("The base of the path must be a UUID: %s", id))
+		return
+	}
+	bytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		LogAPIMsg(request, err.Error())
+		WriteRequestError(writer, err.Error())
+		return
 	}
-	if parsed.DateCompleted.IsZero() {
-		parsed.DateCompleted = time.Now()
+	LogAPIMsg(request, fmt.Sprintf("%s", string(bytes)))
+	parsed, err := ParseJobRequest(bytes, false)
+	if err != nil {
+		LogAPIMsg(request, fmt.Sprintf("Invalid job update: %s", err))
+		WriteBodyError(writer, err)
+		return
+	}
+	var orphaned bool
+	if parsed.InvocationID != nil && *parsed.InvocationID != "" {
+		if before, err := h.d.GetJob(id); err == nil {
+			orphaned = before.InvocationID == ""
+		}
 	}
-	if parsed.DateStarted.IsZero() {
-		parsed.DateStarted = time.Now()
+	job, err := h.d.PatchJob(id, parsed)
+	if err == sql.ErrNoRows {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("Job %s was not found", id))
+		return
 	}
-	job, err := h.d.UpsertJob(&parsed)
 	if err != nil {
-		errMsg := fmt.Sprintf("Error upserting job: %s", err)
-		LogAPIMsg(request, errMsg)
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(err.Error()))
+		LogAPIMsg(request, fmt.Sprintf("Error updating job %s: %s", id, err))
+		WriteError(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
-	writer.Write([]byte(job.ID))
+	if orphaned {
+		h.backfill(request, job)
+	}
+	writeJSON(writer, http.StatusOK, job)
 }
 
 func formatPort(port string) string {
@@ -328,21 +895,45 @@ func formatPort(port string) string {
 	return fmt.Sprintf(":%s", port)
 }
 
-// SetupHTTP configures a new HTTPAPI instance, registers handlers, and fires
-// off a goroutinge that listens for requests. Should probably only be called
-// once.
-func SetupHTTP(config *Configuration, d *Databaser) {
+// SetupHTTP configures a new HTTPAPI instance, sets up its router, and fires
+// off a goroutine that listens for requests. The server is returned so that it
+// can be shut down. Should probably only be called once.
+func SetupHTTP(config *Configuration, d JobStore, events *PostEventHandler) *HTTPServer {
+	api := HTTPAPI{
+		d:           d,
+		events:      events,
+		stopTimeout: defaultStopTimeout,
+	}
+	if config.StopTimeout > 0 {
+		api.stopTimeout = time.Duration(config.StopTimeout) * time.Second
+	}
+	auth, err := NewAuth(&config.Auth)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// The API stays open without Auth until every client signs its requests,
+	// but that has to be asked for with AllowUnauthenticated to keep it quiet.
+	if auth == nil {
+		logger.Print("WARNING: no Auth settings are configured, so the HTTP API is open to anyone who can reach it")
+		if !config.AllowUnauthenticated {
+			logger.Print("WARNING: AllowUnauthenticated isn't set; a later release won't start without Auth unless it is")
+		}
+	}
+	api.auth = auth
+	api.allowUnauthenticated = auth == nil || config.AllowUnauthenticated
+	server, err := NewHTTPServer(config, api.Router())
+	if err != nil {
+		logger.Fatal(err)
+	}
 	go func() {
-		api := HTTPAPI{
-			d: d,
+		scheme := "HTTP"
+		if server.TLS() {
+			scheme = "HTTPS"
+		}
+		logger.Printf("Listening for %s requests on %s", scheme, server.Addr)
+		if err := server.ListenAndServe(); err != nil {
+			logger.Fatal(err)
 		}
-		http.HandleFunc("/jobs/", api.RouteJobRequests)
-		http.HandleFunc("/jobs", api.RouteJobRequests)
-		http.HandleFunc("/invocations/", api.RouteInvocationRequests)
-		http.HandleFunc("/invocations", api.RouteInvocationRequests)
-		http.HandleFunc("/last-events/", api.RouteLastEventRequests)
-		http.HandleFunc("/last-events", api.RouteLastEventRequests)
-		logger.Printf("Listening for HTTP requests on %s", config.HTTPListenPort)
-		logger.Fatal(http.ListenAndServe(formatPort(config.HTTPListenPort), nil))
 	}()
+	return server
 }
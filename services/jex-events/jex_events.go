@@ -8,11 +8,14 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"code.google.com/p/go-uuid/uuid"
 	"github.com/streadway/amqp"
 )
 
@@ -78,6 +81,78 @@ type Configuration struct {
 	ExchangeName, ExchangeType, RoutingKey, QueueName, QueueBindingKey    string
 	ExchangeDurable, ExchangeAutodelete, ExchangeInternal, ExchangeNoWait bool
 	QueueDurable, QueueAutodelete, QueueExclusive, QueueNoWait            bool
+
+	// Settings for the outbound notification queue. Timeouts and backoffs are
+	// in seconds. NotificationTimeouts maps an endpoint URL to the timeout for
+	// that endpoint; NotificationTimeout is used for all other endpoints.
+	NotificationTimeout     int
+	NotificationTimeouts    map[string]int
+	NotificationMaxAttempts int
+	NotificationBackoff     int
+
+	// Additional sinks that job status changes are sent to, on top of the
+	// /de-job endpoint in EventURL.
+	Notifiers []NotifierConfig
+
+	// Event mappings that replace or add to the built-in mappings and the
+	// mappings stored in the condor_events table.
+	EventMappings []EventMapping
+
+	// Rules for handling held jobs. The built-in rules are used if this isn't
+	// set.
+	HoldPolicy HoldPolicy
+
+	// The number of seconds a stop request can go without the job's abort
+	// event arriving before it's reported as timed out.
+	StopTimeout int
+
+	// Settings for authenticating HTTP API requests. Without them the API is
+	// open to anyone, which is logged as a warning unless AllowUnauthenticated
+	// is set.
+	Auth                 AuthConfig
+	AllowUnauthenticated bool
+
+	// Settings for the HTTP server, in seconds. HTTPWriteTimeout isn't set by
+	// default since it also limits how long status streams stay open.
+	HTTPReadTimeout  int
+	HTTPWriteTimeout int
+	HTTPIdleTimeout  int
+
+	// The certificate and key for serving HTTPS. If TLSClientCAFile is set,
+	// clients have to present a certificate signed by one of the CAs in it.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	// The number of seconds to wait for HTTP requests and AMQP deliveries to
+	// finish when shutting down.
+	ShutdownTimeout int
+
+	// Settings for archiving old jobs. Every ArchiveInterval seconds, jobs that
+	// reached a terminal status more than RetentionDays days ago are written
+	// to gzipped JSONL files in ArchiveDir and deleted from the database.
+	// Nothing is archived unless RetentionDays and ArchiveDir are both set.
+	RetentionDays   int
+	ArchiveDir      string
+	ArchiveInterval int
+
+	// Settings for handling events in batches. Up to EventBatchSize events
+	// are stored in one transaction, waiting up to EventBatchWait milliseconds
+	// for a batch to fill up. Events are handled one at a time by default.
+	EventBatchSize int
+	EventBatchWait int
+
+	// Settings for looking up the invocation IDs of jobs that don't have one
+	// in the JEX. InvocationLookupURL is a template that's executed with the
+	// job to get the URL to look it up at. The jobs are looked up every
+	// InvocationLookupInterval seconds, and only if InvocationLookupURL is set.
+	InvocationLookupURL      string
+	InvocationLookupInterval int
+
+	// If this is set, the database isn't migrated at startup, and jex-events
+	// refuses to start unless it's already up to date. The migrate command can
+	// be used to migrate it instead.
+	DisableMigrations bool
 }
 
 // ReadConfig reads JSON from 'path' and returns a pointer to a Configuration
@@ -137,6 +212,10 @@ func (c *Configuration) Valid() bool {
 		logger.Println("QueueBindingKey must be set in the configuration file.")
 		retval = false
 	}
+	if err := c.HoldPolicy.Validate(); err != nil {
+		logger.Printf("HoldPolicy is invalid: %s", err)
+		retval = false
+	}
 	return retval
 }
 
@@ -160,6 +239,7 @@ type AMQPConsumer struct {
 	ConsumerTag        string
 	connection         *amqp.Connection
 	channel            *amqp.Channel
+	closing            chan bool
 }
 
 // NewAMQPConsumer creates a new instance of AMQPConsumer and returns a
@@ -181,6 +261,7 @@ func NewAMQPConsumer(cfg *Configuration) *AMQPConsumer {
 		QueueExclusive:     cfg.QueueExclusive,
 		QueueNoWait:        cfg.QueueNoWait,
 		ConsumerTag:        cfg.ConsumerTag,
+		closing:            make(chan bool),
 	}
 }
 
@@ -191,7 +272,7 @@ type ConnectionErrorChannel struct {
 
 // MsgHandler functions will accept msgs from a Delivery channel and report
 // error on the error channel.
-type MsgHandler func(<-chan amqp.Delivery, <-chan int, *Databaser, string, string)
+type MsgHandler func(<-chan amqp.Delivery, <-chan int, JobStore, *PostEventHandler)
 
 // Connect sets up a connection to an AMQP exchange
 func (c *AMQPConsumer) Connect(errorChannel chan ConnectionErrorChannel) (<-chan amqp.Delivery, error) {
@@ -279,6 +360,12 @@ func (c *AMQPConsumer) SetupReconnection(
 		for {
 			select {
 			case exitError, ok := <-exitChan:
+				select {
+				case <-c.closing:
+					logger.Println("The AMQP connection was closed for shutdown.")
+					return
+				default:
+				}
 				if !ok {
 					logger.Println("Exit channel closed.")
 				}
@@ -290,22 +377,40 @@ func (c *AMQPConsumer) SetupReconnection(
 	}()
 }
 
+// Close closes the connection to the AMQP broker without exiting. Deliveries
+// that were sent to jex-events but never handed to the EventHandler haven't
+// been acked, so the broker requeues them. Should only be called once.
+func (c *AMQPConsumer) Close() error {
+	close(c.closing)
+	if c.connection == nil {
+		return nil
+	}
+	return c.connection.Close()
+}
+
 // Event contains an event received from the AMQP broker and parsed from JSON.
 type Event struct {
-	Event        string
-	Hash         string
-	EventNumber  string
-	ID           string
-	CondorID     string
-	AppID        string
-	InvocationID string
-	User         string
-	Description  string
-	EventName    string
-	ExitCode     int
-	Date         string
-	Time         string
-	Msg          string
+	Event             string
+	Hash              string
+	EventNumber       string
+	ID                string
+	CondorID          string
+	AppID             string
+	InvocationID      string
+	User              string
+	Description       string
+	EventName         string
+	ExitCode          int
+	TerminationKind   string
+	TerminationSignal int
+	Date              string
+	Time              string
+	Msg               string
+	JobID             string
+	HoldReason        string
+	HoldCode          int
+	HoldSubcode       int
+	Usage             *JobResourceUsage `json:"-"`
 }
 
 func (e *Event) String() string {
@@ -321,24 +426,15 @@ func (e *Event) String() string {
 	return retval
 }
 
-// IsFailure returns true if the event denotes a failed job.
+// IsFailure returns true if the event denotes a failed job. Events mapped to
+// the Completed status are failures if the job exited with a non-zero exit
+// code.
 func (e *Event) IsFailure() bool {
-	switch e.EventNumber {
-	case "002":
-		return true
-	case "004":
-		return true
-	case "009":
-		return true
-	case "010":
-		return true
-	case "012":
-		return true
-	case "005": //Assume that Parse() has already been called.
+	m := e.Mapping()
+	if m.Status == StatusCompleted { //Assume that Parse() has already been called.
 		return e.ExitCode != 0
-	default:
-		return false
 	}
+	return m.Status == StatusFailed
 }
 
 const (
@@ -346,20 +442,36 @@ const (
 	EventCodeNotSet = -9000
 )
 
-// setExitCode will parse out the exit code from the event text, if it's there.
-func (e *Event) setExitCode() {
-	r := regexp.MustCompile(`\(return value (.*)\)`)
-	matches := r.FindStringSubmatch(e.Event)
-	matchesLength := len(matches)
-	if matchesLength < 2 {
-		e.ExitCode = EventCodeNotSet
+// setExitCode will parse out how the job terminated from the event text. Jobs
+// that terminated normally get the exit code from the event, while jobs that
+// were killed by a signal get the signal number and an exit code of
+// EventCodeNotSet. So do events that don't say how the job terminated. An error
+// is returned if the code or signal doesn't fit in an int.
+func (e *Event) setExitCode() error {
+	e.ExitCode = EventCodeNotSet
+	e.TerminationKind = TerminationUnknown
+	e.TerminationSignal = 0
+	if matches := normalTerminationRegexp.FindStringSubmatch(e.Event); len(matches) == 2 {
+		code, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return fmt.Errorf("error converting exit code to an integer: %s", matches[1])
+		}
+		e.ExitCode = code
+		e.TerminationKind = TerminationNormal
+		return nil
 	}
-	code, err := strconv.Atoi(matches[1])
-	if err != nil {
-		logger.Printf("Error converting exit code to an integer: %s", matches[1])
-		e.ExitCode = EventCodeNotSet
+	if matches := abnormalTerminationRegexp.FindStringSubmatch(e.Event); len(matches) == 2 {
+		signal, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return fmt.Errorf("error converting signal to an integer: %s", matches[1])
+		}
+		e.TerminationSignal = signal
+		e.TerminationKind = TerminationSignal
+		if coreFileRegexp.MatchString(e.Event) {
+			e.TerminationKind = TerminationCoreDump
+		}
 	}
-	e.ExitCode = code
+	return nil
 }
 
 // setCondorID will return the condor ID in the string that's passed in.
@@ -369,6 +481,7 @@ func (e *Event) setCondorID() {
 	matchesLength := len(matches)
 	if matchesLength < 2 {
 		e.CondorID = ""
+		return
 	}
 	if strings.HasPrefix(matches[1], "0") {
 		e.CondorID = strings.TrimLeft(matches[1], "0")
@@ -378,19 +491,88 @@ func (e *Event) setCondorID() {
 }
 
 // setInvocationID will make sure the Invocation ID gets set when appropriate.
+// It's taken from the IpcUuid attribute that the JEX adds to the job's
+// ClassAd, which shows up in job ad information events and in submit events
+// that include the ClassAd. Values that aren't UUIDs are ignored.
 func (e *Event) setInvocationID() {
-	r := regexp.MustCompile(`IpcUuid = \"(.*)\"`)
+	r := regexp.MustCompile(`IpcUuid\s*=\s*"([^"]*)"`)
 	matches := r.FindStringSubmatch(e.Event)
-	if len(matches) < 2 {
+	if len(matches) < 2 || uuid.Parse(matches[1]) == nil {
 		e.InvocationID = ""
 	} else {
 		e.InvocationID = matches[1]
 	}
-	logger.Printf("Parsed out %s as the invocation ID", e.InvocationID)
 }
 
-// Parse extracts info from an event string.
+// setHoldReason parses the hold reason, code, and subcode out of the text of a
+// held event. The reason is the line after the "Job was held." line and the
+// codes follow it on a line like "Code 12 Subcode 2".
+func (e *Event) setHoldReason() {
+	lines := strings.Split(e.Event, "\n")
+	if len(lines) >= 2 {
+		e.HoldReason = strings.TrimSpace(lines[1])
+	}
+	r := regexp.MustCompile(`Code ([0-9]+) Subcode (-?[0-9]+)`)
+	matches := r.FindStringSubmatch(e.Event)
+	if len(matches) < 3 {
+		return
+	}
+	e.HoldCode, _ = strconv.Atoi(matches[1])
+	e.HoldSubcode, _ = strconv.Atoi(matches[2])
+}
+
+// setResourceUsage parses what the job used out of the text of a terminated
+// event.
+func (e *Event) setResourceUsage() {
+	e.Usage = ParseResourceUsage(e.Event)
+}
+
+// Timestamp returns the time that the event was triggered according to the
+// Date and Time fields. Condor leaves the year out of the date, so the year is
+// taken from 'now'. Events that would end up more than a day in the future are
+// assumed to be from the previous year, which happens when a December event is
+// processed in January.
+func (e *Event) Timestamp(now time.Time) (time.Time, error) {
+	var layout string
+	switch strings.Count(e.Date, "/") {
+	case 1:
+		layout = "01/02 15:04:05"
+	case 2:
+		layout = "01/02/06 15:04:05"
+	default:
+		return time.Time{}, fmt.Errorf("unrecognized event date: '%s'", e.Date)
+	}
+	t, err := time.ParseInLocation(layout, fmt.Sprintf("%s %s", e.Date, e.Time), now.Location())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if layout == "01/02/06 15:04:05" {
+		return t, nil
+	}
+	t = t.AddDate(now.Year()-t.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, nil
+}
+
+// Parse extracts info from an event string and logs what it finds that's worth
+// knowing about as the event comes in.
 func (e *Event) Parse() {
+	if err := e.parse(); err != nil {
+		logger.Printf("Error parsing event %s for %s: %s", e.EventNumber, e.ID, err)
+	}
+	if e.EventNumber == "000" || e.EventNumber == "028" {
+		logger.Printf("Parsed out %s as the invocation ID", e.InvocationID)
+	}
+}
+
+// parse extracts info from an event string without logging anything, which is
+// what's wanted when events that have already been stored are parsed again.
+// Parsing doesn't stop on an error; the fields that couldn't be parsed are
+// left at their defaults.
+func (e *Event) parse() error {
+	var err error
 	r := regexp.MustCompile("^([0-9]{3}) (\\([0-9]+(?:\\.[0-9]+){2}\\)) ([0-9/]+) ([0-9:]+) (.*)\\n")
 	matches := r.FindStringSubmatch(e.Event)
 	matchesLength := len(matches)
@@ -413,118 +595,23 @@ func (e *Event) Parse() {
 		e.setCondorID()
 	}
 	if e.EventNumber == "005" { //This means that the job is in the Completed state.
-		e.setExitCode()
+		err = e.setExitCode()
+		e.setResourceUsage()
 	}
-	if e.EventNumber == "028" { //parse out execution id from the body of the event.
+	if e.EventNumber == "000" || e.EventNumber == "028" { //parse out execution id from the job's ClassAd.
 		e.setInvocationID()
 	}
-}
-
-// EventHandler processes incoming event messages
-func EventHandler(deliveries <-chan amqp.Delivery, quit <-chan int, d *Databaser, postURL string, JEXURL string) {
-	eventHandler := PostEventHandler{
-		PostURL: postURL,
-		JEXURL:  JEXURL,
-		DB:      d,
+	if e.EventNumber == "012" { //parse out the reason the job was held.
+		e.setHoldReason()
 	}
-	for {
-		select {
-		case delivery := <-deliveries:
-			body := delivery.Body
-			delivery.Ack(false) //We're not doing batch deliveries, which is what the false means
-			var event Event
-			err := json.Unmarshal(body, &event)
-			if err != nil {
-				logger.Print(err)
-				logger.Print(string(body[:]))
-				continue
-			}
-
-			// parse the body of the event that came from the amqp broker.
-			event.Parse()
-			logger.Println(event.String())
-
-			// adds the job to the database, but only if it doesn't already exist.
-			job, err := d.AddJob(event.CondorID)
-			if err != nil {
-				logger.Printf("Error adding job: %s", err)
-				continue
-			}
-
-			// make sure the exit code is set so that it gets updated in upcoming steps.
-			job.ExitCode = event.ExitCode
-
-			// set the invocation id, but only if it's not set and the event actually
-			// has a value to update it with.
-			if job.InvocationID == "" && event.InvocationID != "" {
-				job.InvocationID = event.InvocationID
-				logger.Printf("Setting InvocationID to %s", job.InvocationID)
-			} else {
-				logger.Printf("Setting the InvocationID was not necessary")
-			}
-
-			// we're expecting an exit code of 0 for successful runs. HT jobs may have
-			// more than one failure.
-			if job.ExitCode != 0 {
-				job.FailureCount = job.FailureCount + 1
-			}
-
-			// update the job with any additional information
-			job, err = d.UpdateJob(job) // Need to make sure the exit code gets stored.
-			if err != nil {
-				logger.Printf("Error updating job")
-			}
-
-			// update the parsed event object with info returned from the database.
-			event.CondorID = job.CondorID
-			event.InvocationID = job.InvocationID
-			event.AppID = job.AppID
-			event.User = job.Submitter
-
-			// don't add the event to the database if it's already there.
-			exists, err := d.DoesCondorJobEventExist(event.Hash)
-			if err != nil {
-				logger.Printf("Error checking for job event existence by checksum: %s", event.Hash)
-				continue
-			}
-			if exists {
-				logger.Printf("An event with a hash of %s already exists in the database, skipping", event.Hash)
-				continue
-			}
-
-			// send event updates upstream to Donkey
-			err = eventHandler.Route(&event)
-			if err != nil {
-				logger.Printf("Error sending event upstream: %s", err)
-			}
+	return err
+}
 
-			// store the unparsed (raw), event information in the database.
-			rawEventID, err := d.AddCondorRawEvent(event.Event, job.ID)
-			if err != nil {
-				logger.Printf("Error adding raw event: %s", err)
-				continue
-			}
-			ce, err := d.GetCondorEventByNumber(event.EventNumber)
-			if err != nil {
-				logger.Printf("Error getting condor event: %s", err)
-				continue
-			}
-			jobEventID, err := d.AddCondorJobEvent(job.ID, ce.ID, rawEventID, event.Hash)
-			if err != nil {
-				logger.Printf("Error adding job event: %s", err)
-				continue
-			}
-			if eventHandler.ShouldUpdateLastEvents(&event) {
-				_, err = d.UpsertLastCondorJobEvent(jobEventID, job.ID)
-				if err != nil {
-					logger.Printf("Error upserting last condor job event: %s", err)
-					continue
-				}
-			}
-		case <-quit:
-			break
-		}
-	}
+// EventHandler processes incoming event messages one at a time. It returns
+// when 'quit' receives a value or is closed, or when 'deliveries' is closed. A
+// delivery that's being processed when that happens is finished first.
+func EventHandler(deliveries <-chan amqp.Delivery, quit <-chan int, d JobStore, eventHandler *PostEventHandler) {
+	BatchEventHandler(deliveries, quit, d, eventHandler, 1, 0)
 }
 
 // AppVersion prints version information to stdout
@@ -557,26 +644,113 @@ func main() {
 		os.Exit(-1)
 	}
 	logger.Println("Done reading config.")
+	switch flag.Arg(0) {
+	case "":
+	case "migrate":
+		os.Exit(MigrateCommand(config))
+	case "restore":
+		os.Exit(RestoreCommand(config, flag.Args()[1:]))
+	case "rebuild":
+		os.Exit(RebuildCommand(config, flag.Args()[1:]))
+	default:
+		logger.Printf("Unknown command '%s'", flag.Arg(0))
+		os.Exit(-1)
+	}
+	if config.HoldPolicy == nil {
+		config.HoldPolicy = defaultHoldPolicy
+	}
 	if !config.Valid() {
 		logger.Println("Something is wrong with the jex-events config file.")
 		os.Exit(-1)
 	}
-	logger.Println("Configuring database connection...")
-	databaser, err := NewDatabaser(config.DBURI)
+	var databaser JobStore
+	if IsSQLiteURI(config.DBURI) {
+		logger.Println("Opening the SQLite database...")
+		databaser, err = NewSQLiteStore(strings.TrimPrefix(config.DBURI, sqliteScheme))
+		if err != nil {
+			logger.Print(err)
+			os.Exit(-1)
+		}
+		logger.Println("Done opening the SQLite database.")
+	} else {
+		logger.Println("Configuring database connection...")
+		pg, err := NewDatabaser(config.DBURI)
+		if err != nil {
+			logger.Print(err)
+			os.Exit(-1)
+		}
+		logger.Println("Done configuring database connection.")
+
+		if config.DisableMigrations {
+			logger.Println("Checking the database version...")
+			err = pg.CheckVersion()
+		} else {
+			logger.Println("Migrating the database...")
+			_, err = pg.Migrate()
+		}
+		if err != nil {
+			logger.Print(err)
+			os.Exit(-1)
+		}
+		logger.Printf("The database is at version %s", schemaVersion)
+		databaser = pg
+	}
+
+	logger.Println("Loading event mappings...")
+	eventMappings, err = LoadEventMappings(databaser, config.EventMappings)
 	if err != nil {
 		logger.Print(err)
 		os.Exit(-1)
 	}
-	logger.Println("Done configuring database connection.")
+	logger.Println("Done loading event mappings.")
 
 	randomizer := rand.New(rand.NewSource(time.Now().UnixNano()))
 	connErrChan := make(chan ConnectionErrorChannel)
 	quitHandler := make(chan int)
+	quitNotifications := make(chan int)
 	consumer := NewAMQPConsumer(config)
 	consumer.SetupReconnection(connErrChan)
 
+	queue := NewNotificationQueue(config, databaser)
+	quitArchiver := make(chan int)
+	archiver := NewArchiver(config, databaser)
+	if archiver != nil {
+		logger.Printf("Archiving jobs older than %d days to %s", config.RetentionDays, archiver.Dir)
+		go archiver.Run(quitArchiver)
+	}
+	notifiers, err := NewNotifiers(config, queue)
+	if err != nil {
+		logger.Print(err)
+		os.Exit(-1)
+	}
+
+	// The notifiers register their senders with the queue, so it isn't
+	// started until they're set up.
+	logger.Print("Starting the outbound notification queue")
+	go queue.Run(quitNotifications)
+	eventHandler := &PostEventHandler{
+		JEXURL:     config.JEXURL,
+		DB:         databaser,
+		Queue:      queue,
+		Notifiers:  notifiers,
+		HoldPolicy: config.HoldPolicy,
+		Streams:    NewStatusBroker(),
+		LockHolder: lockHolder("jex-events"),
+	}
+
+	quitCorrelator := make(chan int)
+	correlator, err := NewCorrelator(config, eventHandler)
+	if err != nil {
+		logger.Printf("InvocationLookupURL is invalid: %s", err)
+		os.Exit(-1)
+	}
+	if correlator != nil {
+		logger.Printf("Looking up the invocations of orphaned jobs every %s", correlator.Interval)
+		go correlator.Run(quitCorrelator)
+	}
+
 	logger.Print("Setting up HTTP")
-	SetupHTTP(config, databaser)
+	server := SetupHTTP(config, databaser, eventHandler)
 	logger.Print("Done setting up HTTP")
 
 	var deliveries <-chan amqp.Delivery
@@ -594,5 +768,75 @@ func main() {
 		}
 	}
 
-	EventHandler(deliveries, quitHandler, databaser, config.EventURL, config.JEXURL)
+	handlerDone := make(chan bool)
+	go func() {
+		BatchEventHandler(deliveries, quitHandler, databaser, eventHandler, config.EventBatchSize, time.Duration(config.EventBatchWait)*time.Millisecond)
+		close(handlerDone)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		logger.Printf("Received %s, shutting down", sig)
+	case <-handlerDone:
+		logger.Println("Stopped receiving AMQP deliveries, shutting down")
+		exitCode = -1
+	}
+	timeout := configDuration(config.ShutdownTimeout, defaultShutdownTimeout)
+	deadline := time.Now().Add(timeout)
+
+	// Stop taking AMQP deliveries, letting the one in progress finish.
+	close(quitHandler)
+	select {
+	case <-handlerDone:
+	case <-time.After(deadline.Sub(time.Now())):
+		logger.Println("Timed out waiting for the AMQP delivery in progress to be processed")
+	}
+
+	// Rebuilds can start as soon as no more events are being stored.
+	if err = databaser.ReleaseServiceLock(eventsLock, eventHandler.LockHolder); err != nil {
+		logger.Printf("Error releasing the %s lock: %s", eventsLock, err)
+	}
+
+	// End the status streams and let the HTTP requests in progress finish.
+	eventHandler.Streams.Close()
+	if err = server.Shutdown(deadline.Sub(time.Now())); err != nil {
+		logger.Printf("Error shutting down the HTTP server: %s", err)
+	}
+
+	// The notification queue stops between batches of deliveries.
+	select {
+	case quitNotifications <- 1:
+	case <-time.After(deadline.Sub(time.Now())):
+		logger.Println("Timed out waiting for the notification queue to stop")
+	}
+
+	// The archiver stops between runs.
+	if archiver != nil {
+		select {
+		case quitArchiver <- 1:
+		case <-time.After(deadline.Sub(time.Now())):
+			logger.Println("Timed out waiting for the archiver to stop")
+		}
+	}
+
+	// So does the correlator.
+	if correlator != nil {
+		select {
+		case quitCorrelator <- 1:
+		case <-time.After(deadline.Sub(time.Now())):
+			logger.Println("Timed out waiting for the correlator to stop")
+		}
+	}
+
+	if err = consumer.Close(); err != nil {
+		logger.Printf("Error closing the AMQP connection: %s", err)
+	}
+	if err = databaser.Close(); err != nil {
+		logger.Printf("Error closing the database connection: %s", err)
+	}
+	logger.Println("Done shutting down")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// JobEventRecord is a row in a job's event history: a condor_job_events
+// record along with the condor_events info for its event number and the raw
+// event text.
+type JobEventRecord struct {
+	ID               string
+	JobID            string
+	EventNumber      string
+	EventName        string
+	EventDescription string
+	Hash             string
+	DateRecorded     time.Time
+	RawEvent         string
+}
+
+// TimelineEvent is an entry in the timeline returned by the event history
+// endpoints. DateTriggered is the time from the event itself, while
+// DateRecorded is when jex-events stored it. ExitCode and the Termination*
+// fields are only meaningful for event 005 and the Hold* fields are only set
+// for event 012. RawEvent is only included when it's asked for.
+type TimelineEvent struct {
+	ID                string
+	EventNumber       string
+	EventName         string
+	EventDescription  string
+	Status            string
+	CondorID          string
+	Message           string
+	ExitCode          int
+	TerminationKind   string
+	TerminationSignal int
+	HoldReason        string
+	HoldCode          int
+	HoldSubcode       int
+	Checksum          string
+	DateTriggered     time.Time
+	DateRecorded      time.Time
+	RawEvent          string `json:",omitempty"`
+}
+
+// NewTimelineEvent re-parses the raw text of a recorded event to fill in a
+// TimelineEvent. If the event's own timestamp can't be parsed, the time it was
+// recorded is used instead.
+func NewTimelineEvent(record *JobEventRecord, includeRaw bool) TimelineEvent {
+	event := &Event{Event: record.RawEvent}
+	event.parse()
+	triggered, err := event.Timestamp(record.DateRecorded)
+	if err != nil {
+		triggered = record.DateRecorded
+	}
+	te := TimelineEvent{
+		ID:                record.ID,
+		EventNumber:       record.EventNumber,
+		EventName:         record.EventName,
+		EventDescription:  record.EventDescription,
+		Status:            EventStatus(event),
+		CondorID:          event.CondorID,
+		Message:           event.Msg,
+		ExitCode:          event.ExitCode,
+		TerminationKind:   event.TerminationKind,
+		TerminationSignal: event.TerminationSignal,
+		HoldReason:        event.HoldReason,
+		HoldCode:          event.HoldCode,
+		HoldSubcode:       event.HoldSubcode,
+		Checksum:          record.Hash,
+		DateTriggered:     triggered,
+		DateRecorded:      record.DateRecorded,
+	}
+	if includeRaw {
+		te.RawEvent = record.RawEvent
+	}
+	return te
+}
+
+// BuildTimeline turns a job's event history into a timeline ordered by when
+// the events were triggered. Events don't always arrive in order, so this can
+// differ from the order they were recorded in; events with the same timestamp
+// stay in the order they were recorded.
+func BuildTimeline(records []JobEventRecord, includeRaw bool) []TimelineEvent {
+	timeline := []TimelineEvent{}
+	for i := range records {
+		timeline = append(timeline, NewTimelineEvent(&records[i], includeRaw))
+	}
+	sort.Stable(byDateTriggered(timeline))
+	return timeline
+}
+
+// byDateTriggered sorts TimelineEvents by DateTriggered.
+type byDateTriggered []TimelineEvent
+
+func (b byDateTriggered) Len() int           { return len(b) }
+func (b byDateTriggered) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDateTriggered) Less(i, j int) bool { return b[i].DateTriggered.Before(b[j].DateTriggered) }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	recorded := time.Date(2015, 8, 11, 12, 0, 0, 0, time.UTC)
+	records := []JobEventRecord{
+		{
+			ID:           "running",
+			EventNumber:  "001",
+			EventName:    "job executing",
+			RawEvent:     "001 (3216.000.000) 08/11 10:05:00 Job executing on host: <127.0.0.1:9618>\n...\n",
+			DateRecorded: recorded,
+		},
+		{
+			ID:           "submitted",
+			EventNumber:  "000",
+			EventName:    "job submitted",
+			RawEvent:     "000 (3216.000.000) 08/11 10:00:00 Job submitted from host: <127.0.0.1:9618>\n...\n",
+			DateRecorded: recorded.Add(time.Second),
+		},
+		{
+			ID:           "unparseable",
+			EventNumber:  "006",
+			RawEvent:     "garbage",
+			DateRecorded: recorded.Add(2 * time.Second),
+		},
+	}
+	timeline := BuildTimeline(records, false)
+	if len(timeline) != 3 {
+		t.Fatalf("The timeline had %d events instead of 3", len(timeline))
+	}
+	if timeline[0].ID != "submitted" || timeline[1].ID != "running" {
+		t.Errorf("The timeline wasn't ordered by when the events were triggered: %s, %s", timeline[0].ID, timeline[1].ID)
+	}
+	if timeline[0].Status != StatusSubmitted || timeline[1].Status != StatusRunning {
+		t.Errorf("The statuses were %s and %s", timeline[0].Status, timeline[1].Status)
+	}
+	if timeline[1].CondorID != "3216" {
+		t.Errorf("The CondorID was '%s' instead of '3216'", timeline[1].CondorID)
+	}
+	if !timeline[2].DateTriggered.Equal(records[2].DateRecorded) {
+		t.Errorf("An unparseable event didn't fall back to the date it was recorded")
+	}
+	if timeline[0].RawEvent != "" {
+		t.Errorf("The raw event was included when it wasn't asked for")
+	}
+	timeline = BuildTimeline(records, true)
+	if timeline[0].RawEvent != records[1].RawEvent {
+		t.Errorf("The raw event wasn't included when it was asked for")
+	}
+	if len(BuildTimeline(nil, false)) != 0 {
+		t.Errorf("An empty history didn't produce an empty timeline")
+	}
+}
+
+func TestBuildTimelineQuiet(t *testing.T) {
+	var logged bytes.Buffer
+	saved := logger
+	logger = log.New(&logged, "", 0)
+	defer func() { logger = saved }()
+	records := []JobEventRecord{
+		{
+			ID:          "submitted",
+			EventNumber: "000",
+			RawEvent:    "000 (3216.000.000) 08/11 10:00:00 Job submitted from host: <127.0.0.1:9618>\n    IpcUuid = \"07b04ce2-7757-4b21-9e15-0b4c2f44be26\"\n...\n",
+		},
+		{
+			ID:          "terminated",
+			EventNumber: "005",
+			RawEvent:    "005 (3216.000.000) 08/11 10:10:00 Job terminated.\n\t(1) Normal termination (return value 99999999999999999999)\n...\n",
+		},
+	}
+	BuildTimeline(records, false)
+	if logged.Len() != 0 {
+		t.Errorf("Building a timeline logged '%s'", logged.String())
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+const (
+	defaultJobListLimit = 50
+	maxJobListLimit     = 500
+)
+
+// These are the columns that job listings can be sorted by.
+const (
+	SortDateSubmitted = "date_submitted"
+	SortDateCompleted = "date_completed"
+)
+
+// jobStatusExpr is the SQL expression for the current status of the job
+// aliased as 'j'. Jobs that haven't transitioned yet are Submitted.
+const jobStatusExpr = `COALESCE((
+	SELECT t.to_status
+	  FROM job_status_transitions t
+	 WHERE t.job_id = j.id
+	 ORDER BY t.date_triggered DESC, t.date_recorded DESC
+	 LIMIT 1
+), 'Submitted')`
+
+// ErrInvalidCursor is returned when a job listing cursor can't be decoded or
+// doesn't match the listing's sort order.
+var ErrInvalidCursor = errors.New("the cursor is invalid")
+
+// JobListing is a job along with its current status. sortValue is the value
+// of the column the listing was sorted by, as it came out of the database.
+type JobListing struct {
+	JobRecord
+	Status    string
+	sortValue time.Time
+}
+
+// JobListPage is a page of job listings. NextCursor is empty on the last
+// page.
+type JobListPage struct {
+	Jobs       []JobListing
+	NextCursor string
+}
+
+// JobCursor marks the position of the last job on a page of listings. Listings
+// pick up after the cursor's sort value and job ID, so pages stay stable when
+// jobs are added while someone is paging through them.
+type JobCursor struct {
+	Sort  string
+	Value time.Time
+	ID    string
+}
+
+// Encode returns the cursor as an opaque string that can be passed back in the
+// 'cursor' query parameter.
+func (c *JobCursor) Encode() string {
+	raw := fmt.Sprintf("%s|%s|%s", c.Sort, c.Value.Format(time.RFC3339Nano), c.ID)
+	return base64.URLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeJobCursor parses a cursor returned by Encode.
+func DecodeJobCursor(encoded string) (*JobCursor, error) {
+	raw, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || uuid.Parse(parts[2]) == nil {
+		return nil, ErrInvalidCursor
+	}
+	value, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &JobCursor{Sort: parts[0], Value: value, ID: parts[2]}, nil
+}
+
+// JobFilter contains the criteria for a job listing. Empty fields and zero
+// times aren't used to filter the jobs. ExitCode is only used if it's not nil.
+type JobFilter struct {
+	Submitter       string
+	AppID           string
+	Status          string
+	BatchID         string
+	SubmittedAfter  time.Time
+	SubmittedBefore time.Time
+	CompletedAfter  time.Time
+	CompletedBefore time.Time
+	ExitCode        *int
+	Sort            string
+	Descending      bool
+	Limit           int
+	Cursor          *JobCursor
+}
+
+// ParseJobFilter builds a JobFilter from the query parameters of a job listing
+// request. The supported parameters are submitter, app_id, status, batch_id,
+// submitted_after, submitted_before, completed_after, completed_before,
+// exit_code, sort (date_submitted or date_completed), order (asc or desc),
+// limit, and cursor. Dates must be formatted according to RFC3339.
+func ParseJobFilter(values url.Values) (*JobFilter, error) {
+	var err error
+	f := &JobFilter{
+		Submitter:  values.Get("submitter"),
+		AppID:      values.Get("app_id"),
+		Status:     values.Get("status"),
+		BatchID:    values.Get("batch_id"),
+		Sort:       SortDateSubmitted,
+		Descending: true,
+		Limit:      defaultJobListLimit,
+	}
+	for name, id := range map[string]string{"app_id": f.AppID, "batch_id": f.BatchID} {
+		if id != "" && uuid.Parse(id) == nil {
+			return nil, fmt.Errorf("%s must be a UUID: %s", name, id)
+		}
+	}
+	if _, ok := allowedTransitions[f.Status]; !ok {
+		return nil, fmt.Errorf("unknown status: %s", f.Status)
+	}
+	dates := map[string]*time.Time{
+		"submitted_after":  &f.SubmittedAfter,
+		"submitted_before": &f.SubmittedBefore,
+		"completed_after":  &f.CompletedAfter,
+		"completed_before": &f.CompletedBefore,
+	}
+	for name, date := range dates {
+		if v := values.Get(name); v != "" {
+			if *date, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 timestamp: %s", name, v)
+			}
+		}
+	}
+	if v := values.Get("exit_code"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("exit_code must be an integer: %s", v)
+		}
+		f.ExitCode = &code
+	}
+	if v := values.Get("sort"); v != "" {
+		if v != SortDateSubmitted && v != SortDateCompleted {
+			return nil, fmt.Errorf("sort must be %s or %s: %s", SortDateSubmitted, SortDateCompleted, v)
+		}
+		f.Sort = v
+	}
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		f.Descending = false
+	default:
+		return nil, fmt.Errorf("order must be asc or desc: %s", values.Get("order"))
+	}
+	if v := values.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > maxJobListLimit {
+			return nil, fmt.Errorf("limit must be an integer between 1 and %d: %s", maxJobListLimit, v)
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		if f.Cursor, err = DecodeJobCursor(v); err != nil {
+			return nil, err
+		}
+		if f.Cursor.Sort != f.Sort {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return f, nil
+}
+
+// Query returns the SQL for the filter along with its arguments. One more row
+// than the limit is selected so that the caller can tell whether there's
+// another page.
+func (f *JobFilter) Query() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+	if f.Submitter != "" {
+		add("j.submitter = ?", f.Submitter)
+	}
+	if f.AppID != "" {
+		add("j.app_id = cast(? as uuid)", f.AppID)
+	}
+	if f.BatchID != "" {
+		add("j.batch_id = cast(? as uuid)", f.BatchID)
+	}
+	if f.Status != "" {
+		add(jobStatusExpr+" = ?", f.Status)
+	}
+	if !f.SubmittedAfter.IsZero() {
+		add("j.date_submitted >= ?", f.SubmittedAfter)
+	}
+	if !f.SubmittedBefore.IsZero() {
+		add("j.date_submitted < ?", f.SubmittedBefore)
+	}
+	if !f.CompletedAfter.IsZero() {
+		add("j.date_completed >= ?", f.CompletedAfter)
+	}
+	if !f.CompletedBefore.IsZero() {
+		add("j.date_completed < ?", f.CompletedBefore)
+	}
+	if f.ExitCode != nil {
+		add("j.exit_code = ?", *f.ExitCode)
+	}
+	direction, comparison := "ASC", ">"
+	if f.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if f.Cursor != nil {
+		args = append(args, f.Cursor.Value, f.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(j.%s, j.id) %s ($%d, cast($%d as uuid))",
+			f.Sort, comparison, len(args)-1, len(args),
+		))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, "\n\t   AND ")
+	}
+	args = append(args, f.Limit+1)
+	query := fmt.Sprintf(`
+	SELECT cast(j.id as varchar),
+	       j.batch_id,
+	       j.submitter,
+	       j.date_submitted,
+	       j.date_started,
+	       j.date_completed,
+	       j.app_id,
+	       j.exit_code,
+	       j.failure_threshold,
+	       j.failure_count,
+	       j.condor_id,
+	       j.invocation_id,
+	       j.termination_kind,
+	       j.termination_signal,
+	       %s
+	  FROM jobs j
+	 %s
+	 ORDER BY j.%s %s, j.id %s
+	 LIMIT $%d
+	`, jobStatusExpr, where, f.Sort, direction, direction, len(args))
+	return query, args
+}
+
+// Page turns the rows returned by the filter's query into a JobListPage,
+// dropping the extra row and setting the cursor for the next page if there is
+// one.
+func (f *JobFilter) Page(listings []JobListing) *JobListPage {
+	page := &JobListPage{Jobs: listings}
+	if page.Jobs == nil {
+		page.Jobs = []JobListing{}
+	}
+	if len(page.Jobs) > f.Limit {
+		page.Jobs = page.Jobs[:f.Limit]
+		last := page.Jobs[len(page.Jobs)-1]
+		cursor := &JobCursor{Sort: f.Sort, ID: last.ID, Value: last.sortValue}
+		page.NextCursor = cursor.Encode()
+	}
+	return page
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobCursor(t *testing.T) {
+	c := &JobCursor{
+		Sort:  SortDateCompleted,
+		Value: time.Date(2015, 8, 10, 12, 30, 0, 123, time.UTC),
+		ID:    "bf6ff4a0-7bcf-11e4-b116-123b93f75cba",
+	}
+	decoded, err := DecodeJobCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Sort != c.Sort || decoded.ID != c.ID || !decoded.Value.Equal(c.Value) {
+		t.Errorf("The decoded cursor %#v doesn't match %#v", decoded, c)
+	}
+	if _, err = DecodeJobCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Errorf("Decoding garbage returned %v instead of ErrInvalidCursor", err)
+	}
+}
+
+func TestParseJobFilter(t *testing.T) {
+	values := url.Values{}
+	values.Set("submitter", "ipcdev")
+	values.Set("status", StatusFailed)
+	values.Set("submitted_after", "2015-08-10T00:00:00Z")
+	values.Set("exit_code", "1")
+	values.Set("order", "asc")
+	values.Set("limit", "10")
+	f, err := ParseJobFilter(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Submitter != "ipcdev" || f.Status != StatusFailed || f.Limit != 10 || f.Descending {
+		t.Errorf("The filter wasn't parsed correctly: %#v", f)
+	}
+	if f.ExitCode == nil || *f.ExitCode != 1 {
+		t.Errorf("The exit code wasn't parsed correctly")
+	}
+	if !f.SubmittedAfter.Equal(time.Date(2015, 8, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("SubmittedAfter was %s", f.SubmittedAfter)
+	}
+	bad := []url.Values{
+		{"status": {"Bogus"}},
+		{"app_id": {"not-a-uuid"}},
+		{"submitted_before": {"yesterday"}},
+		{"limit": {"0"}},
+		{"sort": {"submitter"}},
+		{"order": {"sideways"}},
+	}
+	for _, v := range bad {
+		if _, err = ParseJobFilter(v); err == nil {
+			t.Errorf("ParseJobFilter(%v) didn't return an error", v)
+		}
+	}
+	cursor := &JobCursor{Sort: SortDateSubmitted, ID: "bf6ff4a0-7bcf-11e4-b116-123b93f75cba"}
+	v := url.Values{"sort": {SortDateCompleted}, "cursor": {cursor.Encode()}}
+	if _, err = ParseJobFilter(v); err != ErrInvalidCursor {
+		t.Errorf("A cursor for a different sort returned %v instead of ErrInvalidCursor", err)
+	}
+}
+
+func TestJobFilterQuery(t *testing.T) {
+	f, err := ParseJobFilter(url.Values{"submitter": {"ipcdev"}, "exit_code": {"2"}, "limit": {"5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Cursor = &JobCursor{Sort: SortDateSubmitted, ID: "bf6ff4a0-7bcf-11e4-b116-123b93f75cba"}
+	query, args := f.Query()
+	if len(args) != 5 {
+		t.Errorf("The query had %d arguments instead of 5", len(args))
+	}
+	for _, expected := range []string{
+		"j.submitter = $1",
+		"j.exit_code = $2",
+		"(j.date_submitted, j.id) < ($3, cast($4 as uuid))",
+		"ORDER BY j.date_submitted DESC, j.id DESC",
+		"LIMIT $5",
+	} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("The query doesn't contain '%s':\n%s", expected, query)
+		}
+	}
+	if args[4] != 6 {
+		t.Errorf("The limit argument was %v instead of 6", args[4])
+	}
+}
+
+func TestJobFilterPage(t *testing.T) {
+	f := &JobFilter{Sort: SortDateSubmitted, Limit: 2}
+	listings := []JobListing{
+		{JobRecord: JobRecord{ID: "bf6ff4a0-7bcf-11e4-b116-123b93f75cba"}},
+		{JobRecord: JobRecord{ID: "c06ff4a0-7bcf-11e4-b116-123b93f75cba"}, sortValue: time.Unix(100, 0)},
+		{JobRecord: JobRecord{ID: "c16ff4a0-7bcf-11e4-b116-123b93f75cba"}},
+	}
+	page := f.Page(listings)
+	if len(page.Jobs) != 2 {
+		t.Errorf("The page had %d jobs instead of 2", len(page.Jobs))
+	}
+	cursor, err := DecodeJobCursor(page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.ID != listings[1].ID || !cursor.Value.Equal(time.Unix(100, 0)) {
+		t.Errorf("The next cursor didn't point at the last job on the page: %#v", cursor)
+	}
+	page = f.Page(listings[:2])
+	if page.NextCursor != "" {
+		t.Errorf("The last page had a cursor")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// These are the limits on the job columns that are stored as varchars.
+const (
+	maxCondorIDLength  = 32
+	maxSubmitterLength = 512
+)
+
+// ErrEmptyJobRequest is returned when a request to update a job doesn't have
+// any fields in it.
+var ErrEmptyJobRequest = errors.New("at least one field has to be given")
+
+// FieldError describes what's wrong with one of the fields in a request body.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError is returned when a request body doesn't match its schema.
+// Fields lists every problem that was found, not just the first one.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, f := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s %s", f.Field, f.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add records a problem with a field.
+func (e *ValidationError) add(field, msg string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(msg, args...)})
+}
+
+// JobRequest is the body of a request to add or update a job. Fields that
+// weren't in the request are nil. BatchID, AppID, and InvocationID can be set
+// to "" to clear them.
+type JobRequest struct {
+	BatchID          *string
+	CondorID         *string
+	Submitter        *string
+	DateSubmitted    *time.Time
+	DateStarted      *time.Time
+	DateCompleted    *time.Time
+	AppID            *string
+	InvocationID     *string
+	ExitCode         *int
+	FailureThreshold *int64
+	FailureCount     *int64
+}
+
+// jobRequestFields are the fields that JobRequests accept.
+var jobRequestFields = []string{
+	"BatchID",
+	"CondorID",
+	"Submitter",
+	"DateSubmitted",
+	"DateStarted",
+	"DateCompleted",
+	"AppID",
+	"InvocationID",
+	"ExitCode",
+	"FailureThreshold",
+	"FailureCount",
+}
+
+// ignoredJobRequestFields are accepted for compatibility with clients that
+// followed the old documentation, but they aren't stored anywhere.
+var ignoredJobRequestFields = []string{
+	"CommandLine",
+	"EnvVariables",
+}
+
+// canonicalField returns the name of the field that 'key' refers to, matching
+// case-insensitively like encoding/json does. An empty string is returned for
+// unknown fields.
+func canonicalField(key string, fields []string) string {
+	for _, f := range fields {
+		if strings.EqualFold(key, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// target returns a pointer to the JobRequest field with the given name, which
+// is where its value gets unmarshalled into, along with a description of what
+// the value has to be.
+func (r *JobRequest) target(field string) (interface{}, string) {
+	switch field {
+	case "BatchID":
+		r.BatchID = new(string)
+		return r.BatchID, "a string"
+	case "CondorID":
+		r.CondorID = new(string)
+		return r.CondorID, "a string"
+	case "Submitter":
+		r.Submitter = new(string)
+		return r.Submitter, "a string"
+	case "DateSubmitted":
+		r.DateSubmitted = new(time.Time)
+		return r.DateSubmitted, "an RFC3339 timestamp"
+	case "DateStarted":
+		r.DateStarted = new(time.Time)
+		return r.DateStarted, "an RFC3339 timestamp"
+	case "DateCompleted":
+		r.DateCompleted = new(time.Time)
+		return r.DateCompleted, "an RFC3339 timestamp"
+	case "AppID":
+		r.AppID = new(string)
+		return r.AppID, "a string"
+	case "InvocationID":
+		r.InvocationID = new(string)
+		return r.InvocationID, "a string"
+	case "ExitCode":
+		r.ExitCode = new(int)
+		return r.ExitCode, "an integer"
+	case "FailureThreshold":
+		r.FailureThreshold = new(int64)
+		return r.FailureThreshold, "an integer"
+	case "FailureCount":
+		r.FailureCount = new(int64)
+		return r.FailureCount, "an integer"
+	}
+	return nil, ""
+}
+
+// ParseJobRequest parses and validates the body of a request to add or update
+// a job. The body has to be a JSON object, and unknown fields aren't allowed.
+// If 'create' is true, the fields needed for a new job are required. Problems
+// with the fields are returned as a *ValidationError.
+func ParseJobRequest(body []byte, create bool) (*JobRequest, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil || raw == nil {
+		return nil, errors.New("the request body must be a JSON object")
+	}
+	r := &JobRequest{}
+	verr := &ValidationError{}
+	seen := make(map[string]bool)
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if canonicalField(key, ignoredJobRequestFields) != "" {
+			continue
+		}
+		field := canonicalField(key, jobRequestFields)
+		if field == "" {
+			verr.add(key, "isn't a known field")
+			continue
+		}
+		if seen[field] {
+			verr.add(field, "is given more than once")
+			continue
+		}
+		seen[field] = true
+		value := raw[key]
+		if string(value) == "null" {
+			verr.add(field, "can't be null")
+			continue
+		}
+		target, kind := r.target(field)
+		if err := json.Unmarshal(value, target); err != nil {
+			verr.add(field, "must be %s", kind)
+		}
+	}
+	r.validate(create, seen, verr)
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	if !create && len(seen) == 0 {
+		return nil, ErrEmptyJobRequest
+	}
+	return r, nil
+}
+
+// validate checks the values of the fields that were parsed without problems.
+// 'seen' has the fields that were in the request.
+func (r *JobRequest) validate(create bool, seen map[string]bool, verr *ValidationError) {
+	parsed := func(field string) bool {
+		for _, f := range verr.Fields {
+			if f.Field == field {
+				return false
+			}
+		}
+		return seen[field]
+	}
+	if create {
+		for _, field := range []string{"CondorID", "Submitter", "AppID"} {
+			if !seen[field] {
+				verr.add(field, "is required")
+			}
+		}
+	}
+	checkString := func(field string, value *string, max int) {
+		if !parsed(field) {
+			return
+		}
+		if *value == "" {
+			verr.add(field, "can't be empty")
+		} else if len(*value) > max {
+			verr.add(field, "can't be longer than %d characters", max)
+		}
+	}
+	checkString("CondorID", r.CondorID, maxCondorIDLength)
+	checkString("Submitter", r.Submitter, maxSubmitterLength)
+	checkUUID := func(field string, value *string, required bool) {
+		if !parsed(field) {
+			return
+		}
+		if *value == "" {
+			if required {
+				verr.add(field, "can't be empty")
+			}
+			return
+		}
+		if uuid.Parse(*value) == nil {
+			verr.add(field, "must be a UUID")
+		}
+	}
+	checkUUID("BatchID", r.BatchID, false)
+	checkUUID("AppID", r.AppID, create)
+	checkUUID("InvocationID", r.InvocationID, false)
+	if parsed("FailureThreshold") && *r.FailureThreshold < 0 {
+		verr.add("FailureThreshold", "can't be negative")
+	}
+	if parsed("FailureCount") && *r.FailureCount < 0 {
+		verr.add("FailureCount", "can't be negative")
+	}
+}
+
+// NewJobRecord returns the JobRecord for a new job made from the request.
+// DateSubmitted defaults to now; the other dates are left unset until the
+// job's events arrive.
+func (r *JobRequest) NewJobRecord(now time.Time) *JobRecord {
+	jr := &JobRecord{DateSubmitted: now}
+	r.Apply(jr)
+	return jr
+}
+
+// Apply sets the fields that are in the request on the JobRecord and leaves
+// the rest of them alone.
+func (r *JobRequest) Apply(jr *JobRecord) {
+	if r.BatchID != nil {
+		jr.BatchID = *r.BatchID
+	}
+	if r.CondorID != nil {
+		jr.CondorID = *r.CondorID
+	}
+	if r.Submitter != nil {
+		jr.Submitter = *r.Submitter
+	}
+	if r.DateSubmitted != nil {
+		jr.DateSubmitted = *r.DateSubmitted
+	}
+	if r.DateStarted != nil {
+		jr.DateStarted = *r.DateStarted
+	}
+	if r.DateCompleted != nil {
+		jr.DateCompleted = *r.DateCompleted
+	}
+	if r.AppID != nil {
+		jr.AppID = *r.AppID
+	}
+	if r.InvocationID != nil {
+		jr.InvocationID = *r.InvocationID
+	}
+	if r.ExitCode != nil {
+		jr.ExitCode = *r.ExitCode
+	}
+	if r.FailureThreshold != nil {
+		jr.FailureThreshold = *r.FailureThreshold
+	}
+	if r.FailureCount != nil {
+		jr.FailureCount = *r.FailureCount
+	}
+}
+
+// nullableUUID returns nil for an empty UUID so that it's stored as NULL.
+func nullableUUID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// UpdateQuery returns the SQL that sets the fields in the request on the job
+// with the given ID, along with its arguments. Fields that weren't in the
+// request are left alone. An empty query is returned if there's nothing to
+// update.
+func (r *JobRequest) UpdateQuery(id string) (string, []interface{}) {
+	var sets []string
+	var args []interface{}
+	add := func(set string, arg interface{}) {
+		args = append(args, arg)
+		sets = append(sets, strings.Replace(set, "?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+	if r.BatchID != nil {
+		add("batch_id = cast(? as uuid)", nullableUUID(*r.BatchID))
+	}
+	if r.CondorID != nil {
+		add("condor_id = ?", *r.CondorID)
+	}
+	if r.Submitter != nil {
+		add("submitter = ?", *r.Submitter)
+	}
+	if r.DateSubmitted != nil {
+		add("date_submitted = ?", *r.DateSubmitted)
+	}
+	if r.DateStarted != nil {
+		add("date_started = ?", *r.DateStarted)
+	}
+	if r.DateCompleted != nil {
+		add("date_completed = ?", *r.DateCompleted)
+	}
+	if r.AppID != nil {
+		add("app_id = cast(? as uuid)", nullableUUID(*r.AppID))
+	}
+	if r.InvocationID != nil {
+		add("invocation_id = cast(? as uuid)", nullableUUID(*r.InvocationID))
+	}
+	if r.ExitCode != nil {
+		add("exit_code = ?", *r.ExitCode)
+	}
+	if r.FailureThreshold != nil {
+		add("failure_threshold = ?", *r.FailureThreshold)
+	}
+	if r.FailureCount != nil {
+		add("failure_count = ?", *r.FailureCount)
+	}
+	if len(sets) == 0 {
+		return "", nil
+	}
+	args = append(args, id)
+	query := fmt.Sprintf(`
+	UPDATE jobs
+	   SET %s
+	 WHERE id = cast($%d as uuid)
+	RETURNING id
+	`, strings.Join(sets, ",\n\t       "), len(args))
+	return query, args
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fieldMessages maps the fields in a ValidationError to their messages.
+func fieldMessages(t *testing.T, err error) map[string]string {
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("%v isn't a *ValidationError", err)
+	}
+	retval := make(map[string]string)
+	for _, f := range verr.Fields {
+		retval[f.Field] = f.Message
+	}
+	return retval
+}
+
+func TestParseJobRequest(t *testing.T) {
+	body := `{
+		"Submitter"     : "test_user",
+		"AppID"         : "1a8f2a2e-4e8f-11e5-8d3a-3c15c2d0a0f4",
+		"CondorID"      : "10000",
+		"InvocationID"  : "5c1b2d1e-4e8f-11e5-8d3a-3c15c2d0a0f4",
+		"DateSubmitted" : "2015-08-11T12:00:00Z",
+		"CommandLine"   : "ignored"
+	}`
+	r, err := ParseJobRequest([]byte(body), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r.Submitter != "test_user" || *r.CondorID != "10000" {
+		t.Errorf("The request was parsed as %#v", r)
+	}
+	if r.BatchID != nil || r.ExitCode != nil {
+		t.Error("Fields that weren't in the request were set")
+	}
+	jr := r.NewJobRecord(time.Now())
+	if jr.InvocationID != "5c1b2d1e-4e8f-11e5-8d3a-3c15c2d0a0f4" {
+		t.Errorf("The InvocationID was '%s'", jr.InvocationID)
+	}
+	if !jr.DateSubmitted.Equal(time.Date(2015, 8, 11, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("DateSubmitted was %s", jr.DateSubmitted)
+	}
+	if !jr.DateStarted.IsZero() || !jr.DateCompleted.IsZero() {
+		t.Error("DateStarted or DateCompleted were set on a new job")
+	}
+
+	// Keys match case-insensitively, like encoding/json.
+	if _, err = ParseJobRequest([]byte(`{"submitter" : "test_user"}`), false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseJobRequestErrors(t *testing.T) {
+	body := `{
+		"Submitter"        : "",
+		"AppID"            : "not-a-uuid",
+		"BatchID"          : 12,
+		"FailureCount"     : -1,
+		"DateStarted"      : "yesterday",
+		"InvocationID"     : null,
+		"Bogus"            : true
+	}`
+	_, err := ParseJobRequest([]byte(body), true)
+	msgs := fieldMessages(t, err)
+	expected := map[string]string{
+		"Submitter":    "can't be empty",
+		"AppID":        "must be a UUID",
+		"BatchID":      "must be a string",
+		"FailureCount": "can't be negative",
+		"DateStarted":  "must be an RFC3339 timestamp",
+		"InvocationID": "can't be null",
+		"Bogus":        "isn't a known field",
+		"CondorID":     "is required",
+	}
+	for field, msg := range expected {
+		if msgs[field] != msg {
+			t.Errorf("The message for %s was '%s' instead of '%s'", field, msgs[field], msg)
+		}
+	}
+	if len(msgs) != len(expected) {
+		t.Errorf("There were %d field errors instead of %d: %v", len(msgs), len(expected), msgs)
+	}
+
+	msgs = fieldMessages(t, func() error {
+		_, err := ParseJobRequest([]byte(`{"CondorID" : "1", "condorid" : "2"}`), false)
+		return err
+	}())
+	if msgs["CondorID"] != "is given more than once" {
+		t.Errorf("A duplicated field got '%s'", msgs["CondorID"])
+	}
+	if _, err = ParseJobRequest([]byte(`{}`), false); err != ErrEmptyJobRequest {
+		t.Errorf("An empty update returned %v instead of ErrEmptyJobRequest", err)
+	}
+	for _, body := range []string{`[]`, `null`, `"job"`, `{`} {
+		if _, err = ParseJobRequest([]byte(body), false); err == nil {
+			t.Errorf("%s was accepted", body)
+		}
+	}
+}
+
+func TestJobRequestUpdateQuery(t *testing.T) {
+	r, err := ParseJobRequest([]byte(`{"ExitCode" : 1, "BatchID" : ""}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args := r.UpdateQuery("job")
+	if !strings.Contains(query, "batch_id = cast($1 as uuid)") || !strings.Contains(query, "exit_code = $2") {
+		t.Errorf("The query didn't set the fields in the request:\n%s", query)
+	}
+	if strings.Contains(query, "submitter") || strings.Contains(query, "invocation_id") {
+		t.Errorf("The query set fields that weren't in the request:\n%s", query)
+	}
+	if !strings.Contains(query, "WHERE id = cast($3 as uuid)") {
+		t.Errorf("The query didn't select the job:\n%s", query)
+	}
+	if len(args) != 3 || args[0] != nil || args[1] != 1 || args[2] != "job" {
+		t.Errorf("The arguments were %#v", args)
+	}
+	if query, _ = (&JobRequest{}).UpdateQuery("job"); query != "" {
+		t.Errorf("An empty request had a query:\n%s", query)
+	}
+}
+
+func TestWriteBodyError(t *testing.T) {
+	_, err := ParseJobRequest([]byte(`{"Bogus" : 1}`), false)
+	recorder := httptest.NewRecorder()
+	WriteBodyError(recorder, err)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("The status was %d instead of 400", recorder.Code)
+	}
+	var response ErrorResponse
+	if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Error.Fields) != 1 || response.Error.Fields[0].Field != "Bogus" {
+		t.Errorf("The field errors were %#v", response.Error.Fields)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// These are the statuses that a job can be in as far as the job state machine
+// is concerned. They're a superset of the statuses reported upstream, which
+// only know about Submitted, Running, Completed, and Failed.
+const (
+	StatusNone      = ""
+	StatusSubmitted = "Submitted"
+	StatusRunning   = "Running"
+	StatusHeld      = "Held"
+	StatusEvicted   = "Evicted"
+	StatusCompleted = "Completed"
+	StatusFailed    = "Failed"
+)
+
+// allowedTransitions maps a status to the statuses that a job is allowed to
+// move into from it. Completed and Failed are terminal, so nothing is allowed
+// after them.
+var allowedTransitions = map[string][]string{
+	StatusNone:      {StatusSubmitted, StatusRunning, StatusHeld, StatusEvicted, StatusCompleted, StatusFailed},
+	StatusSubmitted: {StatusRunning, StatusHeld, StatusCompleted, StatusFailed},
+	StatusRunning:   {StatusHeld, StatusEvicted, StatusCompleted, StatusFailed},
+	StatusHeld:      {StatusRunning, StatusCompleted, StatusFailed},
+	StatusEvicted:   {StatusRunning, StatusHeld, StatusCompleted, StatusFailed},
+	StatusCompleted: {},
+	StatusFailed:    {},
+}
+
+// IsTerminalStatus returns true if a job can't leave the status once it's in
+// it.
+func IsTerminalStatus(status string) bool {
+	return status == StatusCompleted || status == StatusFailed
+}
+
+// ValidTransition returns true if a job is allowed to move from the 'from'
+// status to the 'to' status.
+func ValidTransition(from, to string) bool {
+	allowed, ok := allowedTransitions[from]
+	if !ok {
+		return false
+	}
+	for _, s := range allowed {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// EventStatus returns the status that the event would put a job into. An empty
+// string is returned for events that don't affect the status of a job, like
+// image size updates.
+func EventStatus(event *Event) string {
+	status := event.Mapping().JobStatus
+	if status == StatusCompleted && event.IsFailure() {
+		return StatusFailed
+	}
+	return status
+}
+
+// TransitionError is returned when an event would move a job into a status
+// that it isn't allowed to move into.
+type TransitionError struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (t *TransitionError) Error() string {
+	return fmt.Sprintf("transition from '%s' to '%s' rejected: %s", t.From, t.To, t.Reason)
+}
+
+// JobStatusTransition records a job moving from one status to another.
+// Sequence is assigned by the database and increases with each transition
+// that gets recorded.
+type JobStatusTransition struct {
+	ID               string
+	JobID            string
+	CondorJobEventID string
+	FromStatus       string
+	ToStatus         string
+	Reason           string
+	DateTriggered    time.Time
+	DateRecorded     time.Time
+	Sequence         int64
+}
+
+// NextJobStatus figures out the transition that an event causes for a job. The
+// 'last' parameter is the last transition recorded for the job and may be nil
+// if the job doesn't have any yet. A nil transition and a nil error are
+// returned if the event doesn't change the status of the job. A
+// *TransitionError is returned if the event isn't allowed to change the status
+// of the job, either because it's older than the current status or because
+// the state machine doesn't allow the move.
+func NextJobStatus(jobID string, last *JobStatusTransition, event *Event, now time.Time) (*JobStatusTransition, error) {
+	to := EventStatus(event)
+	if to == StatusNone {
+		return nil, nil
+	}
+	triggered, err := event.Timestamp(now)
+	if err != nil {
+		logger.Printf("Error parsing the timestamp of event %s, using the current time instead: %s", event.Hash, err)
+		triggered = now
+	}
+	from := StatusNone
+	if last != nil {
+		from = last.ToStatus
+		if triggered.Before(last.DateTriggered) {
+			return nil, &TransitionError{
+				From:   from,
+				To:     to,
+				Reason: fmt.Sprintf("event time %s is older than the current status time %s", triggered, last.DateTriggered),
+			}
+		}
+	}
+	if from == to {
+		return nil, nil
+	}
+	if !ValidTransition(from, to) {
+		reason := "transition is not allowed"
+		if IsTerminalStatus(from) {
+			reason = "job is already in a terminal status"
+		}
+		return nil, &TransitionError{
+			From:   from,
+			To:     to,
+			Reason: reason,
+		}
+	}
+	return &JobStatusTransition{
+		JobID:         jobID,
+		FromStatus:    from,
+		ToStatus:      to,
+		Reason:        fmt.Sprintf("event %s: %s", event.EventNumber, event.Msg),
+		DateTriggered: triggered,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidTransition(t *testing.T) {
+	if !ValidTransition(StatusNone, StatusSubmitted) {
+		t.Error("A new job should be able to move to Submitted")
+	}
+	if !ValidTransition(StatusSubmitted, StatusRunning) {
+		t.Error("A submitted job should be able to move to Running")
+	}
+	if !ValidTransition(StatusEvicted, StatusRunning) {
+		t.Error("An evicted job should be able to move back to Running")
+	}
+	if ValidTransition(StatusRunning, StatusSubmitted) {
+		t.Error("A running job should not be able to move back to Submitted")
+	}
+	if ValidTransition(StatusCompleted, StatusRunning) {
+		t.Error("A completed job should not be able to move to Running")
+	}
+	if ValidTransition(StatusFailed, StatusCompleted) {
+		t.Error("A failed job should not be able to move to Completed")
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+	now := time.Date(2015, time.January, 2, 10, 0, 0, 0, time.UTC)
+	e := &Event{Date: "01/01", Time: "14:18:27"}
+	ts, err := e.Timestamp(now)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := time.Date(2015, time.January, 1, 14, 18, 27, 0, time.UTC)
+	if !ts.Equal(expected) {
+		t.Errorf("Timestamp was %s instead of %s", ts, expected)
+	}
+	e = &Event{Date: "12/31", Time: "23:59:59"}
+	ts, err = e.Timestamp(now)
+	if err != nil {
+		t.Error(err)
+	}
+	expected = time.Date(2014, time.December, 31, 23, 59, 59, 0, time.UTC)
+	if !ts.Equal(expected) {
+		t.Errorf("Timestamp was %s instead of %s", ts, expected)
+	}
+	e = &Event{Date: "", Time: "23:59:59"}
+	_, err = e.Timestamp(now)
+	if err == nil {
+		t.Error("Timestamp didn't return an error for an empty date")
+	}
+}
+
+func TestNextJobStatus(t *testing.T) {
+	now := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	submitted := &Event{EventNumber: "000", Date: "03/01", Time: "10:00:00"}
+	jt, err := NextJobStatus("job", nil, submitted, now)
+	if err != nil {
+		t.Error(err)
+	}
+	if jt == nil || jt.FromStatus != StatusNone || jt.ToStatus != StatusSubmitted {
+		t.Errorf("Unexpected transition for a new job: %#v", jt)
+	}
+
+	completed := &JobStatusTransition{
+		ToStatus:      StatusCompleted,
+		DateTriggered: time.Date(2015, time.March, 1, 11, 0, 0, 0, time.UTC),
+	}
+	lateRunning := &Event{EventNumber: "001", Date: "03/01", Time: "11:30:00"}
+	jt, err = NextJobStatus("job", completed, lateRunning, now)
+	if _, ok := err.(*TransitionError); !ok {
+		t.Errorf("A Running event after Completed didn't return a TransitionError: %#v", err)
+	}
+	if jt != nil {
+		t.Errorf("A rejected transition was returned: %#v", jt)
+	}
+
+	running := &JobStatusTransition{
+		ToStatus:      StatusRunning,
+		DateTriggered: time.Date(2015, time.March, 1, 11, 0, 0, 0, time.UTC),
+	}
+	staleHeld := &Event{EventNumber: "012", Date: "03/01", Time: "10:30:00"}
+	_, err = NextJobStatus("job", running, staleHeld, now)
+	if _, ok := err.(*TransitionError); !ok {
+		t.Errorf("An event older than the current status didn't return a TransitionError: %#v", err)
+	}
+
+	imageSize := &Event{EventNumber: "006", Date: "03/01", Time: "11:30:00"}
+	jt, err = NextJobStatus("job", running, imageSize, now)
+	if err != nil || jt != nil {
+		t.Errorf("An informational event caused a transition: %#v %#v", jt, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// eventsLock is the name of the lock that's held while events are stored and
+// while job state is being rebuilt, so the two never write to the same job at
+// once.
+const eventsLock = "events"
+
+// serviceLockTTL is how long a lock is held after it's acquired or renewed.
+// The event loop renews the events lock for each batch, so it's let go of
+// this long after the service stops storing events, even if it dies.
+const serviceLockTTL = 2 * time.Minute
+
+// ServiceLock is a lock that's held by one process at a time, across every
+// process using the same database. Locks expire unless they're renewed, so a
+// process that dies doesn't hold on to one.
+type ServiceLock struct {
+	Name         string
+	Holder       string
+	DateAcquired time.Time
+	DateExpires  time.Time
+}
+
+// lockHolder returns a holder name for this process that's unique across
+// hosts and readable in the logs.
+func lockHolder(role string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s on %s (pid %d)", role, host, os.Getpid())
+}
+
+// acquireLock acquires or renews the named lock for 'holder'. An error is
+// returned if another holder has it.
+func acquireLock(d JobStore, name, holder string) error {
+	l, err := d.AcquireServiceLock(&ServiceLock{Name: name, Holder: holder}, time.Now(), serviceLockTTL)
+	if err != nil {
+		return err
+	}
+	if l.Holder != holder {
+		return fmt.Errorf("the %s lock is held by %s until %s", name, l.Holder, l.DateExpires.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,1885 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// condorEventInsert matches the INSERTs in the condor_events seed data.
+var condorEventInsert = regexp.MustCompile(`VALUES \('([^']*)', '((?:[^']|'')*)', '((?:[^']|'')*)'\)`)
+
+// MemoryStore is a JobStore that keeps everything in memory. It enforces the
+// same keys and cascading deletes as the database, so it can stand in for
+// PostgreSQL when testing EventHandler and the HTTP API. Records are kept in
+// the order they were added, which is how ties are broken when sorting.
+type MemoryStore struct {
+	mutex         sync.Mutex
+	jobs          []JobRecord
+	condorEvents  []CondorEvent
+	rawEvents     []CondorRawEvent
+	jobEvents     []CondorJobEvent
+	lastEvents    []LastCondorJobEvent
+	stopRequests  []CondorJobStopRequest
+	flags         []JobFlag
+	deps          []CondorJobDep
+	transitions   []JobStatusTransition
+	notifications []OutboundNotification
+	usage         []JobResourceUsage
+	archived      map[string]time.Time
+	locks         map[string]ServiceLock
+	sequence      int64
+}
+
+var _ JobStore = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a pointer to an empty MemoryStore that has the same
+// condor_events as a new database.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{condorEvents: defaultCondorEvents()}
+}
+
+// defaultCondorEvents returns the condor_events from the jex-db seed data.
+func defaultCondorEvents() []CondorEvent {
+	var retval []CondorEvent
+	unescape := strings.NewReplacer("''", "'")
+	for _, f := range schemaFiles {
+		if f.Name != "data/01_condor_events.sql" {
+			continue
+		}
+		for _, m := range condorEventInsert.FindAllStringSubmatch(f.SQL, -1) {
+			retval = append(retval, CondorEvent{
+				ID:          newUUID(),
+				EventNumber: m[1],
+				EventName:   unescape.Replace(m[2]),
+				EventDesc:   unescape.Replace(m[3]),
+			})
+		}
+	}
+	return retval
+}
+
+// errMissing is returned when a record refers to another record that isn't in
+// the store, which would violate a foreign key in the database.
+func errMissing(kind, id string) error {
+	return fmt.Errorf("%s %s doesn't exist", kind, id)
+}
+
+// checkUUIDs returns an error if any of the values aren't empty or a UUID,
+// since they couldn't be cast to a uuid in the database.
+func checkUUIDs(values ...string) error {
+	for _, v := range values {
+		if v != "" && uuid.Parse(v) == nil {
+			return fmt.Errorf("invalid input syntax for uuid: \"%s\"", v)
+		}
+	}
+	return nil
+}
+
+// rezeroDates sets the job dates that were never set to the epoch, which is
+// how they come back out of the database.
+func rezeroDates(jr *JobRecord) {
+	epoch := time.Unix(0, 0)
+	if jr.DateSubmitted.Before(epoch) {
+		jr.DateSubmitted = epoch
+	}
+	if jr.DateStarted.Before(epoch) {
+		jr.DateStarted = epoch
+	}
+	if jr.DateCompleted.Before(epoch) {
+		jr.DateCompleted = epoch
+	}
+}
+
+// Close does nothing, since there isn't a connection to close.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) jobIndex(id string) int {
+	for i := range m.jobs {
+		if m.jobs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// getJob returns a copy of the job with the given ID. The mutex must be held.
+func (m *MemoryStore) getJob(id string) (*JobRecord, error) {
+	i := m.jobIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	jr := m.jobs[i]
+	rezeroDates(&jr)
+	return &jr, nil
+}
+
+// InsertJob adds a new JobRecord to the store. The ID field is ignored.
+func (m *MemoryStore) InsertJob(jr *JobRecord) (string, error) {
+	if err := checkUUIDs(jr.BatchID, jr.AppID, jr.InvocationID); err != nil {
+		return "", err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored := *jr
+	stored.ID = newUUID()
+	m.jobs = append(m.jobs, stored)
+	return stored.ID, nil
+}
+
+// AddJob adds a job with the given Condor ID if there isn't one already. The
+// job is returned either way.
+func (m *MemoryStore) AddJob(condorID string) (*JobRecord, error) {
+	job, err := m.GetJobByCondorID(condorID)
+	if err == sql.ErrNoRows {
+		jr := &JobRecord{
+			CondorID: condorID,
+		}
+		if jr.ID, err = m.InsertJob(jr); err != nil {
+			return nil, err
+		}
+		return jr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpsertJob updates the job with the same Condor ID if there is one, otherwise
+// it inserts a new job.
+func (m *MemoryStore) UpsertJob(jr *JobRecord) (*JobRecord, error) {
+	job, err := m.GetJobByCondorID(jr.CondorID)
+	if err == sql.ErrNoRows {
+		id, err := m.InsertJob(jr)
+		if err != nil {
+			return nil, err
+		}
+		return m.GetJob(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	jr.ID = job.ID
+	return m.UpdateJob(jr)
+}
+
+// DeleteJob removes a job along with everything that refers to it.
+func (m *MemoryStore) DeleteJob(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.jobIndex(id)
+	if i < 0 {
+		return nil
+	}
+	m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+	delete(m.archived, id)
+	var rawEvents []CondorRawEvent
+	for _, re := range m.rawEvents {
+		if re.JobID != id {
+			rawEvents = append(rawEvents, re)
+		}
+	}
+	m.rawEvents = rawEvents
+	var jobEventIDs []string
+	for _, je := range m.jobEvents {
+		if je.JobID == id {
+			jobEventIDs = append(jobEventIDs, je.ID)
+		}
+	}
+	for _, jeID := range jobEventIDs {
+		m.deleteJobEvent(jeID)
+	}
+	var stopRequests []CondorJobStopRequest
+	for _, sr := range m.stopRequests {
+		if sr.JobID != id {
+			stopRequests = append(stopRequests, sr)
+		}
+	}
+	m.stopRequests = stopRequests
+	var flags []JobFlag
+	for _, f := range m.flags {
+		if f.JobID != id {
+			flags = append(flags, f)
+		}
+	}
+	m.flags = flags
+	var deps []CondorJobDep
+	for _, dep := range m.deps {
+		if dep.SuccessorID != id && dep.PredecessorID != id {
+			deps = append(deps, dep)
+		}
+	}
+	m.deps = deps
+	var transitions []JobStatusTransition
+	for _, jt := range m.transitions {
+		if jt.JobID != id {
+			transitions = append(transitions, jt)
+		}
+	}
+	m.transitions = transitions
+	return nil
+}
+
+// GetJob returns the job with the given ID. sql.ErrNoRows is returned if it
+// doesn't exist.
+func (m *MemoryStore) GetJob(id string) (*JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getJob(id)
+}
+
+// GetJobByCondorID returns the first job with the given Condor ID.
+// sql.ErrNoRows is returned if there aren't any.
+func (m *MemoryStore) GetJobByCondorID(condorID string) (*JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, jr := range m.jobs {
+		if jr.CondorID == condorID {
+			return m.getJob(jr.ID)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetJobByInvocationID returns the first job with the given invocation ID, or
+// nil if there aren't any.
+func (m *MemoryStore) GetJobByInvocationID(invocationID string) (*JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, jr := range m.jobs {
+		if jr.InvocationID == invocationID {
+			return m.getJob(jr.ID)
+		}
+	}
+	return nil, nil
+}
+
+// PatchJob sets the fields that are in the request on the job with the given
+// ID and leaves the rest of them alone. sql.ErrNoRows is returned if the job
+// doesn't exist.
+func (m *MemoryStore) PatchJob(id string, r *JobRequest) (*JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.jobIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	patched := m.jobs[i]
+	r.Apply(&patched)
+	if err := checkUUIDs(patched.BatchID, patched.AppID, patched.InvocationID); err != nil {
+		return nil, err
+	}
+	m.jobs[i] = patched
+	return m.getJob(id)
+}
+
+// UpdateJob replaces the job that has the same ID as jr. sql.ErrNoRows is
+// returned if it doesn't exist.
+func (m *MemoryStore) UpdateJob(jr *JobRecord) (*JobRecord, error) {
+	if err := checkUUIDs(jr.BatchID, jr.AppID, jr.InvocationID); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.jobIndex(jr.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	m.jobs[i] = *jr
+	return m.getJob(jr.ID)
+}
+
+// jobStatus returns the current status of a job, like jobStatusExpr does. The
+// mutex must be held.
+func (m *MemoryStore) jobStatus(id string) string {
+	if jt := m.lastTransition(id); jt != nil {
+		return jt.ToStatus
+	}
+	return StatusSubmitted
+}
+
+// ListJobs returns the jobs that match the filter along with their current
+// statuses. Like JobFilter.Query, one more job than the limit is returned.
+func (m *MemoryStore) ListJobs(f *JobFilter) ([]JobListing, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []JobListing
+	for _, jr := range m.jobs {
+		jl := JobListing{JobRecord: jr, Status: m.jobStatus(jr.ID), sortValue: jr.DateSubmitted}
+		if f.Sort == SortDateCompleted {
+			jl.sortValue = jr.DateCompleted
+		}
+		switch {
+		case f.Submitter != "" && jr.Submitter != f.Submitter,
+			f.AppID != "" && jr.AppID != f.AppID,
+			f.BatchID != "" && jr.BatchID != f.BatchID,
+			f.Status != "" && jl.Status != f.Status,
+			!f.SubmittedAfter.IsZero() && jr.DateSubmitted.Before(f.SubmittedAfter),
+			!f.SubmittedBefore.IsZero() && !jr.DateSubmitted.Before(f.SubmittedBefore),
+			!f.CompletedAfter.IsZero() && jr.DateCompleted.Before(f.CompletedAfter),
+			!f.CompletedBefore.IsZero() && !jr.DateCompleted.Before(f.CompletedBefore),
+			f.ExitCode != nil && jr.ExitCode != *f.ExitCode:
+			continue
+		}
+		if f.Cursor != nil {
+			after := jl.sortValue.After(f.Cursor.Value) ||
+				jl.sortValue.Equal(f.Cursor.Value) && jr.ID > f.Cursor.ID
+			before := jl.sortValue.Before(f.Cursor.Value) ||
+				jl.sortValue.Equal(f.Cursor.Value) && jr.ID < f.Cursor.ID
+			if f.Descending && !before || !f.Descending && !after {
+				continue
+			}
+		}
+		rezeroDates(&jl.JobRecord)
+		retval = append(retval, jl)
+	}
+	sort.Sort(jobListingSorter{retval, f.Descending})
+	if len(retval) > f.Limit+1 {
+		retval = retval[:f.Limit+1]
+	}
+	return retval, nil
+}
+
+// jobListingSorter sorts job listings by their sort values and then their
+// IDs, which is the order JobFilter.Query uses.
+type jobListingSorter struct {
+	listings   []JobListing
+	descending bool
+}
+
+func (s jobListingSorter) Len() int      { return len(s.listings) }
+func (s jobListingSorter) Swap(i, j int) { s.listings[i], s.listings[j] = s.listings[j], s.listings[i] }
+func (s jobListingSorter) Less(i, j int) bool {
+	a, b := s.listings[i], s.listings[j]
+	if s.descending {
+		a, b = b, a
+	}
+	if !a.sortValue.Equal(b.sortValue) {
+		return a.sortValue.Before(b.sortValue)
+	}
+	return a.ID < b.ID
+}
+
+// GetBatchJobs returns the jobs in the batch, ordered by their submission
+// dates.
+func (m *MemoryStore) GetBatchJobs(batchID string) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []JobRecord
+	for _, jr := range m.jobs {
+		if jr.BatchID == batchID {
+			retval = append(retval, jr)
+		}
+	}
+	sort.Stable(jobsBySubmission(retval))
+	for i := range retval {
+		rezeroDates(&retval[i])
+	}
+	return retval, nil
+}
+
+// jobsBySubmission sorts jobs by the dates they were submitted.
+type jobsBySubmission []JobRecord
+
+func (s jobsBySubmission) Len() int           { return len(s) }
+func (s jobsBySubmission) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s jobsBySubmission) Less(i, j int) bool { return s[i].DateSubmitted.Before(s[j].DateSubmitted) }
+
+func (m *MemoryStore) condorEventIndex(id string) int {
+	for i := range m.condorEvents {
+		if m.condorEvents[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertCondorEvent adds a new CondorEvent to the store. The ID field is
+// ignored.
+func (m *MemoryStore) InsertCondorEvent(ce *CondorEvent) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored := *ce
+	stored.ID = newUUID()
+	m.condorEvents = append(m.condorEvents, stored)
+	return stored.ID, nil
+}
+
+// DeleteCondorEvent removes a CondorEvent along with the job events that refer
+// to it.
+func (m *MemoryStore) DeleteCondorEvent(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.condorEventIndex(id)
+	if i < 0 {
+		return nil
+	}
+	m.condorEvents = append(m.condorEvents[:i], m.condorEvents[i+1:]...)
+	var jobEventIDs []string
+	for _, je := range m.jobEvents {
+		if je.CondorEventID == id {
+			jobEventIDs = append(jobEventIDs, je.ID)
+		}
+	}
+	for _, jeID := range jobEventIDs {
+		m.deleteJobEvent(jeID)
+	}
+	return nil
+}
+
+// GetCondorEvent returns the CondorEvent with the given ID.
+func (m *MemoryStore) GetCondorEvent(id string) (*CondorEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.condorEventIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	ce := m.condorEvents[i]
+	return &ce, nil
+}
+
+// GetCondorEventByNumber returns the CondorEvent with the given event number.
+func (m *MemoryStore) GetCondorEventByNumber(number string) (*CondorEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, ce := range m.condorEvents {
+		if ce.EventNumber == number {
+			return &ce, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateCondorEvent replaces the CondorEvent that has the same ID as ce.
+func (m *MemoryStore) UpdateCondorEvent(ce *CondorEvent) (*CondorEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.condorEventIndex(ce.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	m.condorEvents[i] = *ce
+	updated := *ce
+	return &updated, nil
+}
+
+// GetCondorEventMappings returns nothing, since the store doesn't keep any
+// mapping overrides. The built-in mappings are used instead.
+func (m *MemoryStore) GetCondorEventMappings() ([]EventMapping, error) {
+	return nil, nil
+}
+
+func (m *MemoryStore) rawEventIndex(id string) int {
+	for i := range m.rawEvents {
+		if m.rawEvents[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertCondorRawEvent adds an unparsed event to the store.
+func (m *MemoryStore) InsertCondorRawEvent(re *CondorRawEvent) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobIndex(re.JobID) < 0 {
+		return "", errMissing("job", re.JobID)
+	}
+	stored := *re
+	stored.ID = newUUID()
+	m.rawEvents = append(m.rawEvents, stored)
+	return stored.ID, nil
+}
+
+// AddCondorRawEvent adds a raw event that was triggered now.
+func (m *MemoryStore) AddCondorRawEvent(eventText string, jobID string) (string, error) {
+	return m.InsertCondorRawEvent(&CondorRawEvent{
+		JobID:         jobID,
+		EventText:     eventText,
+		DateTriggered: time.Now(),
+	})
+}
+
+// DeleteCondorRawEvent removes an unparsed event along with the job events
+// that refer to it.
+func (m *MemoryStore) DeleteCondorRawEvent(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.rawEventIndex(id)
+	if i < 0 {
+		return nil
+	}
+	m.rawEvents = append(m.rawEvents[:i], m.rawEvents[i+1:]...)
+	var jobEventIDs []string
+	for _, je := range m.jobEvents {
+		if je.CondorRawEventID == id {
+			jobEventIDs = append(jobEventIDs, je.ID)
+		}
+	}
+	for _, jeID := range jobEventIDs {
+		m.deleteJobEvent(jeID)
+	}
+	return nil
+}
+
+// GetCondorRawEvent returns the unparsed event with the given ID.
+func (m *MemoryStore) GetCondorRawEvent(id string) (*CondorRawEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.rawEventIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	re := m.rawEvents[i]
+	return &re, nil
+}
+
+// UpdateCondorRawEvent replaces the unparsed event that has the same ID as re.
+func (m *MemoryStore) UpdateCondorRawEvent(re *CondorRawEvent) (*CondorRawEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.rawEventIndex(re.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	if m.jobIndex(re.JobID) < 0 {
+		return nil, errMissing("job", re.JobID)
+	}
+	m.rawEvents[i] = *re
+	updated := *re
+	return &updated, nil
+}
+
+func (m *MemoryStore) jobEventIndex(id string) int {
+	for i := range m.jobEvents {
+		if m.jobEvents[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkJobEvent returns an error if the job event refers to records that
+// aren't in the store. The mutex must be held.
+func (m *MemoryStore) checkJobEvent(je *CondorJobEvent) error {
+	if m.jobIndex(je.JobID) < 0 {
+		return errMissing("job", je.JobID)
+	}
+	if m.condorEventIndex(je.CondorEventID) < 0 {
+		return errMissing("condor event", je.CondorEventID)
+	}
+	if m.rawEventIndex(je.CondorRawEventID) < 0 {
+		return errMissing("raw event", je.CondorRawEventID)
+	}
+	return nil
+}
+
+// InsertCondorJobEvent adds a parsed job event to the store.
+func (m *MemoryStore) InsertCondorJobEvent(je *CondorJobEvent) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkJobEvent(je); err != nil {
+		return "", err
+	}
+	stored := *je
+	stored.ID = newUUID()
+	m.jobEvents = append(m.jobEvents, stored)
+	return stored.ID, nil
+}
+
+// DoesCondorJobEventExist returns true if an event with a matching checksum
+// is already in the store.
+func (m *MemoryStore) DoesCondorJobEventExist(checksum string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, je := range m.jobEvents {
+		if je.Hash == checksum {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FindCondorJobEventChecksums returns the checksums that are already in the
+// store out of the ones that are passed in.
+func (m *MemoryStore) FindCondorJobEventChecksums(checksums []string) (map[string]bool, error) {
+	wanted := make(map[string]bool)
+	for _, checksum := range checksums {
+		wanted[checksum] = true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	found := make(map[string]bool)
+	for _, je := range m.jobEvents {
+		if wanted[je.Hash] {
+			found[je.Hash] = true
+		}
+	}
+	return found, nil
+}
+
+// CountCondorJobEventsByNumber returns the number of events with the given
+// event number that have been recorded for a job.
+func (m *MemoryStore) CountCondorJobEventsByNumber(jobID, eventNumber string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	count := 0
+	for _, je := range m.jobEvents {
+		if je.JobID != jobID {
+			continue
+		}
+		if i := m.condorEventIndex(je.CondorEventID); i >= 0 && m.condorEvents[i].EventNumber == eventNumber {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetJobEventHistory returns all of the events recorded for a job, along with
+// their names, descriptions, and raw text, in the order they were recorded.
+func (m *MemoryStore) GetJobEventHistory(jobID string) ([]JobEventRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []JobEventRecord
+	for _, je := range m.jobEvents {
+		if je.JobID != jobID {
+			continue
+		}
+		ce := m.condorEvents[m.condorEventIndex(je.CondorEventID)]
+		re := m.rawEvents[m.rawEventIndex(je.CondorRawEventID)]
+		retval = append(retval, JobEventRecord{
+			ID:               je.ID,
+			JobID:            je.JobID,
+			EventNumber:      ce.EventNumber,
+			EventName:        ce.EventName,
+			EventDescription: ce.EventDesc,
+			Hash:             je.Hash,
+			DateRecorded:     je.DateTriggered,
+			RawEvent:         re.EventText,
+		})
+	}
+	sort.Stable(jobEventsByDate(retval))
+	return retval, nil
+}
+
+// jobEventsByDate sorts job events by the dates they were recorded.
+type jobEventsByDate []JobEventRecord
+
+func (s jobEventsByDate) Len() int           { return len(s) }
+func (s jobEventsByDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s jobEventsByDate) Less(i, j int) bool { return s[i].DateRecorded.Before(s[j].DateRecorded) }
+
+// AddCondorJobEvent adds a job event that was triggered now.
+func (m *MemoryStore) AddCondorJobEvent(jobID string, eventID string, rawEventID string, hash string) (string, error) {
+	return m.InsertCondorJobEvent(&CondorJobEvent{
+		JobID:            jobID,
+		CondorEventID:    eventID,
+		CondorRawEventID: rawEventID,
+		DateTriggered:    time.Now(),
+		Hash:             hash,
+	})
+}
+
+// deleteJobEvent removes a job event, along with the last events and status
+// transitions that refer to it, and unlinks the stop requests it confirmed.
+// The mutex must be held.
+func (m *MemoryStore) deleteJobEvent(id string) {
+	i := m.jobEventIndex(id)
+	if i < 0 {
+		return
+	}
+	m.jobEvents = append(m.jobEvents[:i], m.jobEvents[i+1:]...)
+	var lastEvents []LastCondorJobEvent
+	for _, le := range m.lastEvents {
+		if le.CondorJobEventID != id {
+			lastEvents = append(lastEvents, le)
+		}
+	}
+	m.lastEvents = lastEvents
+	var transitions []JobStatusTransition
+	for _, jt := range m.transitions {
+		if jt.CondorJobEventID != id {
+			transitions = append(transitions, jt)
+		}
+	}
+	m.transitions = transitions
+	var usage []JobResourceUsage
+	for _, u := range m.usage {
+		if u.CondorJobEventID != id {
+			usage = append(usage, u)
+		}
+	}
+	m.usage = usage
+	for i := range m.stopRequests {
+		if m.stopRequests[i].CondorJobEventID == id {
+			m.stopRequests[i].CondorJobEventID = ""
+		}
+	}
+}
+
+// DeleteCondorJobEvent removes a parsed job event from the store.
+func (m *MemoryStore) DeleteCondorJobEvent(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.deleteJobEvent(id)
+	return nil
+}
+
+// GetCondorJobEvent returns the parsed job event with the given ID. Like the
+// database version, the Hash isn't filled in.
+func (m *MemoryStore) GetCondorJobEvent(id string) (*CondorJobEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.jobEventIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	je := m.jobEvents[i]
+	je.Hash = ""
+	return &je, nil
+}
+
+// UpdateCondorJobEvent updates the job, condor event, raw event, and date of a
+// parsed job event. The checksum is left alone.
+func (m *MemoryStore) UpdateCondorJobEvent(je *CondorJobEvent) (*CondorJobEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.jobEventIndex(je.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	if err := m.checkJobEvent(je); err != nil {
+		return nil, err
+	}
+	stored := &m.jobEvents[i]
+	stored.JobID = je.JobID
+	stored.CondorEventID = je.CondorEventID
+	stored.CondorRawEventID = je.CondorRawEventID
+	stored.DateTriggered = je.DateTriggered
+	updated := *stored
+	updated.Hash = ""
+	return &updated, nil
+}
+
+func (m *MemoryStore) lastEventIndex(jobID string) int {
+	for i := range m.lastEvents {
+		if m.lastEvents[i].JobID == jobID {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertLastCondorJobEvent adds an entry that points to the last event for a
+// job. Each job can only have one.
+func (m *MemoryStore) InsertLastCondorJobEvent(je *LastCondorJobEvent) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lastEventIndex(je.JobID) >= 0 {
+		return "", fmt.Errorf("job %s already has a last event", je.JobID)
+	}
+	if m.jobIndex(je.JobID) < 0 {
+		return "", errMissing("job", je.JobID)
+	}
+	if m.jobEventIndex(je.CondorJobEventID) < 0 {
+		return "", errMissing("job event", je.CondorJobEventID)
+	}
+	m.lastEvents = append(m.lastEvents, *je)
+	return je.JobID, nil
+}
+
+// DeleteLastCondorJobEvent removes the entry for job that points to the last
+// event.
+func (m *MemoryStore) DeleteLastCondorJobEvent(jobID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.lastEventIndex(jobID); i >= 0 {
+		m.lastEvents = append(m.lastEvents[:i], m.lastEvents[i+1:]...)
+	}
+	return nil
+}
+
+// GetLastCondorJobEvent returns a record that tells what the last event for a
+// job was.
+func (m *MemoryStore) GetLastCondorJobEvent(jobID string) (*LastCondorJobEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.lastEventIndex(jobID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	le := m.lastEvents[i]
+	return &le, nil
+}
+
+// UpdateLastCondorJobEvent modifies the record that tells what the last event
+// for a job was.
+func (m *MemoryStore) UpdateLastCondorJobEvent(je *LastCondorJobEvent) (*LastCondorJobEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.lastEventIndex(je.JobID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	if m.jobEventIndex(je.CondorJobEventID) < 0 {
+		return nil, errMissing("job event", je.CondorJobEventID)
+	}
+	m.lastEvents[i] = *je
+	updated := *je
+	return &updated, nil
+}
+
+// UpsertLastCondorJobEvent updates the last CondorJobEvent for a job if it's
+// already set, but will insert it if it isn't already set.
+func (m *MemoryStore) UpsertLastCondorJobEvent(jobEventID, jobID string) (string, error) {
+	le := &LastCondorJobEvent{
+		JobID:            jobID,
+		CondorJobEventID: jobEventID,
+	}
+	if _, err := m.GetLastCondorJobEvent(jobID); err == sql.ErrNoRows {
+		return m.InsertLastCondorJobEvent(le)
+	}
+	updated, err := m.UpdateLastCondorJobEvent(le)
+	if err != nil {
+		return "", err
+	}
+	return updated.JobID, nil
+}
+
+func (m *MemoryStore) stopRequestIndex(id string) int {
+	for i := range m.stopRequests {
+		if m.stopRequests[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertCondorJobStopRequest adds a record of a job stop request. The ID,
+// DateConfirmed, and CondorJobEventID fields are ignored.
+func (m *MemoryStore) InsertCondorJobStopRequest(jr *CondorJobStopRequest) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobIndex(jr.JobID) < 0 {
+		return "", errMissing("job", jr.JobID)
+	}
+	stored := *jr
+	stored.ID = newUUID()
+	stored.DateConfirmed = time.Time{}
+	stored.CondorJobEventID = ""
+	m.stopRequests = append(m.stopRequests, stored)
+	return stored.ID, nil
+}
+
+// DeleteCondorJobStopRequest deletes the record of a job stop request.
+func (m *MemoryStore) DeleteCondorJobStopRequest(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.stopRequestIndex(id); i >= 0 {
+		m.stopRequests = append(m.stopRequests[:i], m.stopRequests[i+1:]...)
+	}
+	return nil
+}
+
+// GetCondorJobStopRequest returns the record of a job stop request.
+func (m *MemoryStore) GetCondorJobStopRequest(id string) (*CondorJobStopRequest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.stopRequestIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	sr := m.stopRequests[i]
+	return &sr, nil
+}
+
+// GetLastCondorJobStopRequest returns the most recent stop request for a job.
+// sql.ErrNoRows is returned if nobody has asked for the job to be stopped.
+func (m *MemoryStore) GetLastCondorJobStopRequest(jobID string) (*CondorJobStopRequest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var last *CondorJobStopRequest
+	for i := range m.stopRequests {
+		sr := &m.stopRequests[i]
+		if sr.JobID == jobID && (last == nil || sr.DateRequested.After(last.DateRequested)) {
+			last = sr
+		}
+	}
+	if last == nil {
+		return nil, sql.ErrNoRows
+	}
+	retval := *last
+	return &retval, nil
+}
+
+// ConfirmCondorJobStopRequests marks all of the unconfirmed stop requests for a
+// job as confirmed by the job event passed in. The number of stop requests that
+// were confirmed is returned.
+func (m *MemoryStore) ConfirmCondorJobStopRequests(jobID, jobEventID string, confirmed time.Time) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobEventIndex(jobEventID) < 0 {
+		return 0, errMissing("job event", jobEventID)
+	}
+	var count int64
+	for i := range m.stopRequests {
+		sr := &m.stopRequests[i]
+		if sr.JobID == jobID && sr.DateConfirmed.IsZero() {
+			sr.DateConfirmed = confirmed
+			sr.CondorJobEventID = jobEventID
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpdateCondorJobStopRequest updates the record of a job stop request.
+func (m *MemoryStore) UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*CondorJobStopRequest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.stopRequestIndex(jr.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	if m.jobIndex(jr.JobID) < 0 {
+		return nil, errMissing("job", jr.JobID)
+	}
+	if jr.CondorJobEventID != "" && m.jobEventIndex(jr.CondorJobEventID) < 0 {
+		return nil, errMissing("job event", jr.CondorJobEventID)
+	}
+	m.stopRequests[i] = *jr
+	updated := *jr
+	return &updated, nil
+}
+
+// HasCondorJobStopRequest returns true if a request to stop the job has
+// already been recorded.
+func (m *MemoryStore) HasCondorJobStopRequest(jobID string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, sr := range m.stopRequests {
+		if sr.JobID == jobID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetJobFlag sets the flag on the job. The returned bool is false if the flag
+// was already set.
+func (m *MemoryStore) SetJobFlag(jobID, flag string, set time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobIndex(jobID) < 0 {
+		return false, errMissing("job", jobID)
+	}
+	for _, f := range m.flags {
+		if f.JobID == jobID && f.Flag == flag {
+			return false, nil
+		}
+	}
+	m.flags = append(m.flags, JobFlag{JobID: jobID, Flag: flag, DateSet: set})
+	return true, nil
+}
+
+// HasJobFlag returns true if the flag is set on the job.
+func (m *MemoryStore) HasJobFlag(jobID, flag string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, f := range m.flags {
+		if f.JobID == jobID && f.Flag == flag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InsertCondorJobDep adds a job dependency to the store. Like the database,
+// which uses successor_id as the primary key of condor_job_deps, a job can
+// only have one predecessor.
+func (m *MemoryStore) InsertCondorJobDep(jd *CondorJobDep) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.insertCondorJobDep(jd)
+}
+
+// AddCondorJobDep adds a job dependency to the store unless it's already
+// there, returning ErrDependencyCycle if it would create a cycle. The mutex is
+// held throughout, so dependencies are added one at a time.
+func (m *MemoryStore) AddCondorJobDep(jd *CondorJobDep) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, dep := range m.deps {
+		if dep.PredecessorID == jd.PredecessorID && dep.SuccessorID == jd.SuccessorID {
+			return nil
+		}
+	}
+	descendants, err := m.getJobs(m.walkJobs(jd.SuccessorID, false))
+	if err != nil {
+		return err
+	}
+	if CreatesCycle(jd, descendants) {
+		return ErrDependencyCycle
+	}
+	return m.insertCondorJobDep(jd)
+}
+
+// insertCondorJobDep adds a job dependency to the store. The mutex must be
+// held.
+func (m *MemoryStore) insertCondorJobDep(jd *CondorJobDep) error {
+	for _, id := range []string{jd.SuccessorID, jd.PredecessorID} {
+		if m.jobIndex(id) < 0 {
+			return errMissing("job", id)
+		}
+	}
+	for _, dep := range m.deps {
+		if dep.SuccessorID == jd.SuccessorID {
+			return fmt.Errorf("job %s already has a predecessor", jd.SuccessorID)
+		}
+	}
+	m.deps = append(m.deps, *jd)
+	return nil
+}
+
+// relatedJobs returns the jobs that are next to the job in the dependency
+// graph. The predecessors are returned if 'up' is true, otherwise the
+// successors are. The mutex must be held.
+func (m *MemoryStore) relatedJobs(id string, up bool) []string {
+	var retval []string
+	for _, dep := range m.deps {
+		if up && dep.SuccessorID == id {
+			retval = append(retval, dep.PredecessorID)
+		} else if !up && dep.PredecessorID == id {
+			retval = append(retval, dep.SuccessorID)
+		}
+	}
+	return retval
+}
+
+// walkJobs returns the jobs that are reachable from the job by following the
+// dependencies in one direction. The mutex must be held.
+func (m *MemoryStore) walkJobs(id string, up bool) []string {
+	var retval []string
+	seen := map[string]bool{}
+	queue := m.relatedJobs(id, up)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		retval = append(retval, next)
+		queue = append(queue, m.relatedJobs(next, up)...)
+	}
+	return retval
+}
+
+// getJobs returns copies of the jobs with the given IDs. The mutex must be
+// held.
+func (m *MemoryStore) getJobs(ids []string) ([]JobRecord, error) {
+	var retval []JobRecord
+	for _, id := range ids {
+		jr, err := m.getJob(id)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, *jr)
+	}
+	return retval, nil
+}
+
+// GetPredecessors returns the jobs that the job directly depends on.
+func (m *MemoryStore) GetPredecessors(successor string) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getJobs(m.relatedJobs(successor, true))
+}
+
+// GetSuccessors returns the jobs that directly depend on the job.
+func (m *MemoryStore) GetSuccessors(predecessor string) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getJobs(m.relatedJobs(predecessor, false))
+}
+
+// DeleteCondorJobDep removes a job dependency from the store.
+func (m *MemoryStore) DeleteCondorJobDep(predUUID, succUUID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, dep := range m.deps {
+		if dep.PredecessorID == predUUID && dep.SuccessorID == succUUID {
+			m.deps = append(m.deps[:i], m.deps[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// HasCondorJobDep returns true if the successor already depends on the
+// predecessor.
+func (m *MemoryStore) HasCondorJobDep(predUUID, succUUID string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, dep := range m.deps {
+		if dep.PredecessorID == predUUID && dep.SuccessorID == succUUID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetAncestors returns all of the jobs that the job depends on, either
+// directly or through other jobs.
+func (m *MemoryStore) GetAncestors(successor string) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getJobs(m.walkJobs(successor, true))
+}
+
+// GetDescendants returns all of the jobs that depend on the job, either
+// directly or through other jobs.
+func (m *MemoryStore) GetDescendants(predecessor string) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getJobs(m.walkJobs(predecessor, false))
+}
+
+// InsertJobStatusTransition adds a record of a job moving from one status to
+// another. The ID, DateRecorded, and Sequence fields are ignored, although
+// Sequence is set to the next value in the store's sequence.
+func (m *MemoryStore) InsertJobStatusTransition(jt *JobStatusTransition) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobIndex(jt.JobID) < 0 {
+		return "", errMissing("job", jt.JobID)
+	}
+	if m.jobEventIndex(jt.CondorJobEventID) < 0 {
+		return "", errMissing("job event", jt.CondorJobEventID)
+	}
+	m.sequence++
+	stored := *jt
+	stored.ID = newUUID()
+	stored.DateRecorded = time.Now()
+	stored.Sequence = m.sequence
+	m.transitions = append(m.transitions, stored)
+	jt.Sequence = stored.Sequence
+	return stored.ID, nil
+}
+
+func (m *MemoryStore) transitionIndex(id string) int {
+	for i := range m.transitions {
+		if m.transitions[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// DeleteJobStatusTransition removes the record of a job status transition.
+func (m *MemoryStore) DeleteJobStatusTransition(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.transitionIndex(id); i >= 0 {
+		m.transitions = append(m.transitions[:i], m.transitions[i+1:]...)
+	}
+	return nil
+}
+
+// transitionsInOrder returns the status transitions for a job, ordered by the
+// dates they were triggered and then recorded. The mutex must be held.
+func (m *MemoryStore) transitionsInOrder(jobID string) []JobStatusTransition {
+	var retval []JobStatusTransition
+	for _, jt := range m.transitions {
+		if jt.JobID == jobID {
+			retval = append(retval, jt)
+		}
+	}
+	sort.Stable(transitionsByDate(retval))
+	return retval
+}
+
+// lastTransition returns the most recent status transition for a job, or nil
+// if it doesn't have any. The mutex must be held.
+func (m *MemoryStore) lastTransition(jobID string) *JobStatusTransition {
+	transitions := m.transitionsInOrder(jobID)
+	if len(transitions) == 0 {
+		return nil
+	}
+	return &transitions[len(transitions)-1]
+}
+
+// transitionsByDate sorts transitions by the dates they were triggered and
+// then recorded.
+type transitionsByDate []JobStatusTransition
+
+func (s transitionsByDate) Len() int      { return len(s) }
+func (s transitionsByDate) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s transitionsByDate) Less(i, j int) bool {
+	if !s[i].DateTriggered.Equal(s[j].DateTriggered) {
+		return s[i].DateTriggered.Before(s[j].DateTriggered)
+	}
+	return s[i].DateRecorded.Before(s[j].DateRecorded)
+}
+
+// GetLastJobStatusTransition returns the most recent status transition for a
+// job. sql.ErrNoRows is returned if the job hasn't transitioned yet.
+func (m *MemoryStore) GetLastJobStatusTransition(jobID string) (*JobStatusTransition, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jt := m.lastTransition(jobID)
+	if jt == nil {
+		return nil, sql.ErrNoRows
+	}
+	return jt, nil
+}
+
+// GetJobStatusTransitions returns all of the status transitions for a job in
+// the order they were triggered.
+func (m *MemoryStore) GetJobStatusTransitions(jobID string) ([]JobStatusTransition, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.transitionsInOrder(jobID), nil
+}
+
+// GetStreamEvents returns up to 'limit' of the status transitions that come
+// after the 'after' sequence number for the jobs that pass the filter, in the
+// order they were recorded.
+func (m *MemoryStore) GetStreamEvents(f *StreamFilter, after int64, limit int) ([]StreamEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []StreamEvent
+	for _, jt := range m.transitions {
+		if len(retval) >= limit {
+			break
+		}
+		if jt.Sequence <= after {
+			continue
+		}
+		i := m.jobIndex(jt.JobID)
+		if i < 0 {
+			continue
+		}
+		jr := m.jobs[i]
+		switch {
+		case f.JobID != "" && jr.ID != f.JobID,
+			f.InvocationID != "" && jr.InvocationID != f.InvocationID,
+			f.BatchID != "" && jr.BatchID != f.BatchID,
+			f.Submitter != "" && jr.Submitter != f.Submitter:
+			continue
+		}
+		retval = append(retval, StreamEvent{
+			ID:            jt.Sequence,
+			JobID:         jr.ID,
+			InvocationID:  jr.InvocationID,
+			BatchID:       jr.BatchID,
+			Submitter:     jr.Submitter,
+			CondorID:      jr.CondorID,
+			AppID:         jr.AppID,
+			FromStatus:    jt.FromStatus,
+			ToStatus:      jt.ToStatus,
+			Reason:        jt.Reason,
+			DateTriggered: jt.DateTriggered,
+		})
+	}
+	return retval, nil
+}
+
+func (m *MemoryStore) notificationIndex(id string) int {
+	for i := range m.notifications {
+		if m.notifications[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertOutboundNotification adds a notification to the outbound queue. The ID,
+// DateCreated, and DateDelivered fields are ignored. Idempotency keys must be
+// unique.
+func (m *MemoryStore) InsertOutboundNotification(n *OutboundNotification) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, existing := range m.notifications {
+		if existing.IdempotencyKey == n.IdempotencyKey {
+			return "", fmt.Errorf("a notification with the idempotency key %s already exists", n.IdempotencyKey)
+		}
+	}
+	stored := *n
+	stored.ID = newUUID()
+	stored.DateCreated = time.Now()
+	stored.DateDelivered = time.Time{}
+	m.notifications = append(m.notifications, stored)
+	return stored.ID, nil
+}
+
+// GetOutboundNotification returns a notification from the outbound queue.
+func (m *MemoryStore) GetOutboundNotification(id string) (*OutboundNotification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.notificationIndex(id)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	n := m.notifications[i]
+	return &n, nil
+}
+
+// GetOutboundNotificationByKey returns the notification with the given
+// idempotency key. sql.ErrNoRows is returned if it hasn't been queued.
+func (m *MemoryStore) GetOutboundNotificationByKey(key string) (*OutboundNotification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, n := range m.notifications {
+		if n.IdempotencyKey == key {
+			return &n, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetDueOutboundNotifications returns up to 'limit' pending notifications
+// whose next attempt is at or before 'now', oldest first. Notifications that
+// are waiting on an earlier pending notification for the same job and URL
+// aren't due.
+func (m *MemoryStore) GetDueOutboundNotifications(now time.Time, limit int) ([]OutboundNotification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []OutboundNotification
+	waiting := make(map[string]bool)
+	for _, n := range m.notifications {
+		if len(retval) >= limit {
+			break
+		}
+		if n.Status != NotificationPending {
+			continue
+		}
+		key := n.InvocationID + " " + n.URL
+		if n.InvocationID != "" && waiting[key] {
+			continue
+		}
+		waiting[key] = true
+		if !n.NextAttempt.After(now) {
+			retval = append(retval, n)
+		}
+	}
+	return retval, nil
+}
+
+// GetDeadOutboundNotifications returns the notifications that ran out of
+// delivery attempts, most recent first.
+func (m *MemoryStore) GetDeadOutboundNotifications() ([]OutboundNotification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []OutboundNotification
+	for i := len(m.notifications) - 1; i >= 0; i-- {
+		if m.notifications[i].Status == NotificationDead {
+			retval = append(retval, m.notifications[i])
+		}
+	}
+	return retval, nil
+}
+
+// UpdateOutboundNotification records the outcome of a delivery attempt.
+func (m *MemoryStore) UpdateOutboundNotification(n *OutboundNotification) (*OutboundNotification, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.notificationIndex(n.ID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	stored := &m.notifications[i]
+	stored.Status = n.Status
+	stored.Attempts = n.Attempts
+	stored.LastError = n.LastError
+	stored.NextAttempt = n.NextAttempt
+	stored.DateDelivered = n.DateDelivered
+	updated := *stored
+	return &updated, nil
+}
+
+// DeleteOutboundNotification removes a notification from the outbound queue.
+func (m *MemoryStore) DeleteOutboundNotification(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := m.notificationIndex(id); i >= 0 {
+		m.notifications = append(m.notifications[:i], m.notifications[i+1:]...)
+	}
+	return nil
+}
+
+// usageIndex returns the index of the job's resource usage, or -1 if it
+// doesn't have any.
+func (m *MemoryStore) usageIndex(jobID string) int {
+	for i := range m.usage {
+		if m.usage[i].JobID == jobID {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetJobResourceUsage returns the resource usage of a job. sql.ErrNoRows is
+// returned if none has been recorded.
+func (m *MemoryStore) GetJobResourceUsage(jobID string) (*JobResourceUsage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.usageIndex(jobID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	u := m.usage[i]
+	return &u, nil
+}
+
+// SumJobResourceUsage adds up the resource usage of the jobs that match the
+// filter.
+func (m *MemoryStore) SumJobResourceUsage(f *UsageFilter) (*UsageTotals, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	totals := &UsageTotals{}
+	for i := range m.usage {
+		u := &m.usage[i]
+		jr := m.jobs[m.jobIndex(u.JobID)]
+		switch {
+		case f.Submitter != "" && jr.Submitter != f.Submitter,
+			f.AppID != "" && jr.AppID != f.AppID,
+			!f.From.IsZero() && u.DateTriggered.Before(f.From),
+			!f.To.IsZero() && !u.DateTriggered.Before(f.To):
+			continue
+		}
+		totals.Add(u)
+	}
+	return totals, nil
+}
+
+// GetOrphanedJobs returns the jobs that don't have an invocation ID but have
+// received an event since 'since', in the order they were submitted.
+func (m *MemoryStore) GetOrphanedJobs(since time.Time) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	recent := make(map[string]bool)
+	for _, re := range m.rawEvents {
+		if !re.DateTriggered.Before(since) {
+			recent[re.JobID] = true
+		}
+	}
+	var retval []JobRecord
+	for _, jr := range m.jobs {
+		if jr.InvocationID == "" && recent[jr.ID] {
+			rezeroDates(&jr)
+			retval = append(retval, jr)
+		}
+	}
+	sort.Stable(jobsBySubmission(retval))
+	return retval, nil
+}
+
+// GetExpiredJobs returns up to 'limit' of the jobs that moved into a terminal
+// status before 'before' and haven't been archived, in the order they were
+// submitted.
+func (m *MemoryStore) GetExpiredJobs(before time.Time, limit int) ([]JobRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var retval []JobRecord
+	for _, jr := range m.jobs {
+		if _, ok := m.archived[jr.ID]; ok {
+			continue
+		}
+		jt := m.lastTransition(jr.ID)
+		if jt != nil && IsTerminalStatus(jt.ToStatus) && jt.DateTriggered.Before(before) {
+			rezeroDates(&jr)
+			retval = append(retval, jr)
+		}
+	}
+	sort.Stable(jobsBySubmission(retval))
+	if len(retval) > limit {
+		retval = retval[:limit]
+	}
+	return retval, nil
+}
+
+// GetJobArchive returns everything that's stored for a job.
+func (m *MemoryStore) GetJobArchive(jobID string) (*JobArchive, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, err := m.getJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	ja := &JobArchive{Job: *job, DateArchived: m.archived[jobID]}
+	for _, re := range m.rawEvents {
+		if re.JobID == jobID {
+			ja.RawEvents = append(ja.RawEvents, re)
+		}
+	}
+	for _, je := range m.jobEvents {
+		if je.JobID == jobID {
+			ce := m.condorEvents[m.condorEventIndex(je.CondorEventID)]
+			ja.JobEvents = append(ja.JobEvents, ArchivedJobEvent{CondorJobEvent: je, EventNumber: ce.EventNumber})
+		}
+	}
+	if i := m.lastEventIndex(jobID); i >= 0 {
+		ja.LastEventID = m.lastEvents[i].CondorJobEventID
+	}
+	for _, sr := range m.stopRequests {
+		if sr.JobID == jobID {
+			ja.StopRequests = append(ja.StopRequests, sr)
+		}
+	}
+	ja.Transitions = m.transitionsInOrder(jobID)
+	for _, dep := range m.deps {
+		if dep.SuccessorID == jobID || dep.PredecessorID == jobID {
+			ja.Dependencies = append(ja.Dependencies, dep)
+		}
+	}
+	if i := m.usageIndex(jobID); i >= 0 {
+		u := m.usage[i]
+		ja.Usage = &u
+	}
+	return ja, nil
+}
+
+// RestoreJobArchive puts an archived job back into the store with the IDs it
+// had before. If the job is still in the store, only the raw events, job
+// events, and transitions that were pruned are put back, and the job can be
+// archived again. ErrArchiveRestored is returned if there's nothing to put
+// back. Dependencies are only restored if the other job is in the store too.
+func (m *MemoryStore) RestoreJobArchive(ja *JobArchive) error {
+	if err := checkUUIDs(ja.Job.BatchID, ja.Job.AppID, ja.Job.InvocationID); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	exists := m.jobIndex(ja.Job.ID) >= 0
+
+	// Look everything up before changing anything so that a bad archive
+	// doesn't leave part of a job behind.
+	var rawEvents []CondorRawEvent
+	for _, re := range ja.RawEvents {
+		if m.rawEventIndex(re.ID) < 0 {
+			rawEvents = append(rawEvents, re)
+		}
+	}
+	var jobEvents []CondorJobEvent
+	for _, je := range ja.JobEvents {
+		if m.jobEventIndex(je.ID) >= 0 {
+			continue
+		}
+		found := false
+		for _, ce := range m.condorEvents {
+			if ce.EventNumber == je.EventNumber {
+				je.CondorEventID = ce.ID
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errMissing("condor event", je.EventNumber)
+		}
+		jobEvents = append(jobEvents, je.CondorJobEvent)
+	}
+	var transitions []JobStatusTransition
+	for _, jt := range ja.Transitions {
+		if m.transitionIndex(jt.ID) < 0 {
+			transitions = append(transitions, jt)
+		}
+	}
+	if exists && len(rawEvents)+len(jobEvents)+len(transitions) == 0 {
+		return ErrArchiveRestored
+	}
+
+	if !exists {
+		m.jobs = append(m.jobs, ja.Job)
+	}
+	delete(m.archived, ja.Job.ID)
+	m.rawEvents = append(m.rawEvents, rawEvents...)
+	m.jobEvents = append(m.jobEvents, jobEvents...)
+	for _, jt := range transitions {
+		m.transitions = append(m.transitions, jt)
+		if jt.Sequence > m.sequence {
+			m.sequence = jt.Sequence
+		}
+	}
+	if exists {
+		return nil
+	}
+	if ja.LastEventID != "" {
+		m.lastEvents = append(m.lastEvents, LastCondorJobEvent{JobID: ja.Job.ID, CondorJobEventID: ja.LastEventID})
+	}
+	m.stopRequests = append(m.stopRequests, ja.StopRequests...)
+	if ja.Usage != nil {
+		m.usage = append(m.usage, *ja.Usage)
+	}
+	for _, dep := range ja.Dependencies {
+		if m.jobIndex(dep.SuccessorID) < 0 || m.jobIndex(dep.PredecessorID) < 0 || len(m.relatedJobs(dep.SuccessorID, true)) > 0 {
+			continue
+		}
+		m.deps = append(m.deps, dep)
+	}
+	return nil
+}
+
+// PruneJobHistory deletes the job's raw events and transitions with the given
+// IDs, along with everything that refers to the raw events, and records that
+// the job was archived at 'archived'.
+func (m *MemoryStore) PruneJobHistory(jobID string, rawEventIDs, transitionIDs []string, archived time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.jobIndex(jobID) < 0 {
+		return sql.ErrNoRows
+	}
+	pruned := make(map[string]bool)
+	for _, id := range append(rawEventIDs, transitionIDs...) {
+		pruned[id] = true
+	}
+	var transitions []JobStatusTransition
+	for _, jt := range m.transitions {
+		if jt.JobID != jobID || !pruned[jt.ID] {
+			transitions = append(transitions, jt)
+		}
+	}
+	m.transitions = transitions
+	var rawEvents []CondorRawEvent
+	for _, re := range m.rawEvents {
+		if re.JobID != jobID || !pruned[re.ID] {
+			rawEvents = append(rawEvents, re)
+		}
+	}
+	m.rawEvents = rawEvents
+	var jobEventIDs []string
+	for _, je := range m.jobEvents {
+		if je.JobID == jobID && pruned[je.CondorRawEventID] {
+			jobEventIDs = append(jobEventIDs, je.ID)
+		}
+	}
+	for _, jeID := range jobEventIDs {
+		m.deleteJobEvent(jeID)
+	}
+	if m.archived == nil {
+		m.archived = make(map[string]time.Time)
+	}
+	m.archived[jobID] = archived
+	return nil
+}
+
+// WriteEventBatch stores everything in the batch. Nothing is changed unless
+// the whole batch can be stored, so the references are all checked first;
+// the rows in the batch can refer to each other.
+func (m *MemoryStore) WriteEventBatch(b *EventBatch) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.writeEventBatch(b)
+}
+
+// writeEventBatch does the work of WriteEventBatch. The mutex must be held.
+func (m *MemoryStore) writeEventBatch(b *EventBatch) error {
+	for _, jr := range append(b.NewJobs, b.Jobs...) {
+		if err := checkUUIDs(jr.BatchID, jr.AppID, jr.InvocationID); err != nil {
+			return err
+		}
+	}
+	newJobs := make(map[string]bool)
+	for _, jr := range b.NewJobs {
+		if newJobs[jr.ID] || m.jobIndex(jr.ID) >= 0 {
+			return fmt.Errorf("job %s already exists", jr.ID)
+		}
+		newJobs[jr.ID] = true
+	}
+	hasJob := func(id string) bool {
+		return newJobs[id] || m.jobIndex(id) >= 0
+	}
+	var jobIndexes []int
+	for _, jr := range b.Jobs {
+		i := m.jobIndex(jr.ID)
+		if i < 0 {
+			return sql.ErrNoRows
+		}
+		jobIndexes = append(jobIndexes, i)
+	}
+	rawEvents := make(map[string]bool)
+	for _, re := range b.RawEvents {
+		if !hasJob(re.JobID) {
+			return errMissing("job", re.JobID)
+		}
+		rawEvents[re.ID] = true
+	}
+	jobEvents := make(map[string]bool)
+	for _, je := range b.JobEvents {
+		if !hasJob(je.JobID) {
+			return errMissing("job", je.JobID)
+		}
+		if m.condorEventIndex(je.CondorEventID) < 0 {
+			return errMissing("condor event", je.CondorEventID)
+		}
+		if !rawEvents[je.CondorRawEventID] && m.rawEventIndex(je.CondorRawEventID) < 0 {
+			return errMissing("raw event", je.CondorRawEventID)
+		}
+		jobEvents[je.ID] = true
+	}
+	for _, le := range b.LastEvents {
+		if !hasJob(le.JobID) {
+			return errMissing("job", le.JobID)
+		}
+		if !jobEvents[le.CondorJobEventID] && m.jobEventIndex(le.CondorJobEventID) < 0 {
+			return errMissing("job event", le.CondorJobEventID)
+		}
+	}
+	for _, jt := range b.Transitions {
+		if !hasJob(jt.JobID) {
+			return errMissing("job", jt.JobID)
+		}
+		if !jobEvents[jt.CondorJobEventID] && m.jobEventIndex(jt.CondorJobEventID) < 0 {
+			return errMissing("job event", jt.CondorJobEventID)
+		}
+	}
+	for _, u := range b.Usage {
+		if !hasJob(u.JobID) {
+			return errMissing("job", u.JobID)
+		}
+		if !jobEvents[u.CondorJobEventID] && m.jobEventIndex(u.CondorJobEventID) < 0 {
+			return errMissing("job event", u.CondorJobEventID)
+		}
+	}
+
+	for _, jr := range b.NewJobs {
+		m.jobs = append(m.jobs, *jr)
+	}
+	for n, i := range jobIndexes {
+		jr, stored := b.Jobs[n], &m.jobs[i]
+		stored.ExitCode = jr.ExitCode
+		stored.FailureCount = jr.FailureCount
+		stored.TerminationKind = jr.TerminationKind
+		stored.TerminationSignal = jr.TerminationSignal
+		if stored.InvocationID == "" {
+			stored.InvocationID = jr.InvocationID
+		}
+	}
+	m.rawEvents = append(m.rawEvents, b.RawEvents...)
+	m.jobEvents = append(m.jobEvents, b.JobEvents...)
+	for _, le := range b.LastEvents {
+		if i := m.lastEventIndex(le.JobID); i >= 0 {
+			m.lastEvents[i] = le
+		} else {
+			m.lastEvents = append(m.lastEvents, le)
+		}
+	}
+	for _, u := range b.Usage {
+		if i := m.usageIndex(u.JobID); i >= 0 {
+			m.usage[i] = u
+		} else {
+			m.usage = append(m.usage, u)
+		}
+	}
+	for _, jt := range b.Transitions {
+		m.sequence++
+		jt.ID = newUUID()
+		jt.Sequence = m.sequence
+		m.transitions = append(m.transitions, *jt)
+	}
+	return nil
+}
+
+// WriteJobRebuild stores the rebuilt state of a job. The job's transitions are
+// only replaced if they changed, so that they keep their sequence numbers
+// otherwise. The same goes for its resource usage. Nothing is changed if any of
+// it can't be stored.
+func (m *MemoryStore) WriteJobRebuild(r *JobRebuild) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var jobEventIndexes []int
+	for _, je := range r.JobEvents {
+		i := m.jobEventIndex(je.ID)
+		if i < 0 {
+			return errMissing("job event", je.ID)
+		}
+		if m.condorEventIndex(je.CondorEventID) < 0 {
+			return errMissing("condor event", je.CondorEventID)
+		}
+		jobEventIndexes = append(jobEventIndexes, i)
+	}
+
+	// The old last event, transitions, and usage are left out of new slices,
+	// so that the old ones can be put back if the batch can't be written.
+	transitions, lastEvents, usage := m.transitions, m.lastEvents, m.usage
+	batch := &EventBatch{Jobs: []*JobRecord{&r.Job}}
+	m.lastEvents = nil
+	for _, le := range lastEvents {
+		if le.JobID != r.Job.ID {
+			m.lastEvents = append(m.lastEvents, le)
+		}
+	}
+	if r.LastEventID != "" {
+		batch.LastEvents = []LastCondorJobEvent{{JobID: r.Job.ID, CondorJobEventID: r.LastEventID}}
+	}
+	if r.TransitionsChanged() {
+		m.transitions = nil
+		for _, jt := range transitions {
+			if jt.JobID != r.Job.ID {
+				m.transitions = append(m.transitions, jt)
+			}
+		}
+		batch.Transitions = r.Transitions
+	}
+	if !r.Before.Usage.Equal(r.Usage) {
+		m.usage = nil
+		for _, u := range usage {
+			if u.JobID != r.Job.ID {
+				m.usage = append(m.usage, u)
+			}
+		}
+		if r.Usage != nil {
+			batch.Usage = []JobResourceUsage{*r.Usage}
+		}
+	}
+	if err := m.writeEventBatch(batch); err != nil {
+		m.transitions, m.lastEvents, m.usage = transitions, lastEvents, usage
+		return err
+	}
+	for n, i := range jobEventIndexes {
+		m.jobEvents[i].CondorEventID = r.JobEvents[n].CondorEventID
+	}
+	return nil
+}
+
+// AcquireServiceLock gives the lock to l.Holder until 'ttl' after 'now' if it's
+// free, it's expired, or l.Holder already has it. The lock as it's stored
+// afterwards is returned, so the lock wasn't acquired if its Holder is
+// somebody else.
+func (m *MemoryStore) AcquireServiceLock(l *ServiceLock, now time.Time, ttl time.Duration) (*ServiceLock, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.locks == nil {
+		m.locks = make(map[string]ServiceLock)
+	}
+	current, ok := m.locks[l.Name]
+	switch {
+	case ok && current.Holder == l.Holder:
+		current.DateExpires = now.Add(ttl)
+	case !ok || !current.DateExpires.After(now):
+		current = ServiceLock{Name: l.Name, Holder: l.Holder, DateAcquired: now, DateExpires: now.Add(ttl)}
+	}
+	m.locks[l.Name] = current
+	return &current, nil
+}
+
+// ReleaseServiceLock lets go of the lock if 'holder' has it.
+func (m *MemoryStore) ReleaseServiceLock(name, holder string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if l, ok := m.locks[name]; ok && l.Holder == holder {
+		delete(m.locks, name)
+	}
+	return nil
+}
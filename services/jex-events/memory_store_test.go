@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestMemoryStoreCondorEvents(t *testing.T) {
+	testStoreCondorEvents(t, NewMemoryStore())
+}
+
+func TestMemoryStoreJobs(t *testing.T) {
+	testStoreJobs(t, NewMemoryStore())
+}
+
+func TestMemoryStoreListJobs(t *testing.T) {
+	testStoreListJobs(t, NewMemoryStore())
+}
+
+func TestMemoryStoreDependencies(t *testing.T) {
+	testStoreDependencies(t, NewMemoryStore())
+}
+
+func TestMemoryStoreConcurrentDependencies(t *testing.T) {
+	testStoreConcurrentDependencies(t, NewMemoryStore())
+}
+
+func TestMemoryStoreOutboundNotifications(t *testing.T) {
+	testStoreOutboundNotifications(t, NewMemoryStore())
+}
+
+// TestMemoryStoreEndToEnd runs a job through the HTTP API and EventHandler
+// without a database.
+func TestMemoryStoreEndToEnd(t *testing.T) {
+	testStoreEndToEnd(t, NewMemoryStore(), 1)
+}
+
+func TestMemoryStoreBatchedEndToEnd(t *testing.T) {
+	testStoreEndToEnd(t, NewMemoryStore(), 10)
+}
+
+func TestMemoryStoreEventBatches(t *testing.T) {
+	m := NewMemoryStore()
+	id, err := m.InsertJob(&JobRecord{CondorID: "100", Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStoreEventBatches(t, m, id)
+}
+
+func TestMemoryStoreOrphanedJobs(t *testing.T) {
+	testStoreOrphanedJobs(t, NewMemoryStore(), 100)
+}
+
+func TestMemoryStoreRebuild(t *testing.T) {
+	testStoreRebuild(t, NewMemoryStore(), 100)
+}
+
+func TestMemoryStoreTermination(t *testing.T) {
+	testStoreTermination(t, NewMemoryStore(), 100)
+}
+
+func TestMemoryStoreResourceUsage(t *testing.T) {
+	testStoreResourceUsage(t, NewMemoryStore(), 100)
+}
+
+func TestMemoryStoreArchives(t *testing.T) {
+	testStoreArchives(t, NewMemoryStore())
+}
+
+func TestMemoryStorePruneJobHistory(t *testing.T) {
+	testStorePruneJobHistory(t, NewMemoryStore(), "100")
+}
+
+func TestMemoryStoreServiceLocks(t *testing.T) {
+	testStoreServiceLocks(t, NewMemoryStore(), eventsLock)
+}
+
+func TestMemoryStoreJobFlags(t *testing.T) {
+	testStoreJobFlags(t, NewMemoryStore(), "100")
+}
@@ -0,0 +1,313 @@
+package main
+
+//go:generate go run gen_schema.go
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// migrationLockID is the key of the advisory lock that's held while the
+// database is migrated, so that jex-events instances that start at the same
+// time don't step on each other.
+const migrationLockID = 0x6a65786462 // "jexdb"
+
+// ErrDatabaseTooNew is returned when the database has been migrated past the
+// version that this build of jex-events knows about.
+var ErrDatabaseTooNew = errors.New("the database is newer than this version of jex-events understands")
+
+// SchemaFile is one of the SQL files from jex-db that set up a new database.
+type SchemaFile struct {
+	Name string
+	SQL  string
+}
+
+// Migration is one of the conversions from jex-db. It takes the database from
+// the version before it to Version.
+type Migration struct {
+	Version    string
+	Statements []string
+}
+
+// dbVersion is a parsed database version, like "2.0.0:20150811.01".
+type dbVersion struct {
+	release []int
+	stamp   string
+}
+
+// parseDBVersion parses a database version, which is a release number and a
+// date stamp separated by a colon.
+func parseDBVersion(v string) (*dbVersion, error) {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("'%s' isn't a database version", v)
+	}
+	parsed := &dbVersion{stamp: parts[1]}
+	for _, n := range strings.Split(parts[0], ".") {
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' isn't a database version", v)
+		}
+		parsed.release = append(parsed.release, i)
+	}
+	return parsed, nil
+}
+
+// CompareDBVersions returns -1, 0, or 1 if a is older than, the same as, or
+// newer than b.
+func CompareDBVersions(a, b string) (int, error) {
+	va, err := parseDBVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseDBVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(va.release) || i < len(vb.release); i++ {
+		var ra, rb int
+		if i < len(va.release) {
+			ra = va.release[i]
+		}
+		if i < len(vb.release) {
+			rb = vb.release[i]
+		}
+		if ra != rb {
+			if ra < rb {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	switch {
+	case va.stamp < vb.stamp:
+		return -1, nil
+	case va.stamp > vb.stamp:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// LatestDBVersion returns the newest of the versions, or "" if there aren't
+// any.
+func LatestDBVersion(versions []Version) (string, error) {
+	latest := ""
+	for _, v := range versions {
+		if latest == "" {
+			latest = v.Version
+			continue
+		}
+		c, err := CompareDBVersions(v.Version, latest)
+		if err != nil {
+			return "", err
+		}
+		if c > 0 {
+			latest = v.Version
+		}
+	}
+	return latest, nil
+}
+
+// PendingMigrations returns the migrations that a database at version
+// 'current' needs, in order. ErrDatabaseTooNew is returned if the database is
+// newer than schemaVersion.
+func PendingMigrations(current string) ([]Migration, error) {
+	c, err := CompareDBVersions(current, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	if c > 0 {
+		return nil, ErrDatabaseTooNew
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		c, err = CompareDBVersions(m.Version, current)
+		if err != nil {
+			return nil, err
+		}
+		if c > 0 {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// queryer is the part of *sql.DB and *sql.Tx that's needed to look up the
+// database version.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// hasTable returns true if the table exists in the current schema.
+func hasTable(q queryer, table string) (bool, error) {
+	var count int
+	err := q.QueryRow(`
+	SELECT count(*)
+	  FROM information_schema.tables
+	 WHERE table_schema = current_schema()
+	   AND table_name = $1
+	`, table).Scan(&count)
+	return count > 0, err
+}
+
+// getVersions returns all of the versions that have been applied to the
+// database.
+func getVersions(q queryer) ([]Version, error) {
+	rows, err := q.Query(`SELECT id, version, applied FROM version ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []Version
+	for rows.Next() {
+		var v Version
+		if err = rows.Scan(&v.ID, &v.Version, &v.Applied); err != nil {
+			return nil, err
+		}
+		retval = append(retval, v)
+	}
+	return retval, rows.Err()
+}
+
+// currentDBVersion returns the version of the database, or "" if it hasn't
+// been set up yet. Databases that have tables but no version table weren't set
+// up by jex-db, so they're left alone.
+func currentDBVersion(q queryer) (string, error) {
+	versioned, err := hasTable(q, "version")
+	if err != nil {
+		return "", err
+	}
+	if !versioned {
+		hasJobs, err := hasTable(q, "jobs")
+		if err != nil {
+			return "", err
+		}
+		if hasJobs {
+			return "", errors.New("the database has a jobs table but no version table")
+		}
+		return "", nil
+	}
+	versions, err := getVersions(q)
+	if err != nil {
+		return "", err
+	}
+	return LatestDBVersion(versions)
+}
+
+// GetVersions returns all of the versions that have been applied to the
+// database, oldest first.
+func (d *Databaser) GetVersions() ([]Version, error) {
+	return getVersions(d.db)
+}
+
+// CheckVersion returns an error unless the database is at schemaVersion. It's
+// used instead of Migrate when migrations are turned off.
+func (d *Databaser) CheckVersion() error {
+	current, err := currentDBVersion(d.db)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return errors.New("the database hasn't been set up; run 'jex-events migrate'")
+	}
+	pending, err := PendingMigrations(current)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the database is at version %s and needs to be migrated to %s; run 'jex-events migrate'", current, schemaVersion)
+	}
+	return nil
+}
+
+// Migrate brings the database up to schemaVersion. Empty databases get the
+// whole schema, and databases at an older version get the migrations they
+// need. Everything happens in one transaction while holding an advisory lock,
+// so a failed migration leaves the database as it was and concurrent calls
+// wait for each other. The versions that were applied are returned.
+// ErrDatabaseTooNew is returned if the database is newer than schemaVersion.
+func (d *Databaser) Migrate() ([]string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return nil, err
+	}
+	current, err := currentDBVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []string
+	if current == "" {
+		logger.Printf("Setting up the database at version %s", schemaVersion)
+		for _, f := range schemaFiles {
+			if _, err = tx.Exec(f.SQL); err != nil {
+				return nil, fmt.Errorf("error loading %s: %s", f.Name, err)
+			}
+		}
+		applied = append(applied, schemaVersion)
+	} else {
+		pending, err := PendingMigrations(current)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range pending {
+			logger.Printf("Migrating the database to version %s", m.Version)
+			for _, stmt := range m.Statements {
+				if _, err = tx.Exec(stmt); err != nil {
+					return nil, fmt.Errorf("error migrating to %s: %s", m.Version, err)
+				}
+			}
+			if _, err = tx.Exec("INSERT INTO version (version) VALUES ($1)", m.Version); err != nil {
+				return nil, err
+			}
+			applied = append(applied, m.Version)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// MigrateCommand implements the migrate command, which brings the database up
+// to date without starting anything else. The exit code is returned.
+func MigrateCommand(config *Configuration) int {
+	if config.DBURI == "" {
+		logger.Println("DBURI must be set in the configuration file.")
+		return -1
+	}
+	if IsSQLiteURI(config.DBURI) {
+		s, err := NewSQLiteStore(strings.TrimPrefix(config.DBURI, sqliteScheme))
+		if err != nil {
+			logger.Print(err)
+			return -1
+		}
+		s.Close()
+		logger.Printf("The SQLite database in %s is up to date", s.Path)
+		return 0
+	}
+	d, err := NewDatabaser(config.DBURI)
+	if err != nil {
+		logger.Print(err)
+		return -1
+	}
+	defer d.Close()
+	applied, err := d.Migrate()
+	if err != nil {
+		logger.Print(err)
+		return -1
+	}
+	if len(applied) == 0 {
+		logger.Printf("The database is already at version %s", schemaVersion)
+	} else {
+		logger.Printf("Migrated the database to version %s", applied[len(applied)-1])
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareDBVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.0.0:20150811.01", "2.0.0:20150811.01", 0},
+		{"2.0.0:20150810.01", "2.0.0:20150811.01", -1},
+		{"2.0.0:20150811.02", "2.0.0:20150811.01", 1},
+		{"1.9.3:20151231.01", "2.0.0:20150101.01", -1},
+		{"2.10.0:20150101.01", "2.9.0:20150101.01", 1},
+		{"2.0:20150101.01", "2.0.0:20150101.01", 0},
+	}
+	for _, test := range tests {
+		c, err := CompareDBVersions(test.a, test.b)
+		if err != nil {
+			t.Error(err)
+		}
+		if c != test.expected {
+			t.Errorf("CompareDBVersions(%s, %s) returned %d instead of %d", test.a, test.b, c, test.expected)
+		}
+	}
+	for _, v := range []string{"", "2.0.0", "2.0.0:", "two:20150101.01"} {
+		if _, err := CompareDBVersions(v, schemaVersion); err == nil {
+			t.Errorf("'%s' was accepted as a version", v)
+		}
+	}
+}
+
+func TestLatestDBVersion(t *testing.T) {
+	latest, err := LatestDBVersion([]Version{
+		{Version: "2.0.0:20150803.01"},
+		{Version: "2.0.0:20150811.01"},
+		{Version: "1.9.3:20141114.01"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if latest != "2.0.0:20150811.01" {
+		t.Errorf("The latest version was %s", latest)
+	}
+	if latest, _ = LatestDBVersion(nil); latest != "" {
+		t.Errorf("The latest of no versions was %s", latest)
+	}
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	if len(migrations) == 0 {
+		t.Fatal("There aren't any migrations")
+	}
+	for i := 1; i < len(migrations); i++ {
+		c, err := CompareDBVersions(migrations[i-1].Version, migrations[i].Version)
+		if err != nil {
+			t.Error(err)
+		}
+		if c >= 0 {
+			t.Errorf("Migration %s comes after %s", migrations[i].Version, migrations[i-1].Version)
+		}
+		if len(migrations[i].Statements) == 0 {
+			t.Errorf("Migration %s doesn't have any statements", migrations[i].Version)
+		}
+	}
+	if last := migrations[len(migrations)-1].Version; last != schemaVersion {
+		t.Errorf("The last migration is %s but the schema is at %s", last, schemaVersion)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	pending, err := PendingMigrations(schemaVersion)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("An up to date database had %d pending migrations", len(pending))
+	}
+	previous := migrations[len(migrations)-2].Version
+	pending, err = PendingMigrations(previous)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 1 || pending[0].Version != schemaVersion {
+		t.Errorf("A database at %s had the pending migrations %#v", previous, pending)
+	}
+	if _, err = PendingMigrations("99.0.0:20990101.01"); err != ErrDatabaseTooNew {
+		t.Errorf("A newer database returned %v instead of ErrDatabaseTooNew", err)
+	}
+}
+
+// TestSchemaIsGenerated makes sure that schema.go was regenerated after the
+// last change to jex-db.
+func TestSchemaIsGenerated(t *testing.T) {
+	src := filepath.Join("..", "..", "databases", "jex-db", "src", "main")
+	if _, err := os.Stat(src); err != nil {
+		t.Skip("jex-db isn't available")
+	}
+	for _, f := range schemaFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(src, filepath.FromSlash(f.Name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(contents) != f.SQL {
+			t.Errorf("%s has changed; run 'go generate'", f.Name)
+		}
+	}
+	conversions, err := filepath.Glob(filepath.Join(src, "conversions", "*.clj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversions) != len(migrations) {
+		t.Errorf("jex-db has %d conversions but there are %d migrations; run 'go generate'", len(conversions), len(migrations))
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// These are the states that an outbound notification can be in. Pending
+// notifications are retried until they're either delivered or they run out of
+// attempts, at which point they're marked as dead and left in the database for
+// someone to look at.
+const (
+	NotificationPending   = "pending"
+	NotificationDelivered = "delivered"
+	NotificationDead      = "dead"
+)
+
+const (
+	defaultNotificationTimeout     = 10 * time.Second
+	defaultNotificationMaxAttempts = 10
+	defaultNotificationBackoff     = 5 * time.Second
+	maxNotificationBackoff         = time.Hour
+	notificationPollInterval       = 5 * time.Second
+	notificationBatchSize          = 100
+
+	// statusTooManyRequests is the HTTP status for rate limited requests.
+	// net/http doesn't define it before Go 1.6.
+	statusTooManyRequests = 429
+)
+
+// OutboundNotification is a message waiting to be POSTed to an upstream
+// service, along with the record of the attempts to deliver it. Notifications
+// with the same InvocationID and URL are delivered in the order they were
+// queued, so a job's later notification waits while an earlier one is pending.
+type OutboundNotification struct {
+	ID             string
+	InvocationID   string
+	URL            string
+	IdempotencyKey string
+	Payload        string
+	Status         string
+	Attempts       int
+	MaxAttempts    int
+	LastError      string
+	NextAttempt    time.Time
+	DateCreated    time.Time
+	DateDelivered  time.Time
+}
+
+// NotificationSender delivers the notifications queued for a URL that isn't
+// POSTed to, like the ones for the AMQP and email notifiers. Send must give up
+// after 'timeout'. The returned bool is true if the error is one that retrying
+// won't fix.
+type NotificationSender interface {
+	Send(payload []byte, timeout time.Duration) (bool, error)
+}
+
+// NotificationQueue delivers outbound notifications that have been stored in
+// the database. Notifications survive restarts and outages of the upstream
+// services because nothing is sent until it's been written to the database.
+// Notifications are POSTed to their URL unless a sender is registered for it
+// in Senders.
+type NotificationQueue struct {
+	DB          JobStore
+	Timeout     time.Duration
+	Timeouts    map[string]time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	Senders     map[string]NotificationSender
+	wake        chan bool
+}
+
+// NewNotificationQueue returns a pointer to a new NotificationQueue configured
+// with the notification settings in 'cfg'. Defaults are used for the settings
+// that aren't set.
+func NewNotificationQueue(cfg *Configuration, d JobStore) *NotificationQueue {
+	q := &NotificationQueue{
+		DB:          d,
+		Timeout:     defaultNotificationTimeout,
+		Timeouts:    make(map[string]time.Duration),
+		MaxAttempts: defaultNotificationMaxAttempts,
+		Backoff:     defaultNotificationBackoff,
+		Senders:     make(map[string]NotificationSender),
+		wake:        make(chan bool, 1),
+	}
+	if cfg.NotificationTimeout > 0 {
+		q.Timeout = time.Duration(cfg.NotificationTimeout) * time.Second
+	}
+	for endpoint, seconds := range cfg.NotificationTimeouts {
+		q.Timeouts[endpoint] = time.Duration(seconds) * time.Second
+	}
+	if cfg.NotificationMaxAttempts > 0 {
+		q.MaxAttempts = cfg.NotificationMaxAttempts
+	}
+	if cfg.NotificationBackoff > 0 {
+		q.Backoff = time.Duration(cfg.NotificationBackoff) * time.Second
+	}
+	return q
+}
+
+// Register sets up 'sender' to deliver the notifications queued for 'url'. All
+// of the senders must be registered before Run is called.
+func (q *NotificationQueue) Register(url string, sender NotificationSender) {
+	q.Senders[url] = sender
+}
+
+// Enqueue stores a notification about the job with the given invocation ID
+// for immediate delivery to 'url'. It isn't sent until the job's earlier
+// notifications to 'url' are delivered or dead. Notifications with an
+// idempotency key that has already been queued are ignored, so it's safe to
+// call this more than once for the same event.
+func (q *NotificationQueue) Enqueue(invocationID, url, key string, payload []byte) error {
+	return q.EnqueueAt(invocationID, url, key, payload, time.Now())
+}
+
+// EnqueueAt is like Enqueue, but the first delivery attempt isn't made until
+// 'at'.
+func (q *NotificationQueue) EnqueueAt(invocationID, url, key string, payload []byte, at time.Time) error {
+	_, err := q.DB.GetOutboundNotificationByKey(key)
+	if err == nil {
+		logger.Printf("A notification with an idempotency key of %s has already been queued, skipping", key)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	n := &OutboundNotification{
+		InvocationID:   invocationID,
+		URL:            url,
+		IdempotencyKey: key,
+		Payload:        string(payload),
+		Status:         NotificationPending,
+		MaxAttempts:    q.MaxAttempts,
+		NextAttempt:    at,
+	}
+	id, err := q.DB.InsertOutboundNotification(n)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Queued notification %s for delivery to %s", id, url)
+	q.wakeUp()
+	return nil
+}
+
+// wakeUp makes Run look for due notifications without waiting for the next
+// poll.
+func (q *NotificationQueue) wakeUp() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+// Run delivers queued notifications until something is sent on 'quit'. It
+// wakes up whenever a notification is queued and on a regular interval so that
+// retries go out when they're due.
+func (q *NotificationQueue) Run(quit <-chan int) {
+	ticker := time.NewTicker(notificationPollInterval)
+	defer ticker.Stop()
+	for {
+		q.DeliverPending()
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// DeliverPending attempts delivery of all of the pending notifications that
+// are due. A notification that's waiting on an earlier one for the same job
+// isn't due yet.
+func (q *NotificationQueue) DeliverPending() {
+	due, err := q.DB.GetDueOutboundNotifications(time.Now(), notificationBatchSize)
+	if err != nil {
+		logger.Printf("Error getting the notifications that are due: %s", err)
+		return
+	}
+	for i := range due {
+		q.Attempt(&due[i])
+	}
+}
+
+// Attempt tries to deliver a notification once and records the outcome. The
+// notification is rescheduled with an exponential backoff if the attempt
+// fails and there are attempts left.
+func (q *NotificationQueue) Attempt(n *OutboundNotification) {
+	n.Attempts = n.Attempts + 1
+	permanent, err := q.send(n)
+	switch {
+	case err == nil:
+		n.Status = NotificationDelivered
+		n.LastError = ""
+		n.DateDelivered = time.Now()
+		logger.Printf("Delivered notification %s to %s after %d attempt(s)", n.ID, n.URL, n.Attempts)
+	case permanent || n.Attempts >= n.MaxAttempts:
+		n.Status = NotificationDead
+		n.LastError = err.Error()
+		logger.Printf("Giving up on notification %s to %s after %d attempt(s): %s", n.ID, n.URL, n.Attempts, err)
+	default:
+		n.LastError = err.Error()
+		n.NextAttempt = time.Now().Add(q.backoff(n.Attempts))
+		logger.Printf("Error delivering notification %s to %s, retrying at %s: %s", n.ID, n.URL, n.NextAttempt, err)
+	}
+	if _, err = q.DB.UpdateOutboundNotification(n); err != nil {
+		logger.Printf("Error updating notification %s: %s", n.ID, err)
+		return
+	}
+	// The job's next notification can go out now instead of at the next poll.
+	if n.Status != NotificationPending {
+		q.wakeUp()
+	}
+}
+
+// backoff returns how long to wait before the next attempt after 'attempts'
+// failed attempts.
+func (q *NotificationQueue) backoff(attempts int) time.Duration {
+	wait := q.Backoff
+	for i := 1; i < attempts; i++ {
+		wait = wait * 2
+		if wait >= maxNotificationBackoff {
+			return maxNotificationBackoff
+		}
+	}
+	return wait
+}
+
+// timeout returns the request timeout for the notification's endpoint.
+func (q *NotificationQueue) timeout(n *OutboundNotification) time.Duration {
+	if t, ok := q.Timeouts[n.URL]; ok {
+		return t
+	}
+	return q.Timeout
+}
+
+// send delivers the notification with the sender registered for its URL, or
+// POSTs it if there isn't one. The returned bool is true if the error is one
+// that retrying won't fix.
+func (q *NotificationQueue) send(n *OutboundNotification) (bool, error) {
+	if sender, ok := q.Senders[n.URL]; ok {
+		return sender.Send([]byte(n.Payload), q.timeout(n))
+	}
+	if !strings.HasPrefix(n.URL, "http://") && !strings.HasPrefix(n.URL, "https://") {
+		return true, fmt.Errorf("no notifier is set up to send to %s", n.URL)
+	}
+	return q.post(n)
+}
+
+// post sends the notification. The returned bool is true if the error is one
+// that retrying won't fix, which is the case for most 4xx responses.
+func (q *NotificationQueue) post(n *OutboundNotification) (bool, error) {
+	req, err := http.NewRequest("POST", n.URL, bytes.NewBufferString(n.Payload))
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", n.IdempotencyKey)
+	client := &http.Client{Timeout: q.timeout(n)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	LogResponse(resp)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s returned %s", n.URL, resp.Status)
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout &&
+		resp.StatusCode != statusTooManyRequests
+	return permanent, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotificationBackoff(t *testing.T) {
+	q := &NotificationQueue{Backoff: 5 * time.Second}
+	if q.backoff(1) != 5*time.Second {
+		t.Errorf("The first backoff was %s instead of 5s", q.backoff(1))
+	}
+	if q.backoff(3) != 20*time.Second {
+		t.Errorf("The third backoff was %s instead of 20s", q.backoff(3))
+	}
+	if q.backoff(50) != maxNotificationBackoff {
+		t.Errorf("The backoff wasn't capped: %s", q.backoff(50))
+	}
+}
+
+func TestNotificationTimeout(t *testing.T) {
+	q := &NotificationQueue{
+		Timeout:  10 * time.Second,
+		Timeouts: map[string]time.Duration{"http://slow/": time.Minute},
+	}
+	if q.timeout(&OutboundNotification{URL: "http://slow/"}) != time.Minute {
+		t.Error("The per-endpoint timeout wasn't used")
+	}
+	if q.timeout(&OutboundNotification{URL: "http://fast/"}) != 10*time.Second {
+		t.Error("The default timeout wasn't used")
+	}
+}
+
+func TestNotificationPost(t *testing.T) {
+	var key string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	q := &NotificationQueue{Timeout: time.Second}
+	n := &OutboundNotification{
+		URL:            server.URL,
+		IdempotencyKey: "key",
+		Payload:        "{}",
+	}
+	permanent, err := q.post(n)
+	if err != nil || permanent {
+		t.Errorf("A 200 response was treated as a failure: %s", err)
+	}
+	if key != "key" {
+		t.Errorf("The Idempotency-Key header was '%s' instead of 'key'", key)
+	}
+	status = http.StatusServiceUnavailable
+	permanent, err = q.post(n)
+	if err == nil || permanent {
+		t.Errorf("A 503 response wasn't treated as a temporary failure: %s", err)
+	}
+	status = http.StatusBadRequest
+	permanent, err = q.post(n)
+	if err == nil || !permanent {
+		t.Errorf("A 400 response wasn't treated as a permanent failure: %s", err)
+	}
+}
+
+type recordingSender struct {
+	payloads []string
+	timeout  time.Duration
+}
+
+func (r *recordingSender) Send(payload []byte, timeout time.Duration) (bool, error) {
+	r.payloads = append(r.payloads, string(payload))
+	r.timeout = timeout
+	return false, nil
+}
+
+func TestNotificationSenders(t *testing.T) {
+	m := NewMemoryStore()
+	q := NewNotificationQueue(&Configuration{NotificationTimeouts: map[string]int{"amqp:events": 3}}, m)
+	sender := &recordingSender{}
+	q.Register("amqp:events", sender)
+	if err := q.Enqueue("abc", "amqp:events", "key1", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue("abc", "email:ops", "key2", []byte("Subject: hi")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		n, err := m.GetOutboundNotificationByKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Attempt(n)
+	}
+	if len(sender.payloads) != 1 || sender.payloads[0] != "{}" {
+		t.Errorf("The registered sender was given %v", sender.payloads)
+	}
+	if sender.timeout != 3*time.Second {
+		t.Errorf("The sender was given a timeout of %s instead of 3s", sender.timeout)
+	}
+	n, err := m.GetOutboundNotificationByKey("key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != NotificationDelivered {
+		t.Errorf("The sent notification is %s instead of delivered", n.Status)
+	}
+	n, err = m.GetOutboundNotificationByKey("key2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != NotificationDead {
+		t.Errorf("The notification without a sender is %s instead of dead", n.Status)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// StatusChange describes a job moving into a new status. It's what gets handed
+// to each of the notifiers, and it's also the data that's available to the
+// templates configured for webhooks and emails.
+type StatusChange struct {
+	Status         string `json:"status"`
+	CompletionDate string `json:"completion_date,omitempty"`
+	InvocationID   string `json:"uuid"`
+	CondorID       string `json:"condor_id"`
+	AppID          string `json:"app_id,omitempty"`
+	User           string `json:"user,omitempty"`
+	ExitCode       int    `json:"exit_code"`
+	EventNumber    string `json:"event_number"`
+	Message        string `json:"message,omitempty"`
+	Hash           string `json:"-"`
+}
+
+// NewStatusChange returns a pointer to a StatusChange populated with info
+// from the passed in event.
+func NewStatusChange(event *Event) *StatusChange {
+	state := NewJobState(event)
+	return &StatusChange{
+		Status:         state.State.Status,
+		CompletionDate: state.State.CompletionDate,
+		InvocationID:   event.InvocationID,
+		CondorID:       event.CondorID,
+		AppID:          event.AppID,
+		User:           event.User,
+		ExitCode:       event.ExitCode,
+		EventNumber:    event.EventNumber,
+		Message:        event.TerminationMessage(),
+		Hash:           event.Hash,
+	}
+}
+
+// JobState returns the StatusChange in the format used by the /de-job
+// endpoint.
+func (s *StatusChange) JobState() JobState {
+	return JobState{
+		State: JobStatus{
+			Status:         s.Status,
+			CompletionDate: s.CompletionDate,
+			UUID:           s.InvocationID,
+			Message:        s.Message,
+		},
+	}
+}
+
+// NotificationKey returns the idempotency key for the notifications sent for a
+// status change. The same event always produces the same key, so redelivered
+// events don't result in duplicate notifications.
+func NotificationKey(change *StatusChange) string {
+	return fmt.Sprintf("%s:%s:%s", change.InvocationID, change.Status, change.Hash)
+}
+
+// Notifier is implemented by anything that can tell the outside world about a
+// job's status changes.
+type Notifier interface {
+	Notify(change *StatusChange) error
+}
+
+// NotifierSink is a Notifier along with the statuses it's subscribed to. A sink
+// with no statuses listed receives all of them.
+type NotifierSink struct {
+	Name     string
+	Statuses []string
+	Notifier Notifier
+}
+
+// Wants returns true if the sink is subscribed to the status.
+func (n *NotifierSink) Wants(status string) bool {
+	if len(n.Statuses) == 0 {
+		return true
+	}
+	for _, s := range n.Statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifiers is the list of sinks that status changes are sent to.
+type Notifiers []*NotifierSink
+
+// Notify sends the status change to each of the sinks that are subscribed to
+// its status. A failing sink doesn't stop the others from being notified; the
+// returned error lists all of the sinks that failed.
+func (n Notifiers) Notify(change *StatusChange) error {
+	var failures []string
+	for _, sink := range n {
+		if !sink.Wants(change.Status) {
+			continue
+		}
+		if err := sink.Notifier.Notify(change); err != nil {
+			logger.Printf("Error sending '%s' status for %s to the %s notifier: %s", change.Status, change.InvocationID, sink.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", sink.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("notifiers failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// NotifierConfig contains the settings for a single notification sink in the
+// configuration file. Type is one of "webhook", "amqp", or "email", and decides
+// which of the other fields are used.
+type NotifierConfig struct {
+	Type     string
+	Name     string
+	Statuses []string
+
+	// webhook settings. Template is also used for the email body.
+	URL      string
+	Template string
+
+	// amqp settings
+	AMQPURI          string
+	Exchange         string
+	ExchangeType     string
+	RoutingKeyPrefix string
+
+	// email settings
+	SMTPHost string
+	From     string
+	To       []string
+	Subject  string
+}
+
+// donkeyStatuses are the statuses that are sent to the /de-job endpoint. It
+// doesn't understand Held, so DonkeyNotifier sends holds as Running.
+var donkeyStatuses = []string{StatusSubmitted, StatusRunning, StatusHeld, StatusCompleted, StatusFailed}
+
+// NewNotifiers builds the list of notification sinks from the configuration.
+// The Donkey /de-job endpoint in EventURL is always included if it's set so
+// that existing configurations keep working.
+func NewNotifiers(cfg *Configuration, queue *NotificationQueue) (Notifiers, error) {
+	var notifiers Notifiers
+	if cfg.EventURL != "" {
+		notifiers = append(notifiers, &NotifierSink{
+			Name:     "donkey",
+			Statuses: donkeyStatuses,
+			Notifier: &DonkeyNotifier{URL: cfg.EventURL, Queue: queue},
+		})
+	}
+	for i, nc := range cfg.Notifiers {
+		name := nc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", nc.Type, i)
+		}
+		var (
+			n   Notifier
+			err error
+		)
+		switch nc.Type {
+		case "webhook":
+			n, err = NewWebhookNotifier(name, &nc, queue)
+		case "amqp":
+			n, err = NewAMQPNotifier(name, &nc, queue)
+		case "email":
+			n, err = NewEmailNotifier(name, &nc, queue)
+		default:
+			err = fmt.Errorf("unknown notifier type '%s'", nc.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error configuring notifier %s: %s", name, err)
+		}
+		notifiers = append(notifiers, &NotifierSink{
+			Name:     name,
+			Statuses: nc.Statuses,
+			Notifier: n,
+		})
+	}
+	return notifiers, nil
+}
+
+// templateFuncs are the extra functions available in notifier templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// renderTemplate executes a template against a status change and returns the
+// result.
+func renderTemplate(t *template.Template, change *StatusChange) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, change); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DonkeyNotifier sends status changes to the /de-job endpoint in the format it
+// expects. The notifications go through the outbound notification queue.
+type DonkeyNotifier struct {
+	URL   string
+	Queue *NotificationQueue
+}
+
+// Notify queues the JobState JSON for the status change. The /de-job endpoint
+// doesn't have a Held status, so a held job is sent as Running with the hold
+// reason in the message; it's sent as Failed or Running again once the hold
+// is dealt with.
+func (d *DonkeyNotifier) Notify(change *StatusChange) error {
+	state := change.JobState()
+	if change.Status == StatusHeld {
+		state.State.Status = StatusRunning
+	}
+	json, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Queueing '%s' event for %s: %s", change.Status, d.URL, string(json))
+	return d.Queue.Enqueue(change.InvocationID, d.URL, NotificationKey(change), json)
+}
+
+// WebhookNotifier POSTs status changes to an arbitrary URL. The body is the
+// StatusChange as JSON unless a template is configured. The notifications go
+// through the outbound notification queue.
+type WebhookNotifier struct {
+	Name     string
+	URL      string
+	Template *template.Template
+	Queue    *NotificationQueue
+}
+
+// NewWebhookNotifier returns a pointer to a new WebhookNotifier configured
+// from 'nc'.
+func NewWebhookNotifier(name string, nc *NotifierConfig, queue *NotificationQueue) (*WebhookNotifier, error) {
+	if nc.URL == "" {
+		return nil, fmt.Errorf("URL must be set for webhook notifiers")
+	}
+	w := &WebhookNotifier{
+		Name:  name,
+		URL:   nc.URL,
+		Queue: queue,
+	}
+	if nc.Template != "" {
+		t, err := template.New(name).Funcs(templateFuncs).Parse(nc.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.Template = t
+	}
+	return w, nil
+}
+
+// Notify renders the body for the status change and queues it.
+func (w *WebhookNotifier) Notify(change *StatusChange) error {
+	var (
+		body []byte
+		err  error
+	)
+	if w.Template != nil {
+		body, err = renderTemplate(w.Template, change)
+	} else {
+		body, err = json.Marshal(change)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Queue.Enqueue(change.InvocationID, w.URL, fmt.Sprintf("%s:%s", NotificationKey(change), w.Name), body)
+}
+
+// AMQPNotifier republishes status changes as JSON to an AMQP exchange. The
+// routing key is the prefix followed by the lowercased status, so consumers can
+// bind to jobs.status.* or just to jobs.status.failed. The messages go through
+// the outbound notification queue, so a slow or unreachable broker doesn't
+// hold up the events.
+type AMQPNotifier struct {
+	Name             string
+	URI              string
+	Exchange         string
+	ExchangeType     string
+	RoutingKeyPrefix string
+	Queue            *NotificationQueue
+	mutex            sync.Mutex
+	connection       *amqp.Connection
+	channel          *amqp.Channel
+}
+
+// amqpNotification is what's queued for an AMQPNotifier.
+type amqpNotification struct {
+	RoutingKey string
+	Body       json.RawMessage
+}
+
+// NewAMQPNotifier returns a pointer to a new AMQPNotifier configured from
+// 'nc', and registers it with the queue. The connection isn't established
+// until the first notification is sent.
+func NewAMQPNotifier(name string, nc *NotifierConfig, queue *NotificationQueue) (*AMQPNotifier, error) {
+	if nc.AMQPURI == "" {
+		return nil, fmt.Errorf("AMQPURI must be set for amqp notifiers")
+	}
+	if nc.Exchange == "" {
+		return nil, fmt.Errorf("Exchange must be set for amqp notifiers")
+	}
+	a := &AMQPNotifier{
+		Name:             name,
+		URI:              nc.AMQPURI,
+		Exchange:         nc.Exchange,
+		ExchangeType:     nc.ExchangeType,
+		RoutingKeyPrefix: nc.RoutingKeyPrefix,
+		Queue:            queue,
+	}
+	if a.ExchangeType == "" {
+		a.ExchangeType = "topic"
+	}
+	if a.RoutingKeyPrefix == "" {
+		a.RoutingKeyPrefix = "jobs.status"
+	}
+	if queue != nil {
+		queue.Register(a.QueueURL(), a)
+	}
+	return a, nil
+}
+
+// QueueURL returns the URL that the notifier's messages are queued for.
+func (a *AMQPNotifier) QueueURL() string {
+	return "amqp:" + a.Name
+}
+
+// RoutingKey returns the routing key used for a status.
+func (a *AMQPNotifier) RoutingKey(status string) string {
+	return fmt.Sprintf("%s.%s", a.RoutingKeyPrefix, strings.ToLower(status))
+}
+
+// connect sets up the connection and channel if they aren't already set up,
+// giving up on connecting after 'timeout'. The caller must hold the mutex.
+func (a *AMQPNotifier) connect(timeout time.Duration) error {
+	if a.channel != nil {
+		return nil
+	}
+	connection, err := amqp.DialConfig(a.URI, amqp.Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	err = channel.ExchangeDeclare(a.Exchange, a.ExchangeType, true, false, false, false, nil)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	a.connection = connection
+	a.channel = channel
+	return nil
+}
+
+// disconnect tears down the connection so that the next publish reconnects.
+// The caller must hold the mutex.
+func (a *AMQPNotifier) disconnect() {
+	if a.connection != nil {
+		a.connection.Close()
+	}
+	a.connection = nil
+	a.channel = nil
+}
+
+// Notify queues the status change to be published.
+func (a *AMQPNotifier) Notify(change *StatusChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(&amqpNotification{RoutingKey: a.RoutingKey(change.Status), Body: body})
+	if err != nil {
+		return err
+	}
+	return a.Queue.Enqueue(change.InvocationID, a.QueueURL(), fmt.Sprintf("%s:%s", NotificationKey(change), a.Name), payload)
+}
+
+// Send publishes a queued status change. A failed publish is retried once on
+// a new connection in case the old one went stale.
+func (a *AMQPNotifier) Send(payload []byte, timeout time.Duration) (bool, error) {
+	var n amqpNotification
+	if err := json.Unmarshal(payload, &n); err != nil {
+		return true, err
+	}
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         n.Body,
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = a.connect(timeout); err != nil {
+			continue
+		}
+		err = a.channel.Publish(a.Exchange, n.RoutingKey, false, false, msg)
+		if err == nil {
+			return false, nil
+		}
+		a.disconnect()
+	}
+	return false, err
+}
+
+const (
+	defaultEmailSubject = "Job {{.InvocationID}} is {{.Status}}"
+	defaultEmailBody    = `Job {{.InvocationID}} (Condor ID {{.CondorID}}) submitted by {{.User}} is now {{.Status}}.
+{{if .Message}}
+{{.Message}}
+{{end}}`
+)
+
+// EmailNotifier sends status changes as plain text emails through an SMTP
+// server. It's meant to be pointed at a local relay, so it doesn't
+// authenticate. The emails go through the outbound notification queue, so a
+// slow or unreachable server doesn't hold up the events.
+type EmailNotifier struct {
+	Name     string
+	SMTPHost string
+	From     string
+	To       []string
+	Subject  *template.Template
+	Body     *template.Template
+	Queue    *NotificationQueue
+}
+
+// NewEmailNotifier returns a pointer to a new EmailNotifier configured from
+// 'nc', and registers it with the queue.
+func NewEmailNotifier(name string, nc *NotifierConfig, queue *NotificationQueue) (*EmailNotifier, error) {
+	if nc.From == "" {
+		return nil, fmt.Errorf("From must be set for email notifiers")
+	}
+	if len(nc.To) == 0 {
+		return nil, fmt.Errorf("To must be set for email notifiers")
+	}
+	e := &EmailNotifier{
+		Name:     name,
+		SMTPHost: nc.SMTPHost,
+		From:     nc.From,
+		To:       nc.To,
+		Queue:    queue,
+	}
+	if e.SMTPHost == "" {
+		e.SMTPHost = "localhost:25"
+	}
+	subject := nc.Subject
+	if subject == "" {
+		subject = defaultEmailSubject
+	}
+	body := nc.Template
+	if body == "" {
+		body = defaultEmailBody
+	}
+	var err error
+	if e.Subject, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return nil, err
+	}
+	if e.Body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, err
+	}
+	if queue != nil {
+		queue.Register(e.QueueURL(), e)
+	}
+	return e, nil
+}
+
+// QueueURL returns the URL that the notifier's emails are queued for.
+func (e *EmailNotifier) QueueURL() string {
+	return "email:" + e.Name
+}
+
+// Message returns the full email, headers included, for a status change.
+func (e *EmailNotifier) Message(change *StatusChange) ([]byte, error) {
+	subject, err := renderTemplate(e.Subject, change)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderTemplate(e.Body, change)
+	if err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.TrimSpace(string(subject)))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.Write(body)
+	return msg.Bytes(), nil
+}
+
+// Notify queues the email for the status change.
+func (e *EmailNotifier) Notify(change *StatusChange) error {
+	msg, err := e.Message(change)
+	if err != nil {
+		return err
+	}
+	return e.Queue.Enqueue(change.InvocationID, e.QueueURL(), fmt.Sprintf("%s:%s", NotificationKey(change), e.Name), msg)
+}
+
+// Send sends a queued email the way smtp.SendMail does, except that it gives
+// up once 'timeout' has passed. Errors with a 5xx code from the server aren't
+// worth retrying.
+func (e *EmailNotifier) Send(msg []byte, timeout time.Duration) (bool, error) {
+	permanent := func(err error) bool {
+		tpErr, ok := err.(*textproto.Error)
+		return ok && tpErr.Code >= 500
+	}
+	conn, err := net.DialTimeout("tcp", e.SMTPHost, timeout)
+	if err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	host, _, _ := net.SplitHostPort(e.SMTPHost)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return false, err
+		}
+	}
+	if err = c.Mail(e.From); err != nil {
+		return permanent(err), err
+	}
+	for _, to := range e.To {
+		if err = c.Rcpt(to); err != nil {
+			return permanent(err), err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return permanent(err), err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return false, err
+	}
+	if err = w.Close(); err != nil {
+		return permanent(err), err
+	}
+	return false, c.Quit()
+}
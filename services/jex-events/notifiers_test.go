@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type recordingNotifier struct {
+	changes []*StatusChange
+	err     error
+}
+
+func (r *recordingNotifier) Notify(change *StatusChange) error {
+	r.changes = append(r.changes, change)
+	return r.err
+}
+
+func TestNotifierSinkWants(t *testing.T) {
+	all := &NotifierSink{}
+	if !all.Wants(StatusRunning) {
+		t.Error("A sink without statuses should want everything")
+	}
+	failed := &NotifierSink{Statuses: []string{"failed", "Completed"}}
+	if !failed.Wants(StatusFailed) {
+		t.Error("Status matching should be case insensitive")
+	}
+	if failed.Wants(StatusRunning) {
+		t.Error("A sink subscribed to Failed and Completed wanted Running")
+	}
+}
+
+func TestNotifiersNotify(t *testing.T) {
+	everything := &recordingNotifier{}
+	failures := &recordingNotifier{}
+	broken := &recordingNotifier{err: errors.New("broken")}
+	notifiers := Notifiers{
+		{Name: "everything", Notifier: everything},
+		{Name: "failures", Statuses: []string{StatusFailed}, Notifier: failures},
+		{Name: "broken", Notifier: broken},
+	}
+	err := notifiers.Notify(&StatusChange{Status: StatusRunning})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("The error from the broken notifier wasn't returned: %s", err)
+	}
+	if len(everything.changes) != 1 {
+		t.Errorf("The unfiltered notifier got %d changes instead of 1", len(everything.changes))
+	}
+	if len(failures.changes) != 0 {
+		t.Errorf("The Failed notifier got a Running change")
+	}
+	if len(broken.changes) != 1 {
+		t.Errorf("The broken notifier wasn't called")
+	}
+}
+
+func TestNewNotifiers(t *testing.T) {
+	cfg := &Configuration{
+		EventURL: "http://localhost/de-job",
+		Notifiers: []NotifierConfig{
+			{Type: "webhook", Name: "hook", URL: "http://localhost/hook", Template: `{"id":{{json .InvocationID}}}`},
+			{Type: "amqp", AMQPURI: "amqp://localhost/", Exchange: "jobs"},
+			{Type: "email", From: "jex@localhost", To: []string{"admin@localhost"}},
+		},
+	}
+	notifiers, err := NewNotifiers(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifiers) != 4 {
+		t.Errorf("Got %d notifiers instead of 4", len(notifiers))
+	}
+	if notifiers[2].Name != "amqp-1" {
+		t.Errorf("The unnamed amqp notifier was named %s", notifiers[2].Name)
+	}
+	hook := notifiers[1].Notifier.(*WebhookNotifier)
+	body, err := renderTemplate(hook.Template, &StatusChange{InvocationID: "abc"})
+	if err != nil {
+		t.Error(err)
+	}
+	if string(body) != `{"id":"abc"}` {
+		t.Errorf("The webhook template rendered as %s", string(body))
+	}
+	cfg.Notifiers = []NotifierConfig{{Type: "pigeon"}}
+	if _, err = NewNotifiers(cfg, nil); err == nil {
+		t.Error("An unknown notifier type didn't return an error")
+	}
+}
+
+func TestAMQPNotifierRoutingKey(t *testing.T) {
+	a, err := NewAMQPNotifier("events", &NotifierConfig{AMQPURI: "amqp://localhost/", Exchange: "jobs"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.RoutingKey(StatusFailed) != "jobs.status.failed" {
+		t.Errorf("The routing key was %s", a.RoutingKey(StatusFailed))
+	}
+}
+
+func TestAMQPNotifierQueued(t *testing.T) {
+	m := NewMemoryStore()
+	q := NewNotificationQueue(&Configuration{}, m)
+	a, err := NewAMQPNotifier("events", &NotifierConfig{AMQPURI: "amqp://localhost/", Exchange: "jobs"}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Senders["amqp:events"] != a {
+		t.Error("The notifier wasn't registered with the queue")
+	}
+	change := &StatusChange{Status: StatusFailed, InvocationID: "abc", Hash: "1"}
+	if err = a.Notify(change); err != nil {
+		t.Fatal(err)
+	}
+	n, err := m.GetOutboundNotificationByKey(NotificationKey(change) + ":events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.URL != "amqp:events" {
+		t.Errorf("The status change was queued for %s instead of amqp:events", n.URL)
+	}
+	var queued amqpNotification
+	if err = json.Unmarshal([]byte(n.Payload), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.RoutingKey != "jobs.status.failed" {
+		t.Errorf("The status change was queued with the routing key %s", queued.RoutingKey)
+	}
+}
+
+func TestEmailNotifierMessage(t *testing.T) {
+	e, err := NewEmailNotifier("ops", &NotifierConfig{From: "jex@localhost", To: []string{"a@localhost", "b@localhost"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := e.Message(&StatusChange{InvocationID: "abc", Status: StatusFailed, Message: "out of memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(msg)
+	if !strings.Contains(text, "Subject: Job abc is Failed\r\n") {
+		t.Errorf("The subject wasn't rendered: %s", text)
+	}
+	if !strings.Contains(text, "To: a@localhost, b@localhost\r\n") {
+		t.Errorf("The recipients weren't listed: %s", text)
+	}
+	if !strings.Contains(text, "out of memory") {
+		t.Errorf("The message wasn't included in the body: %s", text)
+	}
+}
+
+func TestDonkeyNotifierHeld(t *testing.T) {
+	m := NewMemoryStore()
+	d := &DonkeyNotifier{URL: "http://localhost/de-job", Queue: NewNotificationQueue(&Configuration{}, m)}
+	if !(&NotifierSink{Statuses: donkeyStatuses}).Wants(StatusHeld) {
+		t.Error("Donkey isn't sent held jobs")
+	}
+	change := &StatusChange{Status: StatusHeld, InvocationID: "abc", Message: "Job was held: out of disk", Hash: "1"}
+	if err := d.Notify(change); err != nil {
+		t.Fatal(err)
+	}
+	n, err := m.GetOutboundNotificationByKey(NotificationKey(change))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Payload != `{"state":{"status":"Running","uuid":"abc","message":"Job was held: out of disk"}}` {
+		t.Errorf("The held job was sent to Donkey as %s", n.Payload)
+	}
+}
@@ -0,0 +1,1954 @@
+package main
+
+import "net/http"
+
+// OpenAPIHTTPGet returns the OpenAPI (Swagger 2.0) document for the HTTP API.
+func OpenAPIHTTPGet(writer http.ResponseWriter, request *http.Request, p *Principal) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write([]byte(openAPIDocument))
+}
+
+// openAPIDocument describes every endpoint in the HTTP API. It needs to be
+// updated whenever a route is added to HTTPAPI.Router.
+const openAPIDocument = `{
+  "swagger": "2.0",
+  "info": {
+    "title": "jex-events",
+    "description": "Tracks HTCondor job events for the Discovery Environment. Every response includes an X-Request-ID header, and every error is returned in an ErrorResponse.",
+    "version": "2.0.0"
+  },
+  "consumes": [
+    "application/json"
+  ],
+  "produces": [
+    "application/json"
+  ],
+  "securityDefinitions": {
+    "bearer": {
+      "type": "apiKey",
+      "in": "header",
+      "name": "Authorization",
+      "description": "A JWT signed with a key in the configured JWKS file, sent as 'Bearer <token>'. Non-admin users can only see their own jobs."
+    },
+    "hmac": {
+      "type": "apiKey",
+      "in": "header",
+      "name": "Authorization",
+      "description": "'HMAC <service>:<unix-timestamp>:<signature>', for service-to-service requests. Services are admins."
+    }
+  },
+  "security": [
+    {
+      "bearer": []
+    },
+    {
+      "hmac": []
+    }
+  ],
+  "paths": {
+    "/jobs": {
+      "get": {
+        "summary": "Lists jobs, a page at a time.",
+        "parameters": [
+          {
+            "name": "submitter",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The user that submitted the jobs."
+          },
+          {
+            "name": "app_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of the app.",
+            "format": "uuid"
+          },
+          {
+            "name": "status",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The jobs' current status."
+          },
+          {
+            "name": "batch_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of the batch.",
+            "format": "uuid"
+          },
+          {
+            "name": "submitted_after",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "An RFC3339 timestamp.",
+            "format": "date-time"
+          },
+          {
+            "name": "submitted_before",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "An RFC3339 timestamp.",
+            "format": "date-time"
+          },
+          {
+            "name": "completed_after",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "An RFC3339 timestamp.",
+            "format": "date-time"
+          },
+          {
+            "name": "completed_before",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "An RFC3339 timestamp.",
+            "format": "date-time"
+          },
+          {
+            "name": "exit_code",
+            "in": "query",
+            "required": false,
+            "type": "integer",
+            "description": "The jobs' exit code."
+          },
+          {
+            "name": "sort",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The column to sort by.",
+            "enum": [
+              "date_submitted",
+              "date_completed"
+            ]
+          },
+          {
+            "name": "order",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The sort order.",
+            "enum": [
+              "asc",
+              "desc"
+            ]
+          },
+          {
+            "name": "limit",
+            "in": "query",
+            "required": false,
+            "type": "integer",
+            "description": "The page size, at most 500."
+          },
+          {
+            "name": "cursor",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The NextCursor from the previous page."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "A page of jobs.",
+            "schema": {
+              "$ref": "#/definitions/JobListPage"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      },
+      "post": {
+        "summary": "Adds a job, or updates the job with the same Condor ID.",
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/JobRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The UUID of the job, as plain text.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "get": {
+        "summary": "Looks up a job.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job.",
+            "schema": {
+              "$ref": "#/definitions/Job"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      },
+      "patch": {
+        "summary": "Updates the given fields of a job.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          },
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/JobUpdate"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The updated job.",
+            "schema": {
+              "$ref": "#/definitions/Job"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/jobs/{id}/stop": {
+      "get": {
+        "summary": "Looks up the most recent stop request for a job.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The stop request.",
+            "schema": {
+              "$ref": "#/definitions/StopRequestState"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      },
+      "post": {
+        "summary": "Asks for a job to be stopped.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          },
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/StopRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job already has a pending or confirmed stop request.",
+            "schema": {
+              "$ref": "#/definitions/StopRequestState"
+            }
+          },
+          "202": {
+            "description": "The stop request was made and sent to the JEX.",
+            "schema": {
+              "$ref": "#/definitions/StopRequestState"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "409": {
+            "description": "The request conflicts with the resource's current state.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "502": {
+            "description": "The JEX couldn't be reached.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/jobs/{id}/usage": {
+      "get": {
+        "summary": "Returns the resource usage from a job's terminated event.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job's resource usage.",
+            "schema": {
+              "$ref": "#/definitions/JobResourceUsage"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/jobs/{id}/events": {
+      "get": {
+        "summary": "Returns the timeline of events for a job.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          },
+          {
+            "name": "raw",
+            "in": "query",
+            "required": false,
+            "type": "boolean",
+            "description": "Include the raw event text if true."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job's events in the order they were triggered.",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/TimelineEvent"
+              }
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/invocations/{id}": {
+      "get": {
+        "summary": "Looks up a job by its invocation UUID.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the invocation."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job.",
+            "schema": {
+              "$ref": "#/definitions/Job"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/invocations/{id}/events": {
+      "get": {
+        "summary": "Returns the timeline of events for a job by its invocation UUID.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the invocation."
+          },
+          {
+            "name": "raw",
+            "in": "query",
+            "required": false,
+            "type": "boolean",
+            "description": "Include the raw event text if true."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job's events in the order they were triggered.",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/TimelineEvent"
+              }
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/last-events/{id}": {
+      "get": {
+        "summary": "Returns the state of a job's last event by its invocation UUID.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the invocation."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job's state.",
+            "schema": {
+              "$ref": "#/definitions/JobState"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/batches/{id}": {
+      "get": {
+        "summary": "Summarizes a batch.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the batch's job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The batch summary.",
+            "schema": {
+              "$ref": "#/definitions/BatchSummary"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/batches/{id}/jobs": {
+      "get": {
+        "summary": "Lists the jobs in a batch.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the batch's job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The batch's jobs.",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/BatchMember"
+              }
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/dependencies": {
+      "post": {
+        "summary": "Makes one job depend on another.",
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/JobDependency"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The dependency.",
+            "schema": {
+              "$ref": "#/definitions/JobDependency"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "409": {
+            "description": "The request conflicts with the resource's current state.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/dependencies/{id}": {
+      "get": {
+        "summary": "Returns the dependency graph around a job.",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The job's dependencies.",
+            "schema": {
+              "$ref": "#/definitions/JobDependencies"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/dependencies/{predecessor}/{successor}": {
+      "delete": {
+        "summary": "Removes a dependency.",
+        "parameters": [
+          {
+            "name": "predecessor",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the job that's depended on."
+          },
+          {
+            "name": "successor",
+            "in": "path",
+            "required": true,
+            "type": "string",
+            "format": "uuid",
+            "description": "The UUID of the dependent job."
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The dependency was removed."
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "404": {
+            "description": "The resource was not found.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/dead-notifications": {
+      "get": {
+        "summary": "Lists the notifications that ran out of delivery attempts.",
+        "responses": {
+          "200": {
+            "description": "The undeliverable notifications.",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/OutboundNotification"
+              }
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/stream": {
+      "get": {
+        "summary": "Streams job status changes as server-sent events, or as WebSocket messages if the request asks for an upgrade. At least one of the filters is required.",
+        "parameters": [
+          {
+            "name": "job_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of a job.",
+            "format": "uuid"
+          },
+          {
+            "name": "invocation_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of an invocation.",
+            "format": "uuid"
+          },
+          {
+            "name": "batch_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of a batch.",
+            "format": "uuid"
+          },
+          {
+            "name": "submitter",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The user that submitted the jobs."
+          },
+          {
+            "name": "last_event_id",
+            "in": "query",
+            "required": false,
+            "type": "integer",
+            "description": "Resume after this event ID. The Last-Event-ID header takes precedence."
+          },
+          {
+            "name": "Last-Event-ID",
+            "in": "header",
+            "required": false,
+            "type": "integer",
+            "description": "Resume after this event ID."
+          }
+        ],
+        "responses": {
+          "101": {
+            "description": "Switched to a WebSocket; each message is a StreamEvent."
+          },
+          "200": {
+            "description": "A text/event-stream of StreamEvents.",
+            "schema": {
+              "$ref": "#/definitions/StreamEvent"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "426": {
+            "description": "The WebSocket version isn't supported.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/usage": {
+      "get": {
+        "summary": "Adds up the resource usage of the jobs that match the filters. Users that aren't admins can only add up their own jobs.",
+        "parameters": [
+          {
+            "name": "submitter",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The user that submitted the jobs."
+          },
+          {
+            "name": "app_id",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "The UUID of the app the jobs ran.",
+            "format": "uuid"
+          },
+          {
+            "name": "from",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "Only jobs that terminated at or after this time.",
+            "format": "date-time"
+          },
+          {
+            "name": "to",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "Only jobs that terminated before this time.",
+            "format": "date-time"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The totals.",
+            "schema": {
+              "$ref": "#/definitions/UsageTotals"
+            }
+          },
+          "400": {
+            "description": "The request was invalid.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "401": {
+            "description": "The request doesn't have valid credentials.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "403": {
+            "description": "The credentials don't allow the request.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "An internal error occurred.",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "Returns this document.",
+        "responses": {
+          "200": {
+            "description": "The OpenAPI document."
+          }
+        },
+        "security": []
+      }
+    }
+  },
+  "definitions": {
+    "APIError": {
+      "type": "object",
+      "properties": {
+        "Status": {
+          "type": "integer"
+        },
+        "Code": {
+          "type": "string"
+        },
+        "Message": {
+          "type": "string"
+        },
+        "RequestID": {
+          "type": "string"
+        },
+        "Fields": {
+          "type": "array",
+          "description": "The problem with each field of a request body that failed validation.",
+          "items": {
+            "$ref": "#/definitions/FieldError"
+          }
+        }
+      }
+    },
+    "FieldError": {
+      "type": "object",
+      "properties": {
+        "Field": {
+          "type": "string"
+        },
+        "Message": {
+          "type": "string"
+        }
+      }
+    },
+    "ErrorResponse": {
+      "type": "object",
+      "properties": {
+        "Error": {
+          "$ref": "#/definitions/APIError"
+        }
+      }
+    },
+    "Job": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "DateSubmitted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateStarted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCompleted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "FailureThreshold": {
+          "type": "integer"
+        },
+        "FailureCount": {
+          "type": "integer"
+        },
+        "TerminationKind": {
+          "type": "string",
+          "description": "How the job terminated according to its terminated event (005). Empty if it hasn't terminated or the event doesn't say.",
+          "enum": [
+            "",
+            "normal",
+            "signal",
+            "core dump"
+          ]
+        },
+        "TerminationSignal": {
+          "type": "integer",
+          "description": "The signal that killed the job, or 0 if it wasn't killed by one."
+        }
+      }
+    },
+    "JobRequest": {
+      "type": "object",
+      "description": "The fields of a new job. Unknown fields are rejected, except for CommandLine and EnvVariables, which are ignored. BatchID and InvocationID can be empty.",
+      "properties": {
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "DateSubmitted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateStarted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCompleted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "FailureThreshold": {
+          "type": "integer",
+          "minimum": 0
+        },
+        "FailureCount": {
+          "type": "integer",
+          "minimum": 0
+        }
+      },
+      "required": [
+        "CondorID",
+        "Submitter",
+        "AppID"
+      ],
+      "additionalProperties": false
+    },
+    "JobUpdate": {
+      "type": "object",
+      "description": "The fields to change on a job. Fields that aren't given are left alone, and unknown fields are rejected. BatchID, AppID, and InvocationID can be set to an empty string to clear them.",
+      "properties": {
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "DateSubmitted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateStarted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCompleted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "FailureThreshold": {
+          "type": "integer",
+          "minimum": 0
+        },
+        "FailureCount": {
+          "type": "integer",
+          "minimum": 0
+        }
+      },
+      "minProperties": 1,
+      "additionalProperties": false
+    },
+    "JobListing": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "DateSubmitted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateStarted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCompleted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "FailureThreshold": {
+          "type": "integer"
+        },
+        "FailureCount": {
+          "type": "integer"
+        },
+        "TerminationKind": {
+          "type": "string",
+          "description": "How the job terminated according to its terminated event (005). Empty if it hasn't terminated or the event doesn't say.",
+          "enum": [
+            "",
+            "normal",
+            "signal",
+            "core dump"
+          ]
+        },
+        "TerminationSignal": {
+          "type": "integer",
+          "description": "The signal that killed the job, or 0 if it wasn't killed by one."
+        },
+        "Status": {
+          "type": "string"
+        }
+      }
+    },
+    "JobListPage": {
+      "type": "object",
+      "properties": {
+        "Jobs": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/JobListing"
+          }
+        },
+        "NextCursor": {
+          "type": "string"
+        }
+      }
+    },
+    "StopRequest": {
+      "type": "object",
+      "properties": {
+        "Username": {
+          "type": "string"
+        },
+        "Reason": {
+          "type": "string"
+        }
+      },
+      "required": [
+        "Username"
+      ]
+    },
+    "StopRequestState": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "JobID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "Username": {
+          "type": "string"
+        },
+        "DateRequested": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "Reason": {
+          "type": "string"
+        },
+        "DateConfirmed": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "CondorJobEventID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "Status": {
+          "type": "string",
+          "enum": [
+            "pending",
+            "confirmed",
+            "timed out"
+          ]
+        }
+      }
+    },
+    "JobResourceUsage": {
+      "type": "object",
+      "properties": {
+        "JobID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorJobEventID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "UserCPUSeconds": {
+          "type": "integer"
+        },
+        "SysCPUSeconds": {
+          "type": "integer"
+        },
+        "BytesSent": {
+          "type": "integer"
+        },
+        "BytesReceived": {
+          "type": "integer"
+        },
+        "CPUsRequested": {
+          "type": "integer"
+        },
+        "CPUsAllocated": {
+          "type": "integer"
+        },
+        "MemoryUsageMB": {
+          "type": "integer"
+        },
+        "MemoryRequestedMB": {
+          "type": "integer"
+        },
+        "MemoryAllocatedMB": {
+          "type": "integer"
+        },
+        "DiskUsageKB": {
+          "type": "integer"
+        },
+        "DiskRequestedKB": {
+          "type": "integer"
+        },
+        "DiskAllocatedKB": {
+          "type": "integer"
+        },
+        "DateTriggered": {
+          "type": "string",
+          "format": "date-time"
+        }
+      }
+    },
+    "UsageTotals": {
+      "type": "object",
+      "properties": {
+        "Jobs": {
+          "type": "integer"
+        },
+        "UserCPUSeconds": {
+          "type": "integer"
+        },
+        "SysCPUSeconds": {
+          "type": "integer"
+        },
+        "BytesSent": {
+          "type": "integer"
+        },
+        "BytesReceived": {
+          "type": "integer"
+        },
+        "MaxMemoryUsageMB": {
+          "type": "integer"
+        },
+        "MaxDiskUsageKB": {
+          "type": "integer"
+        }
+      }
+    },
+    "TimelineEvent": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "EventNumber": {
+          "type": "string"
+        },
+        "EventName": {
+          "type": "string"
+        },
+        "EventDescription": {
+          "type": "string"
+        },
+        "Status": {
+          "type": "string"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Message": {
+          "type": "string"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "TerminationKind": {
+          "type": "string",
+          "description": "How the job terminated according to its terminated event (005). Empty if it hasn't terminated or the event doesn't say.",
+          "enum": [
+            "",
+            "normal",
+            "signal",
+            "core dump"
+          ]
+        },
+        "TerminationSignal": {
+          "type": "integer",
+          "description": "The signal that killed the job, or 0 if it wasn't killed by one."
+        },
+        "HoldReason": {
+          "type": "string"
+        },
+        "HoldCode": {
+          "type": "integer"
+        },
+        "HoldSubcode": {
+          "type": "integer"
+        },
+        "Checksum": {
+          "type": "string"
+        },
+        "DateTriggered": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateRecorded": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "RawEvent": {
+          "type": "string"
+        }
+      }
+    },
+    "JobState": {
+      "type": "object",
+      "properties": {
+        "state": {
+          "type": "object",
+          "properties": {
+            "uuid": {
+              "type": "string",
+              "format": "uuid"
+            },
+            "status": {
+              "type": "string"
+            },
+            "completion_date": {
+              "type": "string"
+            },
+            "message": {
+              "type": "string",
+              "description": "Why the job ended up in this status, like the signal that killed it. Left out when there's nothing to say."
+            }
+          }
+        }
+      }
+    },
+    "BatchMember": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "DateSubmitted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateStarted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCompleted": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "ExitCode": {
+          "type": "integer"
+        },
+        "FailureThreshold": {
+          "type": "integer"
+        },
+        "FailureCount": {
+          "type": "integer"
+        },
+        "TerminationKind": {
+          "type": "string",
+          "description": "How the job terminated according to its terminated event (005). Empty if it hasn't terminated or the event doesn't say.",
+          "enum": [
+            "",
+            "normal",
+            "signal",
+            "core dump"
+          ]
+        },
+        "TerminationSignal": {
+          "type": "integer",
+          "description": "The signal that killed the job, or 0 if it wasn't killed by one."
+        },
+        "Status": {
+          "type": "string"
+        },
+        "Started": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "Completed": {
+          "type": "string",
+          "format": "date-time"
+        }
+      }
+    },
+    "BatchSummary": {
+      "type": "object",
+      "properties": {
+        "Batch": {
+          "$ref": "#/definitions/Job"
+        },
+        "Jobs": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/BatchMember"
+          }
+        },
+        "Counts": {
+          "type": "object",
+          "additionalProperties": {
+            "type": "integer"
+          }
+        },
+        "Total": {
+          "type": "integer"
+        },
+        "Progress": {
+          "type": "number"
+        },
+        "EarliestStart": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "LatestCompletion": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "Status": {
+          "type": "string"
+        }
+      }
+    },
+    "JobDependency": {
+      "type": "object",
+      "properties": {
+        "PredecessorID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "SuccessorID": {
+          "type": "string",
+          "format": "uuid"
+        }
+      },
+      "required": [
+        "PredecessorID",
+        "SuccessorID"
+      ]
+    },
+    "JobDependencies": {
+      "type": "object",
+      "properties": {
+        "JobID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "Predecessors": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "format": "uuid"
+          }
+        },
+        "Successors": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "format": "uuid"
+          }
+        },
+        "Ancestors": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "format": "uuid"
+          }
+        },
+        "Descendants": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "format": "uuid"
+          }
+        }
+      }
+    },
+    "OutboundNotification": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "URL": {
+          "type": "string"
+        },
+        "IdempotencyKey": {
+          "type": "string"
+        },
+        "Payload": {
+          "type": "string"
+        },
+        "Status": {
+          "type": "string"
+        },
+        "Attempts": {
+          "type": "integer"
+        },
+        "MaxAttempts": {
+          "type": "integer"
+        },
+        "LastError": {
+          "type": "string"
+        },
+        "NextAttempt": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateCreated": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "DateDelivered": {
+          "type": "string",
+          "format": "date-time"
+        }
+      }
+    },
+    "StreamEvent": {
+      "type": "object",
+      "properties": {
+        "ID": {
+          "type": "integer"
+        },
+        "JobID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "InvocationID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "BatchID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "Submitter": {
+          "type": "string"
+        },
+        "CondorID": {
+          "type": "string"
+        },
+        "AppID": {
+          "type": "string",
+          "format": "uuid"
+        },
+        "FromStatus": {
+          "type": "string"
+        },
+        "ToStatus": {
+          "type": "string"
+        },
+        "Reason": {
+          "type": "string"
+        },
+        "DateTriggered": {
+          "type": "string",
+          "format": "date-time"
+        }
+      }
+    }
+  }
+}
+`
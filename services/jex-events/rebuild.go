@@ -0,0 +1,348 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rebuildPageSize = 100
+
+// JobRebuild is the state of a job worked out again from its raw events, along
+// with the state that was stored for it before. JobEvents contains the job
+// events that are mapped to a different condor event than before,
+// Transitions contains every transition the job should have, and Usage is the
+// resource usage from the job's last terminated event.
+type JobRebuild struct {
+	Before      *JobArchive
+	Job         JobRecord
+	JobEvents   []CondorJobEvent
+	LastEventID string
+	Transitions []*JobStatusTransition
+	Usage       *JobResourceUsage
+}
+
+// rawEventsByDate sorts raw events by the dates they were received.
+type rawEventsByDate []CondorRawEvent
+
+func (s rawEventsByDate) Len() int           { return len(s) }
+func (s rawEventsByDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s rawEventsByDate) Less(i, j int) bool { return s[i].DateTriggered.Before(s[j].DateTriggered) }
+
+// RebuildJob works out the state of the job in the archive by parsing its raw
+// events again and running them through the same steps that ProcessEvents
+// does, in the order they were received. Nothing is stored and nothing is sent
+// upstream. Raw events without a job event are skipped, since their checksums
+// aren't kept anywhere else. The new transitions are recorded at 'now'.
+func RebuildJob(d JobStore, ja *JobArchive, now time.Time) (*JobRebuild, error) {
+	r := &JobRebuild{Before: ja, Job: ja.Job}
+	r.Job.ExitCode = 0
+	r.Job.TerminationKind = TerminationUnknown
+	r.Job.TerminationSignal = 0
+	r.Job.FailureCount = 0
+
+	jobEvents := make(map[string]CondorJobEvent)
+	for _, je := range ja.JobEvents {
+		jobEvents[je.CondorRawEventID] = je.CondorJobEvent
+	}
+	rawEvents := append([]CondorRawEvent{}, ja.RawEvents...)
+	sort.Stable(rawEventsByDate(rawEvents))
+
+	var last *JobStatusTransition
+	for _, re := range rawEvents {
+		je, ok := jobEvents[re.ID]
+		if !ok {
+			logger.Printf("Raw event %s for job %s doesn't have a job event, skipping it", re.ID, ja.Job.ID)
+			continue
+		}
+		event := &Event{Event: re.EventText, Hash: je.Hash}
+		event.parse()
+		applyEvent(&r.Job, event)
+		if event.Usage != nil {
+			r.Usage = event.Usage
+			r.Usage.JobID = r.Job.ID
+			r.Usage.CondorJobEventID = je.ID
+			r.Usage.DateTriggered = re.DateTriggered
+		}
+
+		ce, err := d.GetCondorEventByNumber(event.EventNumber)
+		if err != nil {
+			return nil, fmt.Errorf("error getting condor event '%s' for raw event %s: %s", event.EventNumber, re.ID, err)
+		}
+		if ce.ID != je.CondorEventID {
+			je.CondorEventID = ce.ID
+			r.JobEvents = append(r.JobEvents, je)
+		}
+
+		// the event dates are in local time, like they were when the event
+		// was received.
+		transition, err := NextJobStatus(r.Job.ID, last, event, re.DateTriggered.In(time.Local))
+		if err != nil {
+			continue
+		}
+		if event.Mapping().UpdateLastEvents {
+			r.LastEventID = je.ID
+		}
+		if transition != nil {
+			transition.CondorJobEventID = je.ID
+			transition.DateRecorded = now.Add(time.Duration(len(r.Transitions)) * time.Microsecond)
+			r.Transitions = append(r.Transitions, transition)
+			last = transition
+		}
+	}
+	return r, nil
+}
+
+// beforeTransitions returns the transitions that were stored for the job, in
+// the order they happened.
+func (r *JobRebuild) beforeTransitions() []JobStatusTransition {
+	transitions := append([]JobStatusTransition{}, r.Before.Transitions...)
+	sort.Stable(transitionsByDate(transitions))
+	return transitions
+}
+
+// Status returns the status of the job before and after the rebuild.
+func (r *JobRebuild) Status() (string, string) {
+	before, after := StatusSubmitted, StatusSubmitted
+	if transitions := r.beforeTransitions(); len(transitions) > 0 {
+		before = transitions[len(transitions)-1].ToStatus
+	}
+	if len(r.Transitions) > 0 {
+		after = r.Transitions[len(r.Transitions)-1].ToStatus
+	}
+	return before, after
+}
+
+// TransitionsChanged returns true if the job's transitions are different after
+// the rebuild. The dates they were recorded and the reasons don't count.
+func (r *JobRebuild) TransitionsChanged() bool {
+	before := r.beforeTransitions()
+	if len(before) != len(r.Transitions) {
+		return true
+	}
+	for i, jt := range r.Transitions {
+		b := before[i]
+		if jt.CondorJobEventID != b.CondorJobEventID ||
+			jt.FromStatus != b.FromStatus ||
+			jt.ToStatus != b.ToStatus ||
+			!jt.DateTriggered.Equal(b.DateTriggered) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeTransitions lists the statuses that the transitions moved a job into
+// along with when they happened.
+func describeTransitions(transitions []JobStatusTransition) string {
+	var described []string
+	for _, jt := range transitions {
+		described = append(described, fmt.Sprintf("%s at %s", jt.ToStatus, jt.DateTriggered.Format(time.RFC3339)))
+	}
+	if len(described) == 0 {
+		return "none"
+	}
+	return strings.Join(described, ", ")
+}
+
+// Differences describes what the rebuild changes about the job, one change per
+// string. Nothing is returned if the stored state is already right.
+func (r *JobRebuild) Differences() []string {
+	var diffs []string
+	if before, after := r.Status(); before != after {
+		diffs = append(diffs, fmt.Sprintf("status: %s -> %s", before, after))
+	}
+	job := r.Before.Job
+	if job.ExitCode != r.Job.ExitCode {
+		diffs = append(diffs, fmt.Sprintf("exit code: %d -> %d", job.ExitCode, r.Job.ExitCode))
+	}
+	if job.TerminationKind != r.Job.TerminationKind || job.TerminationSignal != r.Job.TerminationSignal {
+		diffs = append(diffs, fmt.Sprintf("termination: '%s' (signal %d) -> '%s' (signal %d)",
+			job.TerminationKind, job.TerminationSignal, r.Job.TerminationKind, r.Job.TerminationSignal))
+	}
+	if job.FailureCount != r.Job.FailureCount {
+		diffs = append(diffs, fmt.Sprintf("failure count: %d -> %d", job.FailureCount, r.Job.FailureCount))
+	}
+	if job.InvocationID != r.Job.InvocationID {
+		diffs = append(diffs, fmt.Sprintf("invocation ID: '%s' -> '%s'", job.InvocationID, r.Job.InvocationID))
+	}
+	if r.Before.LastEventID != r.LastEventID {
+		diffs = append(diffs, fmt.Sprintf("last event: '%s' -> '%s'", r.Before.LastEventID, r.LastEventID))
+	}
+	if r.TransitionsChanged() {
+		var after []JobStatusTransition
+		for _, jt := range r.Transitions {
+			after = append(after, *jt)
+		}
+		diffs = append(diffs, fmt.Sprintf(
+			"transitions: %s -> %s",
+			describeTransitions(r.beforeTransitions()),
+			describeTransitions(after),
+		))
+	}
+	if !r.Before.Usage.Equal(r.Usage) {
+		switch {
+		case r.Before.Usage == nil:
+			diffs = append(diffs, "resource usage: recorded")
+		case r.Usage == nil:
+			diffs = append(diffs, "resource usage: removed")
+		default:
+			diffs = append(diffs, "resource usage: updated")
+		}
+	}
+	if len(r.JobEvents) > 0 {
+		diffs = append(diffs, fmt.Sprintf("%d job event(s) mapped to a different condor event", len(r.JobEvents)))
+	}
+	return diffs
+}
+
+// RebuildResult counts the jobs that RebuildJobs looked at.
+type RebuildResult struct {
+	Checked  int
+	Changed  int
+	Failed   int
+	Archived int
+}
+
+// RebuildJobs rebuilds the jobs with the given IDs, or every job if no IDs are
+// given, and writes the differences for each job that changes to 'w'. The
+// changes are only stored if 'dryRun' is false. Jobs that can't be rebuilt are
+// logged and counted, and the rest are still rebuilt; the error is only set if
+// the jobs can't be listed. Archived jobs are counted and left alone, since
+// most of their raw events have been pruned. Unless it's a dry run, the events
+// lock is held the whole time so that no events are stored while the jobs are
+// being rebuilt; the error is set if it can't be acquired or is lost.
+func RebuildJobs(d JobStore, ids []string, dryRun bool, w io.Writer) (*RebuildResult, error) {
+	result := &RebuildResult{}
+	holder := lockHolder("rebuild")
+	if !dryRun {
+		if err := acquireLock(d, eventsLock, holder); err != nil {
+			return result, err
+		}
+		defer func() {
+			if err := d.ReleaseServiceLock(eventsLock, holder); err != nil {
+				logger.Printf("Error releasing the %s lock: %s", eventsLock, err)
+			}
+		}()
+	}
+	var lockErr error
+	rebuild := func(id string) {
+		if lockErr != nil {
+			return
+		}
+		result.Checked++
+		ja, err := d.GetJobArchive(id)
+		if err != nil {
+			logger.Printf("Error getting the events for job %s: %s", id, err)
+			result.Failed++
+			return
+		}
+		if !ja.DateArchived.IsZero() {
+			result.Archived++
+			return
+		}
+		r, err := RebuildJob(d, ja, time.Now())
+		if err != nil {
+			logger.Printf("Error rebuilding job %s: %s", id, err)
+			result.Failed++
+			return
+		}
+		diffs := r.Differences()
+		if len(diffs) == 0 {
+			return
+		}
+		if !dryRun {
+			// Renewing the lock before each write keeps it from expiring
+			// during a long rebuild.
+			if lockErr = acquireLock(d, eventsLock, holder); lockErr != nil {
+				return
+			}
+			if err = d.WriteJobRebuild(r); err != nil {
+				logger.Printf("Error storing the rebuilt state of job %s: %s", id, err)
+				result.Failed++
+				return
+			}
+		}
+		result.Changed++
+		fmt.Fprintf(w, "Job %s (Condor ID %s):\n", id, ja.Job.CondorID)
+		for _, diff := range diffs {
+			fmt.Fprintf(w, "\t%s\n", diff)
+		}
+	}
+
+	if len(ids) > 0 {
+		for _, id := range ids {
+			rebuild(id)
+		}
+		return result, lockErr
+	}
+
+	// date_submitted isn't changed by a rebuild, so the pages stay put.
+	f := &JobFilter{Sort: SortDateSubmitted, Limit: rebuildPageSize}
+	for {
+		listings, err := d.ListJobs(f)
+		if err != nil {
+			return result, err
+		}
+		page := f.Page(listings)
+		for _, jl := range page.Jobs {
+			rebuild(jl.ID)
+		}
+		if lockErr != nil {
+			return result, lockErr
+		}
+		if page.NextCursor == "" {
+			return result, nil
+		}
+		last := page.Jobs[len(page.Jobs)-1]
+		f.Cursor = &JobCursor{Sort: f.Sort, ID: last.ID, Value: last.sortValue}
+	}
+}
+
+// RebuildCommand implements the rebuild command, which works out the state of
+// the jobs listed in 'args', or of every job, from their raw events again and
+// stores it. The differences are written to stdout. With --dry-run, they're
+// reported but not stored. The exit code is returned.
+func RebuildCommand(config *Configuration, args []string) int {
+	flags := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Report the changes without storing them.")
+	if err := flags.Parse(args); err != nil {
+		return -1
+	}
+	if config.DBURI == "" {
+		logger.Println("DBURI must be set in the configuration file.")
+		return -1
+	}
+	d, err := OpenJobStore(config)
+	if err != nil {
+		logger.Print(err)
+		return -1
+	}
+	defer d.Close()
+	if eventMappings, err = LoadEventMappings(d, config.EventMappings); err != nil {
+		logger.Print(err)
+		return -1
+	}
+	result, err := RebuildJobs(d, flags.Args(), *dryRun, os.Stdout)
+	if err != nil {
+		logger.Printf("Stopped rebuilding jobs after checking %d of them: %s", result.Checked, err)
+		return -1
+	}
+	if *dryRun {
+		logger.Printf("%d of %d job(s) would change", result.Changed, result.Checked)
+	} else {
+		logger.Printf("Rebuilt %d of %d job(s)", result.Changed, result.Checked)
+	}
+	if result.Archived > 0 {
+		logger.Printf("%d archived job(s) were skipped; restore them to rebuild them", result.Archived)
+	}
+	if result.Failed > 0 {
+		logger.Printf("%d job(s) couldn't be rebuilt", result.Failed)
+		return -1
+	}
+	return 0
+}
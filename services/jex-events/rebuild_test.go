@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebuildJob(t *testing.T) {
+	m := NewMemoryStore()
+	job := processJobEvents(t, m, 100)
+	ja, err := m.GetJobArchive(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RebuildJob(m, ja, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := r.Differences(); len(diffs) != 0 {
+		t.Errorf("Rebuilding a job that was stored correctly changed %v", diffs)
+	}
+	if before, after := r.Status(); before != StatusCompleted || after != StatusCompleted {
+		t.Errorf("The job went from %s to %s", before, after)
+	}
+
+	// A raw event that doesn't have a job event is skipped.
+	ja.JobEvents = ja.JobEvents[:2]
+	if r, err = RebuildJob(m, ja, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, after := r.Status(); after != StatusRunning || !r.TransitionsChanged() {
+		t.Errorf("The job's status was %s without its last job event", after)
+	}
+}
+
+func TestRebuildJobsWithNewMappings(t *testing.T) {
+	m := NewMemoryStore()
+	for _, condorID := range []int{100, 101, 102} {
+		processJobEvents(t, m, condorID)
+	}
+	saved := eventMappings
+	defer func() { eventMappings = saved }()
+	eventMappings = NewEventMappings(append(defaultEventMappings, EventMapping{"001", StatusRunning, StatusHeld, false, true, ActionRunning}))
+
+	var report bytes.Buffer
+	result, err := RebuildJobs(m, nil, true, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 3 || result.Changed != 3 {
+		t.Errorf("The dry run's result was %#v", result)
+	}
+	if n := strings.Count(report.String(), "Held at"); n != 3 {
+		t.Errorf("The dry run reported %d jobs moving into Held:\n%s", n, report.String())
+	}
+	if result, err = RebuildJobs(m, nil, false, &report); err != nil || result.Changed != 3 {
+		t.Errorf("The rebuild's result was %#v, %v", result, err)
+	}
+	job, _ := m.GetJobByCondorID("101")
+	transitions, _ := m.GetJobStatusTransitions(job.ID)
+	if len(transitions) != 3 || transitions[1].ToStatus != StatusHeld {
+		t.Errorf("The rebuilt transitions were %#v", transitions)
+	}
+	if result, _ = RebuildJobs(m, nil, false, &report); result.Changed != 0 {
+		t.Errorf("Rebuilding the jobs again changed %d of them", result.Changed)
+	}
+}
+
+func TestRebuildRecordsUsage(t *testing.T) {
+	m := NewMemoryStore()
+	processTexts(t, &PostEventHandler{DB: m}, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(terminatedEventWithUsage, 100))
+	job, _ := m.GetJobByCondorID("100")
+	recorded, err := m.GetJobResourceUsage(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs that finished before usage was recorded don't have any.
+	m.usage = nil
+	var report bytes.Buffer
+	if _, err = RebuildJobs(m, nil, false, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "resource usage: recorded") {
+		t.Errorf("The rebuild didn't report the usage:\n%s", report.String())
+	}
+	if u, _ := m.GetJobResourceUsage(job.ID); !u.Equal(recorded) {
+		t.Errorf("The rebuilt usage was %#v instead of %#v", u, recorded)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultArchiveInterval = time.Hour
+	archiveBatchSize       = 100
+
+	// archiveTimeFormat is used to name archive files after the time they
+	// were written, so that they sort in the order they were written.
+	archiveTimeFormat = "20060102T150405.000000000Z"
+)
+
+// ErrArchiveRestored is returned when everything in a JobArchive is already in
+// the store it's being restored into.
+var ErrArchiveRestored = errors.New("the archive has already been restored")
+
+// ArchivedJobEvent is a parsed job event in a JobArchive. The event number is
+// kept along with the ID of the condor_events row, since those IDs are
+// different in every database.
+type ArchivedJobEvent struct {
+	CondorJobEvent
+	EventNumber string
+}
+
+// JobArchive is everything that's stored for a job. It's what's written to
+// archive files, one per line, and it's enough to put the job back the way it
+// was, IDs and all. Dependencies includes the job's dependencies in both
+// directions. Usage is nil if the job doesn't have any resource usage.
+// DateArchived is when the job's history was pruned, and is zero until then.
+type JobArchive struct {
+	Job          JobRecord
+	RawEvents    []CondorRawEvent
+	JobEvents    []ArchivedJobEvent
+	LastEventID  string
+	StopRequests []CondorJobStopRequest
+	Transitions  []JobStatusTransition
+	Dependencies []CondorJobDep
+	Usage        *JobResourceUsage
+	DateArchived time.Time
+}
+
+// History returns the IDs of the raw events and status transitions that are
+// pruned once the archive has been written. The latest transition is kept,
+// since the job's status comes from it, and so are the job events that it,
+// the last event, and the resource usage refer to, along with their raw
+// events. Everything else that's pruned goes with the raw events.
+func (ja *JobArchive) History() (rawEventIDs, transitionIDs []string) {
+	transitions := append([]JobStatusTransition(nil), ja.Transitions...)
+	sort.Stable(transitionsByDate(transitions))
+	keptJobEvents := map[string]bool{ja.LastEventID: true}
+	if len(transitions) > 0 {
+		latest := transitions[len(transitions)-1]
+		keptJobEvents[latest.CondorJobEventID] = true
+		for _, jt := range transitions[:len(transitions)-1] {
+			transitionIDs = append(transitionIDs, jt.ID)
+		}
+	}
+	if ja.Usage != nil {
+		keptJobEvents[ja.Usage.CondorJobEventID] = true
+	}
+	keptRawEvents := make(map[string]bool)
+	for _, je := range ja.JobEvents {
+		if keptJobEvents[je.ID] {
+			keptRawEvents[je.CondorRawEventID] = true
+		}
+	}
+	for _, re := range ja.RawEvents {
+		if !keptRawEvents[re.ID] {
+			rawEventIDs = append(rawEventIDs, re.ID)
+		}
+	}
+	return rawEventIDs, transitionIDs
+}
+
+// Archiver writes jobs that reached a terminal status more than Retention ago
+// to gzipped JSONL files in Dir and prunes their history from the JobStore.
+// The jobs themselves stay, along with their status and resource usage.
+type Archiver struct {
+	DB        JobStore
+	Dir       string
+	Retention time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+// NewArchiver returns a pointer to an Archiver set up from the configuration.
+// nil is returned if RetentionDays or ArchiveDir isn't set.
+func NewArchiver(cfg *Configuration, d JobStore) *Archiver {
+	if cfg.RetentionDays <= 0 || cfg.ArchiveDir == "" {
+		return nil
+	}
+	a := &Archiver{
+		DB:        d,
+		Dir:       cfg.ArchiveDir,
+		Retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		Interval:  defaultArchiveInterval,
+		BatchSize: archiveBatchSize,
+	}
+	if cfg.ArchiveInterval > 0 {
+		a.Interval = time.Duration(cfg.ArchiveInterval) * time.Second
+	}
+	return a
+}
+
+// Run archives the expired jobs every Interval until something is sent on the
+// quit channel.
+func (a *Archiver) Run(quit <-chan int) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.ArchiveExpired(time.Now()); err != nil {
+			logger.Printf("Error archiving old jobs: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// ArchiveExpired writes the jobs that reached a terminal status more than
+// Retention before 'now' to archive files and prunes their history from the
+// JobStore. Each batch of jobs is only pruned once its file has been written.
+// The number of jobs that were archived is returned.
+func (a *Archiver) ArchiveExpired(now time.Time) (int, error) {
+	before := now.Add(-a.Retention)
+	archived := 0
+	for {
+		jobs, err := a.DB.GetExpiredJobs(before, a.BatchSize)
+		if err != nil {
+			return archived, err
+		}
+		if len(jobs) == 0 {
+			return archived, nil
+		}
+		archives, path, err := a.archive(jobs)
+		if err != nil {
+			return archived, err
+		}
+		for _, ja := range archives {
+			rawEventIDs, transitionIDs := ja.History()
+			if err = a.DB.PruneJobHistory(ja.Job.ID, rawEventIDs, transitionIDs, now); err != nil {
+				return archived, err
+			}
+			archived++
+		}
+		logger.Printf("Archived %d job(s) to %s", len(jobs), path)
+		if len(jobs) < a.BatchSize {
+			return archived, nil
+		}
+	}
+}
+
+// archive writes the jobs to a new file in the archive directory and returns
+// what was written along with the file's path. The file is written under a
+// temporary name and renamed when it's complete, so a partially written
+// archive never shows up.
+func (a *Archiver) archive(jobs []JobRecord) ([]*JobArchive, string, error) {
+	var archives []*JobArchive
+	for _, job := range jobs {
+		ja, err := a.DB.GetJobArchive(job.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		archives = append(archives, ja)
+	}
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return nil, "", err
+	}
+	tmp, err := ioutil.TempFile(a.Dir, ".jobs-")
+	if err != nil {
+		return nil, "", err
+	}
+	err = WriteJobArchives(tmp, archives)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	path := filepath.Join(a.Dir, "jobs-"+time.Now().UTC().Format(archiveTimeFormat)+".jsonl.gz")
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	return archives, path, nil
+}
+
+// WriteJobArchives writes the archives to w as gzipped JSON, one archive per
+// line.
+func WriteJobArchives(w io.Writer, archives []*JobArchive) error {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	for _, ja := range archives {
+		if err := enc.Encode(ja); err != nil {
+			gz.Close()
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// ReadJobArchives reads the archives written by WriteJobArchives from r and
+// calls fn with each of them. Reading stops at the first error fn returns.
+func ReadJobArchives(r io.Reader, fn func(*JobArchive) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		ja := &JobArchive{}
+		err = dec.Decode(ja)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(ja); err != nil {
+			return err
+		}
+	}
+}
+
+// RestoreArchive puts the jobs in the archive file at 'path' back into the
+// JobStore. Jobs whose archives are already completely in the store are
+// skipped. The number of jobs that were restored and skipped are returned.
+func RestoreArchive(d JobStore, path string) (restored, skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	err = ReadJobArchives(f, func(ja *JobArchive) error {
+		err := d.RestoreJobArchive(ja)
+		switch err {
+		case nil:
+			restored++
+		case ErrArchiveRestored:
+			skipped++
+		default:
+			return err
+		}
+		return nil
+	})
+	return restored, skipped, err
+}
+
+// RestoreCommand implements the restore command, which puts the jobs in the
+// archive files listed in 'paths' back into the database. The exit code is
+// returned.
+func RestoreCommand(config *Configuration, paths []string) int {
+	if len(paths) == 0 {
+		logger.Println("List the archive files to restore after the restore command.")
+		return -1
+	}
+	if config.DBURI == "" {
+		logger.Println("DBURI must be set in the configuration file.")
+		return -1
+	}
+	d, err := OpenJobStore(config)
+	if err != nil {
+		logger.Print(err)
+		return -1
+	}
+	defer d.Close()
+	for _, path := range paths {
+		restored, skipped, err := RestoreArchive(d, path)
+		if err != nil {
+			logger.Printf("Error restoring %s after restoring %d job(s): %s", path, restored, err)
+			return -1
+		}
+		logger.Printf("Restored %d job(s) from %s; %d were already in the database", restored, path, skipped)
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewArchiver(t *testing.T) {
+	if a := NewArchiver(&Configuration{RetentionDays: 30}, NewMemoryStore()); a != nil {
+		t.Error("An archiver was set up without an ArchiveDir")
+	}
+	if a := NewArchiver(&Configuration{ArchiveDir: "/tmp"}, NewMemoryStore()); a != nil {
+		t.Error("An archiver was set up without RetentionDays")
+	}
+	a := NewArchiver(&Configuration{RetentionDays: 30, ArchiveDir: "/tmp", ArchiveInterval: 60}, NewMemoryStore())
+	if a == nil {
+		t.Fatal("The archiver wasn't set up")
+	}
+	if a.Retention != 30*24*time.Hour || a.Interval != time.Minute {
+		t.Errorf("The archiver was %#v", a)
+	}
+}
+
+func TestJobArchiveFiles(t *testing.T) {
+	archives := []*JobArchive{
+		{Job: JobRecord{ID: "1", CondorID: "100"}},
+		{Job: JobRecord{ID: "2", CondorID: "200"}, RawEvents: []CondorRawEvent{{ID: "3", EventText: "raw"}}},
+	}
+	var buf bytes.Buffer
+	if err := WriteJobArchives(&buf, archives); err != nil {
+		t.Fatal(err)
+	}
+	var read []*JobArchive
+	err := ReadJobArchives(&buf, func(ja *JobArchive) error {
+		read = append(read, ja)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[1].Job.CondorID != "200" || read[1].RawEvents[0].EventText != "raw" {
+		t.Errorf("The archives read back were %#v", read)
+	}
+	if err = ReadJobArchives(bytes.NewBufferString("not gzipped"), nil); err == nil {
+		t.Error("An archive that wasn't gzipped was read")
+	}
+}
+
+func TestArchiveExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jex-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := NewMemoryStore()
+	now := time.Now()
+	var oldIDs []string
+	for _, condorID := range []string{"1", "2", "3"} {
+		oldIDs = append(oldIDs, addFinishedJob(t, m, condorID, now.Add(-48*time.Hour)))
+	}
+	newID := addFinishedJob(t, m, "4", now)
+	a := &Archiver{DB: m, Dir: filepath.Join(dir, "archives"), Retention: 24 * time.Hour, BatchSize: 2}
+
+	archived, err := a.ArchiveExpired(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 3 {
+		t.Errorf("%d jobs were archived instead of 3", archived)
+	}
+	for _, id := range oldIDs {
+		ja, err := m.GetJobArchive(id)
+		if err != nil {
+			t.Fatalf("Job %s wasn't kept after it was archived: %v", id, err)
+		}
+		if ja.DateArchived.IsZero() || len(ja.Transitions) != 1 || len(ja.RawEvents) != 1 {
+			t.Errorf("The archived job was %#v", ja)
+		}
+	}
+	if ja, _ := m.GetJobArchive(newID); ja == nil || !ja.DateArchived.IsZero() {
+		t.Errorf("A job that hasn't expired was archived: %#v", ja)
+	}
+	paths, _ := filepath.Glob(filepath.Join(a.Dir, "*"))
+	if len(paths) != 2 {
+		t.Fatalf("The archive directory contained %v instead of two batches", paths)
+	}
+	if archived, _ = a.ArchiveExpired(now); archived != 0 {
+		t.Errorf("%d jobs were archived again", archived)
+	}
+
+	// The jobs' histories were kept because each only has one event, so only
+	// a deleted job is restored.
+	if err = m.DeleteJob(oldIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.GetJob(oldIDs[0]); err != sql.ErrNoRows {
+		t.Fatalf("The job wasn't deleted: %v", err)
+	}
+	restored, skipped := 0, 0
+	for _, path := range paths {
+		r, s, err := RestoreArchive(m, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored += r
+		skipped += s
+	}
+	if restored != 1 || skipped != 2 {
+		t.Errorf("%d jobs were restored and %d skipped", restored, skipped)
+	}
+	if history, _ := m.GetJobEventHistory(oldIDs[0]); len(history) != 1 {
+		t.Errorf("The restored job had %d events instead of 1", len(history))
+	}
+	if restored, skipped, _ = RestoreArchive(m, paths[0]); restored != 0 || skipped != 2 {
+		t.Errorf("Restoring an archive again restored %d jobs and skipped %d", restored, skipped)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// requestIDHeader is the header that carries the ID of a request. Clients can
+// set it to tie their logs to jex-events' logs; otherwise an ID is generated.
+// Either way it's echoed back in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID that's accepted from a client.
+const maxRequestIDLength = 128
+
+// HandlerFunc handles a request that a Router has authorized. 'p' is whoever
+// made the request. It's never nil, but it's empty for unauthenticated
+// requests to public routes.
+type HandlerFunc func(writer http.ResponseWriter, request *http.Request, p *Principal)
+
+// route is a single entry in a Router. Segments of the pattern that are
+// wrapped in braces, like {id}, match any non-empty path segment. access is
+// one of the Access* constants.
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	access   int
+	handler  HandlerFunc
+}
+
+// matches returns true if the path segments match the route's pattern.
+func (rt *route) matches(segments []string) bool {
+	if len(segments) != len(rt.segments) {
+		return false
+	}
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Router dispatches requests to handlers based on the request method and path.
+// Requests for paths that no route matches get a 404, and requests for paths
+// that are matched by routes for other methods get a 405 along with an Allow
+// header. Every request is given an ID, which is returned in the X-Request-ID
+// header and included in error responses.
+//
+// If Auth is set, requests for routes that aren't public have to be
+// authenticated, and admin routes can only be used by admins. If it's not set,
+// every request is treated as coming from an admin when AllowUnauthenticated
+// is set, and only public routes can be used when it isn't.
+type Router struct {
+	Auth                 *Auth
+	AllowUnauthenticated bool
+	routes               []*route
+}
+
+// NewRouter returns a pointer to a new Router without any routes.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// splitPath turns a URL path into its segments, ignoring leading and trailing
+// slashes.
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+// Handle adds a route for requests with the given method and path pattern.
+// 'access' is the access level the route requires; see the Access* constants.
+func (r *Router) Handle(method, pattern string, access int, handler HandlerFunc) {
+	r.routes = append(r.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		access:   access,
+		handler:  handler,
+	})
+}
+
+// anonymousAdmin returns the Principal for requests when authentication is
+// turned off. Each request gets its own.
+func anonymousAdmin() *Principal {
+	return &Principal{Subject: "anonymous", Admin: true}
+}
+
+// authorize checks whether the request can use the route. If it can, the
+// request's Principal is returned. Otherwise, the error response is written and
+// nil is returned.
+func (r *Router) authorize(writer http.ResponseWriter, request *http.Request, rt *route) *Principal {
+	if r.Auth == nil {
+		if r.AllowUnauthenticated {
+			return anonymousAdmin()
+		}
+		if rt.access == AccessPublic {
+			return &Principal{}
+		}
+		LogAPIMsg(request, "Authentication isn't configured")
+		WriteError(writer, http.StatusServiceUnavailable, "Authentication isn't configured")
+		return nil
+	}
+	p, err := r.Auth.Authenticate(request)
+	if err != nil {
+		if rt.access == AccessPublic {
+			return &Principal{}
+		}
+		LogAPIMsg(request, fmt.Sprintf("Authentication failed: %s", err))
+		writer.Header().Set("WWW-Authenticate", strings.Join(r.Auth.Schemes(), ", "))
+		WriteError(writer, http.StatusUnauthorized, err.Error())
+		return nil
+	}
+	if rt.access == AccessAdmin && !p.Admin {
+		LogAPIMsg(request, fmt.Sprintf("%s isn't an admin", p.Subject))
+		WriteError(writer, http.StatusForbidden, fmt.Sprintf("%s %s is only available to admins", rt.method, rt.pattern))
+		return nil
+	}
+	return p
+}
+
+// Routes returns the method and pattern of every route, like "GET /jobs/{id}".
+func (r *Router) Routes() []string {
+	var retval []string
+	for _, rt := range r.routes {
+		retval = append(retval, fmt.Sprintf("%s %s", rt.method, rt.pattern))
+	}
+	return retval
+}
+
+// requestID returns the ID for a request, which is the one the client sent if
+// it's usable or a new UUID if it isn't.
+func requestID(request *http.Request) string {
+	id := request.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength || strings.ContainsAny(id, "\r\n") {
+		return uuid.New()
+	}
+	return id
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	id := requestID(request)
+	request.Header.Set(requestIDHeader, id)
+	writer.Header().Set(requestIDHeader, id)
+	defer func() {
+		if err := recover(); err != nil {
+			LogAPIMsg(request, fmt.Sprintf("Panic while handling request: %s", err))
+			WriteError(writer, http.StatusInternalServerError, "An internal error occurred")
+		}
+	}()
+	LogAPIMsg(request, "Request received; routing")
+
+	// Requests without a method are treated as GETs, like they always have been.
+	method := request.Method
+	if method == "" {
+		method = "GET"
+	}
+	segments := splitPath(request.URL.Path)
+	allowed := map[string]bool{}
+	for _, rt := range r.routes {
+		if !rt.matches(segments) {
+			continue
+		}
+		if rt.method == method {
+			p := r.authorize(writer, request, rt)
+			if p == nil {
+				return
+			}
+			rt.handler(writer, request, p)
+			return
+		}
+		allowed[rt.method] = true
+	}
+	if len(allowed) == 0 {
+		WriteError(writer, http.StatusNotFound, fmt.Sprintf("%s was not found", request.URL.Path))
+		return
+	}
+	var methods []string
+	for m := range allowed {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	writer.Header().Set("Allow", strings.Join(methods, ", "))
+	WriteError(writer, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not supported on %s", request.Method, request.URL.Path))
+}
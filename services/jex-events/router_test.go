@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testRouter() (*Router, *[]string) {
+	var called []string
+	r := NewRouter()
+	r.AllowUnauthenticated = true
+	handler := func(name string) HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+			called = append(called, name)
+		}
+	}
+	r.Handle("GET", "/jobs", AccessUser, handler("list"))
+	r.Handle("POST", "/jobs", AccessUser, handler("post"))
+	r.Handle("GET", "/jobs/{id}", AccessUser, handler("get"))
+	r.Handle("GET", "/jobs/{id}/stop", AccessUser, handler("get stop"))
+	r.Handle("POST", "/jobs/{id}/stop", AccessUser, handler("post stop"))
+	return r, &called
+}
+
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder) APIError {
+	var parsed ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &parsed); err != nil {
+		t.Fatalf("The error response wasn't JSON: %s", recorder.Body.String())
+	}
+	return parsed.Error
+}
+
+func TestRouterDispatch(t *testing.T) {
+	r, called := testRouter()
+	cases := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/jobs", "list"},
+		{"GET", "/jobs/", "list"},
+		{"POST", "/jobs", "post"},
+		{"GET", "/jobs/abc", "get"},
+		{"", "/jobs/abc", "get"},
+		{"POST", "/jobs/abc/stop", "post stop"},
+	}
+	for _, c := range cases {
+		*called = nil
+		request, _ := http.NewRequest(c.method, c.path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), request)
+		if len(*called) != 1 || (*called)[0] != c.want {
+			t.Errorf("%s %s called %v instead of %s", c.method, c.path, *called, c.want)
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r, called := testRouter()
+	request, _ := http.NewRequest("GET", "/jobs/abc/nope", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if len(*called) != 0 {
+		t.Errorf("A handler was called: %v", *called)
+	}
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("The status was %d instead of 404", recorder.Code)
+	}
+	apiErr := decodeError(t, recorder)
+	if apiErr.Status != http.StatusNotFound || apiErr.Code != "not_found" {
+		t.Errorf("The error was %#v", apiErr)
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	r, _ := testRouter()
+	request, _ := http.NewRequest("DELETE", "/jobs/abc/stop", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("The status was %d instead of 405", recorder.Code)
+	}
+	if allow := recorder.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("The Allow header was '%s'", allow)
+	}
+	if apiErr := decodeError(t, recorder); apiErr.Code != "method_not_allowed" {
+		t.Errorf("The error code was %s", apiErr.Code)
+	}
+}
+
+func TestRouterRequestIDs(t *testing.T) {
+	r, _ := testRouter()
+	request, _ := http.NewRequest("GET", "/nope", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	generated := recorder.Header().Get(requestIDHeader)
+	if generated == "" {
+		t.Fatal("A request ID wasn't generated")
+	}
+	if apiErr := decodeError(t, recorder); apiErr.RequestID != generated {
+		t.Errorf("The error's request ID was '%s' instead of '%s'", apiErr.RequestID, generated)
+	}
+
+	request, _ = http.NewRequest("GET", "/jobs", nil)
+	request.Header.Set(requestIDHeader, "from-the-client")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if id := recorder.Header().Get(requestIDHeader); id != "from-the-client" {
+		t.Errorf("The client's request ID wasn't used: %s", id)
+	}
+
+	request, _ = http.NewRequest("GET", "/jobs", nil)
+	request.Header.Set(requestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if id := recorder.Header().Get(requestIDHeader); len(id) > maxRequestIDLength {
+		t.Error("An overly long request ID was used")
+	}
+}
+
+func TestRouterRecoversFromPanics(t *testing.T) {
+	r := NewRouter()
+	r.AllowUnauthenticated = true
+	r.Handle("GET", "/panic", AccessUser, func(writer http.ResponseWriter, request *http.Request, p *Principal) {
+		panic("oops")
+	})
+	request, _ := http.NewRequest("GET", "/panic", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("The status was %d instead of 500", recorder.Code)
+	}
+}
+
+func TestOpenAPIDocumentCoversRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPIDocument), &doc); err != nil {
+		t.Fatalf("The OpenAPI document isn't valid JSON: %s", err)
+	}
+	api := &HTTPAPI{}
+	for _, rt := range api.Router().Routes() {
+		parts := strings.SplitN(rt, " ", 2)
+		if _, ok := doc.Paths[parts[1]][strings.ToLower(parts[0])]; !ok {
+			t.Errorf("%s isn't in the OpenAPI document", rt)
+		}
+	}
+}
@@ -0,0 +1,820 @@
+// This file was generated by gen_schema.go from databases/jex-db. DO NOT EDIT.
+
+package main
+
+// schemaFiles are the SQL files that set up a new database, in order.
+var schemaFiles = []SchemaFile{
+	{Name: "extensions/uuid.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- Adds functions for generating UUIDs.
+--
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+`},
+	{Name: "tables/01_jobs.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- jobs table
+--
+CREATE TABLE jobs (
+  id                uuid not null default uuid_generate_v1(), -- primary key
+  batch_id          uuid, -- self-join foreign key
+  condor_id         character varying(32) not null,
+  submitter         character varying(512) not null,
+  invocation_id     uuid,
+  date_submitted    timestamp with time zone,
+  date_started      timestamp with time zone,
+  date_completed    timestamp with time zone,
+  app_id            uuid,
+  exit_code         integer, -- nullable because the job might be running
+  failure_threshold integer NOT NULL,
+  failure_count     integer,
+  termination_kind  character varying(16) not null default '', -- normal, signal, or core dump
+  termination_signal integer not null default 0,
+  date_archived     timestamp with time zone -- set once the job's event history is archived
+);
+`},
+	{Name: "tables/02_condor_events.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- condor_events
+--
+CREATE TABLE condor_events (
+  id           uuid not null default uuid_generate_v1(), -- primary key
+  event_number varchar(3) not null,
+  event_name   text not null,
+  event_desc   text not null,
+  -- optional overrides for the event mappings built into jex-events. A row
+  -- is only used as a mapping if both de_status and action are set.
+  de_status          varchar(32),
+  job_status         varchar(32),
+  terminal           boolean,
+  update_last_events boolean,
+  action             varchar(32)
+);
+`},
+	{Name: "tables/03_condor_raw_events.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- condor_raw_events
+--
+CREATE TABLE condor_raw_events (
+  id             uuid not null default uuid_generate_v1(), -- primary key
+  job_id         uuid not null, -- foreign key into the jobs table
+  event_text     text not null,
+  date_triggered timestamp with time zone not null
+);
+`},
+	{Name: "tables/04_condor_job_events.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- condor_job_events
+--
+CREATE TABLE condor_job_events(
+  id                  uuid not null default uuid_generate_v1(), -- primary key
+  job_id              uuid not null, -- foreign key into the jobs table
+  condor_event_id     uuid not null, -- foreign key into the condor_events table
+  condor_raw_event_id uuid not null, -- foreign key into the condor_raw_events table
+  checksum            varchar(64),
+  date_triggered      timestamp with time zone not null
+);
+`},
+	{Name: "tables/05_last_condor_job_event.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- last_condor_job_event
+--
+CREATE TABLE last_condor_job_events (
+  job_id uuid not null, -- foreign key into the jobs table
+  condor_job_event_id uuid not null -- foreign key into the job_events table
+);
+`},
+	{Name: "tables/06_condor_job_stop_requests.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- condor_job_stop_requests
+--
+CREATE TABLE condor_job_stop_requests (
+  id                  uuid not null default uuid_generate_v1(),
+  job_id              uuid not null, -- foreign key into jobs table
+  username            character varying(512) not null,
+  date_requested      timestamp with time zone not null,
+  reason              text,
+  date_confirmed      timestamp with time zone, -- set when the job's abort event arrives
+  condor_job_event_id uuid -- foreign key into the condor_job_events table for the abort event
+);
+`},
+	{Name: "tables/07_condor_job_deps.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- condor_job_deps
+--
+CREATE TABLE condor_job_deps (
+  successor_id   uuid not null, -- foreign key into the jobs table
+  predecessor_id uuid not null -- also a foreign key into the jobs table
+);
+`},
+	{Name: "tables/08_version.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- The ID sequence used for database version records.
+--
+CREATE SEQUENCE version_id_seq;
+
+--
+-- A table listing database versions along with the date they were applied.
+--
+CREATE TABLE version (
+    id bigint DEFAULT nextval('version_id_seq'),
+    version character varying(20) NOT NULL,
+    applied timestamp DEFAULT now()
+);
+`},
+	{Name: "tables/09_job_status_transitions.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- job_status_transitions
+--
+CREATE TABLE job_status_transitions (
+  id                  uuid not null default uuid_generate_v1(), -- primary key
+  job_id              uuid not null, -- foreign key into the jobs table
+  condor_job_event_id uuid not null, -- foreign key into the condor_job_events table
+  from_status         varchar(32) not null,
+  to_status           varchar(32) not null,
+  reason              text,
+  date_triggered      timestamp with time zone not null,
+  date_recorded       timestamp with time zone not null default now(),
+  sequence            bigserial not null -- orders transitions for the status streams
+);
+`},
+	{Name: "tables/10_outbound_notifications.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- outbound_notifications
+--
+CREATE TABLE outbound_notifications (
+  id              uuid not null default uuid_generate_v1(), -- primary key
+  url             text not null,
+  idempotency_key text not null,
+  payload         text not null,
+  status          varchar(16) not null, -- pending, delivered, or dead
+  attempts        integer not null default 0,
+  max_attempts    integer not null,
+  last_error      text not null default '',
+  next_attempt    timestamp with time zone not null,
+  date_created    timestamp with time zone not null default now(),
+  date_delivered  timestamp with time zone,
+  invocation_id   uuid -- notifications for the same job are delivered in order
+);
+`},
+	{Name: "tables/11_job_resource_usage.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- job_resource_usage
+--
+CREATE TABLE job_resource_usage (
+  job_id              uuid not null, -- primary key, foreign key into the jobs table
+  condor_job_event_id uuid not null, -- foreign key into the condor_job_events table for the terminated event
+  user_cpu_seconds    bigint not null default 0,
+  sys_cpu_seconds     bigint not null default 0,
+  bytes_sent          bigint not null default 0,
+  bytes_received      bigint not null default 0,
+  cpus_requested      bigint not null default 0,
+  cpus_allocated      bigint not null default 0,
+  memory_usage_mb     bigint not null default 0,
+  memory_requested_mb bigint not null default 0,
+  memory_allocated_mb bigint not null default 0,
+  disk_usage_kb       bigint not null default 0,
+  disk_requested_kb   bigint not null default 0,
+  disk_allocated_kb   bigint not null default 0,
+  date_triggered      timestamp with time zone not null
+);
+`},
+	{Name: "tables/12_service_locks.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- service_locks
+--
+CREATE TABLE service_locks (
+  name          text not null, -- primary key
+  holder        text not null, -- who holds the lock, e.g. "events on <host> (pid <pid>)"
+  date_acquired timestamp with time zone not null,
+  date_expires  timestamp with time zone not null -- the lock is free after this unless it's renewed
+);
+`},
+	{Name: "tables/13_job_flags.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- job_flags
+--
+CREATE TABLE job_flags (
+  job_id   uuid not null, -- foreign key into the jobs table
+  flag     text not null, -- what happened, e.g. failure_threshold_enforced
+  date_set timestamp with time zone not null
+);
+`},
+	{Name: "tables/99_constraints.sql", SQL: `--
+-- Primary key for the jobs table
+--
+ALTER TABLE ONLY jobs
+    ADD CONSTRAINT jobs_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Primary key for the condor_events table
+--
+ALTER TABLE ONLY condor_events
+    ADD CONSTRAINT condor_events_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Primary key for the condor_raw_events table
+--
+ALTER TABLE ONLY condor_raw_events
+    ADD CONSTRAINT condor_raw_events_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Foreign key into the jobs table from condor_raw_events
+--
+ALTER TABLE ONLY condor_raw_events
+    ADD CONSTRAINT condor_raw_events_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the condor_job_events table
+--
+ALTER TABLE ONLY condor_job_events
+    ADD CONSTRAINT condor_job_events_pkey
+    PRIMARY KEY (id);
+
+--
+-- Foreign key into the jobs table from condor_job_events
+--
+ALTER TABLE ONLY condor_job_events
+    ADD CONSTRAINT condor_job_events_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+--
+-- Foreign key into the condor_event table from condor_job_events
+--
+ALTER TABLE ONLY condor_job_events
+    ADD CONSTRAINT condor_job_events_condor_event_id_fkey
+    FOREIGN KEY (condor_event_id)
+    REFERENCES condor_events(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_event table from condor_job_events
+--
+ALTER TABLE ONLY condor_job_events
+    ADD CONSTRAINT condor_job_events_condor_raw_event_id_fkey
+    FOREIGN KEY (condor_raw_event_id)
+    REFERENCES condor_raw_events(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the last_condor_job_event table
+--
+ALTER TABLE ONLY last_condor_job_events
+    ADD CONSTRAINT last_condor_job_events_pkey
+    PRIMARY KEY (job_id);
+
+
+--
+-- Foreign key into the jobs table for last_condor_job_events
+--
+ALTER TABLE ONLY last_condor_job_events
+    ADD CONSTRAINT last_condor_job_events_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_job_events table for last_condor_job_events
+--
+ALTER TABLE ONLY last_condor_job_events
+    ADD CONSTRAINT last_condor_job_events_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the condor_job_stop_requests table
+--
+ALTER TABLE ONLY condor_job_stop_requests
+    ADD CONSTRAINT condor_job_stop_requests_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Foreign key into the jobs table for condor_job_stop_requests
+--
+ALTER TABLE ONLY condor_job_stop_requests
+    ADD CONSTRAINT condor_job_stop_requests_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for condor_job_deps
+--
+ALTER TABLE ONLY condor_job_deps
+    ADD CONSTRAINT condor_job_deps_pkey
+    PRIMARY KEY (successor_id);
+
+
+--
+-- Foreign key into jobs for condor_job_deps
+--
+ALTER TABLE ONLY condor_job_deps
+    ADD CONSTRAINT condor_job_deps_successor_id_fkey
+    FOREIGN KEY (successor_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into jobs for condor_job_deps
+--
+ALTER TABLE ONLY condor_job_deps
+    ADD CONSTRAINT condor_job_deps_predecessor_id_fkey
+    FOREIGN KEY (predecessor_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the version table
+--
+ALTER TABLE ONLY version
+    ADD CONSTRAINT version_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Primary key for the job_status_transitions table
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Foreign key into the jobs table for job_status_transitions
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_job_events table for job_status_transitions
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE CASCADE;
+
+
+--
+-- Primary key for the outbound_notifications table
+--
+ALTER TABLE ONLY outbound_notifications
+    ADD CONSTRAINT outbound_notifications_pkey
+    PRIMARY KEY (id);
+
+
+--
+-- Idempotency keys must be unique for outbound_notifications
+--
+ALTER TABLE ONLY outbound_notifications
+    ADD CONSTRAINT outbound_notifications_idempotency_key_key
+    UNIQUE (idempotency_key);
+
+
+--
+-- Foreign key into the condor_job_events table for condor_job_stop_requests
+--
+ALTER TABLE ONLY condor_job_stop_requests
+    ADD CONSTRAINT condor_job_stop_requests_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE SET NULL;
+
+
+--
+-- Indexes for job listings and searches
+--
+CREATE INDEX jobs_submitter_date_submitted_idx ON jobs(submitter, date_submitted, id);
+CREATE INDEX jobs_app_id_idx ON jobs(app_id);
+CREATE INDEX jobs_batch_id_idx ON jobs(batch_id);
+CREATE INDEX jobs_date_submitted_idx ON jobs(date_submitted, id);
+CREATE INDEX jobs_date_completed_idx ON jobs(date_completed, id);
+CREATE INDEX jobs_exit_code_idx ON jobs(exit_code);
+CREATE INDEX job_status_transitions_job_id_date_triggered_idx
+    ON job_status_transitions(job_id, date_triggered, date_recorded);
+
+
+--
+-- Indexes for the lookups done for every event, and for the foreign keys that
+-- deleting a job cascades through.
+--
+CREATE INDEX jobs_condor_id_idx ON jobs(condor_id);
+CREATE INDEX condor_job_events_checksum_idx ON condor_job_events(checksum);
+CREATE INDEX condor_raw_events_job_id_idx ON condor_raw_events(job_id);
+CREATE INDEX condor_job_events_job_id_idx ON condor_job_events(job_id);
+CREATE INDEX condor_job_events_condor_raw_event_id_idx ON condor_job_events(condor_raw_event_id);
+CREATE INDEX last_condor_job_events_condor_job_event_id_idx ON last_condor_job_events(condor_job_event_id);
+CREATE INDEX condor_job_stop_requests_job_id_idx ON condor_job_stop_requests(job_id);
+CREATE INDEX condor_job_stop_requests_condor_job_event_id_idx ON condor_job_stop_requests(condor_job_event_id);
+CREATE INDEX condor_job_deps_predecessor_id_idx ON condor_job_deps(predecessor_id);
+CREATE INDEX job_status_transitions_condor_job_event_id_idx ON job_status_transitions(condor_job_event_id);
+
+
+--
+-- Transition sequence numbers must be unique; they're the event IDs in the
+-- status streams.
+--
+ALTER TABLE ONLY job_status_transitions
+    ADD CONSTRAINT job_status_transitions_sequence_key
+    UNIQUE (sequence);
+
+
+--
+-- Primary key for the job_resource_usage table
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_pkey
+    PRIMARY KEY (job_id);
+
+
+--
+-- Primary key for the service_locks table
+--
+ALTER TABLE ONLY service_locks
+    ADD CONSTRAINT service_locks_pkey
+    PRIMARY KEY (name);
+
+
+--
+-- Primary key for the job_flags table. Each flag is only set once per job.
+--
+ALTER TABLE ONLY job_flags
+    ADD CONSTRAINT job_flags_pkey
+    PRIMARY KEY (job_id, flag);
+
+
+--
+-- Foreign key into the jobs table for job_flags
+--
+ALTER TABLE ONLY job_flags
+    ADD CONSTRAINT job_flags_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the jobs table for job_resource_usage
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_job_id_fkey
+    FOREIGN KEY (job_id)
+    REFERENCES jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Foreign key into the condor_job_events table for job_resource_usage
+--
+ALTER TABLE ONLY job_resource_usage
+    ADD CONSTRAINT job_resource_usage_condor_job_event_id_fkey
+    FOREIGN KEY (condor_job_event_id)
+    REFERENCES condor_job_events(id) ON DELETE CASCADE;
+
+
+--
+-- Usage reports are filtered by when the jobs terminated.
+--
+CREATE INDEX job_resource_usage_date_triggered_idx ON job_resource_usage(date_triggered);
+CREATE INDEX job_resource_usage_condor_job_event_id_idx ON job_resource_usage(condor_job_event_id);
+
+
+--
+-- A job's pending notifications are looked up before each delivery so that
+-- they go out in order.
+--
+CREATE INDEX outbound_notifications_invocation_id_idx ON outbound_notifications(invocation_id, url, date_created);
+`},
+	{Name: "views/01_dead_outbound_notifications.sql", SQL: `SET search_path = public, pg_catalog;
+
+--
+-- dead_outbound_notifications view containing the outbound notifications that
+-- ran out of delivery attempts.
+--
+CREATE VIEW dead_outbound_notifications AS
+    SELECT *
+      FROM outbound_notifications
+     WHERE status = 'dead';
+`},
+	{Name: "data/01_condor_events.sql", SQL: `INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('000', 'Job submitted', 'This event occurs when a user submits a job. It is the first event you will see for a job, and it should only occur once.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('001', 'Job executing', 'This shows up when a job is running. It might occur more than once.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('002', 'Error in executable', 'The job could not be run because the executable was bad.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('003', 'Job was checkpointed', 'The job''s complete state was written to a checkpoint file. This might happen without the job being removed from a machine, because the checkpointing can happen periodically.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('004', 'Job evicted from machine', 'A job was removed from a machine before it finished, usually for a policy reason. Perhaps an interactive user has claimed the computer, or perhaps another job is higher priority.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('005', 'Job terminated', 'The job has completed.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('006', 'Image size of job updated', 'An informational event, to update the amount of memory that the job is using while running. It does not reflect the state of the job.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('007', 'Shadow exception', 'The condor_shadow, a program on the submit computer that watches over the job and performs some services for the job, failed for some catastrophic reason. The job will leave the machine and go back into the queue.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('008', 'Generic log event', 'Not used.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('009', 'Job aborted', 'The user canceled the job.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('010', 'Job was suspended', 'The job is still on the computer, but it is no longer executing. This is usually for a policy reason, such as an interactive user using the computer.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('011', 'Job was unsuspended', 'The job has resumed execution, after being suspended earlier.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('012', 'Job was held', 'The job has transitioned to the hold state. This might happen if the user applies the condor_hold command to the job.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('013', 'Job was released', 'The job was in the hold state and is to be re-run.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('014', 'Parallel node executed', 'A parallel universe program is running on a node.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('015', 'Parallel node terminated', 'A parallel universe program has completed on a node.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('016', 'POST script terminated', 'A node in a DAGMan work flow has a script that should be run after a job. The script is run on the submit host. This event signals that the post script has completed.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('017', 'Job submitted to Globus', 'A grid job has been delegated to Globus (version 2, 3, or 4). This event is no longer used.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('018', 'Globus submit failed', 'The attempt to delegate a job to Globus failed.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('019', 'Globus resource up', 'The Globus resource that a job wants to run on was unavailable, but is now available. This event is no longer used.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('020', 'Detected Down Globus Resource', 'The Globus resource that a job wants to run on has become unavailable. This event is no longer used.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('021', 'Remote error', 'The condor_starter (which monitors the job on the execution machine) has failed.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('022', 'Remote system call socket lost', 'The condor_shadow and condor_starter (which communicate while the job runs) have lost contact.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('023', 'Remote system call socket reestablished', 'The condor_shadow and condor_starter (which communicate while the job runs) have been able to resume contact before the job lease expired.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('024', 'Remote system call reconnect failure', 'The condor_shadow and condor_starter (which communicate while the job runs) were unable to resume contact before the job lease expired.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('025', 'Grid Resource Back Up', 'A grid resource that was previously unavailable is now available.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('026', 'Detected Down Grid Resource', 'The grid resource that a job is to run on is unavailable.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('027', 'Job submitted to grid resource', 'A job has been submitted, and is under the auspices of the grid resource.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('028', 'Job ad information event triggered.', 'Extra job ClassAd attributes are noted. This event is written as a supplement to other events when the configuration parameter EVENT_LOG_JOB_AD_INFORMATION_ATTRS is set.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('029', 'The job''s remote status is unknown', 'No updates of the job''s remote status have been received for 15 minutes.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('030', 'The job''s remote status is known again', 'An update has been received for a job whose remote status was previous logged as unknown.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('031', 'Job stage in', 'A grid universe job is doing the stage in of input files.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('032', 'Job stage out', 'A grid universe job is doing the stage out of output files.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('033', 'Job ClassAd attribute update', 'A Job ClassAd attribute is changed due to action by the condor_schedd daemon. This includes changes by condor_prio.');
+
+INSERT INTO condor_events (event_number, event_name, event_desc)
+  VALUES ('034', 'Pre Skip event', 'For DAGMan, this event is logged if a PRE SCRIPT exits with the defined PRE_SKIP value in the DAG input file. This makes it possible for DAGMan to do recovery in a workflow that has such an event, as it would otherwise not have any event for the DAGMan node to which the script belongs, and in recovery, DAGMan''s internal tables would become corrupted.');
+`},
+	{Name: "data/99_version.sql", SQL: `INSERT INTO version (version) VALUES ('2.0.0:20150815.04');
+`},
+}
+
+// schemaVersion is the version of the database that schemaFiles set up.
+const schemaVersion = "2.0.0:20150815.04"
+
+// migrations are the conversions from jex-db, in order.
+var migrations = []Migration{
+	{Version: "1.9.3:20141031.01", Statements: []string{
+		`ALTER TABLE jobs ADD COLUMN condor_id varchar(32) not null`,
+	}},
+	{Version: "1.9.3:20141104.01", Statements: []string{
+		`ALTER TABLE ONLY jobs DROP COLUMN IF EXISTS command_line`,
+		`ALTER TABLE ONLY jobs DROP COLUMN IF EXISTS env_variables`,
+	}},
+	{Version: "1.9.3:20141105.01", Statements: []string{
+		`ALTER TABLE ONLY jobs ALTER COLUMN app_id DROP NOT NULL`,
+	}},
+	{Version: "1.9.3:20141105.02", Statements: []string{
+		`ALTER TABLE ONLY jobs DROP CONSTRAINT batch_id_fkey`,
+	}},
+	{Version: "1.9.3:20141105.03", Statements: []string{
+		`ALTER TABLE ONLY condor_events ALTER COLUMN event_number SET DATA TYPE character varying(3)`,
+		`ALTER TABLE ONLY condor_events ALTER COLUMN event_number SET NOT NULL`,
+	}},
+	{Version: "1.9.3:20141106.01", Statements: []string{
+		`ALTER TABLE ONLY jobs ADD COLUMN invocation_id uuid`,
+	}},
+	{Version: "1.9.3:20141114.01", Statements: []string{
+		`ALTER TABLE ONLY condor_job_events ADD COLUMN checksum varchar(64)`,
+	}},
+	{Version: "2.0.0:20150803.01", Statements: []string{
+		`CREATE TABLE job_status_transitions (
+               id                  uuid not null default uuid_generate_v1(),
+               job_id              uuid not null,
+               condor_job_event_id uuid not null,
+               from_status         varchar(32) not null,
+               to_status           varchar(32) not null,
+               reason              text,
+               date_triggered      timestamp with time zone not null,
+               date_recorded       timestamp with time zone not null default now()
+             )`,
+		`ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_pkey
+               PRIMARY KEY (id)`,
+		`ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE`,
+		`ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE CASCADE`,
+	}},
+	{Version: "2.0.0:20150805.01", Statements: []string{
+		`CREATE TABLE outbound_notifications (
+               id              uuid not null default uuid_generate_v1(),
+               url             text not null,
+               idempotency_key text not null,
+               payload         text not null,
+               status          varchar(16) not null,
+               attempts        integer not null default 0,
+               max_attempts    integer not null,
+               last_error      text not null default '',
+               next_attempt    timestamp with time zone not null,
+               date_created    timestamp with time zone not null default now(),
+               date_delivered  timestamp with time zone
+             )`,
+		`ALTER TABLE ONLY outbound_notifications
+               ADD CONSTRAINT outbound_notifications_pkey
+               PRIMARY KEY (id)`,
+		`ALTER TABLE ONLY outbound_notifications
+               ADD CONSTRAINT outbound_notifications_idempotency_key_key
+               UNIQUE (idempotency_key)`,
+		`CREATE VIEW dead_outbound_notifications AS
+               SELECT *
+                 FROM outbound_notifications
+                WHERE status = 'dead'`,
+	}},
+	{Version: "2.0.0:20150807.01", Statements: []string{
+		`ALTER TABLE ONLY condor_events ADD COLUMN de_status varchar(32)`,
+		`ALTER TABLE ONLY condor_events ADD COLUMN job_status varchar(32)`,
+		`ALTER TABLE ONLY condor_events ADD COLUMN terminal boolean`,
+		`ALTER TABLE ONLY condor_events ADD COLUMN update_last_events boolean`,
+		`ALTER TABLE ONLY condor_events ADD COLUMN action varchar(32)`,
+	}},
+	{Version: "2.0.0:20150809.01", Statements: []string{
+		`ALTER TABLE ONLY condor_job_stop_requests ADD COLUMN date_confirmed timestamp with time zone`,
+		`ALTER TABLE ONLY condor_job_stop_requests ADD COLUMN condor_job_event_id uuid`,
+		`ALTER TABLE ONLY condor_job_stop_requests
+               ADD CONSTRAINT condor_job_stop_requests_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE SET NULL`,
+	}},
+	{Version: "2.0.0:20150810.01", Statements: []string{
+		`CREATE INDEX jobs_submitter_date_submitted_idx ON jobs(submitter, date_submitted, id)`,
+		`CREATE INDEX jobs_app_id_idx ON jobs(app_id)`,
+		`CREATE INDEX jobs_batch_id_idx ON jobs(batch_id)`,
+		`CREATE INDEX jobs_date_submitted_idx ON jobs(date_submitted, id)`,
+		`CREATE INDEX jobs_date_completed_idx ON jobs(date_completed, id)`,
+		`CREATE INDEX jobs_exit_code_idx ON jobs(exit_code)`,
+		`CREATE INDEX job_status_transitions_job_id_date_triggered_idx
+               ON job_status_transitions(job_id, date_triggered, date_recorded)`,
+	}},
+	{Version: "2.0.0:20150811.01", Statements: []string{
+		`ALTER TABLE ONLY job_status_transitions ADD COLUMN sequence bigserial not null`,
+		`ALTER TABLE ONLY job_status_transitions
+               ADD CONSTRAINT job_status_transitions_sequence_key
+               UNIQUE (sequence)`,
+	}},
+	{Version: "2.0.0:20150812.01", Statements: []string{
+		`CREATE INDEX jobs_condor_id_idx ON jobs(condor_id)`,
+		`CREATE INDEX condor_job_events_checksum_idx ON condor_job_events(checksum)`,
+		`CREATE INDEX condor_raw_events_job_id_idx ON condor_raw_events(job_id)`,
+		`CREATE INDEX condor_job_events_job_id_idx ON condor_job_events(job_id)`,
+		`CREATE INDEX condor_job_events_condor_raw_event_id_idx ON condor_job_events(condor_raw_event_id)`,
+		`CREATE INDEX last_condor_job_events_condor_job_event_id_idx ON last_condor_job_events(condor_job_event_id)`,
+		`CREATE INDEX condor_job_stop_requests_job_id_idx ON condor_job_stop_requests(job_id)`,
+		`CREATE INDEX condor_job_stop_requests_condor_job_event_id_idx ON condor_job_stop_requests(condor_job_event_id)`,
+		`CREATE INDEX condor_job_deps_predecessor_id_idx ON condor_job_deps(predecessor_id)`,
+		`CREATE INDEX job_status_transitions_condor_job_event_id_idx ON job_status_transitions(condor_job_event_id)`,
+	}},
+	{Version: "2.0.0:20150813.01", Statements: []string{
+		`CREATE TABLE job_resource_usage (
+               job_id              uuid not null,
+               condor_job_event_id uuid not null,
+               user_cpu_seconds    bigint not null default 0,
+               sys_cpu_seconds     bigint not null default 0,
+               bytes_sent          bigint not null default 0,
+               bytes_received      bigint not null default 0,
+               cpus_requested      bigint not null default 0,
+               cpus_allocated      bigint not null default 0,
+               memory_usage_mb     bigint not null default 0,
+               memory_requested_mb bigint not null default 0,
+               memory_allocated_mb bigint not null default 0,
+               disk_usage_kb       bigint not null default 0,
+               disk_requested_kb   bigint not null default 0,
+               disk_allocated_kb   bigint not null default 0,
+               date_triggered      timestamp with time zone not null
+             )`,
+		`ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_pkey
+               PRIMARY KEY (job_id)`,
+		`ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE`,
+		`ALTER TABLE ONLY job_resource_usage
+               ADD CONSTRAINT job_resource_usage_condor_job_event_id_fkey
+               FOREIGN KEY (condor_job_event_id)
+               REFERENCES condor_job_events(id) ON DELETE CASCADE`,
+		`CREATE INDEX job_resource_usage_date_triggered_idx ON job_resource_usage(date_triggered)`,
+		`CREATE INDEX job_resource_usage_condor_job_event_id_idx ON job_resource_usage(condor_job_event_id)`,
+	}},
+	{Version: "2.0.0:20150814.01", Statements: []string{
+		`ALTER TABLE ONLY jobs ADD COLUMN termination_kind varchar(16) not null default ''`,
+		`ALTER TABLE ONLY jobs ADD COLUMN termination_signal integer not null default 0`,
+	}},
+	{Version: "2.0.0:20150815.01", Statements: []string{
+		`ALTER TABLE ONLY jobs ADD COLUMN date_archived timestamp with time zone`,
+	}},
+	{Version: "2.0.0:20150815.02", Statements: []string{
+		`ALTER TABLE ONLY outbound_notifications ADD COLUMN invocation_id uuid`,
+		`CREATE INDEX outbound_notifications_invocation_id_idx ON outbound_notifications(invocation_id, url, date_created)`,
+		`CREATE OR REPLACE VIEW dead_outbound_notifications AS SELECT * FROM outbound_notifications WHERE status = 'dead'`,
+	}},
+	{Version: "2.0.0:20150815.03", Statements: []string{
+		`CREATE TABLE service_locks (
+               name          text not null,
+               holder        text not null,
+               date_acquired timestamp with time zone not null,
+               date_expires  timestamp with time zone not null
+             )`,
+		`ALTER TABLE ONLY service_locks
+               ADD CONSTRAINT service_locks_pkey
+               PRIMARY KEY (name)`,
+	}},
+	{Version: "2.0.0:20150815.04", Statements: []string{
+		`CREATE TABLE job_flags (
+               job_id   uuid not null,
+               flag     text not null,
+               date_set timestamp with time zone not null
+             )`,
+		`ALTER TABLE ONLY job_flags
+               ADD CONSTRAINT job_flags_pkey
+               PRIMARY KEY (job_id, flag)`,
+		`ALTER TABLE ONLY job_flags
+               ADD CONSTRAINT job_flags_job_id_fkey
+               FOREIGN KEY (job_id)
+               REFERENCES jobs(id) ON DELETE CASCADE`,
+	}},
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHTTPReadTimeout is how long a client gets to send a request when
+	// HTTPReadTimeout isn't set.
+	defaultHTTPReadTimeout = 30 * time.Second
+
+	// defaultHTTPIdleTimeout is how long keep-alive connections are left open
+	// between requests when HTTPIdleTimeout isn't set.
+	defaultHTTPIdleTimeout = 120 * time.Second
+
+	// defaultShutdownTimeout is how long shutting down waits for requests and
+	// deliveries to finish when ShutdownTimeout isn't set.
+	defaultShutdownTimeout = 30 * time.Second
+
+	// shutdownPollInterval is how often a shutting down HTTPServer checks
+	// whether its connections have finished.
+	shutdownPollInterval = 100 * time.Millisecond
+
+	// shutdownNewConnGrace is how long a connection that hasn't sent a request
+	// yet is given to send one once shutting down starts.
+	shutdownNewConnGrace = 5 * time.Second
+)
+
+// ErrShutdownTimedOut is returned when an HTTPServer's connections don't
+// finish before the shutdown timeout. They're closed anyway.
+var ErrShutdownTimedOut = errors.New("timed out waiting for HTTP requests to finish")
+
+// configDuration converts a number of seconds from the configuration into a
+// time.Duration, using 'def' if it isn't set.
+func configDuration(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// NewTLSConfig returns the TLS settings for the HTTP server, or nil if TLS
+// isn't configured. If TLSClientCAFile is set, clients have to present a
+// certificate signed by one of the CAs in it.
+func NewTLSConfig(config *Configuration) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		if config.TLSClientCAFile != "" {
+			return nil, errors.New("TLSClientCAFile can't be used without TLSCertFile and TLSKeyFile")
+		}
+		return nil, nil
+	}
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, errors.New("TLSCertFile and TLSKeyFile have to be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if config.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates were found in TLSClientCAFile")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// trackedConn is what an HTTPServer knows about one of its connections.
+type trackedConn struct {
+	state http.ConnState
+	since time.Time
+	idle  *time.Timer
+}
+
+// HTTPServer serves the HTTP API. It keeps track of its connections so that
+// idle ones can be closed and so that it can shut down without cutting off
+// requests that are in progress.
+type HTTPServer struct {
+	Addr         string
+	server       *http.Server
+	idleTimeout  time.Duration
+	mutex        sync.Mutex
+	listener     net.Listener
+	conns        map[net.Conn]*trackedConn
+	shuttingDown bool
+}
+
+// NewHTTPServer returns a pointer to an HTTPServer that serves requests with
+// 'handler' using the listen port, timeouts, and TLS settings in the
+// configuration. It doesn't start listening; call ListenAndServe for that.
+func NewHTTPServer(config *Configuration, handler http.Handler) (*HTTPServer, error) {
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &HTTPServer{
+		Addr:        formatPort(config.HTTPListenPort),
+		idleTimeout: configDuration(config.HTTPIdleTimeout, defaultHTTPIdleTimeout),
+		conns:       make(map[net.Conn]*trackedConn),
+	}
+	s.server = &http.Server{
+		Handler:      handler,
+		ReadTimeout:  configDuration(config.HTTPReadTimeout, defaultHTTPReadTimeout),
+		WriteTimeout: time.Duration(config.HTTPWriteTimeout) * time.Second,
+		TLSConfig:    tlsConfig,
+		ConnState:    s.trackConn,
+	}
+	return s, nil
+}
+
+// TLS returns true if the server requires TLS.
+func (s *HTTPServer) TLS() bool {
+	return s.server.TLSConfig != nil
+}
+
+// ListenAndServe listens on the server's address and serves requests until
+// the server is shut down, in which case nil is returned.
+func (s *HTTPServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests that come in on the listener until the server is shut
+// down, in which case nil is returned. The listener is wrapped with TLS if it's
+// configured.
+func (s *HTTPServer) Serve(listener net.Listener) error {
+	if s.TLS() {
+		listener = tls.NewListener(listener, s.server.TLSConfig)
+	}
+	s.mutex.Lock()
+	if s.shuttingDown {
+		s.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	err := s.server.Serve(listener)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.shuttingDown {
+		return nil
+	}
+	return err
+}
+
+// trackConn is the http.Server's ConnState hook. Idle connections are closed
+// once they've been idle for idleTimeout, or right away when shutting down.
+// Hijacked connections, like WebSockets, aren't tracked anymore since the
+// server has nothing to do with them.
+func (s *HTTPServer) trackConn(conn net.Conn, state http.ConnState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tc, ok := s.conns[conn]
+	if !ok {
+		tc = &trackedConn{}
+		s.conns[conn] = tc
+	}
+	if tc.idle != nil {
+		tc.idle.Stop()
+		tc.idle = nil
+	}
+	tc.state = state
+	tc.since = time.Now()
+	switch state {
+	case http.StateIdle:
+		if s.shuttingDown {
+			conn.Close()
+		} else if s.idleTimeout > 0 {
+			tc.idle = time.AfterFunc(s.idleTimeout, func() {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				if tc.state == http.StateIdle {
+					conn.Close()
+				}
+			})
+		}
+	case http.StateHijacked, http.StateClosed:
+		delete(s.conns, conn)
+	}
+}
+
+// ConnCount returns the number of connections the server is tracking.
+func (s *HTTPServer) ConnCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// closeIdle closes the connections that aren't in the middle of a request. New
+// connections are given shutdownNewConnGrace to send their request.
+func (s *HTTPServer) closeIdle(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn, tc := range s.conns {
+		if tc.state == http.StateIdle || (tc.state == http.StateNew && now.Sub(tc.since) >= shutdownNewConnGrace) {
+			conn.Close()
+		}
+	}
+}
+
+// closeAll closes every connection the server is tracking.
+func (s *HTTPServer) closeAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Shutdown stops the server from accepting connections, turns off keep-alives,
+// and waits up to 'timeout' for the requests in progress to finish. Connections
+// that are still open after that are closed and ErrShutdownTimedOut is
+// returned. Streams should be ended before calling this, since they don't
+// finish on their own.
+func (s *HTTPServer) Shutdown(timeout time.Duration) error {
+	s.mutex.Lock()
+	s.shuttingDown = true
+	s.server.SetKeepAlivesEnabled(false)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mutex.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		now := time.Now()
+		s.closeIdle(now)
+		if s.ConnCount() == 0 {
+			return nil
+		}
+		if now.After(deadline) {
+			s.closeAll()
+			return ErrShutdownTimedOut
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key for TLS tests.
+type testCert struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate for 127.0.0.1. It's self-signed if parent
+// is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and key to PEM files in dir and returns their
+// paths.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(c.key)})
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// startTestServer serves the handler on a random local port and returns the
+// server along with its address.
+func startTestServer(t *testing.T, config *Configuration, handler http.Handler) (*HTTPServer, string) {
+	s, err := NewHTTPServer(config, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	return s, listener.Addr().String()
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig, err := NewTLSConfig(&Configuration{})
+	if err != nil || tlsConfig != nil {
+		t.Errorf("NewTLSConfig returned %v, %v without any TLS settings", tlsConfig, err)
+	}
+	bad := []*Configuration{
+		&Configuration{TLSClientCAFile: "ca.crt"},
+		&Configuration{TLSCertFile: "server.crt"},
+		&Configuration{TLSKeyFile: "server.key"},
+		&Configuration{TLSCertFile: "missing.crt", TLSKeyFile: "missing.key"},
+	}
+	for _, c := range bad {
+		if _, err = NewTLSConfig(c); err == nil {
+			t.Errorf("NewTLSConfig didn't return an error for %+v", c)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "jex-events-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "ca", nil, true)
+	caPath, _ := ca.write(t, dir, "ca")
+	certPath, keyPath := newTestCert(t, "server", ca, false).write(t, dir, "server")
+	tlsConfig, err = NewTLSConfig(&Configuration{TLSCertFile: certPath, TLSKeyFile: keyPath, TLSClientCAFile: caPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("Client certificates aren't required when TLSClientCAFile is set")
+	}
+	if _, err = NewTLSConfig(&Configuration{TLSCertFile: certPath, TLSKeyFile: keyPath, TLSClientCAFile: keyPath}); err == nil {
+		t.Error("NewTLSConfig didn't return an error for a CA file without certificates")
+	}
+}
+
+func TestHTTPServerMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jex-events-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "ca", nil, true)
+	caPath, _ := ca.write(t, dir, "ca")
+	certPath, keyPath := newTestCert(t, "server", ca, false).write(t, dir, "server")
+	config := &Configuration{TLSCertFile: certPath, TLSKeyFile: keyPath, TLSClientCAFile: caPath}
+	s, addr := startTestServer(t, config, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(writer, request.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	defer s.Shutdown(time.Second)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs []tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		response, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		return string(body), err
+	}
+	body, err := get([]tls.Certificate{newTestCert(t, "client", ca, false).tlsCertificate()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "client" {
+		t.Errorf("The handler saw the client as '%s' instead of 'client'", body)
+	}
+	if _, err = get(nil); err == nil {
+		t.Error("A client without a certificate was let in")
+	}
+	if _, err = get([]tls.Certificate{newTestCert(t, "stranger", nil, false).tlsCertificate()}); err == nil {
+		t.Error("A client with a certificate from an unknown CA was let in")
+	}
+}
+
+func TestHTTPServerShutdown(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	s, addr := startTestServer(t, &Configuration{}, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/slow" {
+			started <- true
+			<-release
+		}
+		fmt.Fprint(writer, "done")
+	}))
+
+	// An idle keep-alive connection shouldn't hold up shutting down.
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	fmt.Fprint(idle, "GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(idle), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	result := make(chan string)
+	go func() {
+		response, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		result <- string(body)
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(5 * time.Second)
+	}()
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request in progress finished", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if _, err = net.Dial("tcp", addr); err == nil {
+		t.Error("The server accepted a connection while shutting down")
+	}
+	close(release)
+	if body := <-result; body != "done" {
+		t.Errorf("The request in progress got '%s' instead of 'done'", body)
+	}
+	if err = <-shutdown; err != nil {
+		t.Errorf("Shutdown returned %s", err)
+	}
+	if s.ConnCount() != 0 {
+		t.Errorf("%d connections were still open after shutting down", s.ConnCount())
+	}
+}
+
+func TestHTTPServerShutdownTimeout(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	s, addr := startTestServer(t, &Configuration{}, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started <- true
+		<-release
+	}))
+	go http.Get("http://" + addr + "/")
+	<-started
+	if err := s.Shutdown(200 * time.Millisecond); err != ErrShutdownTimedOut {
+		t.Errorf("Shutdown returned %v instead of ErrShutdownTimedOut", err)
+	}
+}
+
+func TestHTTPServerIdleTimeout(t *testing.T) {
+	s, addr := startTestServer(t, &Configuration{}, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(writer, "done")
+	}))
+	defer s.Shutdown(time.Second)
+	s.idleTimeout = 100 * time.Millisecond
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = reader.ReadByte(); err != io.EOF {
+		t.Errorf("Reading from an idle connection returned %v instead of EOF", err)
+	}
+}
@@ -0,0 +1,2394 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteScheme is the prefix of the DBURIs that point to SQLite databases.
+const sqliteScheme = "sqlite3:"
+
+// sqliteDriver is the name of the database/sql driver for SQLite. It's only
+// set when jex-events is built with the sqlite tag, since the driver needs cgo.
+var sqliteDriver string
+
+// ErrNoSQLite is returned when a SQLite database is configured but jex-events
+// was built without SQLite support.
+var ErrNoSQLite = errors.New("jex-events was built without SQLite support; rebuild it with -tags sqlite")
+
+// sqliteTimeFormat is how times are stored in SQLite. They're stored as UTC
+// text with a fixed number of digits so that they sort correctly.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteMigrations set up the SQLite schema, which mirrors the jex-db schema.
+// The database's user_version is the number of migrations that have been
+// applied. Add new migrations to the end; don't change the old ones.
+var sqliteMigrations = []string{`
+CREATE TABLE jobs (
+  id                text not null primary key,
+  batch_id          text,
+  condor_id         varchar(32) not null,
+  submitter         varchar(512) not null,
+  invocation_id     text,
+  date_submitted    text,
+  date_started      text,
+  date_completed    text,
+  app_id            text,
+  exit_code         integer,
+  failure_threshold integer not null,
+  failure_count     integer
+);
+
+CREATE TABLE condor_events (
+  id           text not null primary key,
+  event_number varchar(3) not null,
+  event_name   text not null,
+  event_desc   text not null
+);
+
+CREATE TABLE condor_raw_events (
+  id             text not null primary key,
+  job_id         text not null references jobs(id) on delete cascade,
+  event_text     text not null,
+  date_triggered text not null
+);
+
+CREATE TABLE condor_job_events (
+  id                  text not null primary key,
+  job_id              text not null references jobs(id) on delete cascade,
+  condor_event_id     text not null references condor_events(id) on delete cascade,
+  condor_raw_event_id text not null references condor_raw_events(id) on delete cascade,
+  checksum            varchar(64),
+  date_triggered      text not null
+);
+
+CREATE TABLE last_condor_job_events (
+  job_id              text not null primary key references jobs(id) on delete cascade,
+  condor_job_event_id text not null references condor_job_events(id) on delete cascade
+);
+
+CREATE TABLE condor_job_stop_requests (
+  id                  text not null primary key,
+  job_id              text not null references jobs(id) on delete cascade,
+  username            varchar(512) not null,
+  date_requested      text not null,
+  reason              text,
+  date_confirmed      text,
+  condor_job_event_id text references condor_job_events(id) on delete set null
+);
+
+CREATE TABLE condor_job_deps (
+  successor_id   text not null primary key references jobs(id) on delete cascade,
+  predecessor_id text not null references jobs(id) on delete cascade
+);
+
+CREATE TABLE job_status_transitions (
+  sequence            integer primary key autoincrement,
+  id                  text not null unique,
+  job_id              text not null references jobs(id) on delete cascade,
+  condor_job_event_id text not null references condor_job_events(id) on delete cascade,
+  from_status         varchar(32) not null,
+  to_status           varchar(32) not null,
+  reason              text,
+  date_triggered      text not null,
+  date_recorded       text not null
+);
+
+CREATE TABLE outbound_notifications (
+  id              text not null primary key,
+  url             text not null,
+  idempotency_key text not null unique,
+  payload         text not null,
+  status          varchar(16) not null,
+  attempts        integer not null default 0,
+  max_attempts    integer not null,
+  last_error      text not null default '',
+  next_attempt    text not null,
+  date_created    text not null,
+  date_delivered  text
+);
+
+CREATE INDEX jobs_condor_id_index ON jobs(condor_id);
+CREATE INDEX jobs_invocation_id_index ON jobs(invocation_id);
+CREATE INDEX condor_job_events_job_id_index ON condor_job_events(job_id);
+CREATE INDEX condor_job_events_checksum_index ON condor_job_events(checksum);
+CREATE INDEX job_status_transitions_job_id_index ON job_status_transitions(job_id);
+`, `
+CREATE INDEX condor_raw_events_job_id_index ON condor_raw_events(job_id);
+CREATE INDEX condor_job_events_condor_raw_event_id_index ON condor_job_events(condor_raw_event_id);
+CREATE INDEX last_condor_job_events_condor_job_event_id_index ON last_condor_job_events(condor_job_event_id);
+CREATE INDEX condor_job_stop_requests_job_id_index ON condor_job_stop_requests(job_id);
+CREATE INDEX condor_job_stop_requests_condor_job_event_id_index ON condor_job_stop_requests(condor_job_event_id);
+CREATE INDEX condor_job_deps_predecessor_id_index ON condor_job_deps(predecessor_id);
+CREATE INDEX job_status_transitions_condor_job_event_id_index ON job_status_transitions(condor_job_event_id);
+`, `
+CREATE TABLE job_resource_usage (
+  job_id              text not null primary key references jobs(id) on delete cascade,
+  condor_job_event_id text not null references condor_job_events(id) on delete cascade,
+  user_cpu_seconds    integer not null default 0,
+  sys_cpu_seconds     integer not null default 0,
+  bytes_sent          integer not null default 0,
+  bytes_received      integer not null default 0,
+  cpus_requested      integer not null default 0,
+  cpus_allocated      integer not null default 0,
+  memory_usage_mb     integer not null default 0,
+  memory_requested_mb integer not null default 0,
+  memory_allocated_mb integer not null default 0,
+  disk_usage_kb       integer not null default 0,
+  disk_requested_kb   integer not null default 0,
+  disk_allocated_kb   integer not null default 0,
+  date_triggered      text not null
+);
+
+CREATE INDEX job_resource_usage_date_triggered_index ON job_resource_usage(date_triggered);
+CREATE INDEX job_resource_usage_condor_job_event_id_index ON job_resource_usage(condor_job_event_id);
+`, `
+ALTER TABLE jobs ADD COLUMN termination_kind text not null default '';
+ALTER TABLE jobs ADD COLUMN termination_signal integer not null default 0;
+`, `
+ALTER TABLE jobs ADD COLUMN date_archived text;
+`, `
+ALTER TABLE outbound_notifications ADD COLUMN invocation_id text;
+
+CREATE INDEX outbound_notifications_invocation_id_index ON outbound_notifications(invocation_id, url, date_created);
+`, `
+CREATE TABLE service_locks (
+  name          text not null primary key,
+  holder        text not null,
+  date_acquired text not null,
+  date_expires  text not null
+);
+`, `
+CREATE TABLE job_flags (
+  job_id   text not null references jobs(id) on delete cascade,
+  flag     text not null,
+  date_set text not null,
+  primary key (job_id, flag)
+);
+`}
+
+// SQLiteStore is a JobStore that keeps everything in a SQLite database file,
+// for installs that are too small to be worth running PostgreSQL for. It
+// behaves like the Databaser, including the cascading deletes and the version
+// 1 UUIDs that PostgreSQL generates with uuid_generate_v1().
+type SQLiteStore struct {
+	db   *sql.DB
+	Path string
+}
+
+var _ JobStore = (*SQLiteStore)(nil)
+
+// IsSQLiteURI returns true if the DBURI points to a SQLite database.
+func IsSQLiteURI(uri string) bool {
+	return strings.HasPrefix(uri, sqliteScheme)
+}
+
+// NewSQLiteStore opens the SQLite database in the file at 'path', creating it
+// if it doesn't exist, and brings its schema up to date. A path of ':memory:'
+// opens a database that only lasts until the store is closed.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if sqliteDriver == "" {
+		return nil, ErrNoSQLite
+	}
+	db, err := sql.Open(sqliteDriver, path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time, and every connection to a
+	// :memory: database gets a different database, so stick to one
+	// connection.
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStore{db: db, Path: path}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the sqliteMigrations that the database is missing and adds
+// the condor_events to a new database.
+func (s *SQLiteStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var applied int
+	if err = tx.QueryRow("PRAGMA user_version").Scan(&applied); err != nil {
+		return err
+	}
+	if applied > len(sqliteMigrations) {
+		return ErrDatabaseTooNew
+	}
+	for i := applied; i < len(sqliteMigrations); i++ {
+		if _, err = tx.Exec(sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("error applying SQLite migration %d: %s", i+1, err)
+		}
+	}
+	if applied == 0 {
+		for _, ce := range defaultCondorEvents() {
+			_, err = tx.Exec(
+				"INSERT INTO condor_events (id, event_number, event_name, event_desc) VALUES (?, ?, ?, ?)",
+				newUUID(), ce.EventNumber, ce.EventName, ce.EventDesc,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(sqliteMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// sqliteTime returns the time the way it's stored in SQLite.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// nullableSQLiteTime returns nil for a zero time so that it's stored as NULL.
+func nullableSQLiteTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return sqliteTime(t)
+}
+
+// parseSQLiteTime parses a time stored by sqliteTime. NULLs are returned as
+// zero times.
+func parseSQLiteTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(sqliteTimeFormat, s.String)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Local(), nil
+}
+
+// parseSQLiteTimes parses the stored times into the destinations.
+func parseSQLiteTimes(pairs ...interface{}) error {
+	for i := 0; i < len(pairs); i += 2 {
+		t, err := parseSQLiteTime(pairs[i].(sql.NullString))
+		if err != nil {
+			return err
+		}
+		*pairs[i+1].(*time.Time) = t
+	}
+	return nil
+}
+
+// sqliteJobColumns is the list of columns selected when looking up jobs. The
+// order matches scanSQLiteJob.
+const sqliteJobColumns = `
+	j.id,
+	COALESCE(j.batch_id, ''),
+	j.submitter,
+	j.date_submitted,
+	j.date_started,
+	j.date_completed,
+	COALESCE(j.app_id, ''),
+	COALESCE(j.exit_code, 0),
+	j.failure_threshold,
+	COALESCE(j.failure_count, 0),
+	j.condor_id,
+	COALESCE(j.invocation_id, ''),
+	j.termination_kind,
+	j.termination_signal
+`
+
+// scanSQLiteJob fills in a JobRecord from a row that starts with the
+// sqliteJobColumns. Any extra columns are scanned into 'extra'. The dates are
+// returned the way they're stored; use rezeroDates to match the Databaser.
+func scanSQLiteJob(row rowScanner, extra ...interface{}) (*JobRecord, error) {
+	jr := &JobRecord{}
+	var submitted, started, completed sql.NullString
+	dest := []interface{}{
+		&jr.ID,
+		&jr.BatchID,
+		&jr.Submitter,
+		&submitted,
+		&started,
+		&completed,
+		&jr.AppID,
+		&jr.ExitCode,
+		&jr.FailureThreshold,
+		&jr.FailureCount,
+		&jr.CondorID,
+		&jr.InvocationID,
+		&jr.TerminationKind,
+		&jr.TerminationSignal,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	err := parseSQLiteTimes(
+		submitted, &jr.DateSubmitted,
+		started, &jr.DateStarted,
+		completed, &jr.DateCompleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return jr, nil
+}
+
+// queryJob returns the first job found by a query that selects the
+// sqliteJobColumns from jobs aliased as 'j'.
+func (s *SQLiteStore) queryJob(where string, args ...interface{}) (*JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + ` FROM jobs j ` + where + ` LIMIT 1`
+	jr, err := scanSQLiteJob(s.db.QueryRow(query, args...))
+	if err != nil {
+		return nil, err
+	}
+	rezeroDates(jr)
+	return jr, nil
+}
+
+// queryJobs returns the jobs found by a query that selects the
+// sqliteJobColumns from jobs aliased as 'j'.
+func (s *SQLiteStore) queryJobs(query string, args ...interface{}) ([]JobRecord, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobRecord
+	for rows.Next() {
+		jr, err := scanSQLiteJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		rezeroDates(jr)
+		retval = append(retval, *jr)
+	}
+	return retval, rows.Err()
+}
+
+// nullableID returns nil for an empty ID so that it's stored as NULL. IDs that
+// aren't UUIDs are rejected like they are by PostgreSQL.
+func nullableID(id string) (interface{}, error) {
+	if err := checkUUIDs(id); err != nil {
+		return nil, err
+	}
+	return nullableUUID(id), nil
+}
+
+// jobArgs returns the values stored for a job, in the order they're listed in
+// the INSERT in InsertJob.
+func jobArgs(jr *JobRecord) ([]interface{}, error) {
+	var ids []interface{}
+	for _, id := range []string{jr.BatchID, jr.AppID, jr.InvocationID} {
+		v, err := nullableID(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, v)
+	}
+	return []interface{}{
+		ids[0],
+		jr.Submitter,
+		sqliteTime(jr.DateSubmitted),
+		sqliteTime(jr.DateStarted),
+		sqliteTime(jr.DateCompleted),
+		ids[1],
+		jr.ExitCode,
+		jr.FailureThreshold,
+		jr.FailureCount,
+		jr.CondorID,
+		ids[2],
+		jr.TerminationKind,
+		jr.TerminationSignal,
+	}, nil
+}
+
+// InsertJob adds a new JobRecord to the database.
+func (s *SQLiteStore) InsertJob(jr *JobRecord) (string, error) {
+	args, err := jobArgs(jr)
+	if err != nil {
+		return "", err
+	}
+	id := newUUID()
+	query := `
+	INSERT INTO jobs (
+		batch_id,
+		submitter,
+		date_submitted,
+		date_started,
+		date_completed,
+		app_id,
+		exit_code,
+		failure_threshold,
+		failure_count,
+		condor_id,
+		invocation_id,
+		termination_kind,
+		termination_signal,
+		id
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err = s.db.Exec(query, append(args, id)...); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// AddJob adds a job with the given Condor ID if there isn't one already. The
+// job is returned either way.
+func (s *SQLiteStore) AddJob(condorID string) (*JobRecord, error) {
+	job, err := s.GetJobByCondorID(condorID)
+	if err == sql.ErrNoRows {
+		jr := &JobRecord{
+			CondorID: condorID,
+		}
+		if jr.ID, err = s.InsertJob(jr); err != nil {
+			logger.Printf("Error inserting job: %s", err)
+			return nil, err
+		}
+		return jr, nil
+	}
+	if err != nil {
+		logger.Printf("Error getting job by condor id: %s", err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpsertJob updates the job with the same Condor ID if there is one, otherwise
+// it inserts a new job.
+func (s *SQLiteStore) UpsertJob(jr *JobRecord) (*JobRecord, error) {
+	job, err := s.GetJobByCondorID(jr.CondorID)
+	if err == sql.ErrNoRows {
+		id, err := s.InsertJob(jr)
+		if err != nil {
+			logger.Println("Error inserting job")
+			return nil, err
+		}
+		return s.GetJob(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	jr.ID = job.ID
+	return s.UpdateJob(jr)
+}
+
+// DeleteJob removes a JobRecord from the database, along with everything that
+// refers to it.
+func (s *SQLiteStore) DeleteJob(id string) error {
+	_, err := s.db.Exec(`DELETE FROM jobs WHERE id = ?`, id)
+	return err
+}
+
+// GetJob returns a JobRecord from the database.
+func (s *SQLiteStore) GetJob(id string) (*JobRecord, error) {
+	return s.queryJob(`WHERE j.id = ?`, id)
+}
+
+// GetJobByCondorID returns a JobRecord from the database.
+func (s *SQLiteStore) GetJobByCondorID(condorID string) (*JobRecord, error) {
+	return s.queryJob(`WHERE j.condor_id = ?`, condorID)
+}
+
+// GetJobByInvocationID returns a JobRecord from the database, or nil if there
+// isn't a job with the invocation ID.
+func (s *SQLiteStore) GetJobByInvocationID(invocationID string) (*JobRecord, error) {
+	jr, err := s.queryJob(`WHERE j.invocation_id = ?`, invocationID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return jr, err
+}
+
+// PatchJob sets the fields that are in the request on the job with the given
+// ID and leaves the rest of them alone. sql.ErrNoRows is returned if the job
+// doesn't exist.
+func (s *SQLiteStore) PatchJob(id string, r *JobRequest) (*JobRecord, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	jr, err := scanSQLiteJob(tx.QueryRow(`SELECT `+sqliteJobColumns+` FROM jobs j WHERE j.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	r.Apply(jr)
+	if err = updateSQLiteJob(tx, jr); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetJob(id)
+}
+
+// sqliteExecer is the part of *sql.DB and *sql.Tx that's needed to make
+// changes.
+type sqliteExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateSQLiteJob replaces the job with the same ID as jr. sql.ErrNoRows is
+// returned if it doesn't exist.
+func updateSQLiteJob(e sqliteExecer, jr *JobRecord) error {
+	args, err := jobArgs(jr)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE jobs
+	   SET batch_id = ?,
+	       submitter = ?,
+	       date_submitted = ?,
+	       date_started = ?,
+	       date_completed = ?,
+	       app_id = ?,
+	       exit_code = ?,
+	       failure_threshold = ?,
+	       failure_count = ?,
+	       condor_id = ?,
+	       invocation_id = ?,
+	       termination_kind = ?,
+	       termination_signal = ?
+	 WHERE id = ?
+	`
+	return execOne(e, query, append(args, jr.ID)...)
+}
+
+// updateSQLiteEventJob sets the columns of the job that its events decide,
+// the same way the Databaser's updateEventJobQuery does. sql.ErrNoRows is
+// returned if the job doesn't exist.
+func updateSQLiteEventJob(e sqliteExecer, jr *JobRecord) error {
+	invID, err := nullableID(jr.InvocationID)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE jobs
+	   SET exit_code = ?,
+	       failure_count = ?,
+	       termination_kind = ?,
+	       termination_signal = ?,
+	       invocation_id = COALESCE(invocation_id, ?)
+	 WHERE id = ?
+	`
+	return execOne(e, query, jr.ExitCode, jr.FailureCount, jr.TerminationKind, jr.TerminationSignal, invID, jr.ID)
+}
+
+// execOne runs a statement that's supposed to change one row. sql.ErrNoRows is
+// returned if it didn't change any.
+func execOne(e sqliteExecer, query string, args ...interface{}) error {
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateJob updates a job in the database.
+func (s *SQLiteStore) UpdateJob(jr *JobRecord) (*JobRecord, error) {
+	if err := updateSQLiteJob(s.db, jr); err != nil {
+		return nil, err
+	}
+	return s.GetJob(jr.ID)
+}
+
+// ListJobs returns the jobs that match the filter along with their current
+// statuses. Like JobFilter.Query, one more job than the limit is returned.
+func (s *SQLiteStore) ListJobs(f *JobFilter) ([]JobListing, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg ...interface{}) {
+		args = append(args, arg...)
+		conditions = append(conditions, condition)
+	}
+	if f.Submitter != "" {
+		add("j.submitter = ?", f.Submitter)
+	}
+	if f.AppID != "" {
+		add("j.app_id = ?", f.AppID)
+	}
+	if f.BatchID != "" {
+		add("j.batch_id = ?", f.BatchID)
+	}
+	if f.Status != "" {
+		add(jobStatusExpr+" = ?", f.Status)
+	}
+	if !f.SubmittedAfter.IsZero() {
+		add("j.date_submitted >= ?", sqliteTime(f.SubmittedAfter))
+	}
+	if !f.SubmittedBefore.IsZero() {
+		add("j.date_submitted < ?", sqliteTime(f.SubmittedBefore))
+	}
+	if !f.CompletedAfter.IsZero() {
+		add("j.date_completed >= ?", sqliteTime(f.CompletedAfter))
+	}
+	if !f.CompletedBefore.IsZero() {
+		add("j.date_completed < ?", sqliteTime(f.CompletedBefore))
+	}
+	if f.ExitCode != nil {
+		add("j.exit_code = ?", *f.ExitCode)
+	}
+	direction, comparison := "ASC", ">"
+	if f.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if f.Cursor != nil {
+		value := sqliteTime(f.Cursor.Value)
+		add(
+			fmt.Sprintf("(j.%s %s ? OR (j.%s = ? AND j.id %s ?))", f.Sort, comparison, f.Sort, comparison),
+			value, value, f.Cursor.ID,
+		)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, "\n\t   AND ")
+	}
+	query := fmt.Sprintf(`
+	SELECT %s,
+	       %s
+	  FROM jobs j
+	 %s
+	 ORDER BY j.%s %s, j.id %s
+	 LIMIT ?
+	`, sqliteJobColumns, jobStatusExpr, where, f.Sort, direction, direction)
+	rows, err := s.db.Query(query, append(args, f.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobListing
+	for rows.Next() {
+		var status string
+		jr, err := scanSQLiteJob(rows, &status)
+		if err != nil {
+			return nil, err
+		}
+		jl := JobListing{JobRecord: *jr, Status: status, sortValue: jr.DateSubmitted}
+		if f.Sort == SortDateCompleted {
+			jl.sortValue = jr.DateCompleted
+		}
+		rezeroDates(&jl.JobRecord)
+		retval = append(retval, jl)
+	}
+	return retval, rows.Err()
+}
+
+// GetBatchJobs returns a []JobRecord of all of the jobs whose BatchID is the
+// ID passed into the function.
+func (s *SQLiteStore) GetBatchJobs(batchID string) ([]JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + `
+	  FROM jobs j
+	 WHERE j.batch_id = ?
+	 ORDER BY j.date_submitted
+	`
+	return s.queryJobs(query, batchID)
+}
+
+// scanSQLiteCondorEvent fills in a CondorEvent from a row.
+func scanSQLiteCondorEvent(row rowScanner) (*CondorEvent, error) {
+	ce := &CondorEvent{}
+	if err := row.Scan(&ce.ID, &ce.EventNumber, &ce.EventName, &ce.EventDesc); err != nil {
+		return nil, err
+	}
+	return ce, nil
+}
+
+// InsertCondorEvent adds a new CondorEvent to the database. The ID field is
+// ignored.
+func (s *SQLiteStore) InsertCondorEvent(ce *CondorEvent) (string, error) {
+	id := newUUID()
+	_, err := s.db.Exec(
+		`INSERT INTO condor_events (id, event_number, event_name, event_desc) VALUES (?, ?, ?, ?)`,
+		id, ce.EventNumber, ce.EventName, ce.EventDesc,
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteCondorEvent removes a CondorEvent from the database by its uuid.
+func (s *SQLiteStore) DeleteCondorEvent(id string) error {
+	_, err := s.db.Exec(`DELETE FROM condor_events WHERE id = ?`, id)
+	return err
+}
+
+// GetCondorEvent returns the CondorEvent with the given ID.
+func (s *SQLiteStore) GetCondorEvent(id string) (*CondorEvent, error) {
+	query := `SELECT id, event_number, event_name, event_desc FROM condor_events WHERE id = ?`
+	return scanSQLiteCondorEvent(s.db.QueryRow(query, id))
+}
+
+// GetCondorEventByNumber returns the CondorEvent with the given event number.
+func (s *SQLiteStore) GetCondorEventByNumber(number string) (*CondorEvent, error) {
+	query := `SELECT id, event_number, event_name, event_desc FROM condor_events WHERE event_number = ? LIMIT 1`
+	return scanSQLiteCondorEvent(s.db.QueryRow(query, number))
+}
+
+// UpdateCondorEvent updates a CondorEvent in the database. The CondorEvent must
+// be fully filled out with information, not just the fields that you want to
+// update.
+func (s *SQLiteStore) UpdateCondorEvent(ce *CondorEvent) (*CondorEvent, error) {
+	err := execOne(
+		s.db,
+		`UPDATE condor_events SET event_number = ?, event_name = ?, event_desc = ? WHERE id = ?`,
+		ce.EventNumber, ce.EventName, ce.EventDesc, ce.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCondorEvent(ce.ID)
+}
+
+// GetCondorEventMappings returns nothing, since SQLite databases don't have
+// the mapping columns in condor_events. The built-in mappings and the ones in
+// the configuration file are used instead.
+func (s *SQLiteStore) GetCondorEventMappings() ([]EventMapping, error) {
+	return nil, nil
+}
+
+// InsertCondorRawEvent adds an unparsed event record to the database.
+func (s *SQLiteStore) InsertCondorRawEvent(re *CondorRawEvent) (string, error) {
+	id := newUUID()
+	_, err := s.db.Exec(
+		`INSERT INTO condor_raw_events (id, job_id, event_text, date_triggered) VALUES (?, ?, ?, ?)`,
+		id, re.JobID, re.EventText, sqliteTime(re.DateTriggered),
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// AddCondorRawEvent adds a raw event that was triggered now.
+func (s *SQLiteStore) AddCondorRawEvent(eventText string, jobID string) (string, error) {
+	return s.InsertCondorRawEvent(&CondorRawEvent{
+		JobID:         jobID,
+		EventText:     eventText,
+		DateTriggered: time.Now(),
+	})
+}
+
+// DeleteCondorRawEvent removes an unparsed job event from the database.
+func (s *SQLiteStore) DeleteCondorRawEvent(id string) error {
+	_, err := s.db.Exec(`DELETE FROM condor_raw_events WHERE id = ?`, id)
+	return err
+}
+
+// GetCondorRawEvent retrieves an unparsed job event from the database.
+func (s *SQLiteStore) GetCondorRawEvent(id string) (*CondorRawEvent, error) {
+	re := &CondorRawEvent{}
+	var triggered sql.NullString
+	err := s.db.QueryRow(
+		`SELECT id, job_id, event_text, date_triggered FROM condor_raw_events WHERE id = ?`,
+		id,
+	).Scan(&re.ID, &re.JobID, &re.EventText, &triggered)
+	if err != nil {
+		return nil, err
+	}
+	if err = parseSQLiteTimes(triggered, &re.DateTriggered); err != nil {
+		return nil, err
+	}
+	return re, nil
+}
+
+// UpdateCondorRawEvent updates a record of an unparsed job event.
+func (s *SQLiteStore) UpdateCondorRawEvent(re *CondorRawEvent) (*CondorRawEvent, error) {
+	err := execOne(
+		s.db,
+		`UPDATE condor_raw_events SET job_id = ?, event_text = ?, date_triggered = ? WHERE id = ?`,
+		re.JobID, re.EventText, sqliteTime(re.DateTriggered), re.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCondorRawEvent(re.ID)
+}
+
+// InsertCondorJobEvent adds a parsed job event to the database.
+func (s *SQLiteStore) InsertCondorJobEvent(je *CondorJobEvent) (string, error) {
+	id := newUUID()
+	query := `
+	INSERT INTO condor_job_events (
+		id,
+		job_id,
+		condor_event_id,
+		condor_raw_event_id,
+		date_triggered,
+		checksum
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query, id, je.JobID, je.CondorEventID, je.CondorRawEventID, sqliteTime(je.DateTriggered), je.Hash)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DoesCondorJobEventExist returns true if an event with a matching checksum
+// is already in the database.
+func (s *SQLiteStore) DoesCondorJobEventExist(checksum string) (bool, error) {
+	var count int64
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM condor_job_events WHERE checksum = ?`, checksum).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FindCondorJobEventChecksums returns the checksums that are already in the
+// database out of the ones that are passed in.
+func (s *SQLiteStore) FindCondorJobEventChecksums(checksums []string) (map[string]bool, error) {
+	return queryChecksums(s.db, `SELECT DISTINCT checksum FROM condor_job_events WHERE checksum IN (%s)`, checksums)
+}
+
+// CountCondorJobEventsByNumber returns the number of events with the given
+// event number that have been recorded for a job.
+func (s *SQLiteStore) CountCondorJobEventsByNumber(jobID, eventNumber string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	 WHERE je.job_id = ?
+	   AND ce.event_number = ?
+	`
+	var count int
+	if err := s.db.QueryRow(query, jobID, eventNumber).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetJobEventHistory returns all of the events recorded for a job, along with
+// their names, descriptions, and raw text, in the order they were recorded.
+func (s *SQLiteStore) GetJobEventHistory(jobID string) ([]JobEventRecord, error) {
+	query := `
+	SELECT je.id,
+	       je.job_id,
+	       ce.event_number,
+	       ce.event_name,
+	       ce.event_desc,
+	       COALESCE(je.checksum, ''),
+	       je.date_triggered,
+	       re.event_text
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	  JOIN condor_raw_events re ON je.condor_raw_event_id = re.id
+	 WHERE je.job_id = ?
+	 ORDER BY je.date_triggered ASC
+	`
+	rows, err := s.db.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobEventRecord
+	for rows.Next() {
+		var r JobEventRecord
+		var recorded sql.NullString
+		err = rows.Scan(
+			&r.ID,
+			&r.JobID,
+			&r.EventNumber,
+			&r.EventName,
+			&r.EventDescription,
+			&r.Hash,
+			&recorded,
+			&r.RawEvent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err = parseSQLiteTimes(recorded, &r.DateRecorded); err != nil {
+			return nil, err
+		}
+		retval = append(retval, r)
+	}
+	return retval, rows.Err()
+}
+
+// AddCondorJobEvent adds a job event that was triggered now.
+func (s *SQLiteStore) AddCondorJobEvent(jobID string, eventID string, rawEventID string, hash string) (string, error) {
+	return s.InsertCondorJobEvent(&CondorJobEvent{
+		JobID:            jobID,
+		CondorEventID:    eventID,
+		CondorRawEventID: rawEventID,
+		DateTriggered:    time.Now(),
+		Hash:             hash,
+	})
+}
+
+// DeleteCondorJobEvent removes a parsed job event from the database.
+func (s *SQLiteStore) DeleteCondorJobEvent(id string) error {
+	_, err := s.db.Exec(`DELETE FROM condor_job_events WHERE id = ?`, id)
+	return err
+}
+
+// GetCondorJobEvent returns the parsed job event with the given ID. Like the
+// Databaser, the Hash isn't filled in.
+func (s *SQLiteStore) GetCondorJobEvent(id string) (*CondorJobEvent, error) {
+	je := &CondorJobEvent{}
+	var triggered sql.NullString
+	err := s.db.QueryRow(
+		`SELECT id, job_id, condor_event_id, condor_raw_event_id, date_triggered FROM condor_job_events WHERE id = ?`,
+		id,
+	).Scan(&je.ID, &je.JobID, &je.CondorEventID, &je.CondorRawEventID, &triggered)
+	if err != nil {
+		return nil, err
+	}
+	if err = parseSQLiteTimes(triggered, &je.DateTriggered); err != nil {
+		return nil, err
+	}
+	return je, nil
+}
+
+// UpdateCondorJobEvent updates the job, condor event, raw event, and date of a
+// parsed job event. The checksum is left alone.
+func (s *SQLiteStore) UpdateCondorJobEvent(je *CondorJobEvent) (*CondorJobEvent, error) {
+	query := `
+	UPDATE condor_job_events
+	   SET job_id = ?,
+	       condor_event_id = ?,
+	       condor_raw_event_id = ?,
+	       date_triggered = ?
+	 WHERE id = ?
+	`
+	err := execOne(s.db, query, je.JobID, je.CondorEventID, je.CondorRawEventID, sqliteTime(je.DateTriggered), je.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCondorJobEvent(je.ID)
+}
+
+// InsertLastCondorJobEvent adds an entry that points to the last event for a
+// job.
+func (s *SQLiteStore) InsertLastCondorJobEvent(je *LastCondorJobEvent) (string, error) {
+	_, err := s.db.Exec(
+		`INSERT INTO last_condor_job_events (job_id, condor_job_event_id) VALUES (?, ?)`,
+		je.JobID, je.CondorJobEventID,
+	)
+	if err != nil {
+		return "", err
+	}
+	return je.JobID, nil
+}
+
+// DeleteLastCondorJobEvent removes the entry for job that points to the last
+// event.
+func (s *SQLiteStore) DeleteLastCondorJobEvent(jobID string) error {
+	_, err := s.db.Exec(`DELETE FROM last_condor_job_events WHERE job_id = ?`, jobID)
+	return err
+}
+
+// GetLastCondorJobEvent returns a record that tells what the last event for a
+// job was.
+func (s *SQLiteStore) GetLastCondorJobEvent(jobID string) (*LastCondorJobEvent, error) {
+	le := &LastCondorJobEvent{}
+	err := s.db.QueryRow(
+		`SELECT job_id, condor_job_event_id FROM last_condor_job_events WHERE job_id = ?`,
+		jobID,
+	).Scan(&le.JobID, &le.CondorJobEventID)
+	if err != nil {
+		return nil, err
+	}
+	return le, nil
+}
+
+// UpdateLastCondorJobEvent modifies the record that tells what the last event
+// for a job was.
+func (s *SQLiteStore) UpdateLastCondorJobEvent(je *LastCondorJobEvent) (*LastCondorJobEvent, error) {
+	err := execOne(
+		s.db,
+		`UPDATE last_condor_job_events SET condor_job_event_id = ? WHERE job_id = ?`,
+		je.CondorJobEventID, je.JobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetLastCondorJobEvent(je.JobID)
+}
+
+// UpsertLastCondorJobEvent updates the last CondorJobEvent for a job if it's
+// already set, but will insert it if it isn't already set.
+func (s *SQLiteStore) UpsertLastCondorJobEvent(jobEventID, jobID string) (string, error) {
+	le := &LastCondorJobEvent{
+		JobID:            jobID,
+		CondorJobEventID: jobEventID,
+	}
+	if _, err := s.GetLastCondorJobEvent(jobID); err == sql.ErrNoRows {
+		return s.InsertLastCondorJobEvent(le)
+	}
+	updated, err := s.UpdateLastCondorJobEvent(le)
+	if err != nil {
+		return "", err
+	}
+	return updated.JobID, nil
+}
+
+// sqliteStopRequestColumns is the list of columns selected when looking up
+// stop requests. The order matches scanSQLiteStopRequest.
+const sqliteStopRequestColumns = `
+	id,
+	job_id,
+	username,
+	date_requested,
+	COALESCE(reason, ''),
+	date_confirmed,
+	COALESCE(condor_job_event_id, '')
+`
+
+// scanSQLiteStopRequest fills in a CondorJobStopRequest from a row that
+// contains the sqliteStopRequestColumns.
+func scanSQLiteStopRequest(row rowScanner) (*CondorJobStopRequest, error) {
+	jr := &CondorJobStopRequest{}
+	var requested, confirmed sql.NullString
+	err := row.Scan(
+		&jr.ID,
+		&jr.JobID,
+		&jr.Username,
+		&requested,
+		&jr.Reason,
+		&confirmed,
+		&jr.CondorJobEventID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = parseSQLiteTimes(
+		requested, &jr.DateRequested,
+		confirmed, &jr.DateConfirmed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return jr, nil
+}
+
+// InsertCondorJobStopRequest adds a record of a job stop request.
+func (s *SQLiteStore) InsertCondorJobStopRequest(jr *CondorJobStopRequest) (string, error) {
+	id := newUUID()
+	_, err := s.db.Exec(
+		`INSERT INTO condor_job_stop_requests (id, job_id, username, date_requested, reason) VALUES (?, ?, ?, ?, ?)`,
+		id, jr.JobID, jr.Username, sqliteTime(jr.DateRequested), jr.Reason,
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteCondorJobStopRequest deletes the record of a job stop request.
+func (s *SQLiteStore) DeleteCondorJobStopRequest(id string) error {
+	_, err := s.db.Exec(`DELETE FROM condor_job_stop_requests WHERE id = ?`, id)
+	return err
+}
+
+// GetCondorJobStopRequest returns the record of a job stop request.
+func (s *SQLiteStore) GetCondorJobStopRequest(id string) (*CondorJobStopRequest, error) {
+	query := `SELECT ` + sqliteStopRequestColumns + ` FROM condor_job_stop_requests WHERE id = ?`
+	return scanSQLiteStopRequest(s.db.QueryRow(query, id))
+}
+
+// GetLastCondorJobStopRequest returns the most recent stop request for a job.
+// sql.ErrNoRows is returned if nobody has asked for the job to be stopped.
+func (s *SQLiteStore) GetLastCondorJobStopRequest(jobID string) (*CondorJobStopRequest, error) {
+	query := `SELECT ` + sqliteStopRequestColumns + `
+	  FROM condor_job_stop_requests
+	 WHERE job_id = ?
+	 ORDER BY date_requested DESC
+	 LIMIT 1
+	`
+	return scanSQLiteStopRequest(s.db.QueryRow(query, jobID))
+}
+
+// ConfirmCondorJobStopRequests marks all of the unconfirmed stop requests for a
+// job as confirmed by the job event passed in. The number of stop requests that
+// were confirmed is returned.
+func (s *SQLiteStore) ConfirmCondorJobStopRequests(jobID, jobEventID string, confirmed time.Time) (int64, error) {
+	query := `
+	UPDATE condor_job_stop_requests
+	   SET date_confirmed = ?,
+	       condor_job_event_id = ?
+	 WHERE job_id = ?
+	   AND date_confirmed IS NULL
+	`
+	result, err := s.db.Exec(query, sqliteTime(confirmed), jobEventID, jobID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateCondorJobStopRequest updates the record of a job stop request.
+func (s *SQLiteStore) UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*CondorJobStopRequest, error) {
+	query := `
+	UPDATE condor_job_stop_requests
+	   SET job_id = ?,
+	       username = ?,
+	       date_requested = ?,
+	       reason = ?,
+	       date_confirmed = ?,
+	       condor_job_event_id = ?
+	 WHERE id = ?
+	`
+	err := execOne(
+		s.db,
+		query,
+		jr.JobID,
+		jr.Username,
+		sqliteTime(jr.DateRequested),
+		jr.Reason,
+		nullableSQLiteTime(jr.DateConfirmed),
+		nullableUUID(jr.CondorJobEventID),
+		jr.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCondorJobStopRequest(jr.ID)
+}
+
+// HasCondorJobStopRequest returns true if a request to stop the job has
+// already been recorded.
+func (s *SQLiteStore) HasCondorJobStopRequest(jobID string) (bool, error) {
+	var count int64
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM condor_job_stop_requests WHERE job_id = ?`, jobID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// SetJobFlag sets the flag on the job. The returned bool is false if the flag
+// was already set.
+func (s *SQLiteStore) SetJobFlag(jobID, flag string, set time.Time) (bool, error) {
+	result, err := s.db.Exec(
+		`INSERT OR IGNORE INTO job_flags (job_id, flag, date_set) VALUES (?, ?, ?)`,
+		jobID, flag, sqliteTime(set),
+	)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasJobFlag returns true if the flag is set on the job.
+func (s *SQLiteStore) HasJobFlag(jobID, flag string) (bool, error) {
+	var count int64
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM job_flags WHERE job_id = ? AND flag = ?`, jobID, flag).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// InsertCondorJobDep adds a job dependency to the database.
+func (s *SQLiteStore) InsertCondorJobDep(jd *CondorJobDep) error {
+	_, err := s.db.Exec(
+		`INSERT INTO condor_job_deps (successor_id, predecessor_id) VALUES (?, ?)`,
+		jd.SuccessorID, jd.PredecessorID,
+	)
+	return err
+}
+
+// AddCondorJobDep adds a job dependency to the database unless it's already
+// there, returning ErrDependencyCycle if it would create a cycle. The insert
+// comes first so that the transaction holds SQLite's write lock while the
+// cycle is checked for, which means dependencies are added one at a time even
+// across processes.
+func (s *SQLiteStore) AddCondorJobDep(jd *CondorJobDep) error {
+	if jd.PredecessorID == jd.SuccessorID {
+		return ErrDependencyCycle
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(
+		`INSERT INTO condor_job_deps (successor_id, predecessor_id)
+		 SELECT ?1, ?2
+		  WHERE NOT EXISTS (SELECT 1 FROM condor_job_deps WHERE successor_id = ?1 AND predecessor_id = ?2)`,
+		jd.SuccessorID, jd.PredecessorID,
+	)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	// The new dependency doesn't change which jobs depend on the successor
+	// unless it closes a cycle through the predecessor.
+	query := `
+	WITH RECURSIVE descendants(id) AS (
+	    SELECT successor_id
+	      FROM condor_job_deps
+	     WHERE predecessor_id = ?1
+	  UNION
+	    SELECT deps.successor_id
+	      FROM condor_job_deps deps
+	      JOIN descendants de ON deps.predecessor_id = de.id
+	)
+	SELECT COUNT(*) FROM descendants WHERE id = ?2
+	`
+	if err = tx.QueryRow(query, jd.SuccessorID, jd.PredecessorID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDependencyCycle
+	}
+	return tx.Commit()
+}
+
+// GetPredecessors returns the jobs that the job directly depends on.
+func (s *SQLiteStore) GetPredecessors(successor string) ([]JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + `
+	  FROM condor_job_deps d
+	  JOIN jobs j ON d.predecessor_id = j.id
+	 WHERE d.successor_id = ?
+	`
+	return s.queryJobs(query, successor)
+}
+
+// GetSuccessors returns the jobs that directly depend on the job.
+func (s *SQLiteStore) GetSuccessors(predecessor string) ([]JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + `
+	  FROM condor_job_deps d
+	  JOIN jobs j ON d.successor_id = j.id
+	 WHERE d.predecessor_id = ?
+	`
+	return s.queryJobs(query, predecessor)
+}
+
+// DeleteCondorJobDep removes a job dependency from the database.
+func (s *SQLiteStore) DeleteCondorJobDep(predUUID, succUUID string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM condor_job_deps WHERE successor_id = ? AND predecessor_id = ?`,
+		succUUID, predUUID,
+	)
+	return err
+}
+
+// HasCondorJobDep returns true if the successor already depends on the
+// predecessor.
+func (s *SQLiteStore) HasCondorJobDep(predUUID, succUUID string) (bool, error) {
+	var count int64
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM condor_job_deps WHERE successor_id = ? AND predecessor_id = ?`,
+		succUUID, predUUID,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAncestors returns all of the jobs that the job depends on, either
+// directly or through other jobs.
+func (s *SQLiteStore) GetAncestors(successor string) ([]JobRecord, error) {
+	query := `
+	WITH RECURSIVE ancestors(id) AS (
+	    SELECT predecessor_id
+	      FROM condor_job_deps
+	     WHERE successor_id = ?
+	  UNION
+	    SELECT deps.predecessor_id
+	      FROM condor_job_deps deps
+	      JOIN ancestors a ON deps.successor_id = a.id
+	)
+	SELECT ` + sqliteJobColumns + ` FROM ancestors a JOIN jobs j ON a.id = j.id
+	`
+	return s.queryJobs(query, successor)
+}
+
+// GetDescendants returns all of the jobs that depend on the job, either
+// directly or through other jobs.
+func (s *SQLiteStore) GetDescendants(predecessor string) ([]JobRecord, error) {
+	query := `
+	WITH RECURSIVE descendants(id) AS (
+	    SELECT successor_id
+	      FROM condor_job_deps
+	     WHERE predecessor_id = ?
+	  UNION
+	    SELECT deps.successor_id
+	      FROM condor_job_deps deps
+	      JOIN descendants de ON deps.predecessor_id = de.id
+	)
+	SELECT ` + sqliteJobColumns + ` FROM descendants de JOIN jobs j ON de.id = j.id
+	`
+	return s.queryJobs(query, predecessor)
+}
+
+// InsertJobStatusTransition adds a record of a job moving from one status to
+// another. The ID, DateRecorded, and Sequence fields are ignored, although
+// Sequence is set to the value assigned by the database.
+func (s *SQLiteStore) InsertJobStatusTransition(jt *JobStatusTransition) (string, error) {
+	id := newUUID()
+	query := `
+	INSERT INTO job_status_transitions (
+		id,
+		job_id,
+		condor_job_event_id,
+		from_status,
+		to_status,
+		reason,
+		date_triggered,
+		date_recorded
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.Exec(
+		query,
+		id,
+		jt.JobID,
+		jt.CondorJobEventID,
+		jt.FromStatus,
+		jt.ToStatus,
+		jt.Reason,
+		sqliteTime(jt.DateTriggered),
+		sqliteTime(time.Now()),
+	)
+	if err != nil {
+		return "", err
+	}
+	if jt.Sequence, err = result.LastInsertId(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteJobStatusTransition removes the record of a job status transition.
+func (s *SQLiteStore) DeleteJobStatusTransition(id string) error {
+	_, err := s.db.Exec(`DELETE FROM job_status_transitions WHERE id = ?`, id)
+	return err
+}
+
+// sqliteTransitionColumns is the list of columns selected when looking up
+// status transitions. The order matches scanSQLiteTransition.
+const sqliteTransitionColumns = `
+	id,
+	job_id,
+	condor_job_event_id,
+	from_status,
+	to_status,
+	COALESCE(reason, ''),
+	date_triggered,
+	date_recorded,
+	sequence
+`
+
+// scanSQLiteTransition fills in a JobStatusTransition from a row that contains
+// the sqliteTransitionColumns.
+func scanSQLiteTransition(row rowScanner) (*JobStatusTransition, error) {
+	jt := &JobStatusTransition{}
+	var triggered, recorded sql.NullString
+	err := row.Scan(
+		&jt.ID,
+		&jt.JobID,
+		&jt.CondorJobEventID,
+		&jt.FromStatus,
+		&jt.ToStatus,
+		&jt.Reason,
+		&triggered,
+		&recorded,
+		&jt.Sequence,
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = parseSQLiteTimes(
+		triggered, &jt.DateTriggered,
+		recorded, &jt.DateRecorded,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return jt, nil
+}
+
+// GetLastJobStatusTransition returns the most recent status transition for a
+// job. sql.ErrNoRows is returned if the job hasn't transitioned yet.
+func (s *SQLiteStore) GetLastJobStatusTransition(jobID string) (*JobStatusTransition, error) {
+	query := `SELECT ` + sqliteTransitionColumns + `
+	  FROM job_status_transitions
+	 WHERE job_id = ?
+	 ORDER BY date_triggered DESC, date_recorded DESC
+	 LIMIT 1
+	`
+	return scanSQLiteTransition(s.db.QueryRow(query, jobID))
+}
+
+// GetJobStatusTransitions returns all of the status transitions for a job in
+// the order they were triggered.
+func (s *SQLiteStore) GetJobStatusTransitions(jobID string) ([]JobStatusTransition, error) {
+	query := `SELECT ` + sqliteTransitionColumns + `
+	  FROM job_status_transitions
+	 WHERE job_id = ?
+	 ORDER BY date_triggered ASC, date_recorded ASC
+	`
+	rows, err := s.db.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []JobStatusTransition
+	for rows.Next() {
+		jt, err := scanSQLiteTransition(rows)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, *jt)
+	}
+	return retval, rows.Err()
+}
+
+// GetStreamEvents returns up to 'limit' of the status transitions that come
+// after the 'after' sequence number for the jobs that pass the filter, in the
+// order they were recorded.
+func (s *SQLiteStore) GetStreamEvents(f *StreamFilter, after int64, limit int) ([]StreamEvent, error) {
+	query := `
+	SELECT t.sequence,
+	       j.id,
+	       COALESCE(j.invocation_id, ''),
+	       COALESCE(j.batch_id, ''),
+	       j.submitter,
+	       COALESCE(j.condor_id, ''),
+	       COALESCE(j.app_id, ''),
+	       t.from_status,
+	       t.to_status,
+	       COALESCE(t.reason, ''),
+	       t.date_triggered
+	  FROM job_status_transitions t
+	  JOIN jobs j ON t.job_id = j.id
+	 WHERE t.sequence > ?1
+	   AND (?2 = '' OR j.id = ?2)
+	   AND (?3 = '' OR j.invocation_id = ?3)
+	   AND (?4 = '' OR j.batch_id = ?4)
+	   AND (?5 = '' OR j.submitter = ?5)
+	 ORDER BY t.sequence ASC
+	 LIMIT ?6
+	`
+	rows, err := s.db.Query(query, after, f.JobID, f.InvocationID, f.BatchID, f.Submitter, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []StreamEvent
+	for rows.Next() {
+		var e StreamEvent
+		var triggered sql.NullString
+		err := rows.Scan(
+			&e.ID,
+			&e.JobID,
+			&e.InvocationID,
+			&e.BatchID,
+			&e.Submitter,
+			&e.CondorID,
+			&e.AppID,
+			&e.FromStatus,
+			&e.ToStatus,
+			&e.Reason,
+			&triggered,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err = parseSQLiteTimes(triggered, &e.DateTriggered); err != nil {
+			return nil, err
+		}
+		retval = append(retval, e)
+	}
+	return retval, rows.Err()
+}
+
+// InsertOutboundNotification adds a notification to the outbound queue. The ID,
+// DateCreated, and DateDelivered fields are ignored.
+func (s *SQLiteStore) InsertOutboundNotification(n *OutboundNotification) (string, error) {
+	id := newUUID()
+	query := `
+	INSERT INTO outbound_notifications (
+		id,
+		url,
+		idempotency_key,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		last_error,
+		next_attempt,
+		date_created,
+		invocation_id
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(
+		query,
+		id,
+		n.URL,
+		n.IdempotencyKey,
+		n.Payload,
+		n.Status,
+		n.Attempts,
+		n.MaxAttempts,
+		n.LastError,
+		sqliteTime(n.NextAttempt),
+		sqliteTime(time.Now()),
+		nullableUUID(n.InvocationID),
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// sqliteNotificationColumns is the list of columns selected when looking up
+// outbound notifications. The order matches scanSQLiteNotification.
+const sqliteNotificationColumns = `
+	id,
+	url,
+	idempotency_key,
+	payload,
+	status,
+	attempts,
+	max_attempts,
+	last_error,
+	next_attempt,
+	date_created,
+	date_delivered,
+	COALESCE(invocation_id, '')
+`
+
+// scanSQLiteNotification fills in an OutboundNotification from a row that
+// contains the sqliteNotificationColumns.
+func scanSQLiteNotification(row rowScanner) (*OutboundNotification, error) {
+	n := &OutboundNotification{}
+	var next, created, delivered sql.NullString
+	err := row.Scan(
+		&n.ID,
+		&n.URL,
+		&n.IdempotencyKey,
+		&n.Payload,
+		&n.Status,
+		&n.Attempts,
+		&n.MaxAttempts,
+		&n.LastError,
+		&next,
+		&created,
+		&delivered,
+		&n.InvocationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = parseSQLiteTimes(
+		next, &n.NextAttempt,
+		created, &n.DateCreated,
+		delivered, &n.DateDelivered,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// queryNotifications runs a query that selects the sqliteNotificationColumns
+// and returns the notifications it finds.
+func (s *SQLiteStore) queryNotifications(query string, args ...interface{}) ([]OutboundNotification, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retval []OutboundNotification
+	for rows.Next() {
+		n, err := scanSQLiteNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, *n)
+	}
+	return retval, rows.Err()
+}
+
+// GetOutboundNotification returns a notification from the outbound queue.
+func (s *SQLiteStore) GetOutboundNotification(id string) (*OutboundNotification, error) {
+	query := `SELECT ` + sqliteNotificationColumns + ` FROM outbound_notifications WHERE id = ?`
+	return scanSQLiteNotification(s.db.QueryRow(query, id))
+}
+
+// GetOutboundNotificationByKey returns the notification with the given
+// idempotency key. sql.ErrNoRows is returned if it hasn't been queued.
+func (s *SQLiteStore) GetOutboundNotificationByKey(key string) (*OutboundNotification, error) {
+	query := `SELECT ` + sqliteNotificationColumns + ` FROM outbound_notifications WHERE idempotency_key = ?`
+	return scanSQLiteNotification(s.db.QueryRow(query, key))
+}
+
+// GetDueOutboundNotifications returns up to 'limit' pending notifications
+// whose next attempt is at or before 'now', oldest first. Notifications that
+// are waiting on an earlier pending notification for the same job and URL
+// aren't due.
+func (s *SQLiteStore) GetDueOutboundNotifications(now time.Time, limit int) ([]OutboundNotification, error) {
+	query := `SELECT ` + sqliteNotificationColumns + `
+	  FROM outbound_notifications n
+	 WHERE status = ?1
+	   AND next_attempt <= ?2
+	   AND NOT EXISTS (SELECT 1
+	                     FROM outbound_notifications e
+	                    WHERE e.invocation_id = n.invocation_id
+	                      AND e.url = n.url
+	                      AND e.status = ?1
+	                      AND (e.date_created < n.date_created
+	                           OR (e.date_created = n.date_created AND e.rowid < n.rowid)))
+	 ORDER BY date_created ASC, rowid ASC
+	 LIMIT ?3
+	`
+	return s.queryNotifications(query, NotificationPending, sqliteTime(now), limit)
+}
+
+// GetDeadOutboundNotifications returns the notifications that ran out of
+// delivery attempts, most recent first.
+func (s *SQLiteStore) GetDeadOutboundNotifications() ([]OutboundNotification, error) {
+	query := `SELECT ` + sqliteNotificationColumns + `
+	  FROM outbound_notifications
+	 WHERE status = ?
+	 ORDER BY date_created DESC
+	`
+	return s.queryNotifications(query, NotificationDead)
+}
+
+// UpdateOutboundNotification records the outcome of a delivery attempt.
+func (s *SQLiteStore) UpdateOutboundNotification(n *OutboundNotification) (*OutboundNotification, error) {
+	query := `
+	UPDATE outbound_notifications
+	   SET status = ?,
+	       attempts = ?,
+	       last_error = ?,
+	       next_attempt = ?,
+	       date_delivered = ?
+	 WHERE id = ?
+	`
+	err := execOne(
+		s.db,
+		query,
+		n.Status,
+		n.Attempts,
+		n.LastError,
+		sqliteTime(n.NextAttempt),
+		nullableSQLiteTime(n.DateDelivered),
+		n.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetOutboundNotification(n.ID)
+}
+
+// DeleteOutboundNotification removes a notification from the outbound queue.
+func (s *SQLiteStore) DeleteOutboundNotification(id string) error {
+	_, err := s.db.Exec(`DELETE FROM outbound_notifications WHERE id = ?`, id)
+	return err
+}
+
+// upsertSQLiteUsageQuery is the format for batchQueries that replaces the
+// resource usage of jobs.
+const upsertSQLiteUsageQuery = `INSERT OR REPLACE INTO job_resource_usage (` + jobResourceUsageColumns + `) VALUES %s`
+
+// sqliteUsageRow is the SQL for one row of resource usage from usageRow.
+const sqliteUsageRow = `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// GetJobResourceUsage returns the resource usage of a job. sql.ErrNoRows is
+// returned if none has been recorded.
+func (s *SQLiteStore) GetJobResourceUsage(jobID string) (*JobResourceUsage, error) {
+	query := `
+	SELECT ` + jobResourceUsageColumns + `
+	  FROM job_resource_usage
+	 WHERE job_id = ?
+	`
+	u := &JobResourceUsage{}
+	var triggered sql.NullString
+	if err := s.db.QueryRow(query, jobID).Scan(u.scanDest(&triggered)...); err != nil {
+		return nil, err
+	}
+	var err error
+	if u.DateTriggered, err = parseSQLiteTime(triggered); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SumJobResourceUsage adds up the resource usage of the jobs that match the
+// filter.
+func (s *SQLiteStore) SumJobResourceUsage(f *UsageFilter) (*UsageTotals, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, condition)
+	}
+	if f.Submitter != "" {
+		add("j.submitter = ?", f.Submitter)
+	}
+	if f.AppID != "" {
+		add("j.app_id = ?", f.AppID)
+	}
+	if !f.From.IsZero() {
+		add("u.date_triggered >= ?", sqliteTime(f.From))
+	}
+	if !f.To.IsZero() {
+		add("u.date_triggered < ?", sqliteTime(f.To))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, "\n\t   AND ")
+	}
+	query := fmt.Sprintf(`
+	SELECT %s
+	  FROM job_resource_usage u
+	  JOIN jobs j ON u.job_id = j.id
+	 %s
+	`, usageTotalsColumns, where)
+	return scanUsageTotals(s.db.QueryRow(query, args...))
+}
+
+// GetOrphanedJobs returns the jobs that don't have an invocation ID but have
+// received an event since 'since', in the order they were submitted.
+func (s *SQLiteStore) GetOrphanedJobs(since time.Time) ([]JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + `
+	  FROM jobs j
+	 WHERE j.invocation_id IS NULL
+	   AND EXISTS (SELECT 1
+	                 FROM condor_raw_events r
+	                WHERE r.job_id = j.id
+	                  AND r.date_triggered >= ?)
+	 ORDER BY j.date_submitted, j.id
+	`
+	return s.queryJobs(query, sqliteTime(since))
+}
+
+// GetExpiredJobs returns up to 'limit' of the jobs that moved into a terminal
+// status before 'before' and haven't been archived, in the order they were
+// submitted.
+func (s *SQLiteStore) GetExpiredJobs(before time.Time, limit int) ([]JobRecord, error) {
+	query := `SELECT ` + sqliteJobColumns + `
+	  FROM jobs j
+	 WHERE j.date_archived IS NULL
+	   AND ` + jobStatusExpr + ` IN (?, ?)
+	   AND (SELECT max(t.date_triggered)
+	          FROM job_status_transitions t
+	         WHERE t.job_id = j.id) < ?
+	 ORDER BY j.date_submitted, j.id
+	 LIMIT ?
+	`
+	return s.queryJobs(query, StatusCompleted, StatusFailed, sqliteTime(before), limit)
+}
+
+// GetJobArchive returns everything that's stored for a job.
+func (s *SQLiteStore) GetJobArchive(jobID string) (*JobArchive, error) {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	ja := &JobArchive{Job: *job}
+
+	var archived sql.NullString
+	if err = s.db.QueryRow(`SELECT date_archived FROM jobs WHERE id = ?`, jobID).Scan(&archived); err != nil {
+		return nil, err
+	}
+	if err = parseSQLiteTimes(archived, &ja.DateArchived); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, job_id, event_text, date_triggered FROM condor_raw_events WHERE job_id = ? ORDER BY date_triggered`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var re CondorRawEvent
+		var triggered sql.NullString
+		if err = rows.Scan(&re.ID, &re.JobID, &re.EventText, &triggered); err == nil {
+			err = parseSQLiteTimes(triggered, &re.DateTriggered)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.RawEvents = append(ja.RawEvents, re)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`
+	SELECT je.id,
+	       je.job_id,
+	       je.condor_event_id,
+	       je.condor_raw_event_id,
+	       COALESCE(je.checksum, ''),
+	       je.date_triggered,
+	       ce.event_number
+	  FROM condor_job_events je
+	  JOIN condor_events ce ON je.condor_event_id = ce.id
+	 WHERE je.job_id = ?
+	 ORDER BY je.date_triggered
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var je ArchivedJobEvent
+		var triggered sql.NullString
+		err = rows.Scan(
+			&je.ID,
+			&je.JobID,
+			&je.CondorEventID,
+			&je.CondorRawEventID,
+			&je.Hash,
+			&triggered,
+			&je.EventNumber,
+		)
+		if err == nil {
+			err = parseSQLiteTimes(triggered, &je.DateTriggered)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.JobEvents = append(ja.JobEvents, je)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	last, err := s.GetLastCondorJobEvent(jobID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if last != nil {
+		ja.LastEventID = last.CondorJobEventID
+	}
+
+	rows, err = s.db.Query(
+		`SELECT `+sqliteStopRequestColumns+` FROM condor_job_stop_requests WHERE job_id = ? ORDER BY date_requested`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		sr, err := scanSQLiteStopRequest(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ja.StopRequests = append(ja.StopRequests, *sr)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if ja.Transitions, err = s.GetJobStatusTransitions(jobID); err != nil {
+		return nil, err
+	}
+
+	if ja.Usage, err = s.GetJobResourceUsage(jobID); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT successor_id, predecessor_id FROM condor_job_deps WHERE successor_id = ?1 OR predecessor_id = ?1`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dep CondorJobDep
+		if err = rows.Scan(&dep.SuccessorID, &dep.PredecessorID); err != nil {
+			return nil, err
+		}
+		ja.Dependencies = append(ja.Dependencies, dep)
+	}
+	return ja, rows.Err()
+}
+
+// RestoreJobArchive puts an archived job back into the database with the IDs
+// it had before, all in one transaction. If the job is still in the database,
+// only the raw events, job events, and transitions that were pruned are put
+// back, and the job can be archived again. ErrArchiveRestored is returned if
+// there's nothing to put back. Dependencies are only restored if the other job
+// is in the database too.
+func (s *SQLiteStore) RestoreJobArchive(ja *JobArchive) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM jobs WHERE id = ?`, ja.Job.ID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		restored, err := restoreSQLiteJobHistory(tx, ja)
+		if err != nil {
+			return err
+		}
+		if restored == 0 {
+			return ErrArchiveRestored
+		}
+		if _, err = tx.Exec(`UPDATE jobs SET date_archived = NULL WHERE id = ?`, ja.Job.ID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	args, err := jobArgs(&ja.Job)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO jobs (
+		batch_id,
+		submitter,
+		date_submitted,
+		date_started,
+		date_completed,
+		app_id,
+		exit_code,
+		failure_threshold,
+		failure_count,
+		condor_id,
+		invocation_id,
+		termination_kind,
+		termination_signal,
+		id
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, append(args, ja.Job.ID)...)
+	if err != nil {
+		return err
+	}
+
+	if _, err = restoreSQLiteJobHistory(tx, ja); err != nil {
+		return err
+	}
+
+	if ja.LastEventID != "" {
+		_, err = tx.Exec(
+			`INSERT INTO last_condor_job_events (job_id, condor_job_event_id) VALUES (?, ?)`,
+			ja.Job.ID, ja.LastEventID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sr := range ja.StopRequests {
+		_, err = tx.Exec(`
+		INSERT INTO condor_job_stop_requests (
+			id,
+			job_id,
+			username,
+			date_requested,
+			reason,
+			date_confirmed,
+			condor_job_event_id
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+		`,
+			sr.ID,
+			sr.JobID,
+			sr.Username,
+			sqliteTime(sr.DateRequested),
+			sr.Reason,
+			nullableSQLiteTime(sr.DateConfirmed),
+			nullableUUID(sr.CondorJobEventID),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ja.Usage != nil {
+		err = execBatch(tx, insertUsageQuery, sqliteUsageRow, [][]interface{}{usageRow(ja.Usage, sqliteTime(ja.Usage.DateTriggered))})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dep := range ja.Dependencies {
+		_, err = tx.Exec(`
+		INSERT INTO condor_job_deps (successor_id, predecessor_id)
+		SELECT ?1, ?2
+		 WHERE EXISTS (SELECT 1 FROM jobs WHERE id = ?1)
+		   AND EXISTS (SELECT 1 FROM jobs WHERE id = ?2)
+		   AND NOT EXISTS (SELECT 1 FROM condor_job_deps WHERE successor_id = ?1)
+		`, dep.SuccessorID, dep.PredecessorID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// restoreSQLiteJobHistory puts the raw events, job events, and transitions in
+// the archive that aren't in the database back inside 'tx', and returns how
+// many rows it put back.
+func restoreSQLiteJobHistory(tx *sql.Tx, ja *JobArchive) (int, error) {
+	restored := 0
+	insert := func(query string, args ...interface{}) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		restored += int(count)
+		return err
+	}
+
+	for _, re := range ja.RawEvents {
+		err := insert(`
+		INSERT INTO condor_raw_events (id, job_id, event_text, date_triggered)
+		SELECT ?1, ?2, ?3, ?4
+		 WHERE NOT EXISTS (SELECT 1 FROM condor_raw_events WHERE id = ?1)
+		`, re.ID, re.JobID, re.EventText, sqliteTime(re.DateTriggered))
+		if err != nil {
+			return restored, err
+		}
+	}
+
+	// The condor_events are looked up by number, since their IDs depend on
+	// the database that the job was archived from.
+	for _, je := range ja.JobEvents {
+		err := insert(`
+		INSERT INTO condor_job_events (
+			id,
+			job_id,
+			condor_event_id,
+			condor_raw_event_id,
+			checksum,
+			date_triggered
+		)
+		SELECT ?1, ?2, (SELECT id FROM condor_events WHERE event_number = ?3 LIMIT 1), ?4, ?5, ?6
+		 WHERE NOT EXISTS (SELECT 1 FROM condor_job_events WHERE id = ?1)
+		`, je.ID, je.JobID, je.EventNumber, je.CondorRawEventID, je.Hash, sqliteTime(je.DateTriggered))
+		if err != nil {
+			return restored, err
+		}
+	}
+
+	// Transitions keep their sequence numbers so that stream subscribers
+	// don't see them again. SQLite moves the sequence past them on its own.
+	for _, jt := range ja.Transitions {
+		err := insert(`
+		INSERT INTO job_status_transitions (
+			id,
+			job_id,
+			condor_job_event_id,
+			from_status,
+			to_status,
+			reason,
+			date_triggered,
+			date_recorded,
+			sequence
+		)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9
+		 WHERE NOT EXISTS (SELECT 1 FROM job_status_transitions WHERE id = ?1)
+		`,
+			jt.ID,
+			jt.JobID,
+			jt.CondorJobEventID,
+			jt.FromStatus,
+			jt.ToStatus,
+			jt.Reason,
+			sqliteTime(jt.DateTriggered),
+			sqliteTime(jt.DateRecorded),
+			jt.Sequence,
+		)
+		if err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// PruneJobHistory deletes the job's raw events and transitions with the given
+// IDs in one transaction, and records that the job was archived at
+// 'archived'. The job events parsed from the raw events go with them, along
+// with anything else that refers to those job events.
+func (s *SQLiteStore) PruneJobHistory(jobID string, rawEventIDs, transitionIDs []string, archived time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range transitionIDs {
+		if _, err = tx.Exec(`DELETE FROM job_status_transitions WHERE id = ? AND job_id = ?`, id, jobID); err != nil {
+			return err
+		}
+	}
+	for _, id := range rawEventIDs {
+		if _, err = tx.Exec(`DELETE FROM condor_raw_events WHERE id = ? AND job_id = ?`, id, jobID); err != nil {
+			return err
+		}
+	}
+	err = execOne(tx, `UPDATE jobs SET date_archived = ? WHERE id = ?`, sqliteTime(archived), jobID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WriteEventBatch stores everything in the batch in one transaction. The
+// transitions are added one at a time so that each one's sequence number can
+// be read back.
+func (s *SQLiteStore) WriteEventBatch(b *EventBatch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = writeSQLiteEventBatch(tx, b); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeSQLiteEventBatch does the work of WriteEventBatch inside 'tx'.
+func writeSQLiteEventBatch(tx *sql.Tx, b *EventBatch) error {
+	var err error
+	var rows [][]interface{}
+	for _, jr := range b.NewJobs {
+		args, err := jobArgs(jr)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, append(args, jr.ID))
+	}
+	err = execBatch(tx, `
+	INSERT INTO jobs (
+		batch_id,
+		submitter,
+		date_submitted,
+		date_started,
+		date_completed,
+		app_id,
+		exit_code,
+		failure_threshold,
+		failure_count,
+		condor_id,
+		invocation_id,
+		termination_kind,
+		termination_signal,
+		id
+	) VALUES %s
+	`, `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	for _, jr := range b.Jobs {
+		if err = updateSQLiteEventJob(tx, jr); err != nil {
+			return err
+		}
+	}
+
+	rows = nil
+	for _, re := range b.RawEvents {
+		rows = append(rows, []interface{}{re.ID, re.JobID, re.EventText, sqliteTime(re.DateTriggered)})
+	}
+	err = execBatch(tx, `INSERT INTO condor_raw_events (id, job_id, event_text, date_triggered) VALUES %s`, `(?, ?, ?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, je := range b.JobEvents {
+		rows = append(rows, []interface{}{je.ID, je.JobID, je.CondorEventID, je.CondorRawEventID, sqliteTime(je.DateTriggered), je.Hash})
+	}
+	err = execBatch(tx, `
+	INSERT INTO condor_job_events (
+		id,
+		job_id,
+		condor_event_id,
+		condor_raw_event_id,
+		date_triggered,
+		checksum
+	) VALUES %s
+	`, `(?, ?, ?, ?, ?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, le := range b.LastEvents {
+		rows = append(rows, []interface{}{le.JobID, le.CondorJobEventID})
+	}
+	err = execBatch(tx, `INSERT OR REPLACE INTO last_condor_job_events (job_id, condor_job_event_id) VALUES %s`, `(?, ?)`, rows)
+	if err != nil {
+		return err
+	}
+
+	rows = nil
+	for i := range b.Usage {
+		u := &b.Usage[i]
+		rows = append(rows, usageRow(u, sqliteTime(u.DateTriggered)))
+	}
+	if err = execBatch(tx, upsertSQLiteUsageQuery, sqliteUsageRow, rows); err != nil {
+		return err
+	}
+
+	for _, jt := range b.Transitions {
+		id := newUUID()
+		result, err := tx.Exec(`
+		INSERT INTO job_status_transitions (
+			id,
+			job_id,
+			condor_job_event_id,
+			from_status,
+			to_status,
+			reason,
+			date_triggered,
+			date_recorded
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			id,
+			jt.JobID,
+			jt.CondorJobEventID,
+			jt.FromStatus,
+			jt.ToStatus,
+			jt.Reason,
+			sqliteTime(jt.DateTriggered),
+			sqliteTime(jt.DateRecorded),
+		)
+		if err != nil {
+			return err
+		}
+		if jt.Sequence, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		jt.ID = id
+	}
+	return nil
+}
+
+// WriteJobRebuild stores the rebuilt state of a job in one transaction. The
+// job's transitions are only replaced if they changed, so that they keep their
+// sequence numbers otherwise. The same goes for its resource usage.
+func (s *SQLiteStore) WriteJobRebuild(r *JobRebuild) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, je := range r.JobEvents {
+		_, err = tx.Exec(`UPDATE condor_job_events SET condor_event_id = ? WHERE id = ?`, je.CondorEventID, je.ID)
+		if err != nil {
+			return err
+		}
+	}
+	batch := &EventBatch{Jobs: []*JobRecord{&r.Job}}
+	if _, err = tx.Exec(`DELETE FROM last_condor_job_events WHERE job_id = ?`, r.Job.ID); err != nil {
+		return err
+	}
+	if r.LastEventID != "" {
+		batch.LastEvents = []LastCondorJobEvent{{JobID: r.Job.ID, CondorJobEventID: r.LastEventID}}
+	}
+	if r.TransitionsChanged() {
+		if _, err = tx.Exec(`DELETE FROM job_status_transitions WHERE job_id = ?`, r.Job.ID); err != nil {
+			return err
+		}
+		batch.Transitions = r.Transitions
+	}
+	if !r.Before.Usage.Equal(r.Usage) {
+		if _, err = tx.Exec(`DELETE FROM job_resource_usage WHERE job_id = ?`, r.Job.ID); err != nil {
+			return err
+		}
+		if r.Usage != nil {
+			batch.Usage = []JobResourceUsage{*r.Usage}
+		}
+	}
+	if err = writeSQLiteEventBatch(tx, batch); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AcquireServiceLock gives the lock to l.Holder until 'ttl' after 'now' if it's
+// free, it's expired, or l.Holder already has it. The lock as it's stored
+// afterwards is returned, so the lock wasn't acquired if its Holder is
+// somebody else.
+func (s *SQLiteStore) AcquireServiceLock(l *ServiceLock, now time.Time, ttl time.Duration) (*ServiceLock, error) {
+	_, err := s.db.Exec(
+		`UPDATE service_locks
+		    SET date_acquired = CASE WHEN holder = ?2 THEN date_acquired ELSE ?3 END,
+		        holder = ?2,
+		        date_expires = ?4
+		  WHERE name = ?1
+		    AND (holder = ?2 OR date_expires <= ?3)`,
+		l.Name, l.Holder, sqliteTime(now), sqliteTime(now.Add(ttl)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.Exec(
+		`INSERT OR IGNORE INTO service_locks (name, holder, date_acquired, date_expires) VALUES (?, ?, ?, ?)`,
+		l.Name, l.Holder, sqliteTime(now), sqliteTime(now.Add(ttl)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	current := &ServiceLock{}
+	var acquired, expires sql.NullString
+	err = s.db.QueryRow(
+		`SELECT name, holder, date_acquired, date_expires FROM service_locks WHERE name = ?`,
+		l.Name,
+	).Scan(&current.Name, &current.Holder, &acquired, &expires)
+	if err != nil {
+		return nil, err
+	}
+	if err = parseSQLiteTimes(acquired, &current.DateAcquired, expires, &current.DateExpires); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// ReleaseServiceLock lets go of the lock if 'holder' has it.
+func (s *SQLiteStore) ReleaseServiceLock(name, holder string) error {
+	_, err := s.db.Exec(`DELETE FROM service_locks WHERE name = ? AND holder = ?`, name, holder)
+	return err
+}
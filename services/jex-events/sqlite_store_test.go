@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore returns a SQLiteStore backed by an in-memory database.
+// The test is skipped if jex-events was built without SQLite support.
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	if sqliteDriver == "" {
+		t.Skip("built without SQLite support; run the tests with -tags sqlite")
+	}
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLiteStoreCondorEvents(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreCondorEvents(t, s)
+}
+
+func TestSQLiteStoreJobs(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreJobs(t, s)
+}
+
+func TestSQLiteStoreListJobs(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreListJobs(t, s)
+}
+
+func TestSQLiteStoreDependencies(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreDependencies(t, s)
+}
+
+func TestSQLiteStoreConcurrentDependencies(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreConcurrentDependencies(t, s)
+}
+
+func TestSQLiteStoreOutboundNotifications(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreOutboundNotifications(t, s)
+}
+
+func TestSQLiteStoreEndToEnd(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreEndToEnd(t, s, 1)
+}
+
+func TestSQLiteStoreBatchedEndToEnd(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreEndToEnd(t, s, 10)
+}
+
+func TestSQLiteStoreEventBatches(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	id, err := s.InsertJob(&JobRecord{CondorID: "100", Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStoreEventBatches(t, s, id)
+}
+
+func TestSQLiteStoreOrphanedJobs(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreOrphanedJobs(t, s, 100)
+}
+
+func TestSQLiteStoreRebuild(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreRebuild(t, s, 100)
+}
+
+func TestSQLiteStoreTermination(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreTermination(t, s, 100)
+}
+
+func TestSQLiteStoreResourceUsage(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreResourceUsage(t, s, 100)
+}
+
+func TestSQLiteStoreArchives(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreArchives(t, s)
+}
+
+func TestSQLiteStorePruneJobHistory(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStorePruneJobHistory(t, s, "100")
+}
+
+func TestSQLiteStoreStopRequests(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	jobID, err := s.InsertJob(&JobRecord{CondorID: "999", Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := time.Now().Add(-time.Minute)
+	id, err := s.InsertCondorJobStopRequest(&CondorJobStopRequest{
+		JobID:         jobID,
+		Username:      "unit_tests",
+		DateRequested: requested,
+		Reason:        "testing",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := s.GetLastCondorJobStopRequest(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.ID != id || !sr.DateRequested.Equal(requested) || !sr.DateConfirmed.IsZero() {
+		t.Errorf("The stop request was %#v", sr)
+	}
+	rawID, _ := s.AddCondorRawEvent("raw", jobID)
+	ce, _ := s.GetCondorEventByNumber("009")
+	jobEventID, err := s.AddCondorJobEvent(jobID, ce.ID, rawID, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := s.ConfirmCondorJobStopRequests(jobID, jobEventID, time.Now())
+	if err != nil || count != 1 {
+		t.Errorf("ConfirmCondorJobStopRequests returned %d, %v", count, err)
+	}
+	if count, _ = s.ConfirmCondorJobStopRequests(jobID, jobEventID, time.Now()); count != 0 {
+		t.Errorf("%d stop requests were confirmed twice", count)
+	}
+
+	// Deleting the job event clears it from the stop request instead of
+	// deleting the request.
+	if err = s.DeleteCondorJobEvent(jobEventID); err != nil {
+		t.Fatal(err)
+	}
+	if sr, err = s.GetCondorJobStopRequest(id); err != nil || sr.CondorJobEventID != "" || sr.DateConfirmed.IsZero() {
+		t.Errorf("The stop request was %#v, %v", sr, err)
+	}
+	if _, err = s.GetCondorJobStopRequest(newUUID()); err != sql.ErrNoRows {
+		t.Errorf("Looking up a missing stop request returned %v instead of sql.ErrNoRows", err)
+	}
+}
+
+func TestSQLiteStoreReopen(t *testing.T) {
+	if sqliteDriver == "" {
+		t.Skip("built without SQLite support; run the tests with -tags sqlite")
+	}
+	dir, err := ioutil.TempDir("", "jex-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jex.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.InsertJob(&JobRecord{CondorID: "999", Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, _ := s.GetCondorEventByNumber("005")
+	s.Close()
+
+	// Opening the database again doesn't redo the migrations or seed the
+	// condor_events a second time.
+	if s, err = NewSQLiteStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err = s.GetJob(id); err != nil {
+		t.Errorf("The job wasn't kept: %s", err)
+	}
+	if reloaded, err := s.GetCondorEventByNumber("005"); err != nil || reloaded.ID != ce.ID {
+		t.Errorf("The condor events were seeded again: %v, %v", reloaded, err)
+	}
+}
+
+// benchmarkSQLiteStoreReplay runs benchmarkReplay against a SQLite database
+// file, which is how the SQLite store is used outside of the tests.
+func benchmarkSQLiteStoreReplay(b *testing.B, batchSize int) {
+	if sqliteDriver == "" {
+		b.Skip("built without SQLite support; run the benchmarks with -tags sqlite")
+	}
+	dir, err := ioutil.TempDir("", "jex-events")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSQLiteStore(filepath.Join(dir, "jex-events.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	benchmarkReplay(b, s, batchSize)
+}
+
+func BenchmarkSQLiteStoreReplay(b *testing.B) {
+	benchmarkSQLiteStoreReplay(b, 1)
+}
+
+func BenchmarkSQLiteStoreBatchedReplay(b *testing.B) {
+	benchmarkSQLiteStoreReplay(b, 500)
+}
+
+func TestSQLiteStoreServiceLocks(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreServiceLocks(t, s, eventsLock)
+}
+
+func TestSQLiteStoreJobFlags(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	defer s.Close()
+	testStoreJobFlags(t, s, "100")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// These are the states that a stop request can be in. A stop request is
+// pending until the job's abort event (009) arrives, at which point it's
+// confirmed. Requests that stay pending for longer than the stop timeout are
+// reported as timed out.
+const (
+	StopPending   = "pending"
+	StopConfirmed = "confirmed"
+	StopTimedOut  = "timed out"
+)
+
+// defaultStopTimeout is how long a stop request can go without being confirmed
+// before it's considered to have timed out, unless StopTimeout is set in the
+// configuration.
+const defaultStopTimeout = 5 * time.Minute
+
+// abortEventNumber is the number of the event that HTCondor emits when a job
+// is removed from the queue, which is what the JEX's stop endpoint does.
+const abortEventNumber = "009"
+
+// These are returned by RequestStop when a job can't be stopped.
+var (
+	ErrJobFinished     = errors.New("the job has already finished")
+	ErrNoInvocationID  = errors.New("the job doesn't have an invocation ID, so the JEX can't stop it")
+	ErrStopUserMissing = errors.New("the Username field is required")
+)
+
+// StopRequestState is a stop request along with its status. It's what the
+// stop endpoints return.
+type StopRequestState struct {
+	CondorJobStopRequest
+	Status string
+}
+
+// StopRequestStatus returns the status of a stop request at 'now'.
+func StopRequestStatus(sr *CondorJobStopRequest, timeout time.Duration, now time.Time) string {
+	if !sr.DateConfirmed.IsZero() {
+		return StopConfirmed
+	}
+	if now.Sub(sr.DateRequested) > timeout {
+		return StopTimedOut
+	}
+	return StopPending
+}
+
+// NewStopRequestState returns the state of a stop request at 'now'.
+func NewStopRequestState(sr *CondorJobStopRequest, timeout time.Duration, now time.Time) *StopRequestState {
+	return &StopRequestState{
+		CondorJobStopRequest: *sr,
+		Status:               StopRequestStatus(sr, timeout, now),
+	}
+}
+
+// RequestStop records a request from 'username' to stop a job and forwards it
+// to the JEX. If the job already has a stop request that's pending or
+// confirmed, that request is returned instead and nothing is sent to the JEX;
+// the returned bool is true if a new request was made. A stop request that
+// timed out can be retried by asking again.
+func (p *PostEventHandler) RequestStop(job *JobRecord, username, reason string, timeout time.Duration) (*CondorJobStopRequest, bool, error) {
+	if username == "" {
+		return nil, false, ErrStopUserMissing
+	}
+	last, err := p.DB.GetLastCondorJobStopRequest(job.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, err
+	}
+	if err == nil && StopRequestStatus(last, timeout, time.Now()) != StopTimedOut {
+		return last, false, nil
+	}
+	running, err := p.isStillGoing(job)
+	if err != nil {
+		return nil, false, err
+	}
+	if !running {
+		return nil, false, ErrJobFinished
+	}
+	if job.InvocationID == "" {
+		return nil, false, ErrNoInvocationID
+	}
+	sr := &CondorJobStopRequest{
+		JobID:         job.ID,
+		Username:      username,
+		DateRequested: time.Now(),
+		Reason:        reason,
+	}
+	if sr.ID, err = p.DB.InsertCondorJobStopRequest(sr); err != nil {
+		return nil, false, err
+	}
+	logger.Printf("%s asked for job %s to be stopped: %s", username, job.ID, reason)
+	return sr, true, p.stopInvocation(job.InvocationID)
+}
+
+// ConfirmStop marks the outstanding stop requests for the job in the event as
+// confirmed if the event is the job's abort event.
+func (p *PostEventHandler) ConfirmStop(event *Event, jobEventID string) error {
+	if event.EventNumber != abortEventNumber {
+		return nil
+	}
+	confirmed, err := p.DB.ConfirmCondorJobStopRequests(event.JobID, jobEventID, time.Now())
+	if err != nil {
+		return err
+	}
+	if confirmed > 0 {
+		logger.Printf("Confirmed %d stop request(s) for job %s", confirmed, event.JobID)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopRequestStatus(t *testing.T) {
+	requested := time.Date(2015, 8, 9, 10, 0, 0, 0, time.UTC)
+	sr := &CondorJobStopRequest{DateRequested: requested}
+	if s := StopRequestStatus(sr, time.Minute, requested.Add(30*time.Second)); s != StopPending {
+		t.Errorf("The status was '%s' instead of '%s'", s, StopPending)
+	}
+	if s := StopRequestStatus(sr, time.Minute, requested.Add(2*time.Minute)); s != StopTimedOut {
+		t.Errorf("The status was '%s' instead of '%s'", s, StopTimedOut)
+	}
+	sr.DateConfirmed = requested.Add(3 * time.Minute)
+	if s := StopRequestStatus(sr, time.Minute, requested.Add(5*time.Minute)); s != StopConfirmed {
+		t.Errorf("The status was '%s' instead of '%s'", s, StopConfirmed)
+	}
+}
+
+func TestNewStopRequestState(t *testing.T) {
+	sr := &CondorJobStopRequest{ID: "id", Username: "test", DateRequested: time.Now()}
+	state := NewStopRequestState(sr, time.Minute, time.Now())
+	if state.ID != "id" || state.Username != "test" {
+		t.Errorf("The stop request wasn't copied into the state: %#v", state)
+	}
+	if state.Status != StopPending {
+		t.Errorf("The status was '%s' instead of '%s'", state.Status, StopPending)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// JobStore is the storage that jex-events keeps jobs, their events, their
+// dependencies, and their stop requests in. *Databaser stores everything in
+// PostgreSQL, *SQLiteStore stores it in a SQLite database file, and
+// *MemoryStore keeps it in memory.
+//
+// Implementations return sql.ErrNoRows when a lookup doesn't find anything,
+// except for GetJobByInvocationID, which returns a nil job and a nil error.
+type JobStore interface {
+	Close() error
+
+	// Jobs
+	InsertJob(jr *JobRecord) (string, error)
+	AddJob(condorID string) (*JobRecord, error)
+	UpsertJob(jr *JobRecord) (*JobRecord, error)
+	DeleteJob(uuid string) error
+	GetJob(uuid string) (*JobRecord, error)
+	GetJobByCondorID(condorID string) (*JobRecord, error)
+	GetJobByInvocationID(invocationID string) (*JobRecord, error)
+	GetOrphanedJobs(since time.Time) ([]JobRecord, error)
+	PatchJob(id string, r *JobRequest) (*JobRecord, error)
+	UpdateJob(jr *JobRecord) (*JobRecord, error)
+	ListJobs(f *JobFilter) ([]JobListing, error)
+	GetBatchJobs(batchID string) ([]JobRecord, error)
+
+	// Condor events
+	InsertCondorEvent(ce *CondorEvent) (string, error)
+	DeleteCondorEvent(uuid string) error
+	GetCondorEvent(uuid string) (*CondorEvent, error)
+	GetCondorEventByNumber(number string) (*CondorEvent, error)
+	UpdateCondorEvent(ce *CondorEvent) (*CondorEvent, error)
+	GetCondorEventMappings() ([]EventMapping, error)
+
+	// Raw events
+	InsertCondorRawEvent(re *CondorRawEvent) (string, error)
+	AddCondorRawEvent(eventText string, jobID string) (string, error)
+	DeleteCondorRawEvent(uuid string) error
+	GetCondorRawEvent(uuid string) (*CondorRawEvent, error)
+	UpdateCondorRawEvent(re *CondorRawEvent) (*CondorRawEvent, error)
+
+	// Job events
+	InsertCondorJobEvent(je *CondorJobEvent) (string, error)
+	DoesCondorJobEventExist(checksum string) (bool, error)
+	FindCondorJobEventChecksums(checksums []string) (map[string]bool, error)
+	CountCondorJobEventsByNumber(jobID, eventNumber string) (int, error)
+	GetJobEventHistory(jobID string) ([]JobEventRecord, error)
+	AddCondorJobEvent(jobID string, eventID string, rawEventID string, hash string) (string, error)
+	DeleteCondorJobEvent(uuid string) error
+	GetCondorJobEvent(uuid string) (*CondorJobEvent, error)
+	UpdateCondorJobEvent(je *CondorJobEvent) (*CondorJobEvent, error)
+	InsertLastCondorJobEvent(je *LastCondorJobEvent) (string, error)
+	DeleteLastCondorJobEvent(uuid string) error
+	GetLastCondorJobEvent(uuid string) (*LastCondorJobEvent, error)
+	UpdateLastCondorJobEvent(je *LastCondorJobEvent) (*LastCondorJobEvent, error)
+	UpsertLastCondorJobEvent(jobEventID, jobID string) (string, error)
+
+	// Stop requests
+	InsertCondorJobStopRequest(jr *CondorJobStopRequest) (string, error)
+	DeleteCondorJobStopRequest(uuid string) error
+	GetCondorJobStopRequest(uuid string) (*CondorJobStopRequest, error)
+	GetLastCondorJobStopRequest(jobID string) (*CondorJobStopRequest, error)
+	ConfirmCondorJobStopRequests(jobID, jobEventID string, confirmed time.Time) (int64, error)
+	UpdateCondorJobStopRequest(jr *CondorJobStopRequest) (*CondorJobStopRequest, error)
+	HasCondorJobStopRequest(jobID string) (bool, error)
+
+	// Flags
+	SetJobFlag(jobID, flag string, set time.Time) (bool, error)
+	HasJobFlag(jobID, flag string) (bool, error)
+
+	// Dependencies
+	InsertCondorJobDep(jd *CondorJobDep) error
+	AddCondorJobDep(jd *CondorJobDep) error
+	GetPredecessors(successor string) ([]JobRecord, error)
+	GetSuccessors(predecessor string) ([]JobRecord, error)
+	DeleteCondorJobDep(predUUID, succUUID string) error
+	HasCondorJobDep(predUUID, succUUID string) (bool, error)
+	GetAncestors(successor string) ([]JobRecord, error)
+	GetDescendants(predecessor string) ([]JobRecord, error)
+
+	// Status transitions and streams
+	InsertJobStatusTransition(jt *JobStatusTransition) (string, error)
+	DeleteJobStatusTransition(uuid string) error
+	GetLastJobStatusTransition(jobID string) (*JobStatusTransition, error)
+	GetJobStatusTransitions(jobID string) ([]JobStatusTransition, error)
+	GetStreamEvents(f *StreamFilter, after int64, limit int) ([]StreamEvent, error)
+
+	// Outbound notifications
+	InsertOutboundNotification(n *OutboundNotification) (string, error)
+	GetOutboundNotification(uuid string) (*OutboundNotification, error)
+	GetOutboundNotificationByKey(key string) (*OutboundNotification, error)
+	GetDueOutboundNotifications(now time.Time, limit int) ([]OutboundNotification, error)
+	GetDeadOutboundNotifications() ([]OutboundNotification, error)
+	UpdateOutboundNotification(n *OutboundNotification) (*OutboundNotification, error)
+	DeleteOutboundNotification(uuid string) error
+
+	// Resource usage
+	GetJobResourceUsage(jobID string) (*JobResourceUsage, error)
+	SumJobResourceUsage(f *UsageFilter) (*UsageTotals, error)
+
+	// Event batches
+	WriteEventBatch(b *EventBatch) error
+
+	// Rebuilds
+	WriteJobRebuild(r *JobRebuild) error
+
+	// Retention
+	GetExpiredJobs(before time.Time, limit int) ([]JobRecord, error)
+	GetJobArchive(jobID string) (*JobArchive, error)
+	RestoreJobArchive(ja *JobArchive) error
+	PruneJobHistory(jobID string, rawEventIDs, transitionIDs []string, archived time.Time) error
+
+	// Locks
+	AcquireServiceLock(l *ServiceLock, now time.Time, ttl time.Duration) (*ServiceLock, error)
+	ReleaseServiceLock(name, holder string) error
+}
+
+var _ JobStore = (*Databaser)(nil)
+
+// OpenJobStore opens the store that the DBURI in the configuration points to.
+// SQLite databases are brought up to date when they're opened, but PostgreSQL
+// databases have to be up to date already.
+func OpenJobStore(config *Configuration) (JobStore, error) {
+	if IsSQLiteURI(config.DBURI) {
+		s, err := NewSQLiteStore(strings.TrimPrefix(config.DBURI, sqliteScheme))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	d, err := NewDatabaser(config.DBURI)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.CheckVersion(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// newUUID returns a new version 1 UUID, the same kind that uuid_generate_v1()
+// returns in PostgreSQL, for the stores that have to come up with their own IDs.
+func newUUID() string {
+	return uuid.NewUUID().String()
+}
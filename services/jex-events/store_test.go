@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/streadway/amqp"
+)
+
+// The testStore functions check that a JobStore behaves like the Databaser.
+// They're run against each JobStore that doesn't need a database server.
+
+func testStoreCondorEvents(t *testing.T, s JobStore) {
+	ce, err := s.GetCondorEventByNumber("003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ce.EventDesc, "The job's complete state") {
+		t.Errorf("The description of event 003 was '%s'", ce.EventDesc)
+	}
+	if _, err = s.GetCondorEventByNumber("999"); err != sql.ErrNoRows {
+		t.Errorf("Looking up a missing event returned %v instead of sql.ErrNoRows", err)
+	}
+	for _, number := range []string{"000", "001", "005", "009", "012", "028"} {
+		if _, err = s.GetCondorEventByNumber(number); err != nil {
+			t.Errorf("Event %s wasn't seeded: %s", number, err)
+		}
+	}
+}
+
+func testStoreJobs(t *testing.T, s JobStore) {
+	invID := uuid.New()
+	id, err := s.InsertJob(&JobRecord{
+		CondorID:      "999",
+		Submitter:     "unit_tests",
+		AppID:         uuid.New(),
+		InvocationID:  invID,
+		DateSubmitted: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr, err := s.GetJob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jr.DateStarted.Equal(time.Unix(0, 0)) {
+		t.Errorf("DateStarted was %s instead of the epoch", jr.DateStarted)
+	}
+	if found, _ := s.GetJobByInvocationID(invID); found == nil || found.ID != id {
+		t.Errorf("The job wasn't found by its invocation ID")
+	}
+	if found, err := s.GetJobByInvocationID(uuid.New()); found != nil || err != nil {
+		t.Errorf("Looking up a missing invocation returned %v, %v", found, err)
+	}
+	added, err := s.AddJob("999")
+	if err != nil || added.ID != id {
+		t.Errorf("AddJob didn't return the existing job")
+	}
+	exitCode := 2
+	patched, err := s.PatchJob(id, &JobRequest{ExitCode: &exitCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.ExitCode != 2 || patched.Submitter != "unit_tests" {
+		t.Errorf("The patched job was %#v", patched)
+	}
+	if _, err = s.PatchJob(uuid.New(), &JobRequest{ExitCode: &exitCode}); err != sql.ErrNoRows {
+		t.Errorf("Patching a missing job returned %v instead of sql.ErrNoRows", err)
+	}
+	if _, err = s.UpdateJob(&JobRecord{ID: id, AppID: "not-a-uuid"}); err == nil {
+		t.Error("A job with an invalid AppID was stored")
+	}
+	patched.TerminationKind = TerminationCoreDump
+	patched.TerminationSignal = 11
+	if _, err = s.UpdateJob(patched); err != nil {
+		t.Fatal(err)
+	}
+	if jr, _ = s.GetJob(id); jr.TerminationKind != TerminationCoreDump || jr.TerminationSignal != 11 {
+		t.Errorf("The termination was stored as '%s' with signal %d", jr.TerminationKind, jr.TerminationSignal)
+	}
+
+	// Deleting the job cascades to the records that refer to it.
+	rawID, err := s.AddCondorRawEvent("raw", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, _ := s.GetCondorEventByNumber("000")
+	jobEventID, err := s.AddCondorJobEvent(id, ce.ID, rawID, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.UpsertLastCondorJobEvent(jobEventID, id); err != nil {
+		t.Error(err)
+	}
+	if _, err = s.InsertCondorJobStopRequest(&CondorJobStopRequest{JobID: id, Username: "unit_tests"}); err != nil {
+		t.Error(err)
+	}
+	if err = s.DeleteJob(id); err != nil {
+		t.Error(err)
+	}
+	if _, err = s.GetCondorRawEvent(rawID); err != sql.ErrNoRows {
+		t.Error("The raw event wasn't deleted with the job")
+	}
+	if exists, _ := s.DoesCondorJobEventExist("hash"); exists {
+		t.Error("The job event wasn't deleted with the job")
+	}
+	if _, err = s.GetLastCondorJobEvent(id); err != sql.ErrNoRows {
+		t.Error("The last event wasn't deleted with the job")
+	}
+	if has, _ := s.HasCondorJobStopRequest(id); has {
+		t.Error("The stop request wasn't deleted with the job")
+	}
+	if _, err = s.AddCondorRawEvent("raw", id); err == nil {
+		t.Error("A raw event was added for a deleted job")
+	}
+}
+
+func testStoreListJobs(t *testing.T, s JobStore) {
+	submitted := time.Now()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := s.InsertJob(&JobRecord{
+			Submitter:     "unit_tests",
+			DateSubmitted: submitted.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := s.InsertJob(&JobRecord{Submitter: "someone_else"}); err != nil {
+		t.Fatal(err)
+	}
+	filter := &JobFilter{Submitter: "unit_tests", Sort: SortDateSubmitted, Limit: 2}
+	listings, err := s.ListJobs(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := filter.Page(listings)
+	if len(page.Jobs) != 2 || page.Jobs[0].ID != ids[0] || page.Jobs[1].ID != ids[1] {
+		t.Fatalf("The first page was %#v", page.Jobs)
+	}
+	if page.Jobs[0].Status != StatusSubmitted {
+		t.Errorf("The status was %s instead of %s", page.Jobs[0].Status, StatusSubmitted)
+	}
+	if filter.Cursor, err = DecodeJobCursor(page.NextCursor); err != nil {
+		t.Fatal(err)
+	}
+	listings, _ = s.ListJobs(filter)
+	page = filter.Page(listings)
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != ids[2] || page.NextCursor != "" {
+		t.Errorf("The second page was %#v", page)
+	}
+
+	filter = &JobFilter{Submitter: "unit_tests", Sort: SortDateSubmitted, Descending: true, Limit: 10}
+	listings, _ = s.ListJobs(filter)
+	if len(listings) != 3 || listings[0].ID != ids[2] {
+		t.Errorf("The descending listing was %#v", listings)
+	}
+	filter.Status = StatusRunning
+	if listings, _ = s.ListJobs(filter); len(listings) != 0 {
+		t.Errorf("%d jobs were Running", len(listings))
+	}
+}
+
+func testStoreDependencies(t *testing.T, s JobStore) {
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := s.InsertJob(&JobRecord{Submitter: "unit_tests"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	deps := []*CondorJobDep{
+		{PredecessorID: ids[0], SuccessorID: ids[1]},
+		{PredecessorID: ids[1], SuccessorID: ids[2]},
+	}
+	for _, dep := range deps {
+		if err := AddJobDependency(s, dep); err != nil {
+			t.Error(err)
+		}
+	}
+	err := AddJobDependency(s, &CondorJobDep{PredecessorID: ids[2], SuccessorID: ids[0]})
+	if err != ErrDependencyCycle {
+		t.Errorf("Adding a dependency that creates a cycle returned %v instead of ErrDependencyCycle", err)
+	}
+	if err = s.InsertCondorJobDep(&CondorJobDep{PredecessorID: ids[0], SuccessorID: ids[2]}); err == nil {
+		t.Error("A job was given a second predecessor")
+	}
+	graph, err := LoadJobDependencies(s, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Predecessors) != 1 || graph.Predecessors[0].ID != ids[0] {
+		t.Errorf("The predecessors were %#v", graph.Predecessors)
+	}
+	if len(graph.Successors) != 1 || graph.Successors[0].ID != ids[2] {
+		t.Errorf("The successors were %#v", graph.Successors)
+	}
+	if descendants, _ := s.GetDescendants(ids[0]); len(descendants) != 2 {
+		t.Errorf("There were %d descendants instead of 2", len(descendants))
+	}
+	if err = s.DeleteJob(ids[1]); err != nil {
+		t.Error(err)
+	}
+	if ancestors, _ := s.GetAncestors(ids[2]); len(ancestors) != 0 {
+		t.Errorf("The dependencies weren't deleted with the job: %#v", ancestors)
+	}
+}
+
+// testStoreConcurrentDependencies adds the two halves of a cycle at the same
+// time, which should leave exactly one of them in place.
+func testStoreConcurrentDependencies(t *testing.T, s JobStore) {
+	for i := 0; i < 10; i++ {
+		var ids []string
+		for j := 0; j < 2; j++ {
+			id, err := s.InsertJob(&JobRecord{Submitter: "unit_tests"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		errs := make(chan error, 2)
+		for _, dep := range []*CondorJobDep{
+			{PredecessorID: ids[0], SuccessorID: ids[1]},
+			{PredecessorID: ids[1], SuccessorID: ids[0]},
+		} {
+			go func(dep *CondorJobDep) {
+				errs <- AddJobDependency(s, dep)
+			}(dep)
+		}
+		var added, cycles int
+		for j := 0; j < 2; j++ {
+			switch err := <-errs; err {
+			case nil:
+				added++
+			case ErrDependencyCycle:
+				cycles++
+			default:
+				t.Fatal(err)
+			}
+		}
+		if added != 1 || cycles != 1 {
+			t.Fatalf("%d of the dependencies were added and %d were cycles", added, cycles)
+		}
+		for _, id := range ids {
+			if err := s.DeleteJob(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func testStoreOutboundNotifications(t *testing.T, s JobStore) {
+	n := &OutboundNotification{
+		URL:            "http://localhost/",
+		IdempotencyKey: "key",
+		Status:         NotificationPending,
+		MaxAttempts:    3,
+		NextAttempt:    time.Now().Add(-time.Minute),
+	}
+	id, err := s.InsertOutboundNotification(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.InsertOutboundNotification(n); err == nil {
+		t.Error("An idempotency key was used twice")
+	}
+	due, _ := s.GetDueOutboundNotifications(time.Now(), 10)
+	if len(due) != 1 || due[0].ID != id {
+		t.Errorf("The due notifications were %#v", due)
+	}
+	due[0].Status = NotificationDead
+	if _, err = s.UpdateOutboundNotification(&due[0]); err != nil {
+		t.Error(err)
+	}
+	if dead, _ := s.GetDeadOutboundNotifications(); len(dead) != 1 {
+		t.Errorf("There were %d dead notifications instead of 1", len(dead))
+	}
+	if due, _ = s.GetDueOutboundNotifications(time.Now(), 10); len(due) != 0 {
+		t.Errorf("A dead notification was due")
+	}
+
+	// A job's notifications go out in order, so one that's backing off holds
+	// up the later ones to the same URL.
+	invID := uuid.New()
+	var ids []string
+	for i, url := range []string{"http://localhost/", "http://localhost/", "http://localhost/other"} {
+		n := &OutboundNotification{
+			InvocationID:   invID,
+			URL:            url,
+			IdempotencyKey: fmt.Sprintf("ordered %d", i),
+			Status:         NotificationPending,
+			MaxAttempts:    3,
+			NextAttempt:    time.Now().Add(-time.Minute),
+		}
+		if i == 0 {
+			n.NextAttempt = time.Now().Add(time.Hour)
+		}
+		id, err := s.InsertOutboundNotification(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		time.Sleep(time.Millisecond)
+	}
+	if due, _ = s.GetDueOutboundNotifications(time.Now(), 10); len(due) != 1 || due[0].ID != ids[2] || due[0].InvocationID != invID {
+		t.Errorf("The due notifications were %#v instead of just the one to the other URL", due)
+	}
+	first, _ := s.GetOutboundNotification(ids[0])
+	first.Status = NotificationDelivered
+	first.DateDelivered = time.Now()
+	if _, err = s.UpdateOutboundNotification(first); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ = s.GetDueOutboundNotifications(time.Now(), 10); len(due) != 2 || due[0].ID != ids[1] {
+		t.Errorf("The next notification wasn't due after the first was delivered: %#v", due)
+	}
+}
+
+// condorEventDelivery returns an AMQP delivery for a Condor event like the
+// ones that jex-events consumes.
+func condorEventDelivery(t testing.TB, text string) amqp.Delivery {
+	body, err := json.Marshal(&Event{Event: text, Hash: fmt.Sprintf("%x", text)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Body: body}
+}
+
+// testStoreEndToEnd runs a job through the HTTP API and BatchEventHandler
+// with everything stored in s, handling up to batchSize events at a time.
+func testStoreEndToEnd(t *testing.T, s JobStore, batchSize int) {
+	handler := &PostEventHandler{DB: s, Streams: NewStatusBroker()}
+	api := &HTTPAPI{d: s, events: handler, allowUnauthenticated: true, stopTimeout: defaultStopTimeout}
+	server := httptest.NewServer(api.Router())
+	defer server.Close()
+
+	invID := uuid.New()
+	body := fmt.Sprintf(`{"CondorID" : "100", "Submitter" : "unit_tests", "AppID" : "%s", "InvocationID" : "%s"}`, uuid.New(), invID)
+	resp, err := http.Post(server.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Posting the job returned %d", resp.StatusCode)
+	}
+
+	events := []string{
+		"000 (100.000.000) 08/11/15 12:00:00 Job submitted from host: <127.0.0.1:9618>\n",
+		"001 (100.000.000) 08/11/15 12:01:00 Job executing on host: <127.0.0.1:9618>\n",
+		"001 (100.000.000) 08/11/15 12:01:00 Job executing on host: <127.0.0.1:9618>\n",
+		"005 (100.000.000) 08/11/15 12:10:00 Job terminated.\n\t(1) Normal termination (return value 0)\n",
+	}
+	deliveries := make(chan amqp.Delivery, len(events))
+	for _, text := range events {
+		deliveries <- condorEventDelivery(t, text)
+	}
+	close(deliveries)
+	BatchEventHandler(deliveries, make(chan int), s, handler, batchSize, 0)
+
+	job, err := s.GetJobByInvocationID(invID)
+	if err != nil || job == nil {
+		t.Fatalf("The posted job wasn't found: %v", err)
+	}
+	transitions, err := s.GetJobStatusTransitions(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, jt := range transitions {
+		statuses = append(statuses, jt.ToStatus)
+	}
+	if strings.Join(statuses, ",") != "Submitted,Running,Completed" {
+		t.Errorf("The job went through %v", statuses)
+	}
+	history, _ := s.GetJobEventHistory(job.ID)
+	if len(history) != 3 {
+		t.Errorf("%d events were recorded instead of 3", len(history))
+	}
+
+	resp, err = http.Get(server.URL + "/jobs?status=Completed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page JobListPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != job.ID {
+		t.Errorf("The completed jobs were %#v", page.Jobs)
+	}
+
+	resp, err = http.Get(server.URL + "/last-events/" + invID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state JobState
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.State.Status != StatusCompleted || state.State.UUID != invID {
+		t.Errorf("The last event was %#v", state)
+	}
+}
+
+// testStoreEventBatches writes a batch of events for the job and checks that
+// everything in it was stored, then checks that a batch that can't be stored
+// leaves nothing behind.
+func testStoreEventBatches(t *testing.T, s JobStore, jobID string) {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, err := s.GetCondorEventByNumber("001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	hash := uuid.New()
+	re := CondorRawEvent{ID: newUUID(), JobID: jobID, EventText: "batched", DateTriggered: now}
+	je := CondorJobEvent{
+		ID:               newUUID(),
+		JobID:            jobID,
+		CondorEventID:    ce.ID,
+		CondorRawEventID: re.ID,
+		Hash:             hash,
+		DateTriggered:    now,
+	}
+	jt := &JobStatusTransition{
+		JobID:            jobID,
+		CondorJobEventID: je.ID,
+		FromStatus:       StatusSubmitted,
+		ToStatus:         StatusRunning,
+		DateTriggered:    now,
+		DateRecorded:     now,
+	}
+	job.ExitCode = 2
+
+	// The job is linked and its submitter is changed while the batch is being
+	// handled, which the batch's stale copy of the job mustn't undo.
+	invID, submitter := uuid.New(), "patched_unit_tests"
+	if _, err = s.PatchJob(jobID, &JobRequest{InvocationID: &invID, Submitter: &submitter}); err != nil {
+		t.Fatal(err)
+	}
+	newJob := &JobRecord{ID: newUUID(), CondorID: job.CondorID + "-new", Submitter: "unit_tests"}
+	defer s.DeleteJob(newJob.ID)
+	err = s.WriteEventBatch(&EventBatch{
+		NewJobs:     []*JobRecord{newJob},
+		Jobs:        []*JobRecord{job},
+		RawEvents:   []CondorRawEvent{re, {ID: newUUID(), JobID: newJob.ID, EventText: "new", DateTriggered: now}},
+		JobEvents:   []CondorJobEvent{je},
+		LastEvents:  []LastCondorJobEvent{{JobID: jobID, CondorJobEventID: je.ID}},
+		Transitions: []*JobStatusTransition{jt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jt.ID == "" || jt.Sequence == 0 {
+		t.Errorf("The transition's ID and sequence weren't filled in: %#v", jt)
+	}
+	found, err := s.FindCondorJobEventChecksums([]string{hash, uuid.New()})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(found) != 1 || !found[hash] {
+		t.Errorf("The checksums found were %v", found)
+	}
+	if updated, _ := s.GetJob(jobID); updated == nil || updated.ExitCode != 2 {
+		t.Errorf("The job wasn't updated: %#v", updated)
+	} else if updated.InvocationID != invID || updated.Submitter != submitter {
+		t.Errorf("The batch undid the changes made to the job while it was handled: %#v", updated)
+	}
+	if added, _ := s.GetJobByCondorID(newJob.CondorID); added == nil || added.ID != newJob.ID {
+		t.Errorf("The new job wasn't added: %#v", added)
+	}
+	if le, _ := s.GetLastCondorJobEvent(jobID); le == nil || le.CondorJobEventID != je.ID {
+		t.Errorf("The last event was %#v", le)
+	}
+	if last, _ := s.GetLastJobStatusTransition(jobID); last == nil || last.ID != jt.ID {
+		t.Errorf("The last transition was %#v", last)
+	}
+	if history, _ := s.GetJobEventHistory(jobID); len(history) != 1 || history[0].RawEvent != "batched" {
+		t.Errorf("The job's history was %#v", history)
+	}
+
+	err = s.WriteEventBatch(&EventBatch{
+		RawEvents: []CondorRawEvent{{ID: newUUID(), JobID: jobID, EventText: "lost", DateTriggered: now}},
+		JobEvents: []CondorJobEvent{{
+			ID:               newUUID(),
+			JobID:            jobID,
+			CondorEventID:    ce.ID,
+			CondorRawEventID: newUUID(),
+			Hash:             uuid.New(),
+			DateTriggered:    now,
+		}},
+	})
+	if err == nil {
+		t.Error("A batch with a job event for a missing raw event was stored")
+	}
+	if history, _ := s.GetJobEventHistory(jobID); len(history) != 1 {
+		t.Errorf("Part of a batch that couldn't be stored was kept: %#v", history)
+	}
+}
+
+// processJobEvents runs the replay events for a job with the given Condor ID
+// through ProcessEvents and returns the job.
+func processJobEvents(t *testing.T, s JobStore, condorID int) *JobRecord {
+	var bodies [][]byte
+	for _, text := range replayEventTexts {
+		bodies = append(bodies, condorEventDelivery(t, fmt.Sprintf(text, condorID)).Body)
+	}
+	ProcessEvents(s, &PostEventHandler{DB: s}, bodies)
+	job, err := s.GetJobByCondorID(strconv.Itoa(condorID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// testStoreOrphanedJobs checks that jobs with recent events are only orphans
+// until they're linked to an invocation. Other jobs in the store are ignored.
+func testStoreOrphanedJobs(t *testing.T, s JobStore, condorID int) {
+	orphan := processJobEvents(t, s, condorID)
+	defer s.DeleteJob(orphan.ID)
+	linked := processJobEvents(t, s, condorID+1)
+	defer s.DeleteJob(linked.ID)
+	invID := uuid.New()
+	if _, err := s.PatchJob(linked.ID, &JobRequest{InvocationID: &invID}); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := s.GetOrphanedJobs(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, jr := range orphans {
+		found[jr.ID] = true
+	}
+	if !found[orphan.ID] || found[linked.ID] {
+		t.Errorf("The orphaned jobs were %#v", orphans)
+	}
+	if orphans, _ = s.GetOrphanedJobs(time.Now().Add(time.Hour)); len(orphans) != 0 {
+		t.Errorf("Jobs without recent events were orphans: %#v", orphans)
+	}
+}
+
+// testStoreRebuild messes up what's stored for a job the way a bug might, and
+// checks that a dry run reports it without changing anything and that a
+// rebuild puts it back.
+func testStoreRebuild(t *testing.T, s JobStore, condorID int) {
+	job := processJobEvents(t, s, condorID)
+	defer s.DeleteJob(job.ID)
+	transitions, err := s.GetJobStatusTransitions(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 3 {
+		t.Fatalf("The job had %d transitions instead of 3", len(transitions))
+	}
+	if err = s.DeleteJobStatusTransition(transitions[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.UpsertLastCondorJobEvent(transitions[0].CondorJobEventID, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	job.FailureCount = 2
+	if _, err = s.UpdateJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	var report bytes.Buffer
+	result, err := RebuildJobs(s, []string{job.ID}, true, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 1 || result.Changed != 1 || result.Failed != 0 {
+		t.Errorf("The dry run's result was %#v", result)
+	}
+	for _, diff := range []string{"status: Running -> Completed", "failure count: 2 -> 0", "last event: "} {
+		if !strings.Contains(report.String(), diff) {
+			t.Errorf("The dry run didn't report '%s':\n%s", diff, report.String())
+		}
+	}
+	if jt, _ := s.GetLastJobStatusTransition(job.ID); jt == nil || jt.ToStatus != StatusRunning {
+		t.Errorf("The dry run changed the job's status: %#v", jt)
+	}
+
+	if result, err = RebuildJobs(s, []string{job.ID}, false, &report); err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed != 1 {
+		t.Errorf("The rebuild's result was %#v", result)
+	}
+	if jt, _ := s.GetLastJobStatusTransition(job.ID); jt == nil || jt.ToStatus != StatusCompleted {
+		t.Errorf("The job's last transition after the rebuild was %#v", jt)
+	}
+	if rebuilt, _ := s.GetJob(job.ID); rebuilt == nil || rebuilt.FailureCount != 0 {
+		t.Errorf("The job after the rebuild was %#v", rebuilt)
+	}
+	if le, _ := s.GetLastCondorJobEvent(job.ID); le == nil || le.CondorJobEventID != transitions[2].CondorJobEventID {
+		t.Errorf("The last event after the rebuild was %#v", le)
+	}
+
+	report.Reset()
+	if result, _ = RebuildJobs(s, []string{job.ID}, false, &report); result.Changed != 0 {
+		t.Errorf("Rebuilding the job again changed it:\n%s", report.String())
+	}
+}
+
+// testStoreTermination checks that how a job terminated stays on the job when
+// Condor logs a job ad event after the terminated event, both when the events
+// are processed and when the job is rebuilt.
+func testStoreTermination(t *testing.T, s JobStore, condorID int) {
+	handler := &PostEventHandler{DB: s}
+	for _, text := range []string{
+		"000 (%d.000.000) 08/14/15 09:00:00 Job submitted from host: <127.0.0.1:9618>\n",
+		"005 (%d.000.000) 08/14/15 09:30:00 Job terminated.\n\t(0) Abnormal termination (signal 9)\n\t(0) No core file\n",
+		"028 (%d.000.000) 08/14/15 09:30:01 Job ad information event triggered.\n",
+	} {
+		body := condorEventDelivery(t, fmt.Sprintf(text, condorID)).Body
+		ProcessEvents(s, handler, [][]byte{body})
+	}
+	job, err := s.GetJobByCondorID(strconv.Itoa(condorID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteJob(job.ID)
+	check := func(when string, jr *JobRecord) {
+		if jr.TerminationKind != TerminationSignal || jr.TerminationSignal != 9 || jr.ExitCode != EventCodeNotSet || jr.FailureCount != 1 {
+			t.Errorf("%s, the job was '%s' with signal %d, exit code %d, and %d failures",
+				when, jr.TerminationKind, jr.TerminationSignal, jr.ExitCode, jr.FailureCount)
+		}
+	}
+	check("After the job ad event", job)
+
+	var report bytes.Buffer
+	result, err := RebuildJobs(s, []string{job.ID}, false, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed != 0 {
+		t.Errorf("Rebuilding the job changed it:\n%s", report.String())
+	}
+	if job, err = s.GetJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	check("After the rebuild", job)
+}
+
+// testStoreResourceUsage checks that the usage in a job's terminated event is
+// recorded, added up, archived, and restored. The jobs are given a submitter
+// of their own so that other jobs in the store aren't added up.
+func testStoreResourceUsage(t *testing.T, s JobStore, condorID int) {
+	submitter := fmt.Sprintf("usage_tests_%d", condorID)
+	var jobs []*JobRecord
+	for _, id := range []int{condorID, condorID + 1} {
+		ProcessEvents(s, &PostEventHandler{DB: s}, [][]byte{
+			condorEventDelivery(t, fmt.Sprintf(replayEventTexts[0], id)).Body,
+			condorEventDelivery(t, fmt.Sprintf(terminatedEventWithUsage, id)).Body,
+		})
+		job, err := s.GetJobByCondorID(strconv.Itoa(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.DeleteJob(job.ID)
+		job.Submitter = submitter
+		if _, err = s.UpdateJob(job); err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	u, err := s.GetJobResourceUsage(jobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := s.GetLastCondorJobEvent(jobs[0].ID)
+	if last == nil || u.CondorJobEventID != last.CondorJobEventID {
+		t.Errorf("The usage was recorded for job event %s instead of the last one", u.CondorJobEventID)
+	}
+	if u.UserCPUSeconds != 66 || u.BytesReceived != 801816 || u.MemoryAllocatedMB != 1024 || u.DiskUsageKB != 1019 {
+		t.Errorf("The recorded usage was %#v", u)
+	}
+
+	totals, err := s.SumJobResourceUsage(&UsageFilter{Submitter: submitter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UsageTotals{Jobs: 2, UserCPUSeconds: 132, SysCPUSeconds: 4, BytesSent: 246182, BytesReceived: 1603632, MaxMemoryUsageMB: 3, MaxDiskUsageKB: 1019}
+	if *totals != want {
+		t.Errorf("The usage totals were %#v instead of %#v", totals, want)
+	}
+	if totals, _ = s.SumJobResourceUsage(&UsageFilter{Submitter: submitter, From: time.Now().Add(time.Hour)}); totals == nil || totals.Jobs != 0 {
+		t.Errorf("Usage from the future was %#v", totals)
+	}
+
+	ja, err := s.GetJobArchive(jobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ja.Usage.Equal(u) {
+		t.Errorf("The archived usage was %#v", ja.Usage)
+	}
+	if err = s.DeleteJob(jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetJobResourceUsage(jobs[0].ID); err != sql.ErrNoRows {
+		t.Errorf("Getting the usage of a deleted job returned %v", err)
+	}
+	if err = s.RestoreJobArchive(ja); err != nil {
+		t.Fatal(err)
+	}
+	if restored, _ := s.GetJobResourceUsage(jobs[0].ID); !restored.Equal(u) {
+		t.Errorf("The restored usage was %#v", restored)
+	}
+}
+
+// addFinishedJob adds a job that completed at 'finished', with a raw event, a
+// job event, a status transition, and a stop request, and returns its ID.
+func addFinishedJob(t *testing.T, s JobStore, condorID string, finished time.Time) string {
+	id, err := s.InsertJob(&JobRecord{
+		CondorID:      condorID,
+		Submitter:     "unit_tests",
+		InvocationID:  uuid.New(),
+		DateSubmitted: finished.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawID, err := s.InsertCondorRawEvent(&CondorRawEvent{JobID: id, EventText: "raw " + condorID, DateTriggered: finished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, _ := s.GetCondorEventByNumber("005")
+	jobEventID, err := s.InsertCondorJobEvent(&CondorJobEvent{
+		JobID:            id,
+		CondorEventID:    ce.ID,
+		CondorRawEventID: rawID,
+		Hash:             "hash " + condorID,
+		DateTriggered:    finished,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.UpsertLastCondorJobEvent(jobEventID, id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.InsertCondorJobStopRequest(&CondorJobStopRequest{JobID: id, Username: "unit_tests", DateRequested: finished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.InsertJobStatusTransition(&JobStatusTransition{
+		JobID:            id,
+		CondorJobEventID: jobEventID,
+		FromStatus:       StatusRunning,
+		ToStatus:         StatusCompleted,
+		DateTriggered:    finished,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func testStoreArchives(t *testing.T, s JobStore) {
+	now := time.Now()
+	oldID := addFinishedJob(t, s, "1", now.Add(-48*time.Hour))
+	newID := addFinishedJob(t, s, "2", now)
+	if _, err := s.InsertJob(&JobRecord{CondorID: "3", Submitter: "unit_tests"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertCondorJobDep(&CondorJobDep{PredecessorID: oldID, SuccessorID: newID}); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.GetExpiredJobs(now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != oldID {
+		t.Fatalf("The expired jobs were %#v", expired)
+	}
+	ja, err := s.GetJobArchive(oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ja.RawEvents) != 1 || len(ja.JobEvents) != 1 || len(ja.StopRequests) != 1 || len(ja.Transitions) != 1 || len(ja.Dependencies) != 1 {
+		t.Fatalf("The archive was %#v", ja)
+	}
+	if ja.JobEvents[0].EventNumber != "005" || ja.LastEventID != ja.JobEvents[0].ID {
+		t.Errorf("The archived job events were %#v", ja.JobEvents)
+	}
+
+	if err = s.DeleteJob(oldID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RestoreJobArchive(ja); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RestoreJobArchive(ja); err != ErrArchiveRestored {
+		t.Errorf("Restoring a job twice returned %v instead of ErrArchiveRestored", err)
+	}
+	restored, err := s.GetJobArchive(oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Job.InvocationID != ja.Job.InvocationID || restored.LastEventID != ja.LastEventID {
+		t.Errorf("The restored job was %#v", restored)
+	}
+	if len(restored.Transitions) != 1 || restored.Transitions[0].Sequence != ja.Transitions[0].Sequence {
+		t.Errorf("The restored transitions were %#v", restored.Transitions)
+	}
+	if history, _ := s.GetJobEventHistory(oldID); len(history) != 1 || history[0].RawEvent != "raw 1" {
+		t.Errorf("The restored history was %#v", history)
+	}
+	if successors, _ := s.GetSuccessors(oldID); len(successors) != 1 || successors[0].ID != newID {
+		t.Errorf("The dependency wasn't restored: %#v", successors)
+	}
+}
+
+// testStorePruneJobHistory checks that pruning a job's history keeps the job
+// along with its status, last event, and stop requests, and that restoring
+// its archive puts the rest back.
+func testStorePruneJobHistory(t *testing.T, s JobStore, condorID string) {
+	now := time.Now()
+	id := addFinishedJob(t, s, condorID, now.Add(-48*time.Hour))
+	defer s.DeleteJob(id)
+	started := now.Add(-49 * time.Hour)
+	rawID, err := s.InsertCondorRawEvent(&CondorRawEvent{JobID: id, EventText: "raw running", DateTriggered: started})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, _ := s.GetCondorEventByNumber("001")
+	jobEventID, err := s.InsertCondorJobEvent(&CondorJobEvent{
+		JobID:            id,
+		CondorEventID:    ce.ID,
+		CondorRawEventID: rawID,
+		Hash:             "hash running " + condorID,
+		DateTriggered:    started,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.InsertJobStatusTransition(&JobStatusTransition{
+		JobID:            id,
+		CondorJobEventID: jobEventID,
+		FromStatus:       StatusSubmitted,
+		ToStatus:         StatusRunning,
+		DateTriggered:    started,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ja, err := s.GetJobArchive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawEventIDs, transitionIDs := ja.History()
+	if len(rawEventIDs) != 1 || rawEventIDs[0] != rawID || len(transitionIDs) != 1 {
+		t.Fatalf("The history to prune was %v and %v", rawEventIDs, transitionIDs)
+	}
+	if err = s.PruneJobHistory(id, rawEventIDs, transitionIDs, now); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := s.GetJobArchive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned.RawEvents) != 1 || len(pruned.JobEvents) != 1 || len(pruned.StopRequests) != 1 {
+		t.Errorf("The pruned job was %#v", pruned)
+	}
+	if len(pruned.Transitions) != 1 || pruned.Transitions[0].ToStatus != StatusCompleted || pruned.LastEventID != ja.LastEventID {
+		t.Errorf("The pruned job's status and last event weren't kept: %#v", pruned)
+	}
+	if pruned.DateArchived.IsZero() {
+		t.Error("The pruned job wasn't marked as archived")
+	}
+	expired, err := s.GetExpiredJobs(now.Add(-24*time.Hour), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jr := range expired {
+		if jr.ID == id {
+			t.Error("An archived job expired again")
+		}
+	}
+
+	if err = s.RestoreJobArchive(ja); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := s.GetJobArchive(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.RawEvents) != 2 || len(restored.JobEvents) != 2 || len(restored.Transitions) != 2 || !restored.DateArchived.IsZero() {
+		t.Errorf("The restored job was %#v", restored)
+	}
+	if err = s.RestoreJobArchive(ja); err != ErrArchiveRestored {
+		t.Errorf("Restoring a history twice returned %v instead of ErrArchiveRestored", err)
+	}
+}
+
+// testStoreServiceLocks checks that a lock is only held by one holder at a
+// time until it expires or is released.
+func testStoreServiceLocks(t *testing.T, s JobStore, name string) {
+	now := time.Now()
+	l, err := s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "a"}, now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "a" || !l.DateExpires.Equal(now.Add(time.Minute)) {
+		t.Errorf("The new lock was %#v", l)
+	}
+	if l, err = s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "b"}, now.Add(time.Second), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "a" {
+		t.Errorf("A held lock was taken by %s", l.Holder)
+	}
+
+	// Renewing the lock pushes back when it expires.
+	later := now.Add(30 * time.Second)
+	if l, err = s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "a"}, later, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "a" || !l.DateAcquired.Equal(now) || !l.DateExpires.Equal(later.Add(time.Minute)) {
+		t.Errorf("The renewed lock was %#v", l)
+	}
+
+	// An expired lock can be taken.
+	expired := later.Add(time.Minute)
+	if l, err = s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "b"}, expired, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "b" || !l.DateAcquired.Equal(expired) {
+		t.Errorf("The expired lock wasn't taken: %#v", l)
+	}
+
+	// Only the holder can release a lock.
+	if err = s.ReleaseServiceLock(name, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "a"}, expired, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "b" {
+		t.Errorf("The lock was released by somebody else")
+	}
+	if err = s.ReleaseServiceLock(name, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = s.AcquireServiceLock(&ServiceLock{Name: name, Holder: "a"}, expired, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.Holder != "a" {
+		t.Errorf("The released lock wasn't free: %#v", l)
+	}
+	if err = s.ReleaseServiceLock(name, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStoreJobFlags checks that a flag can only be set once per job and goes
+// away with the job.
+func testStoreJobFlags(t *testing.T, s JobStore, condorID string) {
+	jobID, err := s.InsertJob(&JobRecord{CondorID: condorID, Submitter: "unit_tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, err := s.HasJobFlag(jobID, FlagBatchFinishedReported); err != nil || has {
+		t.Errorf("HasJobFlag returned %t, %v before the flag was set", has, err)
+	}
+	if first, err := s.SetJobFlag(jobID, FlagBatchFinishedReported, time.Now()); err != nil || !first {
+		t.Errorf("SetJobFlag returned %t, %v the first time", first, err)
+	}
+	if first, err := s.SetJobFlag(jobID, FlagBatchFinishedReported, time.Now()); err != nil || first {
+		t.Errorf("SetJobFlag returned %t, %v the second time", first, err)
+	}
+	if has, err := s.HasJobFlag(jobID, FlagBatchFinishedReported); err != nil || !has {
+		t.Errorf("HasJobFlag returned %t, %v after the flag was set", has, err)
+	}
+	if has, _ := s.HasJobFlag(jobID, FlagFailureThresholdEnforced); has {
+		t.Error("Setting one flag set another")
+	}
+	if err = s.DeleteJob(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.HasJobFlag(jobID, FlagBatchFinishedReported); has {
+		t.Error("The flag outlived its job")
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+const (
+	// streamBufferSize is the number of events that can be waiting to be
+	// written to a subscriber before it's dropped. Dropped subscribers can
+	// reconnect and resume from the last event they received.
+	streamBufferSize = 100
+
+	// streamKeepAlive is how often something is written to idle streams so
+	// that proxies don't close them.
+	streamKeepAlive = 30 * time.Second
+
+	// streamReplayLimit is how many missed events are looked up at a time for
+	// a subscriber that's resuming a stream.
+	streamReplayLimit = 1000
+)
+
+// ErrNoStreamFilter is returned when a stream request doesn't say what to
+// subscribe to.
+var ErrNoStreamFilter = errors.New("at least one of job_id, invocation_id, batch_id, or submitter is required")
+
+// StreamEvent is a job status transition as it's sent to stream subscribers.
+// ID is the transition's sequence number, which subscribers can use to resume
+// the stream.
+type StreamEvent struct {
+	ID            int64
+	JobID         string
+	InvocationID  string
+	BatchID       string
+	Submitter     string
+	CondorID      string
+	AppID         string
+	FromStatus    string
+	ToStatus      string
+	Reason        string
+	DateTriggered time.Time
+}
+
+// NewStreamEvent returns the StreamEvent for a transition of a job.
+func NewStreamEvent(job *JobRecord, jt *JobStatusTransition) *StreamEvent {
+	return &StreamEvent{
+		ID:            jt.Sequence,
+		JobID:         job.ID,
+		InvocationID:  job.InvocationID,
+		BatchID:       job.BatchID,
+		Submitter:     job.Submitter,
+		CondorID:      job.CondorID,
+		AppID:         job.AppID,
+		FromStatus:    jt.FromStatus,
+		ToStatus:      jt.ToStatus,
+		Reason:        jt.Reason,
+		DateTriggered: jt.DateTriggered,
+	}
+}
+
+// StreamFilter decides which events a subscriber gets. Events have to match
+// all of the fields that are set.
+type StreamFilter struct {
+	JobID        string
+	InvocationID string
+	BatchID      string
+	Submitter    string
+}
+
+// ParseStreamFilter builds a StreamFilter from the job_id, invocation_id,
+// batch_id, and submitter query parameters. At least one of them must be set.
+func ParseStreamFilter(values url.Values) (*StreamFilter, error) {
+	f := &StreamFilter{
+		JobID:        values.Get("job_id"),
+		InvocationID: values.Get("invocation_id"),
+		BatchID:      values.Get("batch_id"),
+		Submitter:    values.Get("submitter"),
+	}
+	if f.JobID == "" && f.InvocationID == "" && f.BatchID == "" && f.Submitter == "" {
+		return nil, ErrNoStreamFilter
+	}
+	for _, id := range []string{f.JobID, f.InvocationID, f.BatchID} {
+		if id != "" && uuid.Parse(id) == nil {
+			return nil, errors.New("job_id, invocation_id, and batch_id must be UUIDs")
+		}
+	}
+	return f, nil
+}
+
+// Matches returns true if the event passes the filter.
+func (f *StreamFilter) Matches(e *StreamEvent) bool {
+	return (f.JobID == "" || f.JobID == e.JobID) &&
+		(f.InvocationID == "" || f.InvocationID == e.InvocationID) &&
+		(f.BatchID == "" || f.BatchID == e.BatchID) &&
+		(f.Submitter == "" || f.Submitter == e.Submitter)
+}
+
+// ParseLastEventID returns the ID of the last event a subscriber received,
+// which comes from the Last-Event-ID header sent by reconnecting EventSource
+// clients or from the last_event_id query parameter. Zero is returned for
+// subscribers that aren't resuming a stream.
+func ParseLastEventID(header string, values url.Values) (int64, error) {
+	v := header
+	if v == "" {
+		v = values.Get("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("the last event ID must be a non-negative integer")
+	}
+	return id, nil
+}
+
+// StreamSubscription is a subscriber's view of a StatusBroker. Events come in
+// on C, which is closed if the subscriber falls too far behind or is
+// unsubscribed.
+type StreamSubscription struct {
+	C      chan *StreamEvent
+	filter *StreamFilter
+}
+
+// StatusBroker fans job status transitions out to the stream subscribers that
+// want them.
+type StatusBroker struct {
+	mutex       sync.Mutex
+	subscribers map[*StreamSubscription]bool
+	closed      bool
+}
+
+// NewStatusBroker returns a pointer to a new StatusBroker with no subscribers.
+func NewStatusBroker() *StatusBroker {
+	return &StatusBroker{
+		subscribers: make(map[*StreamSubscription]bool),
+	}
+}
+
+// Subscribe adds a subscriber for the events that pass the filter. If the
+// broker has been closed, the subscription's channel is already closed.
+func (b *StatusBroker) Subscribe(filter *StreamFilter) *StreamSubscription {
+	s := &StreamSubscription{
+		C:      make(chan *StreamEvent, streamBufferSize),
+		filter: filter,
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		close(s.C)
+		return s
+	}
+	b.subscribers[s] = true
+	return s
+}
+
+// Unsubscribe removes a subscriber and closes its channel. It's safe to call
+// more than once.
+func (b *StatusBroker) Unsubscribe(s *StreamSubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.C)
+	}
+}
+
+// Publish sends the event to every subscriber whose filter it passes.
+// Publishing never blocks; subscribers that have a full buffer are dropped.
+func (b *StatusBroker) Publish(e *StreamEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.subscribers {
+		if !s.filter.Matches(e) {
+			continue
+		}
+		select {
+		case s.C <- e:
+		default:
+			logger.Printf("Dropping a stream subscriber that fell behind at event %d", e.ID)
+			delete(b.subscribers, s)
+			close(s.C)
+		}
+	}
+}
+
+// Close unsubscribes everyone, which ends their streams, and closes the
+// channels of any later subscriptions right away. It's used when shutting
+// down so that streams don't hold up draining the HTTP server.
+func (b *StatusBroker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		close(s.C)
+	}
+}
+
+// Count returns the number of subscribers.
+func (b *StatusBroker) Count() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers)
+}
+
+// FormatSSE returns the event formatted as a server-sent event. The event's ID
+// is used as the SSE id so that EventSource clients send it back in the
+// Last-Event-ID header when they reconnect.
+func FormatSSE(e *StreamEvent) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("id: %d\nevent: status\ndata: %s\n\n", e.ID, data)), nil
+}
+
+// streamSink is where the events in a stream get written to.
+type streamSink interface {
+	Send(e *StreamEvent) error
+	KeepAlive() error
+}
+
+// sseSink writes events to a response as server-sent events.
+type sseSink struct {
+	writer  http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSESink sets up the response for server-sent events. An error is returned
+// if the response can't be flushed, since the events would never get to the
+// client.
+func newSSESink(writer http.ResponseWriter) (*sseSink, error) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming isn't supported by the connection")
+	}
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseSink{writer: writer, flusher: flusher}, nil
+}
+
+func (s *sseSink) Send(e *StreamEvent) error {
+	msg, err := FormatSSE(e)
+	if err != nil {
+		return err
+	}
+	if _, err = s.writer.Write(msg); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseSink) KeepAlive() error {
+	if _, err := s.writer.Write([]byte(": keepalive\n\n")); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// wsSink writes events to a WebSocket as JSON text messages.
+type wsSink struct {
+	conn *WebSocketConn
+}
+
+func (s *wsSink) Send(e *StreamEvent) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteText(msg)
+}
+
+func (s *wsSink) KeepAlive() error {
+	return s.conn.Ping()
+}
+
+// serveStream writes the events that a subscriber missed, starting with
+// 'missed' and picking up after the last one from the database if there were
+// more than streamReplayLimit of them, and then writes live events until the
+// subscription ends, 'done' is closed, or a write fails. The subscription has
+// to be made before the missed events are looked up so that nothing falls in
+// between; live events that were already sent as part of the replay are
+// skipped.
+func serveStream(d JobStore, sink streamSink, sub *StreamSubscription, filter *StreamFilter, missed []StreamEvent, done <-chan bool) error {
+	var last int64
+	for {
+		for i := range missed {
+			if err := sink.Send(&missed[i]); err != nil {
+				return err
+			}
+			last = missed[i].ID
+		}
+		if len(missed) < streamReplayLimit {
+			break
+		}
+		var err error
+		if missed, err = d.GetStreamEvents(filter, last, streamReplayLimit); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if e.ID <= last {
+				continue
+			}
+			if err := sink.Send(e); err != nil {
+				return err
+			}
+			last = e.ID
+		case <-ticker.C:
+			if err := sink.KeepAlive(); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
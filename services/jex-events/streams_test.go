@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreamFilter(t *testing.T) {
+	_, err := ParseStreamFilter(url.Values{})
+	if err != ErrNoStreamFilter {
+		t.Errorf("An empty filter returned %v instead of ErrNoStreamFilter", err)
+	}
+	_, err = ParseStreamFilter(url.Values{"job_id": {"not-a-uuid"}})
+	if err == nil {
+		t.Error("A job_id that isn't a UUID was accepted")
+	}
+	f, err := ParseStreamFilter(url.Values{
+		"batch_id":  {"c4ba8e06-3f14-11e5-b5f6-3c4a92e4a804"},
+		"submitter": {"wregglej"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.BatchID != "c4ba8e06-3f14-11e5-b5f6-3c4a92e4a804" || f.Submitter != "wregglej" {
+		t.Errorf("The filter was %#v", f)
+	}
+}
+
+func TestStreamFilterMatches(t *testing.T) {
+	e := &StreamEvent{JobID: "job", BatchID: "batch", Submitter: "wregglej"}
+	cases := []struct {
+		filter StreamFilter
+		want   bool
+	}{
+		{StreamFilter{JobID: "job"}, true},
+		{StreamFilter{BatchID: "batch", Submitter: "wregglej"}, true},
+		{StreamFilter{BatchID: "batch", Submitter: "someone else"}, false},
+		{StreamFilter{InvocationID: "invocation"}, false},
+	}
+	for _, c := range cases {
+		if got := c.filter.Matches(e); got != c.want {
+			t.Errorf("%#v.Matches returned %t instead of %t", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestParseLastEventID(t *testing.T) {
+	id, err := ParseLastEventID("", url.Values{})
+	if err != nil || id != 0 {
+		t.Errorf("No last event ID returned %d, %v", id, err)
+	}
+	id, err = ParseLastEventID("42", url.Values{"last_event_id": {"7"}})
+	if err != nil || id != 42 {
+		t.Errorf("The Last-Event-ID header wasn't preferred: %d, %v", id, err)
+	}
+	id, err = ParseLastEventID("", url.Values{"last_event_id": {"7"}})
+	if err != nil || id != 7 {
+		t.Errorf("The last_event_id parameter returned %d, %v", id, err)
+	}
+	if _, err = ParseLastEventID("-1", url.Values{}); err == nil {
+		t.Error("A negative last event ID was accepted")
+	}
+}
+
+func TestStatusBroker(t *testing.T) {
+	b := NewStatusBroker()
+	job := b.Subscribe(&StreamFilter{JobID: "job"})
+	other := b.Subscribe(&StreamFilter{JobID: "other"})
+	b.Publish(&StreamEvent{ID: 1, JobID: "job"})
+	select {
+	case e := <-job.C:
+		if e.ID != 1 {
+			t.Errorf("The subscriber got event %d instead of 1", e.ID)
+		}
+	default:
+		t.Error("The subscriber didn't get the event")
+	}
+	select {
+	case e := <-other.C:
+		t.Errorf("A subscriber got event %d for another job", e.ID)
+	default:
+	}
+	b.Unsubscribe(other)
+	b.Unsubscribe(other)
+	if b.Count() != 1 {
+		t.Errorf("The broker had %d subscribers instead of 1", b.Count())
+	}
+	for i := 0; i <= streamBufferSize; i++ {
+		b.Publish(&StreamEvent{ID: int64(i + 2), JobID: "job"})
+	}
+	if b.Count() != 0 {
+		t.Error("A subscriber that fell behind wasn't dropped")
+	}
+	received := 0
+	for range job.C {
+		received++
+	}
+	if received != streamBufferSize {
+		t.Errorf("The dropped subscriber got %d events instead of %d", received, streamBufferSize)
+	}
+}
+
+func TestStatusBrokerClose(t *testing.T) {
+	b := NewStatusBroker()
+	before := b.Subscribe(&StreamFilter{JobID: "job"})
+	b.Close()
+	if _, ok := <-before.C; ok {
+		t.Error("A subscription wasn't closed when the broker was")
+	}
+	after := b.Subscribe(&StreamFilter{JobID: "job"})
+	if _, ok := <-after.C; ok {
+		t.Error("A subscription made after the broker was closed wasn't closed")
+	}
+	if b.Count() != 0 {
+		t.Errorf("The closed broker had %d subscribers", b.Count())
+	}
+	b.Publish(&StreamEvent{ID: 1, JobID: "job"})
+}
+
+func TestFormatSSE(t *testing.T) {
+	e := &StreamEvent{
+		ID:            12,
+		JobID:         "job",
+		ToStatus:      StatusRunning,
+		DateTriggered: time.Date(2015, 8, 11, 12, 0, 0, 0, time.UTC),
+	}
+	msg, err := FormatSSE(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(msg, []byte("id: 12\nevent: status\ndata: {")) {
+		t.Errorf("The event started with the wrong fields: %q", msg)
+	}
+	if !bytes.HasSuffix(msg, []byte("}\n\n")) {
+		t.Errorf("The event wasn't terminated by a blank line: %q", msg)
+	}
+	if strings.Count(string(msg), "\n") != 4 {
+		t.Errorf("The event's data spanned more than one line: %q", msg)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// The ways a job can terminate according to its terminated event (005). A job
+// that was killed by a signal and left a core file behind is recorded as a
+// core dump. TerminationUnknown is used when the event doesn't say how the
+// job terminated, in which case its exit code is EventCodeNotSet. So is a job
+// that was killed by a signal, since it doesn't have an exit code.
+const (
+	TerminationUnknown  = ""
+	TerminationNormal   = "normal"
+	TerminationSignal   = "signal"
+	TerminationCoreDump = "core dump"
+)
+
+// oomSignal is SIGKILL, which is what the kernel's OOM killer uses. Condor
+// uses it too when a job goes over its memory limit.
+const oomSignal = 9
+
+var (
+	// normalTerminationRegexp matches the line of a terminated event that has
+	// the job's exit code.
+	normalTerminationRegexp = regexp.MustCompile(`Normal termination \(return value (-?[0-9]+)\)`)
+
+	// abnormalTerminationRegexp matches the line of a terminated event that has
+	// the signal that killed the job.
+	abnormalTerminationRegexp = regexp.MustCompile(`Abnormal termination \(signal ([0-9]+)\)`)
+
+	// coreFileRegexp matches the line that follows an abnormal termination if
+	// the job left a core file behind. It's "(0) No core file" otherwise.
+	coreFileRegexp = regexp.MustCompile(`\(1\) Corefile in:`)
+)
+
+// TerminationMessage returns the message sent to the notifiers when a job
+// terminates. It's empty for jobs that exited with an exit code of 0 and for
+// events other than the terminated event.
+func (e *Event) TerminationMessage() string {
+	if e.EventNumber != "005" {
+		return ""
+	}
+	switch e.TerminationKind {
+	case TerminationNormal:
+		if e.ExitCode == 0 {
+			return ""
+		}
+		return fmt.Sprintf("Job exited with code %d.", e.ExitCode)
+	case TerminationSignal, TerminationCoreDump:
+		msg := fmt.Sprintf("Job was killed by signal %d", e.TerminationSignal)
+		if e.TerminationSignal == oomSignal {
+			msg += " (likely OOM)"
+		}
+		if e.TerminationKind == TerminationCoreDump {
+			msg += " and dumped core"
+		}
+		return msg + "."
+	default:
+		return "Job terminated, but the event doesn't say how."
+	}
+}
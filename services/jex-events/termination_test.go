@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// killedEvent is a terminated event for a job that was killed by a signal.
+const killedEvent = "005 (%d.000.000) 08/14 09:30:00 Job terminated.\n" +
+	"\t(0) Abnormal termination (signal %d)\n" +
+	"\t%s\n" +
+	"\t\tUsr 0 00:00:06, Sys 0 00:00:01  -  Run Remote Usage\n" +
+	"...\n"
+
+func TestParseTermination(t *testing.T) {
+	tests := []struct {
+		text   string
+		kind   string
+		code   int
+		signal int
+	}{
+		{fmt.Sprintf(replayEventTexts[2], 100), TerminationNormal, 0, 0},
+		{"005 (100.000.000) 08/14 09:30:00 Job terminated.\n\t(1) Normal termination (return value 2)\n", TerminationNormal, 2, 0},
+		{fmt.Sprintf(killedEvent, 100, 9, "(0) No core file"), TerminationSignal, EventCodeNotSet, 9},
+		{fmt.Sprintf(killedEvent, 100, 11, "(1) Corefile in: /var/lib/condor/execute/core.1234"), TerminationCoreDump, EventCodeNotSet, 11},
+		{"005 (100.000.000) 08/14 09:30:00 Job terminated.\n...\n", TerminationUnknown, EventCodeNotSet, 0},
+	}
+	for i, test := range tests {
+		e := &Event{Event: test.text}
+		e.Parse()
+		if e.TerminationKind != test.kind || e.ExitCode != test.code || e.TerminationSignal != test.signal {
+			t.Errorf("Event %d was parsed as '%s' with exit code %d and signal %d", i, e.TerminationKind, e.ExitCode, e.TerminationSignal)
+		}
+	}
+}
+
+func TestParseCondorIDWithoutID(t *testing.T) {
+	e := &Event{ID: "100.0.0"}
+	e.setCondorID()
+	if e.CondorID != "" {
+		t.Errorf("The Condor ID was '%s' instead of blank", e.CondorID)
+	}
+}
+
+func TestTerminationMessage(t *testing.T) {
+	tests := []struct {
+		text    string
+		message string
+	}{
+		{fmt.Sprintf(replayEventTexts[2], 100), ""},
+		{"005 (100.000.000) 08/14 09:30:00 Job terminated.\n\t(1) Normal termination (return value 2)\n", "Job exited with code 2."},
+		{fmt.Sprintf(killedEvent, 100, 9, "(0) No core file"), "Job was killed by signal 9 (likely OOM)."},
+		{fmt.Sprintf(killedEvent, 100, 11, "(1) Corefile in: core.1234"), "Job was killed by signal 11 and dumped core."},
+		{"005 (100.000.000) 08/14 09:30:00 Job terminated.\n...\n", "Job terminated, but the event doesn't say how."},
+		{fmt.Sprintf(replayEventTexts[1], 100), ""},
+	}
+	for i, test := range tests {
+		e := &Event{Event: test.text}
+		e.Parse()
+		if msg := e.TerminationMessage(); msg != test.message {
+			t.Errorf("The message for event %d was '%s' instead of '%s'", i, msg, test.message)
+		}
+	}
+}
+
+func TestProcessEventsKilledJob(t *testing.T) {
+	p, r := newRecordingHandler()
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(killedEvent, 100, 9, "(0) No core file"))
+	job, err := p.DB.GetJobByCondorID("100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.TerminationKind != TerminationSignal || job.TerminationSignal != 9 || job.ExitCode != EventCodeNotSet {
+		t.Errorf("The job was stored as '%s' with signal %d and exit code %d", job.TerminationKind, job.TerminationSignal, job.ExitCode)
+	}
+	if len(r.changes) != 2 {
+		t.Fatalf("%d changes were sent instead of 2", len(r.changes))
+	}
+	change := r.changes[1]
+	if change.Status != StatusFailed || change.Message != "Job was killed by signal 9 (likely OOM)." {
+		t.Errorf("The change was sent as '%s' with the message '%s'", change.Status, change.Message)
+	}
+	if state := change.JobState(); state.State.Message != change.Message {
+		t.Errorf("The message sent to the /de-job endpoint was '%s'", state.State.Message)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// JobResourceUsage is what a job used according to its terminated event (005).
+// The CPU times are the job's total remote usage in seconds, and the bytes are
+// the totals that the job sent and received. The CPU, memory, and disk fields
+// come from the event's partitionable resources table and are 0 if the table
+// doesn't have them. DateTriggered is when the event was received.
+type JobResourceUsage struct {
+	JobID             string
+	CondorJobEventID  string
+	UserCPUSeconds    int64
+	SysCPUSeconds     int64
+	BytesSent         int64
+	BytesReceived     int64
+	CPUsRequested     int64
+	CPUsAllocated     int64
+	MemoryUsageMB     int64
+	MemoryRequestedMB int64
+	MemoryAllocatedMB int64
+	DiskUsageKB       int64
+	DiskRequestedKB   int64
+	DiskAllocatedKB   int64
+	DateTriggered     time.Time
+}
+
+// Equal returns true if the two records say the same thing.
+func (u *JobResourceUsage) Equal(other *JobResourceUsage) bool {
+	if u == nil || other == nil {
+		return u == other
+	}
+	a, b := *u, *other
+	a.DateTriggered, b.DateTriggered = time.Time{}, time.Time{}
+	return a == b && u.DateTriggered.Equal(other.DateTriggered)
+}
+
+var (
+	// cpuUsageRegexps match the remote CPU usage lines of a terminated event.
+	// The total covers every run of the job, so it's preferred.
+	cpuUsageRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`Usr ([0-9]+) ([0-9]+):([0-9]+):([0-9]+), Sys ([0-9]+) ([0-9]+):([0-9]+):([0-9]+)\s+-\s+Total Remote Usage`),
+		regexp.MustCompile(`Usr ([0-9]+) ([0-9]+):([0-9]+):([0-9]+), Sys ([0-9]+) ([0-9]+):([0-9]+):([0-9]+)\s+-\s+Run Remote Usage`),
+	}
+	bytesSentRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`([0-9.]+)\s+-\s+Total Bytes Sent By Job`),
+		regexp.MustCompile(`([0-9.]+)\s+-\s+Run Bytes Sent By Job`),
+	}
+	bytesReceivedRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`([0-9.]+)\s+-\s+Total Bytes Received By Job`),
+		regexp.MustCompile(`([0-9.]+)\s+-\s+Run Bytes Received By Job`),
+	}
+	resourcesHeaderRegexp = regexp.MustCompile(`Partitionable Resources\s*:([^\n]*)`)
+	resourceRowRegexp     = regexp.MustCompile(`(?m)^\s*(Cpus|Memory \(MB\)|Disk \(KB\))\s*:([^\n]*)$`)
+	resourceValueRegexp   = regexp.MustCompile(`\S+`)
+)
+
+// condorSeconds converts the days, hours, minutes, and seconds in a Condor
+// usage line to seconds.
+func condorSeconds(parts []string) int64 {
+	var values [4]int64
+	for i, p := range parts {
+		values[i], _ = strconv.ParseInt(p, 10, 64)
+	}
+	return ((values[0]*24+values[1])*60+values[2])*60 + values[3]
+}
+
+// firstNumber returns the first number that one of the regexps finds in the
+// text. The number is truncated, since Condor sometimes prints the byte counts
+// as floats.
+func firstNumber(text string, regexps []*regexp.Regexp) (int64, bool) {
+	for _, r := range regexps {
+		if m := r.FindStringSubmatch(text); len(m) == 2 {
+			if f, err := strconv.ParseFloat(m[1], 64); err == nil {
+				return int64(f), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// parseResources fills in the CPU, memory, and disk fields from the
+// partitionable resources table in the text of a terminated event, which
+// looks like this:
+//
+//	Partitionable Resources :    Usage  Request Allocated
+//	   Cpus                 :                 1         1
+//	   Disk (KB)            :     1019     1024   2825331
+//	   Memory (MB)          :        3        1      1024
+//
+// Cells can be blank, so each value goes in the column whose heading it lines
+// up with on the right. It returns false if there's no table.
+func (u *JobResourceUsage) parseResources(text string) bool {
+	header := resourcesHeaderRegexp.FindStringSubmatchIndex(text)
+	if header == nil {
+		return false
+	}
+	headings := text[header[2]:header[3]]
+	var columns []string
+	var ends []int
+	for _, loc := range resourceValueRegexp.FindAllStringIndex(headings, -1) {
+		columns = append(columns, headings[loc[0]:loc[1]])
+		ends = append(ends, loc[1])
+	}
+	fields := map[string]map[string]*int64{
+		"Cpus":        {"Request": &u.CPUsRequested, "Allocated": &u.CPUsAllocated},
+		"Memory (MB)": {"Usage": &u.MemoryUsageMB, "Request": &u.MemoryRequestedMB, "Allocated": &u.MemoryAllocatedMB},
+		"Disk (KB)":   {"Usage": &u.DiskUsageKB, "Request": &u.DiskRequestedKB, "Allocated": &u.DiskAllocatedKB},
+	}
+	for _, row := range resourceRowRegexp.FindAllStringSubmatch(text[header[1]:], -1) {
+		cells := row[2]
+		for _, loc := range resourceValueRegexp.FindAllStringIndex(cells, -1) {
+			value, err := strconv.ParseFloat(cells[loc[0]:loc[1]], 64)
+			if err != nil || len(columns) == 0 {
+				continue
+			}
+			closest := 0
+			for i, end := range ends {
+				if abs(end-loc[1]) < abs(ends[closest]-loc[1]) {
+					closest = i
+				}
+			}
+			if field, ok := fields[row[1]][columns[closest]]; ok {
+				*field = int64(value)
+			}
+		}
+	}
+	return true
+}
+
+// abs returns the absolute value of an int.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ParseResourceUsage parses the resource usage out of the text of a terminated
+// event. nil is returned if the text doesn't say anything about it. The JobID,
+// CondorJobEventID, and DateTriggered fields aren't filled in.
+func ParseResourceUsage(text string) *JobResourceUsage {
+	u := &JobResourceUsage{}
+	found := false
+	for _, r := range cpuUsageRegexps {
+		if m := r.FindStringSubmatch(text); len(m) == 9 {
+			u.UserCPUSeconds = condorSeconds(m[1:5])
+			u.SysCPUSeconds = condorSeconds(m[5:9])
+			found = true
+			break
+		}
+	}
+	var ok bool
+	if u.BytesSent, ok = firstNumber(text, bytesSentRegexps); ok {
+		found = true
+	}
+	if u.BytesReceived, ok = firstNumber(text, bytesReceivedRegexps); ok {
+		found = true
+	}
+	if u.parseResources(text) {
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return u
+}
+
+// UsageFilter picks the jobs whose resource usage is added up. Empty fields
+// and zero times aren't used to filter the jobs. From and To apply to when
+// the jobs' terminated events were received; From is inclusive and To isn't.
+type UsageFilter struct {
+	Submitter string
+	AppID     string
+	From      time.Time
+	To        time.Time
+}
+
+// ParseUsageFilter builds a UsageFilter from the submitter, app_id, from, and
+// to query parameters. Dates must be formatted according to RFC3339.
+func ParseUsageFilter(values url.Values) (*UsageFilter, error) {
+	f := &UsageFilter{
+		Submitter: values.Get("submitter"),
+		AppID:     values.Get("app_id"),
+	}
+	if f.AppID != "" && uuid.Parse(f.AppID) == nil {
+		return nil, fmt.Errorf("app_id must be a UUID: %s", f.AppID)
+	}
+	dates := map[string]*time.Time{
+		"from": &f.From,
+		"to":   &f.To,
+	}
+	for name, date := range dates {
+		if v := values.Get(name); v != "" {
+			var err error
+			if *date, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 timestamp: %s", name, v)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Query returns the PostgreSQL query that adds up the usage of the jobs that
+// match the filter, along with its arguments.
+func (f *UsageFilter) Query() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+	if f.Submitter != "" {
+		add("j.submitter = ?", f.Submitter)
+	}
+	if f.AppID != "" {
+		add("j.app_id = cast(? as uuid)", f.AppID)
+	}
+	if !f.From.IsZero() {
+		add("u.date_triggered >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("u.date_triggered < ?", f.To)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, "\n\t   AND ")
+	}
+	query := fmt.Sprintf(`
+	SELECT %s
+	  FROM job_resource_usage u
+	  JOIN jobs j ON u.job_id = j.id
+	 %s
+	`, usageTotalsColumns, where)
+	return query, args
+}
+
+// UsageTotals adds up the resource usage of the jobs that match a
+// UsageFilter. The maximums are the most that any one of the jobs used.
+type UsageTotals struct {
+	Jobs             int64
+	UserCPUSeconds   int64
+	SysCPUSeconds    int64
+	BytesSent        int64
+	BytesReceived    int64
+	MaxMemoryUsageMB int64
+	MaxDiskUsageKB   int64
+}
+
+// Add adds a job's usage to the totals.
+func (t *UsageTotals) Add(u *JobResourceUsage) {
+	t.Jobs++
+	t.UserCPUSeconds += u.UserCPUSeconds
+	t.SysCPUSeconds += u.SysCPUSeconds
+	t.BytesSent += u.BytesSent
+	t.BytesReceived += u.BytesReceived
+	if u.MemoryUsageMB > t.MaxMemoryUsageMB {
+		t.MaxMemoryUsageMB = u.MemoryUsageMB
+	}
+	if u.DiskUsageKB > t.MaxDiskUsageKB {
+		t.MaxDiskUsageKB = u.DiskUsageKB
+	}
+}
+
+// usageTotalsColumns is the SQL that adds up the job_resource_usage rows
+// aliased as 'u' into the columns of a UsageTotals.
+const usageTotalsColumns = `count(*),
+	       COALESCE(sum(u.user_cpu_seconds), 0),
+	       COALESCE(sum(u.sys_cpu_seconds), 0),
+	       COALESCE(sum(u.bytes_sent), 0),
+	       COALESCE(sum(u.bytes_received), 0),
+	       COALESCE(max(u.memory_usage_mb), 0),
+	       COALESCE(max(u.disk_usage_kb), 0)`
+
+// scanUsageTotals scans a row selected with usageTotalsColumns.
+func scanUsageTotals(row rowScanner) (*UsageTotals, error) {
+	t := &UsageTotals{}
+	err := row.Scan(
+		&t.Jobs,
+		&t.UserCPUSeconds,
+		&t.SysCPUSeconds,
+		&t.BytesSent,
+		&t.BytesReceived,
+		&t.MaxMemoryUsageMB,
+		&t.MaxDiskUsageKB,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// jobResourceUsageColumns are the columns of the job_resource_usage table, in
+// the order that usageRow and scanDest use.
+const jobResourceUsageColumns = `job_id,
+	       condor_job_event_id,
+	       user_cpu_seconds,
+	       sys_cpu_seconds,
+	       bytes_sent,
+	       bytes_received,
+	       cpus_requested,
+	       cpus_allocated,
+	       memory_usage_mb,
+	       memory_requested_mb,
+	       memory_allocated_mb,
+	       disk_usage_kb,
+	       disk_requested_kb,
+	       disk_allocated_kb,
+	       date_triggered`
+
+// insertUsageQuery is the format for batchQueries that adds resource usage.
+const insertUsageQuery = `INSERT INTO job_resource_usage (` + jobResourceUsageColumns + `) VALUES %s`
+
+// usageRow returns the values of the usage for batchQueries, with 'date' in
+// place of DateTriggered, since the stores keep dates differently.
+func usageRow(u *JobResourceUsage, date interface{}) []interface{} {
+	return []interface{}{
+		u.JobID,
+		u.CondorJobEventID,
+		u.UserCPUSeconds,
+		u.SysCPUSeconds,
+		u.BytesSent,
+		u.BytesReceived,
+		u.CPUsRequested,
+		u.CPUsAllocated,
+		u.MemoryUsageMB,
+		u.MemoryRequestedMB,
+		u.MemoryAllocatedMB,
+		u.DiskUsageKB,
+		u.DiskRequestedKB,
+		u.DiskAllocatedKB,
+		date,
+	}
+}
+
+// scanDest returns the destinations for scanning jobResourceUsageColumns into
+// the usage, with 'date' as the destination for date_triggered.
+func (u *JobResourceUsage) scanDest(date interface{}) []interface{} {
+	return []interface{}{
+		&u.JobID,
+		&u.CondorJobEventID,
+		&u.UserCPUSeconds,
+		&u.SysCPUSeconds,
+		&u.BytesSent,
+		&u.BytesReceived,
+		&u.CPUsRequested,
+		&u.CPUsAllocated,
+		&u.MemoryUsageMB,
+		&u.MemoryRequestedMB,
+		&u.MemoryAllocatedMB,
+		&u.DiskUsageKB,
+		&u.DiskRequestedKB,
+		&u.DiskAllocatedKB,
+		date,
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// terminatedEventWithUsage is a terminated event with the usage that Condor
+// reports for a job. The CPU usage in the resources table is blank, like it
+// often is.
+const terminatedEventWithUsage = "005 (%d.000.000) 08/11/15 12:10:00 Job terminated.\n" +
+	"\t(1) Normal termination (return value 0)\n" +
+	"\t\tUsr 0 00:00:06, Sys 0 00:00:01  -  Run Remote Usage\n" +
+	"\t\tUsr 0 00:00:00, Sys 0 00:00:00  -  Run Local Usage\n" +
+	"\t\tUsr 0 00:01:06, Sys 0 00:00:02  -  Total Remote Usage\n" +
+	"\t\tUsr 0 00:00:00, Sys 0 00:00:00  -  Total Local Usage\n" +
+	"\t123091  -  Run Bytes Sent By Job\n" +
+	"\t801816  -  Run Bytes Received By Job\n" +
+	"\t123091  -  Total Bytes Sent By Job\n" +
+	"\t801816  -  Total Bytes Received By Job\n" +
+	"\tPartitionable Resources :    Usage  Request Allocated\n" +
+	"\t   Cpus                 :                 1         1\n" +
+	"\t   Disk (KB)            :     1019     1024   2825331\n" +
+	"\t   Memory (MB)          :        3        1      1024\n"
+
+func TestParseResourceUsage(t *testing.T) {
+	e := &Event{Event: fmt.Sprintf(terminatedEventWithUsage, 100)}
+	e.Parse()
+	want := JobResourceUsage{
+		UserCPUSeconds:    66,
+		SysCPUSeconds:     2,
+		BytesSent:         123091,
+		BytesReceived:     801816,
+		CPUsRequested:     1,
+		CPUsAllocated:     1,
+		MemoryUsageMB:     3,
+		MemoryRequestedMB: 1,
+		MemoryAllocatedMB: 1024,
+		DiskUsageKB:       1019,
+		DiskRequestedKB:   1024,
+		DiskAllocatedKB:   2825331,
+	}
+	if e.Usage == nil || *e.Usage != want {
+		t.Errorf("The usage was parsed as %#v instead of %#v", e.Usage, want)
+	}
+
+	// Older versions of Condor only report the run usage, and some print the
+	// byte counts as floats.
+	u := ParseResourceUsage("005 (100.000.000) 08/11 12:10:00 Job terminated.\n" +
+		"\t\tUsr 1 02:03:04, Sys 0 00:00:05  -  Run Remote Usage\n" +
+		"\t5.0  -  Run Bytes Sent By Job\n")
+	if u == nil || u.UserCPUSeconds != 93784 || u.SysCPUSeconds != 5 || u.BytesSent != 5 || u.MemoryUsageMB != 0 {
+		t.Errorf("The run usage was parsed as %#v", u)
+	}
+
+	e = &Event{Event: fmt.Sprintf(replayEventTexts[2], 100)}
+	e.Parse()
+	if e.Usage != nil {
+		t.Errorf("Usage was parsed out of an event without any: %#v", e.Usage)
+	}
+}
+
+func TestParseUsageFilter(t *testing.T) {
+	appID := uuid.New()
+	values := url.Values{}
+	values.Set("submitter", "ipcdev")
+	values.Set("app_id", appID)
+	values.Set("from", "2015-08-01T00:00:00Z")
+	f, err := ParseUsageFilter(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Submitter != "ipcdev" || f.AppID != appID || !f.From.Equal(time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)) || !f.To.IsZero() {
+		t.Errorf("The filter wasn't parsed correctly: %#v", f)
+	}
+	for _, v := range []url.Values{{"app_id": {"not-a-uuid"}}, {"to": {"tomorrow"}}} {
+		if _, err = ParseUsageFilter(v); err == nil {
+			t.Errorf("ParseUsageFilter(%v) didn't return an error", v)
+		}
+	}
+}
+
+func TestUsageHTTP(t *testing.T) {
+	p, _ := newRecordingHandler()
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 100), fmt.Sprintf(terminatedEventWithUsage, 100))
+	processTexts(t, p, fmt.Sprintf(replayEventTexts[0], 101))
+	server := httptest.NewServer((&HTTPAPI{d: p.DB, events: p, allowUnauthenticated: true}).Router())
+	defer server.Close()
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	job, _ := p.DB.GetJobByCondorID("100")
+	var u JobResourceUsage
+	if status := get("/jobs/"+job.ID+"/usage", &u); status != http.StatusOK || u.JobID != job.ID || u.UserCPUSeconds != 66 {
+		t.Errorf("Getting the job's usage returned %d: %#v", status, u)
+	}
+	other, _ := p.DB.GetJobByCondorID("101")
+	if status := get("/jobs/"+other.ID+"/usage", nil); status != http.StatusNotFound {
+		t.Errorf("Getting the usage of a job without any returned %d", status)
+	}
+
+	var totals UsageTotals
+	if status := get("/usage", &totals); status != http.StatusOK || totals.Jobs != 1 || totals.BytesSent != 123091 {
+		t.Errorf("Getting the usage totals returned %d: %#v", status, totals)
+	}
+	from := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	if status := get("/usage?from="+from, &totals); status != http.StatusOK || totals.Jobs != 0 {
+		t.Errorf("Getting the usage totals from the future returned %d: %#v", status, totals)
+	}
+	if status := get("/usage?from=yesterday", nil); status != http.StatusBadRequest {
+		t.Errorf("Getting the usage totals with a bad date returned %d", status)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is just enough of RFC 6455 to push messages to clients. Messages from
+// clients are read only so that pings get answered and closes get noticed.
+
+// websocketGUID is the magic value used to compute Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The WebSocket frame opcodes that jex-events deals with.
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// statusUpgradeRequired is the HTTP status for clients that need to switch to
+// a different version of the WebSocket protocol. net/http doesn't define it.
+const statusUpgradeRequired = 426
+
+// maxWebSocketControlPayload is the largest payload a control frame may have.
+const maxWebSocketControlPayload = 125
+
+// IsWebSocketRequest returns true if the request is asking to be upgraded to a
+// WebSocket.
+func IsWebSocketRequest(request *http.Request) bool {
+	return headerContainsToken(request.Header.Get("Connection"), "upgrade") &&
+		strings.EqualFold(request.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContainsToken returns true if the comma separated header value
+// contains the token, ignoring case.
+func headerContainsToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketAccept returns the Sec-WebSocket-Accept value for a
+// Sec-WebSocket-Key.
+func WebSocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// WebSocketConn is a server side WebSocket connection.
+type WebSocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+// UpgradeWebSocket completes the WebSocket handshake for the request. An error
+// response is written if the request isn't a valid WebSocket handshake.
+func UpgradeWebSocket(writer http.ResponseWriter, request *http.Request) (*WebSocketConn, error) {
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if request.Method != "GET" || !IsWebSocketRequest(request) || key == "" {
+		WriteRequestError(writer, "The request isn't a WebSocket handshake")
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		writer.Header().Set("Sec-WebSocket-Version", "13")
+		WriteError(writer, statusUpgradeRequired, "Only version 13 of the WebSocket protocol is supported")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		WriteError(writer, http.StatusInternalServerError, "The connection can't be upgraded to a WebSocket")
+		return nil, errors.New("the connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts are meant for requests, not for
+	// connections that stay open for as long as the client wants them.
+	conn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + WebSocketAccept(key) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocketConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// WriteText sends a text message.
+func (c *WebSocketConn) WriteText(msg []byte) error {
+	return c.writeFrame(wsOpText, msg)
+}
+
+// Ping sends a ping, which keeps idle connections open.
+func (c *WebSocketConn) Ping() error {
+	return c.writeFrame(wsOpPing, nil)
+}
+
+// Close sends a close frame and closes the connection.
+func (c *WebSocketConn) Close() error {
+	c.writeFrame(wsOpClose, nil)
+	return c.conn.Close()
+}
+
+// writeFrame writes a single unfragmented, unmasked frame.
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// ReadUntilClosed reads frames from the client, answering pings and dropping
+// everything else, until the client closes the connection or an error occurs.
+func (c *WebSocketConn) ReadUntilClosed() error {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case wsOpClose:
+			return nil
+		case wsOpPing:
+			if err = c.writeFrame(wsOpPong, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readFrame reads a single frame from the client and unmasks its payload.
+func (c *WebSocketConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if !masked {
+		return 0, nil, errors.New("client frames must be masked")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if opcode >= wsOpClose && length > maxWebSocketControlPayload {
+		return 0, nil, fmt.Errorf("control frame payload of %d bytes is too large", length)
+	}
+	if opcode < wsOpClose {
+		// Data frames aren't used for anything, so skip them instead of
+		// buffering them.
+		_, err := io.CopyN(ioutil.Discard, c.reader, int64(length))
+		return opcode, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
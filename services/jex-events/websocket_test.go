@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// This is the example from RFC 6455.
+	accept := WebSocketAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	if accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("The accept value was %s", accept)
+	}
+}
+
+func TestIsWebSocketRequest(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+	if IsWebSocketRequest(r) {
+		t.Error("A plain request was treated as a WebSocket request")
+	}
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	if !IsWebSocketRequest(r) {
+		t.Error("A WebSocket request wasn't recognized")
+	}
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &WebSocketConn{conn: server, reader: bufio.NewReader(server)}
+	done := make(chan error)
+	go func() {
+		done <- conn.ReadUntilClosed()
+	}()
+
+	// A masked ping with a payload of "hi" should come back as a pong.
+	mask := []byte{1, 2, 3, 4}
+	ping := []byte{0x80 | wsOpPing, 0x80 | 2}
+	ping = append(ping, mask...)
+	ping = append(ping, 'h'^mask[0], 'i'^mask[1])
+	if _, err := client.Write(ping); err != nil {
+		t.Fatal(err)
+	}
+	pong := make([]byte, 4)
+	if _, err := io.ReadFull(client, pong); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pong, []byte{0x80 | wsOpPong, 2, 'h', 'i'}) {
+		t.Errorf("The pong was %v", pong)
+	}
+
+	closing := append([]byte{0x80 | wsOpClose, 0x80}, mask...)
+	if _, err := client.Write(closing); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("ReadUntilClosed returned %s", err)
+	}
+}
+
+func TestWebSocketWriteText(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &WebSocketConn{conn: server}
+	msg := bytes.Repeat([]byte("a"), 200)
+	go conn.WriteText(msg)
+	frame := make([]byte, 4+len(msg))
+	if _, err := io.ReadFull(client, frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != 0x80|wsOpText || frame[1] != 126 || frame[2] != 0 || frame[3] != 200 {
+		t.Errorf("The frame header was %v", frame[:4])
+	}
+	if !bytes.Equal(frame[4:], msg) {
+		t.Error("The frame's payload didn't match the message")
+	}
+}
@@ -3,17 +3,26 @@
   (:require [clj-http.client :as http]
             [clojure.tools.logging :as log]
             [cemerick.url :as curl]
+            [clojure-commons.hmac :as hmac]
             [metadactyl.util.config :as config]))
 
 (defn- jex-events-url
   [& components]
   (str (apply curl/url (config/jex-events-base-url) components)))
 
+(defn- jex-events-get
+  "Sends a GET request to the JEX events service, signed if metadactyl is
+   configured with credentials for it."
+  [url]
+  (http/request (hmac/sign-request (config/jex-events-hmac-service)
+                                   (config/jex-events-hmac-secret)
+                                   {:method :get :url url :as :json})))
+
 (defn- job-exists?
   [job-id]
   (try+
    (-> (jex-events-url "invocations" job-id)
-       (http/get {:as :json})
+       (jex-events-get)
        (:body))
    (catch [:status 404] _
      (log/warn (str "invocation " job-id " does not exist"))
@@ -23,6 +32,6 @@
   [job-id]
   (when (job-exists? job-id)
     (-> (jex-events-url "last-events" job-id)
-        (http/get {:as :json})
+        (jex-events-get)
         (:body)
         (:state))))
@@ -246,6 +246,16 @@
   [props config-valid configs]
   "metadactyl.jex-events.base-url")
 
+(cc/defprop-optstr jex-events-hmac-service
+  "The service name that requests to the JEX events service are signed as."
+  [props config-valid configs]
+  "metadactyl.jex-events.hmac-service")
+
+(cc/defprop-optstr jex-events-hmac-secret
+  "The shared secret that requests to the JEX events service are signed with."
+  [props config-valid configs]
+  "metadactyl.jex-events.hmac-secret")
+
 (cc/defprop-int job-status-poll-interval
   "The job status polling interval in minutes."
   [props config-valid configs]
@@ -291,6 +301,6 @@
   "Loads the configuration settings from a file."
   [cfg-path]
   (cc/load-config-from-file cfg-path props)
-  (cc/log-config props)
+  (cc/log-config props :filters [#"hmac-secret"])
   (log-environment)
   (validate-config))